@@ -82,6 +82,8 @@ func init() {
 	rootCmd.Flags().StringVarP(&(config.Configures.LogDir), "logdir", "d", config.DefaultLogDir, "Set log directory: default is /tmp")
 	rootCmd.Flags().StringVarP(&(config.Configures.LogLevel), "loglevel", "l", config.DefaultLogLevel, "Set log level: default is info")
 	rootCmd.Flags().IntVarP(&(config.Configures.ShardNum), "shardnum", "s", config.DefaultShardNum, "Set shard number: default is 1024")
+	rootCmd.Flags().StringVar(&(config.Configures.NodeID), "node-id", config.DefaultNodeID, "Set the node name used by active-active replication")
+	rootCmd.Flags().StringSliceVar(&(config.Configures.Peers), "peers", nil, "Set active-active peers: such as 10.0.0.2:6379,10.0.0.3:6379")
 	rootCmd.AddCommand(completionCmd)
 	memdb.RegisterKeyCommand()
 	memdb.RegisterStringCommands()
@@ -90,6 +92,9 @@ func init() {
 	memdb.RegisterSetCommands()
 	memdb.RegisterZSetCommands()
 	memdb.RegisterInfoCommands()
+	memdb.RegisterMultiCommands()
+	memdb.RegisterPubSubCommands()
+	memdb.RegisterACLCommands()
 }
 func Run() {
 	if err := rootCmd.Execute(); err != nil {
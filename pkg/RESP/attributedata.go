@@ -0,0 +1,26 @@
+package RESP
+
+// AttributeData is a reply with the RESP3 attribute describing it.
+// attrs holds the keys and values of the attribute in turn.
+// The attribute is dropped for RESP2 connections.
+type AttributeData struct {
+	attrs []RedisData
+	data  RedisData
+}
+
+func MakeAttributeData(attrs []RedisData, data RedisData) *AttributeData {
+	return &AttributeData{attrs: attrs, data: data}
+}
+
+func (a *AttributeData) ToBytes() []byte {
+	return Encode(a, RESP3)
+}
+func (a *AttributeData) Attrs() []RedisData {
+	return a.attrs
+}
+func (a *AttributeData) Data() RedisData {
+	return a.data
+}
+func (a *AttributeData) ByteData() []byte {
+	return a.data.ByteData()
+}
@@ -0,0 +1,20 @@
+package RESP
+
+// BigNumberData is the RESP3 big number, encoded as a bulk string for RESP2 connections
+type BigNumberData struct {
+	data string
+}
+
+func MakeBigNumberData(data string) *BigNumberData {
+	return &BigNumberData{data: data}
+}
+
+func (b *BigNumberData) ToBytes() []byte {
+	return []byte("(" + b.data + CRLF)
+}
+func (b *BigNumberData) Data() string {
+	return b.data
+}
+func (b *BigNumberData) ByteData() []byte {
+	return []byte(b.data)
+}
@@ -0,0 +1,26 @@
+package RESP
+
+// BooleanData is the RESP3 boolean, encoded as integer 1 or 0 for RESP2 connections
+type BooleanData struct {
+	data bool
+}
+
+func MakeBooleanData(data bool) *BooleanData {
+	return &BooleanData{data: data}
+}
+
+func (b *BooleanData) ToBytes() []byte {
+	if b.data {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+func (b *BooleanData) Data() bool {
+	return b.data
+}
+func (b *BooleanData) ByteData() []byte {
+	if b.data {
+		return []byte("1")
+	}
+	return []byte("0")
+}
@@ -12,7 +12,7 @@ func MakeBulkData(data []byte) *BulkData {
 	}
 }
 func MakeNullBulkData() *BulkData {
-	return &BulkData{data: []byte{}}
+	return &BulkData{data: nil}
 }
 func (r *BulkData) ToBytes() []byte {
 	if r.data == nil {
@@ -0,0 +1,35 @@
+package RESP
+
+import (
+	"math"
+	"strconv"
+)
+
+// DoubleData is the RESP3 double, encoded as a bulk string for RESP2 connections
+type DoubleData struct {
+	data float64
+}
+
+func MakeDoubleData(data float64) *DoubleData {
+	return &DoubleData{data: data}
+}
+
+func (d *DoubleData) ToBytes() []byte {
+	return []byte("," + string(d.ByteData()) + CRLF)
+}
+func (d *DoubleData) Data() float64 {
+	return d.data
+}
+
+// ByteData returns the text of the double, inf, -inf and nan are spelled as Redis does
+func (d *DoubleData) ByteData() []byte {
+	switch {
+	case math.IsInf(d.data, 1):
+		return []byte("inf")
+	case math.IsInf(d.data, -1):
+		return []byte("-inf")
+	case math.IsNaN(d.data):
+		return []byte("nan")
+	}
+	return []byte(strconv.FormatFloat(d.data, 'g', -1, 64))
+}
@@ -0,0 +1,21 @@
+package RESP
+
+// MapData is the RESP3 map, data holds the keys and values in turn.
+// It's encoded as a flat array for RESP2 connections.
+type MapData struct {
+	data []RedisData
+}
+
+func MakeMapData(data []RedisData) *MapData {
+	return &MapData{data: data}
+}
+
+func (m *MapData) ToBytes() []byte {
+	return Encode(m, RESP3)
+}
+func (m *MapData) Data() []RedisData {
+	return m.data
+}
+func (m *MapData) ByteData() []byte {
+	return concatByteData(m.data)
+}
@@ -0,0 +1,15 @@
+package RESP
+
+// NullData is the RESP3 null, encoded as $-1 for RESP2 connections
+type NullData struct{}
+
+func MakeNullData() *NullData {
+	return &NullData{}
+}
+
+func (n *NullData) ToBytes() []byte {
+	return []byte("_" + CRLF)
+}
+func (n *NullData) ByteData() []byte {
+	return nil
+}
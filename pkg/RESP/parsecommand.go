@@ -0,0 +1,258 @@
+package RESP
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const (
+	// maxInlineLen is the longest line accepted without LF, such as an inline command or the header of a multi bulk
+	maxInlineLen = 64 << 10
+	// maxMultiBulkLen is the largest number of arguments of a command
+	maxMultiBulkLen = 1024 * 1024
+)
+
+// ErrProtocol is wrapped by the errors of malformed commands, the connection can't be read any further after them
+var ErrProtocol = errors.New("Protocol error")
+
+// Limits bounds the input of a client
+type Limits struct {
+	MaxBulkLen     int64 // proto-max-bulk-len, the largest argument of a command
+	MaxQueryBuffer int64 // client-query-buffer-limit, the largest input buffered for a client, 0 is unlimited
+}
+
+// DefaultLimits are the limits of Redis
+var DefaultLimits = Limits{MaxBulkLen: 512 << 20, MaxQueryBuffer: 1 << 30}
+
+// ParseCommand parses the command at the beginning of buf.
+// It's a multi bulk such as *2\r\n$3\r\nget\r\n$3\r\nkey\r\n, or an inline command such as get key\r\n
+// whose arguments are split by spaces and may be quoted like redis-cli.
+// It returns the arguments and the number of bytes consumed, n is 0 if buf doesn't hold a complete command yet.
+// The arguments point into buf except the quoted inline ones, they are only valid until buf is modified.
+// An empty multi bulk or an empty line is consumed with an empty command.
+func ParseCommand(buf []byte, maxBulkLen int64) (cmd [][]byte, n int, err error) {
+	return parseCommand(buf, nil, maxBulkLen)
+}
+
+// parseCommand is ParseCommand appending the arguments to cmd[:0], a nil cmd is allocated by the count of arguments
+func parseCommand(buf []byte, cmd [][]byte, maxBulkLen int64) ([][]byte, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, nil
+	}
+	if buf[0] != '*' {
+		return parseInline(buf, cmd)
+	}
+	line, pos, err := readCRLF(buf, 0, "too big mbulk count string")
+	if line == nil || err != nil {
+		return nil, 0, err
+	}
+	count, ok := parseLen(line[1:])
+	if !ok || count < -1 || count > maxMultiBulkLen {
+		return nil, 0, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	if cmd == nil {
+		// a huge count may be a lie, the slice grows as the arguments arrive
+		cmd = make([][]byte, 0, min(max(count, 0), 1024))
+	}
+	cmd = cmd[:0]
+	if count <= 0 {
+		return cmd, pos, nil
+	}
+	for i := 0; i < count; i++ {
+		if pos >= len(buf) {
+			return nil, 0, nil
+		}
+		if buf[pos] != '$' {
+			return nil, 0, fmt.Errorf("%w: expected '$', got '%c'", ErrProtocol, buf[pos])
+		}
+		line, pos, err = readCRLF(buf, pos, "too big bulk count string")
+		if line == nil || err != nil {
+			return nil, 0, err
+		}
+		size, ok := parseLen(line[1:])
+		if !ok || size < 0 || int64(size) > maxBulkLen {
+			return nil, 0, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		if len(buf)-pos < size+2 {
+			return nil, 0, nil
+		}
+		if buf[pos+size] != '\r' || buf[pos+size+1] != '\n' {
+			return nil, 0, fmt.Errorf("%w: bulk length doesn't match the data", ErrProtocol)
+		}
+		cmd = append(cmd, buf[pos:pos+size:pos+size])
+		pos += size + 2
+	}
+	return cmd, pos, nil
+}
+
+// readCRLF returns the line starting at pos without CRLF and the position after it,
+// the line is nil if it's not complete yet. tooLong is the error of a line longer than maxInlineLen.
+func readCRLF(buf []byte, pos int, tooLong string) ([]byte, int, error) {
+	i := bytes.IndexByte(buf[pos:], '\n')
+	if i < 0 {
+		if len(buf)-pos > maxInlineLen {
+			return nil, 0, fmt.Errorf("%w: %s", ErrProtocol, tooLong)
+		}
+		return nil, 0, nil
+	}
+	if i == 0 || buf[pos+i-1] != '\r' {
+		return nil, 0, fmt.Errorf("%w: line not terminated by CRLF", ErrProtocol)
+	}
+	return buf[pos : pos+i-1], pos + i + 1, nil
+}
+
+// parseInline parses an inline command terminated by LF, a CR before LF is ignored
+func parseInline(buf []byte, cmd [][]byte) ([][]byte, int, error) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		if len(buf) > maxInlineLen {
+			return nil, 0, fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+		return nil, 0, nil
+	}
+	line := buf[:i]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	cmd, ok := splitArgs(line, cmd[:0])
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+	}
+	return cmd, i + 1, nil
+}
+
+// splitArgs splits line into arguments like sdssplitargs of Redis.
+// An argument is separated by spaces, or quoted by double quotes with escapes such as \n and \x41,
+// or by single quotes where only \' is escaped. A closing quote must be followed by a space or the end of line.
+// The unquoted arguments point into line. It returns false if the quotes are unbalanced.
+func splitArgs(line []byte, args [][]byte) ([][]byte, bool) {
+	pos := 0
+	for {
+		for pos < len(line) && isSpace(line[pos]) {
+			pos++
+		}
+		if pos == len(line) {
+			return args, true
+		}
+		if line[pos] != '"' && line[pos] != '\'' {
+			start := pos
+			for pos < len(line) && !isSpace(line[pos]) {
+				pos++
+			}
+			args = append(args, line[start:pos:pos])
+			continue
+		}
+		quote := line[pos]
+		pos++
+		var arg []byte
+		for {
+			if pos == len(line) {
+				return nil, false
+			}
+			ch := line[pos]
+			switch {
+			case ch == quote:
+				pos++
+				if pos < len(line) && !isSpace(line[pos]) {
+					return nil, false
+				}
+				// an empty quoted argument is not nil
+				args = append(args, append([]byte{}, arg...))
+			case ch == '\\' && quote == '"' && pos+3 < len(line) && line[pos+1] == 'x' && isHex(line[pos+2]) && isHex(line[pos+3]):
+				arg = append(arg, fromHex(line[pos+2])<<4|fromHex(line[pos+3]))
+				pos += 4
+				continue
+			case ch == '\\' && quote == '"' && pos+1 < len(line):
+				pos++
+				switch line[pos] {
+				case 'n':
+					ch = '\n'
+				case 'r':
+					ch = '\r'
+				case 't':
+					ch = '\t'
+				case 'b':
+					ch = '\b'
+				case 'a':
+					ch = '\a'
+				default:
+					ch = line[pos]
+				}
+				arg = append(arg, ch)
+				pos++
+				continue
+			case ch == '\\' && quote == '\'' && pos+1 < len(line) && line[pos+1] == '\'':
+				arg = append(arg, '\'')
+				pos += 2
+				continue
+			default:
+				arg = append(arg, ch)
+				pos++
+				continue
+			}
+			break
+		}
+	}
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\v' || ch == '\f'
+}
+
+func isHex(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func fromHex(ch byte) byte {
+	switch {
+	case ch >= 'a':
+		return ch - 'a' + 10
+	case ch >= 'A':
+		return ch - 'A' + 10
+	}
+	return ch - '0'
+}
+
+// parseLen parses the length of a multi bulk or a bulk header without allocating
+func parseLen(b []byte) (int, bool) {
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	neg := b[0] == '-'
+	if neg {
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, false
+		}
+	}
+	n := 0
+	for _, ch := range b {
+		if ch < '0' || ch > '9' {
+			return 0, false
+		}
+		n = n*10 + int(ch-'0')
+	}
+	if neg {
+		return -n, true
+	}
+	return n, true
+}
+
+// CloneCommand copies the arguments of cmd into a single allocation,
+// so that the command can be kept after the buffer it points into is reused.
+func CloneCommand(cmd [][]byte) [][]byte {
+	size := 0
+	for _, arg := range cmd {
+		size += len(arg)
+	}
+	buf := make([]byte, size)
+	res := make([][]byte, len(cmd))
+	pos := 0
+	for i, arg := range cmd {
+		n := copy(buf[pos:], arg)
+		res[i] = buf[pos : pos+n : pos+n]
+		pos += n
+	}
+	return res
+}
@@ -3,11 +3,13 @@ package RESP
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/hsn/tiny-redis/pkg/config"
 	"github.com/hsn/tiny-redis/pkg/logger"
 	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func init() {
@@ -230,34 +232,38 @@ func TestParseStream(t *testing.T) {
 			}
 			break
 		}
-		array := parseRes.Data.(*ArrayData)
-		if k == 0 { // first array
-			for i, intData := range array.Data() {
-				data := intData.(*IntData)
-				if data.Data() != int64(i+1) || !bytes.Equal(data.ToBytes(), []byte(fmt.Sprintf(":%d\r\n", i+1))) {
-					t.Error("parse nested array error: ")
-					t.Error(fmt.Sprintf("get %v, %v | expect: %v, %v", data.Data(), data.ToBytes(), int64(i+1), []byte(fmt.Sprintf(":%d\r\n", i+1))))
-				}
-			}
+		outer := parseRes.Data.(*ArrayData)
+		if len(outer.Data()) != 2 || !bytes.Equal(outer.ToBytes(), data) {
+			t.Error(fmt.Sprintf("get %v | expect: %v", outer.ToBytes(), data))
 		}
-		if k == 1 { // second array
-			for i, v := range array.Data() {
-				if i == 0 {
-					data := v.(*StringData)
-					if data.Data() != "Hello" || !bytes.Equal(data.ToBytes(), []byte("+Hello\r\n")) {
-						t.Error(fmt.Sprintf("get %s, %v | expect: %s, %v", data.Data(), data.ToBytes(), "Hello", []byte("+Hello\r\n")))
+		for k = range outer.Data() {
+			array := outer.Data()[k].(*ArrayData)
+			if k == 0 { // first array
+				for i, intData := range array.Data() {
+					data := intData.(*IntData)
+					if data.Data() != int64(i+1) || !bytes.Equal(data.ToBytes(), []byte(fmt.Sprintf(":%d\r\n", i+1))) {
+						t.Error("parse nested array error: ")
+						t.Error(fmt.Sprintf("get %v, %v | expect: %v, %v", data.Data(), data.ToBytes(), int64(i+1), []byte(fmt.Sprintf(":%d\r\n", i+1))))
 					}
 				}
-				if i == 1 {
-					data := v.(*ErrorData)
-					if data.Error() != "World" || !bytes.Equal(data.ToBytes(), []byte("-World\r\n")) {
-						t.Error(fmt.Sprintf("get %s, %v | expect: %s, %v", data.Error(), data.ToBytes(), "Hello", []byte("+Hello\r\n")))
+			}
+			if k == 1 { // second array
+				for i, v := range array.Data() {
+					if i == 0 {
+						data := v.(*StringData)
+						if data.Data() != "Hello" || !bytes.Equal(data.ToBytes(), []byte("+Hello\r\n")) {
+							t.Error(fmt.Sprintf("get %s, %v | expect: %s, %v", data.Data(), data.ToBytes(), "Hello", []byte("+Hello\r\n")))
+						}
+					}
+					if i == 1 {
+						data := v.(*ErrorData)
+						if data.Error() != "World" || !bytes.Equal(data.ToBytes(), []byte("-World\r\n")) {
+							t.Error(fmt.Sprintf("get %s, %v | expect: %s, %v", data.Error(), data.ToBytes(), "Hello", []byte("+Hello\r\n")))
+						}
 					}
 				}
 			}
 		}
-		k++
-
 	}
 
 	// test 5: bulk strings
@@ -291,3 +297,269 @@ func TestParseStream(t *testing.T) {
 		k++
 	}
 }
+
+func TestParseStreamResp3(t *testing.T) {
+	data := []byte("_\r\n,1.5\r\n,-inf\r\n#t\r\n(3492890328409238509324850943850943825024385\r\n" +
+		"!21\r\nSYNTAX invalid syntax\r\n=15\r\ntxt:Some string\r\n" +
+		"%2\r\n+first\r\n:1\r\n+second\r\n*2\r\n:2\r\n_\r\n~2\r\n+orange\r\n+apple\r\n" +
+		">3\r\n$7\r\nmessage\r\n$7\r\nchannel\r\n$5\r\nhello\r\n" +
+		"*2\r\n|1\r\n+ttl\r\n:3600\r\n:1\r\n:2\r\n%0\r\n")
+	var res []RedisData
+	for parseRes := range ParseStream(bytes.NewReader(data)) {
+		if parseRes.Err != nil {
+			if parseRes.Err != io.EOF {
+				t.Error(parseRes.Err)
+			}
+			break
+		}
+		res = append(res, parseRes.Data)
+	}
+	if len(res) != 12 {
+		t.Fatalf("get %d values | expect: 12", len(res))
+	}
+	if _, ok := res[0].(*NullData); !ok {
+		t.Errorf("get %T | expect: null", res[0])
+	}
+	if res[1].(*DoubleData).Data() != 1.5 || !bytes.Equal(res[2].ToBytes(), []byte(",-inf\r\n")) {
+		t.Errorf("parse double error: %v, %q", res[1].(*DoubleData).Data(), res[2].ToBytes())
+	}
+	if !res[3].(*BooleanData).Data() {
+		t.Error("parse boolean error")
+	}
+	if res[4].(*BigNumberData).Data() != "3492890328409238509324850943850943825024385" {
+		t.Error("parse big number error")
+	}
+	if res[5].(*ErrorData).Error() != "SYNTAX invalid syntax" {
+		t.Error("parse bulk error error")
+	}
+	if v := res[6].(*VerbatimData); v.Format() != "txt" || string(v.Data()) != "Some string" {
+		t.Errorf("parse verbatim string error: %q, %q", v.Format(), v.Data())
+	}
+	m := res[7].(*MapData)
+	if len(m.Data()) != 4 || len(m.Data()[3].(*ArrayData).Data()) != 2 {
+		t.Errorf("parse map error: %q", m.ToBytes())
+	}
+	if len(res[8].(*SetData).Data()) != 2 {
+		t.Error("parse set error")
+	}
+	if p := res[9].(*PushData); string(p.Data()[0].ByteData()) != "message" {
+		t.Error("parse push error")
+	}
+	// the attribute describes the first element
+	array := res[10].(*ArrayData)
+	if attr, ok := array.Data()[0].(*AttributeData); !ok || len(attr.Attrs()) != 2 || attr.Data().(*IntData).Data() != 1 {
+		t.Errorf("parse attribute error: %q", array.ToBytes())
+	}
+	if len(res[11].(*MapData).Data()) != 0 {
+		t.Error("parse empty map error")
+	}
+
+	// RESP3 values are transferred as they are parsed, except that bulk errors become simple errors
+	var encoded []byte
+	for _, v := range res {
+		encoded = append(encoded, v.ToBytes()...)
+	}
+	expect := bytes.Replace(data, []byte("!21\r\nSYNTAX"), []byte("-SYNTAX"), 1)
+	if !bytes.Equal(encoded, expect) {
+		t.Errorf("get %q | expect: %q", encoded, expect)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	data := []byte("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n*0\r\n*-1\r\n*1\r\n$0\r\n\r\n")
+	cmd, n, err := ParseCommand(data, DefaultLimits.MaxBulkLen)
+	if err != nil || n != 33 || len(cmd) != 3 || string(cmd[0]) != "set" || string(cmd[2]) != "va\r\nl" {
+		t.Fatalf("ParseCommand == %q, %d, %v", cmd, n, err)
+	}
+	// the arguments point into the buffer
+	if &cmd[1][0] != &data[17] || cap(cmd[1]) != 3 {
+		t.Error("the arguments should point into the buffer without sharing the rest of it")
+	}
+	for _, expect := range []int{4, 5} {
+		data = data[n:]
+		if cmd, n, err = ParseCommand(data, DefaultLimits.MaxBulkLen); err != nil || n != expect || len(cmd) != 0 {
+			t.Errorf("empty multi bulk: ParseCommand == %q, %d, %v", cmd, n, err)
+		}
+	}
+	data = data[n:]
+	if cmd, n, err = ParseCommand(data, DefaultLimits.MaxBulkLen); err != nil || n != len(data) || len(cmd) != 1 || len(cmd[0]) != 0 {
+		t.Errorf("empty bulk: ParseCommand == %q, %d, %v", cmd, n, err)
+	}
+
+	// every prefix of a command is incomplete
+	whole := []byte("*2\r\n$3\r\nget\r\n$3\r\nkey\r\n")
+	for i := 0; i < len(whole); i++ {
+		if cmd, n, err = ParseCommand(whole[:i], DefaultLimits.MaxBulkLen); err != nil || n != 0 || cmd != nil {
+			t.Errorf("ParseCommand(%q) == %q, %d, %v | expect: nil, 0, nil", whole[:i], cmd, n, err)
+		}
+	}
+
+	for _, invalid := range []string{
+		"*x\r\n",
+		"*-2\r\n",
+		"*1\n",
+		"*1\r\n:1\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$3\r\nfoobar\r\n",
+		"*1\r\n$1x\r\n",
+	} {
+		if cmd, n, err = ParseCommand([]byte(invalid), DefaultLimits.MaxBulkLen); err == nil || !bytes.HasPrefix([]byte(err.Error()), []byte("Protocol error: ")) {
+			t.Errorf("ParseCommand(%q) == %q, %d, %v | expect: protocol error", invalid, cmd, n, err)
+		}
+	}
+}
+
+// repeatReader reads data n times
+type repeatReader struct {
+	data []byte
+	pos  int
+	n    int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	if r.pos == len(r.data) {
+		r.pos = 0
+		r.n--
+	}
+	return n, nil
+}
+
+func TestReader(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 3*readerBufSize+5)
+	data := []byte("*2\r\n$3\r\nget\r\n$3\r\nkey\r\n*0\r\n*-1\r\n*3\r\n$3\r\nset\r\n$3\r\nbig\r\n$" +
+		fmt.Sprint(len(big)) + "\r\n" + string(big) + "\r\n*1\r\n$4\r\nping\r\n")
+	expect := make([][][]byte, 0)
+	for parseRes := range ParseStream(bytes.NewReader(data)) {
+		if parseRes.Err != nil {
+			break
+		}
+		if cmd := parseRes.Data.(*ArrayData).ToCommand(); len(cmd) > 0 {
+			expect = append(expect, cmd)
+		}
+	}
+	if len(expect) != 3 {
+		t.Fatalf("ParseStream should read 3 commands, but get %d", len(expect))
+	}
+
+	// the commands are the same as parsed by ParseStream, however the input is split
+	for _, rd := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+		reader := NewReader(rd)
+		for _, e := range expect {
+			cmd, err := reader.ReadCommand()
+			if err != nil || len(cmd) != len(e) {
+				t.Fatalf("ReadCommand() == %q, %v | expect: %q", cmd, err, e)
+			}
+			for i := range e {
+				if !bytes.Equal(cmd[i], e[i]) {
+					t.Errorf("ReadCommand() == %q | expect: %q", cmd, e)
+				}
+			}
+		}
+		if cmd, err := reader.ReadCommand(); err != io.EOF {
+			t.Errorf("ReadCommand() == %q, %v | expect: EOF", cmd, err)
+		}
+		if len(reader.buf) != readerBufSize {
+			t.Errorf("the buffer grown for a large command should be replaced, the size is %d", len(reader.buf))
+		}
+		reader.Release()
+	}
+
+	reader := NewReader(bytes.NewReader([]byte("*1\r\n$4\r\npi")))
+	if _, err := reader.ReadCommand(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadCommand() of a truncated command == %v | expect: unexpected EOF", err)
+	}
+	reader = NewReader(bytes.NewReader([]byte("*1\r\n$4\r\nping\r\n*1\r\n+ping\r\n")))
+	if cmd, err := reader.ReadCommand(); err != nil || string(cmd[0]) != "ping" {
+		t.Errorf("ReadCommand() == %q, %v | expect: ping", cmd, err)
+	}
+	if _, err := reader.ReadCommand(); !errors.Is(err, ErrProtocol) || err.Error() != "Protocol error: expected '$', got '+'" {
+		t.Errorf("ReadCommand() of a malformed command == %v | expect: protocol error", err)
+	}
+
+	// reading commands allocates nothing once the buffer is warm
+	reader = NewReader(&repeatReader{data: []byte("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"), n: -1})
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, err := reader.ReadCommand(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("ReadCommand() allocates %v times per command", allocs)
+	}
+}
+
+var benchCommand = []byte("*3\r\n$3\r\nset\r\n$16\r\nkey:000000000001\r\n$64\r\n" + string(bytes.Repeat([]byte("v"), 64)) + "\r\n")
+
+func BenchmarkParseStream(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchCommand)))
+	for parseRes := range ParseStream(&repeatReader{data: benchCommand, n: b.N}) {
+		if parseRes.Err != nil {
+			break
+		}
+		_ = parseRes.Data.(*ArrayData).ToCommand()
+	}
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchCommand)))
+	reader := NewReader(&repeatReader{data: benchCommand, n: b.N})
+	defer reader.Release()
+	for {
+		if _, err := reader.ReadCommand(); err != nil {
+			break
+		}
+	}
+}
+
+func TestParseInlineCommand(t *testing.T) {
+	tests := []struct {
+		data   string
+		expect []string
+		n      int
+	}{
+		{"PING\r\n", []string{"PING"}, 6},
+		{"set a  b\nget a\r\n", []string{"set", "a", "b"}, 9},
+		{`set "a b" 'c\'d' "\x41\n\"" ''` + "\r\n", []string{"set", "a b", "c'd", "A\n\"", ""}, 32},
+		{" \t\r\n", []string{}, 4},
+		{"get a", nil, 0},
+	}
+	for _, test := range tests {
+		cmd, n, err := ParseCommand([]byte(test.data), DefaultLimits.MaxBulkLen)
+		if err != nil || n != test.n || len(cmd) != len(test.expect) {
+			t.Errorf("ParseCommand(%q) == %q, %d, %v | expect: %q, %d", test.data, cmd, n, err, test.expect, test.n)
+			continue
+		}
+		for i := range test.expect {
+			if string(cmd[i]) != test.expect[i] {
+				t.Errorf("ParseCommand(%q) == %q | expect: %q", test.data, cmd, test.expect)
+			}
+		}
+	}
+
+	for data, expect := range map[string]string{
+		"set \"a\r\n":  "Protocol error: unbalanced quotes in request",
+		"set 'a'b\r\n": "Protocol error: unbalanced quotes in request",
+		string(bytes.Repeat([]byte("a"), 65<<10)):        "Protocol error: too big inline request",
+		"*1" + string(bytes.Repeat([]byte("1"), 65<<10)): "Protocol error: too big mbulk count string",
+		"*1\r\n$2000000\r\n":                             "Protocol error: invalid bulk length",
+		"*2000000\r\n":                                   "Protocol error: invalid multibulk length",
+	} {
+		if _, _, err := ParseCommand([]byte(data), 1<<20); err == nil || err.Error() != expect {
+			t.Errorf("ParseCommand(%.20q) error == %v | expect: %s", data, err, expect)
+		}
+	}
+
+	// the query buffer limit bounds an incomplete command
+	reader := NewReader(iotest.OneByteReader(bytes.NewReader([]byte("*2\r\n$4\r\nping\r\n$2000\r\n" + string(bytes.Repeat([]byte("x"), 2000)) + "\r\n"))))
+	reader.SetLimits(Limits{MaxBulkLen: DefaultLimits.MaxBulkLen, MaxQueryBuffer: 1024})
+	if _, err := reader.ReadCommand(); err != ErrQueryBufferLimit {
+		t.Errorf("ReadCommand() of a command over the query buffer limit == %v | expect: %v", err, ErrQueryBufferLimit)
+	}
+}
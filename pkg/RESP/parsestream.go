@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/hsn/tiny-redis/pkg/logger"
 	"io"
+	"math/big"
 	"strconv"
 )
 
@@ -16,11 +17,23 @@ type ParsedRes struct {
 
 // readState 用于跟踪 RESP 数据解析的读取状态。
 type readState struct {
-	bulkLen   int64      // bulkLen 表示当前块数据的长度，用于解析 RESP 块数据。
-	arrayLen  int        // arrayLen 表示当前 RESP 数组的元素数量，用于解析 RESP 数组数据。
-	multiLine bool       // multiLine 指示是否正在读取多行数据。如果为 true，则表示当前解析的是多行块数据。
-	arrayData *ArrayData // arrayData 用于存储 RESP 数组数据的详细信息，它可能包含每个数组元素的类型和值等信息。
-	inArray   bool       // inArray 表示当前是否正在解析 RESP 数组内部。
+	bulkLen   int64       // bulkLen 表示当前块数据的长度，用于解析 RESP 块数据。
+	bulkType  byte        // bulkType 表示当前块数据的类型：'$' 块字符串，'=' verbatim 字符串，'!' 块错误。
+	arrayLen  int         // arrayLen 表示当前 RESP 聚合类型的元素数量，map 和 attribute 的键和值分别计数。
+	multiLine bool        // multiLine 指示是否正在读取多行数据。如果为 true，则表示当前解析的是多行块数据。
+	arrayData *ArrayData  // arrayData 用于存储当前聚合类型已经读取的元素。
+	inArray   bool        // inArray 表示当前是否正在解析 RESP 聚合类型内部。
+	arrayType byte        // arrayType 表示当前聚合类型：'*' 数组，'%' map，'~' set，'>' push，'|' attribute。
+	parents   []aggregate // parents 保存嵌套时外层尚未读完的聚合类型。
+	attrs     []RedisData // attrs 是等待附加到下一个值上的 attribute。
+	attrDepth int         // attrDepth 是 attrs 所在的嵌套深度。
+}
+
+// aggregate 是嵌套时外层尚未读完的聚合类型。
+type aggregate struct {
+	arrayLen  int
+	arrayData *ArrayData
+	arrayType byte
 }
 
 func ParseStream(reader io.Reader) <-chan *ParsedRes {
@@ -36,6 +49,18 @@ func ParseStream(reader io.Reader) <-chan *ParsedRes {
 // IntReply: :123456\r\n
 // BulkReply: $11\r\nhello world\r\n
 // ArrayReply: *3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n
+// RESP3
+// NullReply: _\r\n
+// DoubleReply: ,1.23\r\n
+// BooleanReply: #t\r\n
+// BigNumberReply: (3492890328409238509324850943850943825024385\r\n
+// BulkErrorReply: !21\r\nSYNTAX invalid syntax\r\n
+// VerbatimReply: =15\r\ntxt:Some string\r\n
+// MapReply: %2\r\n+first\r\n:1\r\n+second\r\n:2\r\n
+// SetReply: ~2\r\n+orange\r\n+apple\r\n
+// PushReply: >3\r\n$7\r\nmessage\r\n$7\r\nchannel\r\n$5\r\nhello\r\n
+// AttributeReply: |1\r\n+ttl\r\n:3600\r\n followed by the value it describes
+// Aggregate types can be nested.
 func parse(reader io.Reader, ch chan<- *ParsedRes) {
 	bufReader := bufio.NewReader(reader)
 	state := new(readState)
@@ -57,72 +82,40 @@ func parse(reader io.Reader, ch chan<- *ParsedRes) {
 			continue
 		}
 		// parse the read messages
-		// if msg is an array or a bulk string, then parse their header first.
+		// if msg is the header of an aggregate or a bulk string, then parse the header first,
+		// the aggregate or the bulk string is complete after its elements or its bulk line are read.
 		// if msg is a normal line, parse it directly.
 		if !state.multiLine {
-			if msg[0] == '*' {
-				err := parseArrayHeader(msg, state)
-				if err != nil {
-					logger.Error(err)
-					ch <- &ParsedRes{
-						Err: err,
-					}
-					*state = readState{}
-				} else {
-					if state.arrayLen == -1 {
-						// null array
-						ch <- &ParsedRes{
-							Data: MakeArrayData(nil),
-						}
-						*state = readState{}
-					} else if state.arrayLen == 0 {
-						// empty array
-						ch <- &ParsedRes{
-							Data: MakeArrayData([]RedisData{}),
-						}
-						*state = readState{}
+			switch msg[0] {
+			case '*', '%', '~', '>', '|':
+				if err = parseArrayHeader(msg, state); err == nil {
+					if state.arrayLen > 0 {
+						continue
 					}
+					// null or empty aggregate
+					res = finishAggregate(state)
 				}
-				continue
-			}
-			if msg[0] == '$' {
-				err := parseBulkHeader(msg, state)
-				if err != nil {
-					logger.Error(err)
-					ch <- &ParsedRes{
-						Err: err,
-					}
-					*state = readState{}
-
-				} else {
-					if state.bulkLen == -1 {
-						state.multiLine = false
-						state.bulkLen = 0
-						res = MakeNullBulkData()
-						if state.inArray {
-							state.arrayData.data = append(state.arrayData.data, res)
-							if len(state.arrayData.data) == state.arrayLen {
-								ch <- &ParsedRes{
-									Data: state.arrayData,
-									Err:  err,
-								}
-								*state = readState{}
-							}
-						} else {
-							ch <- &ParsedRes{
-								Data: res,
-							}
-						}
-
+			case '$', '=', '!':
+				if err = parseBulkHeader(msg, state); err == nil {
+					if state.bulkLen != -1 {
+						continue
 					}
+					// null bulk string
+					state.multiLine = false
+					state.bulkLen = 0
+					res = MakeBulkData(nil)
 				}
-				continue
+			default:
+				res, err = parseSingleLine(msg)
 			}
-			res, err = parseSingleLine(msg)
 		} else {
 			state.multiLine = false
 			state.bulkLen = 0
-			res, err = parseMultiLine(msg)
+			if state.bulkType == '$' {
+				res, err = parseMultiLine(msg)
+			} else {
+				res, err = parseBlobLine(msg, state.bulkType)
+			}
 		}
 		if err != nil {
 			logger.Error(err)
@@ -132,24 +125,73 @@ func parse(reader io.Reader, ch chan<- *ParsedRes) {
 			*state = readState{}
 			continue
 		}
-		if state.inArray {
-			state.arrayData.data = append(state.arrayData.data, res)
-			if len(state.arrayData.data) == state.arrayLen {
-				ch <- &ParsedRes{
-					Data: state.arrayData,
-					Err:  nil,
-				}
-				*state = readState{}
-			}
-		} else {
+		if res = appendElement(state, res); res != nil {
 			ch <- &ParsedRes{
 				Data: res,
-				Err:  err,
 			}
 		}
 	}
 }
 
+// appendElement adds a complete value to the aggregate being read.
+// It returns the top level value once it's complete, otherwise nil.
+func appendElement(state *readState, res RedisData) RedisData {
+	for {
+		depth := len(state.parents)
+		if state.inArray {
+			depth++
+		}
+		if attr, ok := res.(*AttributeData); ok && attr.data == nil {
+			// an attribute is not an element, it describes the next value at the same depth
+			state.attrs, state.attrDepth = attr.attrs, depth
+			return nil
+		}
+		if state.attrs != nil && state.attrDepth == depth {
+			res = MakeAttributeData(state.attrs, res)
+			state.attrs = nil
+		}
+		if !state.inArray {
+			return res
+		}
+		state.arrayData.data = append(state.arrayData.data, res)
+		if len(state.arrayData.data) < state.arrayLen {
+			return nil
+		}
+		res = finishAggregate(state)
+	}
+}
+
+// finishAggregate makes the value of the aggregate whose elements are all read, and resumes reading its parent.
+// The value of an attribute is nil until the value it describes is read.
+func finishAggregate(state *readState) RedisData {
+	var res RedisData
+	data := state.arrayData.data
+	switch state.arrayType {
+	case '%':
+		res = MakeMapData(data)
+	case '~':
+		res = MakeSetData(data)
+	case '>':
+		res = MakePushData(data)
+	case '|':
+		res = MakeAttributeData(data, nil)
+	default:
+		if state.arrayLen == -1 {
+			res = MakeArrayData(nil)
+		} else {
+			res = state.arrayData
+		}
+	}
+	if n := len(state.parents); n > 0 {
+		parent := state.parents[n-1]
+		state.parents = state.parents[:n-1]
+		state.arrayLen, state.arrayData, state.arrayType = parent.arrayLen, parent.arrayData, parent.arrayType
+	} else {
+		state.arrayLen, state.arrayData, state.arrayType, state.inArray = 0, nil, 0, false
+	}
+	return res
+}
+
 // Read a line or bulk line end of "\r\n" from a reader.
 // Return:
 //
@@ -175,7 +217,7 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, error) {
 		if err != nil {
 			return msg, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
+		if len(msg) < 2 || msg[len(msg)-2] != '\r' {
 			return nil, errors.New(fmt.Sprintf("Protocol error. Stream message %s is invalid.", string(msg)))
 		}
 	}
@@ -202,6 +244,31 @@ func parseSingleLine(msg []byte) (RedisData, error) {
 			return nil, err
 		}
 		res = MakeIntData(data)
+	case '_':
+		// null
+		if msgData != "" {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeNullData()
+	case ',':
+		// double
+		data, err := strconv.ParseFloat(msgData, 64)
+		if err != nil {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeDoubleData(data)
+	case '#':
+		// boolean
+		if msgData != "t" && msgData != "f" {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeBooleanData(msgData == "t")
+	case '(':
+		// big number
+		if _, ok := new(big.Int).SetString(msgData, 10); !ok {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeBigNumberData(msgData)
 	default:
 		// plain string
 		res = MakePlainData(msgData)
@@ -212,12 +279,23 @@ func parseSingleLine(msg []byte) (RedisData, error) {
 	}
 	return res, nil
 }
+
+// parseArrayHeader parses the header of an aggregate, the aggregate being read becomes its parent.
+// Only arrays can be null.
 func parseArrayHeader(msg []byte, state *readState) error {
 	arrayLen, err := strconv.Atoi(string(msg[1 : len(msg)-2]))
-	if err != nil || arrayLen < -1 {
+	if err != nil || arrayLen < -1 || (arrayLen == -1 && msg[0] != '*') {
 		return errors.New("Protocol error: " + string(msg))
 	}
+	if msg[0] == '%' || msg[0] == '|' {
+		// keys and values
+		arrayLen *= 2
+	}
+	if state.inArray {
+		state.parents = append(state.parents, aggregate{state.arrayLen, state.arrayData, state.arrayType})
+	}
 	state.arrayLen = arrayLen
+	state.arrayType = msg[0]
 	state.inArray = true
 	state.arrayData = MakeArrayData([]RedisData{})
 	return nil
@@ -228,6 +306,7 @@ func parseBulkHeader(msg []byte, state *readState) error {
 		return errors.New("Protocol error: " + string(msg))
 	}
 	state.bulkLen = bulkLen
+	state.bulkType = msg[0]
 	state.multiLine = true
 	return nil
 }
@@ -240,3 +319,20 @@ func parseMultiLine(msg []byte) (RedisData, error) {
 	res := MakeBulkData(msgData)
 	return res, nil
 }
+
+// parseBlobLine parses the bulk line of a verbatim string or a bulk error
+func parseBlobLine(msg []byte, bulkType byte) (RedisData, error) {
+	// discard "\r\n"
+	if len(msg) < 2 {
+		return nil, errors.New("protocol error: invalid bulk string")
+	}
+	msgData := msg[:len(msg)-2]
+	if bulkType == '!' {
+		return MakeErrorData(string(msgData)), nil
+	}
+	// verbatim string starts with the format of three bytes and a colon
+	if len(msgData) < 4 || msgData[3] != ':' {
+		return nil, errors.New("protocol error: invalid verbatim string")
+	}
+	return MakeVerbatimData(string(msgData[:3]), msgData[4:]), nil
+}
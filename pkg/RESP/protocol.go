@@ -0,0 +1,112 @@
+package RESP
+
+import "strconv"
+
+// Protocol versions negotiated by HELLO
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Encode returns the transfer format of data for a connection speaking protocol proto.
+// The RESP3 types are downgraded to their RESP2 equivalents for RESP2 connections,
+// and null bulk strings and null arrays become the RESP3 null for RESP3 connections.
+func Encode(data RedisData, proto int) []byte {
+	return appendData(nil, data, proto)
+}
+
+// AppendEncode appends the transfer format of data for protocol proto to buf,
+// the common replies are encoded without allocating a buffer of their own.
+func AppendEncode(buf []byte, data RedisData, proto int) []byte {
+	return appendData(buf, data, proto)
+}
+
+func appendData(buf []byte, data RedisData, proto int) []byte {
+	switch d := data.(type) {
+	case *BulkData:
+		if d.data == nil {
+			if proto == RESP3 {
+				return append(buf, "_"+CRLF...)
+			}
+			return append(buf, "$-1"+CRLF...)
+		}
+		buf = appendHeader(buf, '$', len(d.data))
+		buf = append(buf, d.data...)
+		return append(buf, CRLF...)
+	case *StringData:
+		buf = append(buf, '+')
+		buf = append(buf, d.data...)
+		return append(buf, CRLF...)
+	case *ErrorData:
+		buf = append(buf, '-')
+		buf = append(buf, d.data...)
+		return append(buf, CRLF...)
+	case *IntData:
+		buf = append(buf, ':')
+		buf = strconv.AppendInt(buf, d.data, 10)
+		return append(buf, CRLF...)
+	case *ArrayData:
+		if d.data == nil {
+			if proto == RESP3 {
+				return append(buf, "_"+CRLF...)
+			}
+			return append(buf, "*-1"+CRLF...)
+		}
+		return appendAggregate(buf, '*', len(d.data), d.data, proto)
+	case *MapData:
+		if proto == RESP2 {
+			return appendAggregate(buf, '*', len(d.data), d.data, proto)
+		}
+		return appendAggregate(buf, '%', len(d.data)/2, d.data, proto)
+	case *SetData:
+		if proto == RESP2 {
+			return appendAggregate(buf, '*', len(d.data), d.data, proto)
+		}
+		return appendAggregate(buf, '~', len(d.data), d.data, proto)
+	case *PushData:
+		if proto == RESP2 {
+			return appendAggregate(buf, '*', len(d.data), d.data, proto)
+		}
+		return appendAggregate(buf, '>', len(d.data), d.data, proto)
+	case *AttributeData:
+		if proto == RESP3 {
+			buf = appendAggregate(buf, '|', len(d.attrs)/2, d.attrs, proto)
+		}
+		return appendData(buf, d.data, proto)
+	case *NullData:
+		if proto == RESP2 {
+			return append(buf, "$-1"+CRLF...)
+		}
+	case *DoubleData, *BigNumberData, *VerbatimData:
+		if proto == RESP2 {
+			return append(buf, MakeBulkData(d.ByteData()).ToBytes()...)
+		}
+	case *BooleanData:
+		if proto == RESP2 {
+			return append(buf, ":"+string(d.ByteData())+CRLF...)
+		}
+	}
+	return append(buf, data.ToBytes()...)
+}
+
+func appendAggregate(buf []byte, prefix byte, n int, data []RedisData, proto int) []byte {
+	buf = appendHeader(buf, prefix, n)
+	for _, v := range data {
+		buf = appendData(buf, v, proto)
+	}
+	return buf
+}
+
+func concatByteData(data []RedisData) []byte {
+	res := make([]byte, 0)
+	for _, v := range data {
+		res = append(res, v.ByteData()...)
+	}
+	return res
+}
+
+func appendHeader(buf []byte, prefix byte, n int) []byte {
+	buf = append(buf, prefix)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, CRLF...)
+}
@@ -0,0 +1,53 @@
+package RESP
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		data  RedisData
+		resp2 string
+		resp3 string
+	}{
+		{MakeBulkData(nil), "$-1\r\n", "_\r\n"},
+		{MakeArrayData(nil), "*-1\r\n", "_\r\n"},
+		{MakeNullData(), "$-1\r\n", "_\r\n"},
+		{MakeDoubleData(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{MakeDoubleData(math.Inf(1)), "$3\r\ninf\r\n", ",inf\r\n"},
+		{MakeBooleanData(false), ":0\r\n", "#f\r\n"},
+		{MakeBigNumberData("12345678901234567890"), "$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{MakeVerbatimData("txt", []byte("hi")), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{
+			MakeMapData([]RedisData{MakeBulkData([]byte("a")), MakeDoubleData(2)}),
+			"*2\r\n$1\r\na\r\n$1\r\n2\r\n",
+			"%1\r\n$1\r\na\r\n,2\r\n",
+		},
+		{MakeSetData([]RedisData{MakeIntData(1)}), "*1\r\n:1\r\n", "~1\r\n:1\r\n"},
+		{
+			MakePushData([]RedisData{MakeBulkData([]byte("invalidate")), MakeArrayData(nil)}),
+			"*2\r\n$10\r\ninvalidate\r\n*-1\r\n",
+			">2\r\n$10\r\ninvalidate\r\n_\r\n",
+		},
+		{
+			MakeAttributeData([]RedisData{MakeStringData("ttl"), MakeIntData(1)}, MakeIntData(2)),
+			":2\r\n",
+			"|1\r\n+ttl\r\n:1\r\n:2\r\n",
+		},
+		{
+			MakeArrayData([]RedisData{MakeBooleanData(true), MakeStringData("OK")}),
+			"*2\r\n:1\r\n+OK\r\n",
+			"*2\r\n#t\r\n+OK\r\n",
+		},
+	}
+	for _, test := range tests {
+		if res := Encode(test.data, RESP2); !bytes.Equal(res, []byte(test.resp2)) {
+			t.Errorf("Encode(%T, 2) == %q | expect: %q", test.data, res, test.resp2)
+		}
+		if res := Encode(test.data, RESP3); !bytes.Equal(res, []byte(test.resp3)) {
+			t.Errorf("Encode(%T, 3) == %q | expect: %q", test.data, res, test.resp3)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package RESP
+
+// PushData is the RESP3 out-of-band push, like pub/sub messages and invalidation messages.
+// It's encoded as an array for RESP2 connections.
+type PushData struct {
+	data []RedisData
+}
+
+func MakePushData(data []RedisData) *PushData {
+	return &PushData{data: data}
+}
+
+func (p *PushData) ToBytes() []byte {
+	return Encode(p, RESP3)
+}
+func (p *PushData) Data() []RedisData {
+	return p.data
+}
+func (p *PushData) ByteData() []byte {
+	return concatByteData(p.data)
+}
@@ -0,0 +1,127 @@
+package RESP
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// readerBufSize is the initial size of the buffer of a Reader, it grows for the commands larger than it
+const readerBufSize = 16 << 10
+
+// ErrQueryBufferLimit is returned when a command is larger than client-query-buffer-limit
+var ErrQueryBufferLimit = errors.New("max query buffer length reached")
+
+// bufPool and cmdPool keep the buffers and the command slices of the released readers for the new connections
+var (
+	bufPool = sync.Pool{New: func() any { return make([]byte, readerBufSize) }}
+	cmdPool = sync.Pool{New: func() any { return make([][]byte, 0, 16) }}
+)
+
+// Reader reads commands from a stream synchronously, unlike ParseStream it needs no goroutine and no channel.
+// The input is read into a reusable buffer, and a command is parsed in place without copying its arguments.
+type Reader struct {
+	rd  io.Reader
+	buf []byte
+	r   int // start of the unparsed input
+	w   int // end of the input read into buf
+	cmd [][]byte
+	err error // read error returned once the buffered commands are consumed
+
+	limits Limits
+}
+
+// NewReader returns a Reader reading commands from rd
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{
+		rd:     rd,
+		buf:    bufPool.Get().([]byte),
+		cmd:    cmdPool.Get().([][]byte),
+		limits: DefaultLimits,
+	}
+}
+
+// SetLimits changes the limits of the commands read afterwards
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// ReadCommand returns the next multi bulk or inline command, the empty ones are skipped.
+// The arguments point into the buffer of the reader and the command slice is reused,
+// both are only valid until the next call, use CloneCommand to keep a command.
+// It returns io.EOF at the end of the input, io.ErrUnexpectedEOF if the input ends in the middle of a command,
+// an error wrapping ErrProtocol if the input is malformed, and ErrQueryBufferLimit if a command is too large.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		if r.r < r.w {
+			cmd, n, err := parseCommand(r.buf[r.r:r.w], r.cmd, r.limits.MaxBulkLen)
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				r.r += n
+				if len(cmd) == 0 {
+					continue
+				}
+				r.cmd = cmd
+				return cmd, nil
+			}
+			if r.limits.MaxQueryBuffer > 0 && int64(r.w-r.r) >= r.limits.MaxQueryBuffer {
+				return nil, ErrQueryBufferLimit
+			}
+		}
+		if r.err != nil {
+			if r.err == io.EOF && r.r < r.w {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, r.err
+		}
+		r.fill()
+	}
+}
+
+// Buffered returns the number of bytes read from the stream but not parsed yet
+func (r *Reader) Buffered() int {
+	return r.w - r.r
+}
+
+// fill reads more input, the buffer is compacted or grown if it's full
+func (r *Reader) fill() {
+	if r.r == r.w {
+		r.r, r.w = 0, 0
+		// the buffer grown for a large command is replaced once the command is consumed
+		if len(r.buf) > readerBufSize {
+			r.buf = bufPool.Get().([]byte)
+		}
+	} else if r.r > 0 {
+		r.w = copy(r.buf, r.buf[r.r:r.w])
+		r.r = 0
+	}
+	if r.w == len(r.buf) {
+		buf := make([]byte, 2*len(r.buf))
+		copy(buf, r.buf[:r.w])
+		r.buf = buf
+	}
+	n, err := r.rd.Read(r.buf[r.w:])
+	r.w += n
+	if err != nil {
+		r.err = err
+	}
+}
+
+// Release returns the buffer and the command slice to the pools, the reader can't be used any more
+func (r *Reader) Release() {
+	if len(r.buf) == readerBufSize {
+		bufPool.Put(r.buf)
+	}
+	if cap(r.cmd) <= 1024 {
+		// the arguments of the last command would keep the buffer alive
+		clear(r.cmd[:cap(r.cmd)])
+		cmdPool.Put(r.cmd[:0])
+	}
+	r.buf, r.cmd = nil, nil
+	r.r, r.w = 0, 0
+	if r.err == nil {
+		r.err = io.ErrClosedPipe
+	}
+}
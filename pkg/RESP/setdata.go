@@ -0,0 +1,20 @@
+package RESP
+
+// SetData is the RESP3 set, encoded as an array for RESP2 connections
+type SetData struct {
+	data []RedisData
+}
+
+func MakeSetData(data []RedisData) *SetData {
+	return &SetData{data: data}
+}
+
+func (s *SetData) ToBytes() []byte {
+	return Encode(s, RESP3)
+}
+func (s *SetData) Data() []RedisData {
+	return s.data
+}
+func (s *SetData) ByteData() []byte {
+	return concatByteData(s.data)
+}
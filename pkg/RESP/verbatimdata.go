@@ -0,0 +1,27 @@
+package RESP
+
+import "strconv"
+
+// VerbatimData is the RESP3 verbatim string, format is a three bytes type like txt or mkd.
+// It's encoded as a bulk string of data for RESP2 connections.
+type VerbatimData struct {
+	format string
+	data   []byte
+}
+
+func MakeVerbatimData(format string, data []byte) *VerbatimData {
+	return &VerbatimData{format: format, data: data}
+}
+
+func (v *VerbatimData) ToBytes() []byte {
+	return []byte("=" + strconv.Itoa(len(v.format)+1+len(v.data)) + CRLF + v.format + ":" + string(v.data) + CRLF)
+}
+func (v *VerbatimData) Format() string {
+	return v.format
+}
+func (v *VerbatimData) Data() []byte {
+	return v.data
+}
+func (v *VerbatimData) ByteData() []byte {
+	return v.data
+}
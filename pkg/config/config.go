@@ -6,9 +6,12 @@ import (
 	"io"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/hsn/tiny-redis/pkg/util"
 	"github.com/spf13/cobra"
 )
 
@@ -24,8 +27,45 @@ var (
 	DefaultLogDir   = "./"
 	DefaultLogLevel = "info"
 	DefaultShardNum = 1024
+	DefaultNodeID   = "node"
+	DefaultAofFile  = "aof"
+
+	DefaultShutdownTimeout    = 10
+	DefaultTlsAuthClients     = "yes"
+	DefaultTlsAuthClientsUser = "off"
+	DefaultReactor            = "goroutine"
+
+	DefaultMaxClients   = 10000
+	DefaultTcpKeepalive = 300
+	DefaultTcpBacklog   = 511
+
+	DefaultProtoMaxBulkLen        int64 = 512 * 1024 * 1024
+	DefaultClientQueryBufferLimit int64 = 1024 * 1024 * 1024
+
+	DefaultSlowlogLogSlowerThan int64 = 10000
+	DefaultSlowlogMaxLen              = 128
+
+	DefaultLatencyTracking    = true
+	DefaultLatencyPercentiles = []float64{50, 99, 99.9}
+
+	DefaultClientOutputBufferLimit = map[string]OutputBufferLimit{
+		"normal":  {},
+		"replica": {Hard: 256 * 1024 * 1024, Soft: 64 * 1024 * 1024, SoftSeconds: 60},
+		"pubsub":  {Hard: 32 * 1024 * 1024, Soft: 8 * 1024 * 1024, SoftSeconds: 60},
+	}
 )
 
+// OutputBufferClasses are the classes of clients limited by client-output-buffer-limit
+var OutputBufferClasses = []string{"normal", "replica", "pubsub"}
+
+// OutputBufferLimit bounds the output buffered for a class of clients, a limit of 0 is disabled.
+// A client is closed once its output reaches Hard, or stays at Soft or above for SoftSeconds.
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
+
 type Config struct {
 	ConfFile string
 	Host     string
@@ -33,6 +73,51 @@ type Config struct {
 	LogDir   string
 	LogLevel string
 	ShardNum int
+	AofFile  string   // Path of the AOF file the writes are appended to and loaded from at startup
+	NodeID   string   // Name of this node in active-active replication
+	Peers    []string // Addresses of the active-active peers, empty disables replication
+
+	MasterUser string // ACL user the replication links authenticate as on the peers, empty is the default user
+	MasterAuth string // Password the replication links authenticate with on the peers, empty doesn't authenticate
+
+	NotifyKeyspaceEvents string // Classes of keyspace events published to pub/sub, empty disables notifications
+	ShutdownTimeout      int    // Seconds SHUTDOWN waits for the peers to receive all writes, 0 doesn't wait
+
+	RequirePass string // Password of the default user, empty means no password
+	AclFile     string // File of ACL users loaded at startup and by ACL LOAD, saved by ACL SAVE
+
+	TlsPort            int    // Port of the TLS listener, 0 disables TLS
+	TlsCertFile        string // Certificate of the server
+	TlsKeyFile         string // Private key of the server certificate
+	TlsCaCertFile      string // CA certificates verifying the client certificates
+	TlsAuthClients     string // yes requires a client certificate, optional verifies it if given, no doesn't ask for it
+	TlsAuthClientsUser string // CN authenticates a client as the ACL user named by its certificate CN, off disables it
+
+	UnixSocket     string      // Path of the Unix domain socket listener, empty disables it
+	UnixSocketPerm os.FileMode // Permissions of the socket file, 0 keeps the ones given by umask
+
+	MetricsPort int // Port of the HTTP listener serving the Prometheus metrics on /metrics, 0 disables it
+
+	Reactor      string // goroutine serves each connection in its own goroutine, epoll serves them by event loops (Linux only)
+	ReactorLoops int    // Number of epoll event loops, 0 uses GOMAXPROCS
+
+	MaxClients   int // Largest number of connected clients, the new connections beyond it are rejected
+	Timeout      int // Seconds a client may stay idle before it's closed, 0 never closes it
+	TcpKeepalive int // Seconds between the TCP keepalive probes of the client connections, 0 disables them
+	TcpBacklog   int // Length of the queue of the pending connections of the listeners
+
+	ProtoMaxBulkLen        int64 // Largest argument of a command in bytes
+	ClientQueryBufferLimit int64 // Largest input buffered for a client in bytes, the client is closed beyond it
+
+	// Limits of the output buffered for the clients by class, the map is replaced rather than modified
+	ClientOutputBufferLimit map[string]OutputBufferLimit
+
+	SlowlogLogSlowerThan int64 // Microseconds a command must run to be logged by SLOWLOG, 0 logs every command and a negative value none
+	SlowlogMaxLen        int   // Largest number of entries kept by SLOWLOG, the oldest are dropped beyond it
+
+	LatencyMonitorThreshold int64     // Milliseconds an event must last to be sampled by LATENCY, 0 disables the monitor
+	LatencyTracking         bool      // Whether the latency histograms of the commands are kept
+	LatencyPercentiles      []float64 // Percentiles of the histograms reported by INFO latencystats
 }
 type CfgError struct {
 	message string
@@ -43,31 +128,55 @@ func (err *CfgError) Error() string {
 }
 
 func Setup(cmd *cobra.Command) (*Config, error) {
-	cfg := &Config{
-		Host:     DefaultHost,
-		Port:     DefaultPort,
-		LogDir:   DefaultLogDir,
-		LogLevel: DefaultLogLevel,
-		ShardNum: DefaultShardNum,
-	}
+	cfg := NewDefaultConfig()
 	var err error
 	if err = cmd.ParseFlags(os.Args[1:]); err != nil {
 		return nil, err
 	}
-	if cfg.Host, err = cmd.Flags().GetString("host"); err != nil {
-		return nil, fmt.Errorf("failed to parse host flag: %w", err)
+	if cfg.ConfFile, err = cmd.Flags().GetString("config"); err != nil {
+		return nil, fmt.Errorf("failed to parse config flag: %w", err)
 	}
-	if cfg.Port, err = cmd.Flags().GetInt("port"); err != nil {
-		return nil, fmt.Errorf("failed to parse port flag: %w", err)
+	if cfg.ConfFile != "" {
+		if err = cfg.Parse(cfg.ConfFile); err != nil {
+			return nil, err
+		}
 	}
-	if cfg.LogDir, err = cmd.Flags().GetString("logdir"); err != nil {
-		return nil, fmt.Errorf("failed to parse logdir flag: %w", err)
+	// flags given on the command line override the config file
+	flags := cmd.Flags()
+	if flags.Changed("host") || cfg.ConfFile == "" {
+		if cfg.Host, err = flags.GetString("host"); err != nil {
+			return nil, fmt.Errorf("failed to parse host flag: %w", err)
+		}
+	}
+	if flags.Changed("port") || cfg.ConfFile == "" {
+		if cfg.Port, err = flags.GetInt("port"); err != nil {
+			return nil, fmt.Errorf("failed to parse port flag: %w", err)
+		}
+	}
+	if flags.Changed("logdir") || cfg.ConfFile == "" {
+		if cfg.LogDir, err = flags.GetString("logdir"); err != nil {
+			return nil, fmt.Errorf("failed to parse logdir flag: %w", err)
+		}
+	}
+	if flags.Changed("loglevel") || cfg.ConfFile == "" {
+		if cfg.LogLevel, err = flags.GetString("loglevel"); err != nil {
+			return nil, fmt.Errorf("failed to parse loglevel flag: %w", err)
+		}
+	}
+	if flags.Changed("shardnum") || cfg.ConfFile == "" {
+		if cfg.ShardNum, err = flags.GetInt("shardnum"); err != nil {
+			return nil, fmt.Errorf("failed to parse shardnum flag: %w", err)
+		}
 	}
-	if cfg.LogLevel, err = cmd.Flags().GetString("loglevel"); err != nil {
-		return nil, fmt.Errorf("failed to parse loglevel flag: %w", err)
+	if flags.Changed("node-id") || cfg.ConfFile == "" {
+		if cfg.NodeID, err = flags.GetString("node-id"); err != nil {
+			return nil, fmt.Errorf("failed to parse node-id flag: %w", err)
+		}
 	}
-	if cfg.ShardNum, err = cmd.Flags().GetInt("shardnum"); err != nil {
-		return nil, fmt.Errorf("failed to parse shardnum flag: %w", err)
+	if flags.Changed("peers") || cfg.ConfFile == "" {
+		if cfg.Peers, err = flags.GetStringSlice("peers"); err != nil {
+			return nil, fmt.Errorf("failed to parse peers flag: %w", err)
+		}
 	}
 	Configures = cfg
 	return cfg, nil
@@ -99,44 +208,671 @@ func (cfg *Config) Parse(cfgFile string) error {
 
 		fields := strings.Fields(line)
 		if len(fields) >= 2 {
-			cfgName := strings.ToLower(fields[0])
-			if cfgName == "host" {
-				if ip := net.ParseIP(fields[1]); ip == nil {
-					ipErr := &CfgError{
-						message: fmt.Sprintf("Given ip address %s is invalid", cfg.Host),
+			if err := cfg.set(strings.ToLower(fields[0]), fields[1:]); err != nil {
+				return err
+			}
+		}
+		if ioErr == io.EOF {
+			break
+		}
+	}
+	return nil
+}
+
+// param is a parameter of the config file.
+// Mutable parameters can be changed by CONFIG SET at runtime.
+type param struct {
+	name    string
+	mutable bool
+	get     func(cfg *Config) string
+	set     func(cfg *Config, args []string) error
+}
+
+// mu guards the mutable parameters of Configures
+var mu sync.RWMutex
+
+var params = []*param{
+	{
+		name: "host",
+		get:  func(cfg *Config) string { return cfg.Host },
+		set: func(cfg *Config, args []string) error {
+			if ip := net.ParseIP(args[0]); ip == nil {
+				return &CfgError{
+					message: fmt.Sprintf("Given ip address %s is invalid", args[0]),
+				}
+			}
+			cfg.Host = args[0]
+			return nil
+		},
+	},
+	{
+		name: "port",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.Port) },
+		set: func(cfg *Config, args []string) error {
+			port, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			// 0 disables the plain TCP listener, such as when only TLS is allowed
+			if port != 0 && (port <= 1024 || port >= 65535) {
+				return &CfgError{
+					message: fmt.Sprintf("Listening port should be between 1024 and 65535, but %d is given.", port),
+				}
+			}
+			cfg.Port = port
+			return nil
+		},
+	},
+	{
+		name: "logdir",
+		get:  func(cfg *Config) string { return cfg.LogDir },
+		set: func(cfg *Config, args []string) error {
+			cfg.LogDir = strings.ToLower(args[0])
+			return nil
+		},
+	},
+	{
+		name: "loglevel",
+		get:  func(cfg *Config) string { return cfg.LogLevel },
+		set: func(cfg *Config, args []string) error {
+			cfg.LogLevel = strings.ToLower(args[0])
+			return nil
+		},
+	},
+	{
+		name: "shardnum",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.ShardNum) },
+		set: func(cfg *Config, args []string) error {
+			shardNum, err := strconv.Atoi(args[0])
+			if err != nil {
+				return &CfgError{
+					message: fmt.Sprintf("ShardNum should be a number. Get: %s", args[0]),
+				}
+			}
+			cfg.ShardNum = shardNum
+			return nil
+		},
+	},
+	{
+		name: "appendfilename",
+		get:  func(cfg *Config) string { return cfg.AofFile },
+		set: func(cfg *Config, args []string) error {
+			cfg.AofFile = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name: "node-id",
+		get:  func(cfg *Config) string { return cfg.NodeID },
+		set: func(cfg *Config, args []string) error {
+			cfg.NodeID = args[0]
+			return nil
+		},
+	},
+	{
+		name: "peers",
+		get:  func(cfg *Config) string { return strings.Join(cfg.Peers, ",") },
+		set: func(cfg *Config, args []string) error {
+			cfg.Peers = cfg.Peers[:0]
+			for _, field := range args {
+				for _, addr := range strings.Split(field, ",") {
+					if addr != "" {
+						cfg.Peers = append(cfg.Peers, addr)
 					}
-					return ipErr
 				}
-				cfg.Host = fields[1]
-			} else if cfgName == "port" {
-				port, err := strconv.Atoi(fields[1])
-				if err != nil {
-					return err
+			}
+			return nil
+		},
+	},
+	{
+		name:    "masteruser",
+		mutable: true,
+		get:     func(cfg *Config) string { return cfg.MasterUser },
+		set: func(cfg *Config, args []string) error {
+			cfg.MasterUser = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name:    "masterauth",
+		mutable: true,
+		get:     func(cfg *Config) string { return cfg.MasterAuth },
+		set: func(cfg *Config, args []string) error {
+			cfg.MasterAuth = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name:    "notify-keyspace-events",
+		mutable: true,
+		get:     func(cfg *Config) string { return cfg.NotifyKeyspaceEvents },
+		set: func(cfg *Config, args []string) error {
+			cfg.NotifyKeyspaceEvents = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name:    "shutdown-timeout",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.Itoa(cfg.ShutdownTimeout) },
+		set: func(cfg *Config, args []string) error {
+			timeout, err := strconv.Atoi(args[0])
+			if err != nil || timeout < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("shutdown-timeout should be a non-negative number of seconds, but %s is given.", args[0]),
+				}
+			}
+			cfg.ShutdownTimeout = timeout
+			return nil
+		},
+	},
+	{
+		name:    "requirepass",
+		mutable: true,
+		get:     func(cfg *Config) string { return cfg.RequirePass },
+		set: func(cfg *Config, args []string) error {
+			cfg.RequirePass = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name: "tls-port",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.TlsPort) },
+		set: func(cfg *Config, args []string) error {
+			port, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			if port != 0 && (port <= 1024 || port >= 65535) {
+				return &CfgError{
+					message: fmt.Sprintf("TLS port should be between 1024 and 65535, but %d is given.", port),
+				}
+			}
+			cfg.TlsPort = port
+			return nil
+		},
+	},
+	{
+		name: "tls-cert-file",
+		get:  func(cfg *Config) string { return cfg.TlsCertFile },
+		set: func(cfg *Config, args []string) error {
+			cfg.TlsCertFile = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name: "tls-key-file",
+		get:  func(cfg *Config) string { return cfg.TlsKeyFile },
+		set: func(cfg *Config, args []string) error {
+			cfg.TlsKeyFile = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name: "tls-ca-cert-file",
+		get:  func(cfg *Config) string { return cfg.TlsCaCertFile },
+		set: func(cfg *Config, args []string) error {
+			cfg.TlsCaCertFile = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name: "tls-auth-clients",
+		get:  func(cfg *Config) string { return cfg.TlsAuthClients },
+		set: func(cfg *Config, args []string) error {
+			value := strings.ToLower(args[0])
+			if value != "yes" && value != "no" && value != "optional" {
+				return &CfgError{
+					message: fmt.Sprintf("tls-auth-clients should be yes, no or optional, but %s is given.", args[0]),
+				}
+			}
+			cfg.TlsAuthClients = value
+			return nil
+		},
+	},
+	{
+		name: "tls-auth-clients-user",
+		get:  func(cfg *Config) string { return cfg.TlsAuthClientsUser },
+		set: func(cfg *Config, args []string) error {
+			value := strings.ToLower(args[0])
+			if value != "off" && value != "cn" {
+				return &CfgError{
+					message: fmt.Sprintf("tls-auth-clients-user should be off or CN, but %s is given.", args[0]),
+				}
+			}
+			cfg.TlsAuthClientsUser = value
+			return nil
+		},
+	},
+	{
+		name: "unixsocket",
+		get:  func(cfg *Config) string { return cfg.UnixSocket },
+		set: func(cfg *Config, args []string) error {
+			cfg.UnixSocket = unquote(args[0])
+			return nil
+		},
+	},
+	{
+		name: "unixsocketperm",
+		get:  func(cfg *Config) string { return strconv.FormatUint(uint64(cfg.UnixSocketPerm), 8) },
+		set: func(cfg *Config, args []string) error {
+			perm, err := strconv.ParseUint(args[0], 8, 32)
+			if err != nil || perm > 0777 {
+				return &CfgError{
+					message: fmt.Sprintf("unixsocketperm should be an octal number of permissions, but %s is given.", args[0]),
+				}
+			}
+			cfg.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+	},
+	{
+		name: "metrics-port",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.MetricsPort) },
+		set: func(cfg *Config, args []string) error {
+			port, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			if port != 0 && (port <= 1024 || port >= 65535) {
+				return &CfgError{
+					message: fmt.Sprintf("Metrics port should be between 1024 and 65535, but %d is given.", port),
+				}
+			}
+			cfg.MetricsPort = port
+			return nil
+		},
+	},
+	{
+		name: "reactor",
+		get:  func(cfg *Config) string { return cfg.Reactor },
+		set: func(cfg *Config, args []string) error {
+			value := strings.ToLower(args[0])
+			if value != "goroutine" && value != "epoll" {
+				return &CfgError{
+					message: fmt.Sprintf("reactor should be goroutine or epoll, but %s is given.", args[0]),
+				}
+			}
+			cfg.Reactor = value
+			return nil
+		},
+	},
+	{
+		name: "reactor-loops",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.ReactorLoops) },
+		set: func(cfg *Config, args []string) error {
+			loops, err := strconv.Atoi(args[0])
+			if err != nil || loops < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("reactor-loops should be a non-negative number, but %s is given.", args[0]),
+				}
+			}
+			cfg.ReactorLoops = loops
+			return nil
+		},
+	},
+	{
+		name:    "maxclients",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.Itoa(cfg.MaxClients) },
+		set: func(cfg *Config, args []string) error {
+			maxClients, err := strconv.Atoi(args[0])
+			if err != nil || maxClients < 1 {
+				return &CfgError{
+					message: fmt.Sprintf("maxclients should be a positive number, but %s is given.", args[0]),
+				}
+			}
+			cfg.MaxClients = maxClients
+			return nil
+		},
+	},
+	{
+		name:    "timeout",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.Itoa(cfg.Timeout) },
+		set: func(cfg *Config, args []string) error {
+			timeout, err := strconv.Atoi(args[0])
+			if err != nil || timeout < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("timeout should be a non-negative number of seconds, but %s is given.", args[0]),
+				}
+			}
+			cfg.Timeout = timeout
+			return nil
+		},
+	},
+	{
+		name:    "tcp-keepalive",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.Itoa(cfg.TcpKeepalive) },
+		set: func(cfg *Config, args []string) error {
+			keepalive, err := strconv.Atoi(args[0])
+			if err != nil || keepalive < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("tcp-keepalive should be a non-negative number of seconds, but %s is given.", args[0]),
+				}
+			}
+			cfg.TcpKeepalive = keepalive
+			return nil
+		},
+	},
+	{
+		name: "tcp-backlog",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.TcpBacklog) },
+		set: func(cfg *Config, args []string) error {
+			backlog, err := strconv.Atoi(args[0])
+			if err != nil || backlog < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("tcp-backlog should be a non-negative number, but %s is given.", args[0]),
+				}
+			}
+			cfg.TcpBacklog = backlog
+			return nil
+		},
+	},
+	{
+		name:    "proto-max-bulk-len",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.FormatInt(cfg.ProtoMaxBulkLen, 10) },
+		set: func(cfg *Config, args []string) error {
+			size, err := parseMemory(args[0])
+			if err != nil || size < 1024*1024 {
+				return &CfgError{
+					message: fmt.Sprintf("proto-max-bulk-len should be at least 1mb, but %s is given.", args[0]),
+				}
+			}
+			cfg.ProtoMaxBulkLen = size
+			return nil
+		},
+	},
+	{
+		name:    "client-query-buffer-limit",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.FormatInt(cfg.ClientQueryBufferLimit, 10) },
+		set: func(cfg *Config, args []string) error {
+			size, err := parseMemory(args[0])
+			if err != nil || size < 1024*1024 {
+				return &CfgError{
+					message: fmt.Sprintf("client-query-buffer-limit should be at least 1mb, but %s is given.", args[0]),
+				}
+			}
+			cfg.ClientQueryBufferLimit = size
+			return nil
+		},
+	},
+	{
+		name:    "client-output-buffer-limit",
+		mutable: true,
+		get: func(cfg *Config) string {
+			res := make([]string, 0, len(OutputBufferClasses))
+			for _, class := range OutputBufferClasses {
+				limit := cfg.ClientOutputBufferLimit[class]
+				res = append(res, fmt.Sprintf("%s %d %d %d", class, limit.Hard, limit.Soft, limit.SoftSeconds))
+			}
+			return strings.Join(res, " ")
+		},
+		set: func(cfg *Config, args []string) error {
+			// CONFIG SET gives all the classes in a single value
+			if len(args) == 1 {
+				args = strings.Fields(unquote(args[0]))
+			}
+			if len(args) == 0 || len(args)%4 != 0 {
+				return &CfgError{
+					message: fmt.Sprintf("client-output-buffer-limit should be <class> <hard limit> <soft limit> <soft seconds>, but %s is given.", strings.Join(args, " ")),
+				}
+			}
+			limits := make(map[string]OutputBufferLimit, len(OutputBufferClasses))
+			for class, limit := range cfg.ClientOutputBufferLimit {
+				limits[class] = limit
+			}
+			for i := 0; i < len(args); i += 4 {
+				class := strings.ToLower(args[i])
+				if class == "slave" {
+					class = "replica"
 				}
-				if port <= 1024 || port >= 65535 {
-					portErr := &CfgError{
-						message: fmt.Sprintf("Listening port should be between 1024 and 65535, but %d is given.", port),
+				hard, hardErr := parseMemory(args[i+1])
+				soft, softErr := parseMemory(args[i+2])
+				seconds, secondsErr := strconv.Atoi(args[i+3])
+				if !slices.Contains(OutputBufferClasses, class) || hardErr != nil || softErr != nil || secondsErr != nil || seconds < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("client-output-buffer-limit should be <normal|replica|pubsub> <hard limit> <soft limit> <soft seconds>, but %s is given.", strings.Join(args[i:i+4], " ")),
 					}
-					return portErr
 				}
-				cfg.Port = port
-			} else if cfgName == "logdir" {
-				cfg.LogDir = strings.ToLower(fields[1])
-			} else if cfgName == "loglevel" {
-				cfg.LogLevel = strings.ToLower(fields[1])
-			} else if cfgName == "shardnum" {
-				cfg.ShardNum, err = strconv.Atoi(fields[1])
-				if err != nil {
-					fmt.Println("ShardNum should be a number. Get: ", fields[1])
-					panic(err)
+				limits[class] = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+			}
+			cfg.ClientOutputBufferLimit = limits
+			return nil
+		},
+	},
+	{
+		name:    "slowlog-log-slower-than",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.FormatInt(cfg.SlowlogLogSlowerThan, 10) },
+		set: func(cfg *Config, args []string) error {
+			slowerThan, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return &CfgError{
+					message: fmt.Sprintf("slowlog-log-slower-than should be a number of microseconds, but %s is given.", args[0]),
+				}
+			}
+			cfg.SlowlogLogSlowerThan = slowerThan
+			return nil
+		},
+	},
+	{
+		name:    "slowlog-max-len",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.Itoa(cfg.SlowlogMaxLen) },
+		set: func(cfg *Config, args []string) error {
+			maxLen, err := strconv.Atoi(args[0])
+			if err != nil || maxLen < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("slowlog-max-len should be a non-negative number, but %s is given.", args[0]),
+				}
+			}
+			cfg.SlowlogMaxLen = maxLen
+			return nil
+		},
+	},
+	{
+		name:    "latency-monitor-threshold",
+		mutable: true,
+		get:     func(cfg *Config) string { return strconv.FormatInt(cfg.LatencyMonitorThreshold, 10) },
+		set: func(cfg *Config, args []string) error {
+			threshold, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil || threshold < 0 {
+				return &CfgError{
+					message: fmt.Sprintf("latency-monitor-threshold should be a non-negative number of milliseconds, but %s is given.", args[0]),
 				}
 			}
+			cfg.LatencyMonitorThreshold = threshold
+			return nil
+		},
+	},
+	{
+		name:    "latency-tracking",
+		mutable: true,
+		get: func(cfg *Config) string {
+			if cfg.LatencyTracking {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(cfg *Config, args []string) error {
+			value := strings.ToLower(args[0])
+			if value != "yes" && value != "no" {
+				return &CfgError{
+					message: fmt.Sprintf("latency-tracking should be yes or no, but %s is given.", args[0]),
+				}
+			}
+			cfg.LatencyTracking = value == "yes"
+			return nil
+		},
+	},
+	{
+		name:    "latency-tracking-info-percentiles",
+		mutable: true,
+		get: func(cfg *Config) string {
+			res := make([]string, len(cfg.LatencyPercentiles))
+			for i, p := range cfg.LatencyPercentiles {
+				res[i] = strconv.FormatFloat(p, 'f', -1, 64)
+			}
+			return strings.Join(res, " ")
+		},
+		set: func(cfg *Config, args []string) error {
+			// CONFIG SET gives all the percentiles in a single value
+			if len(args) == 1 {
+				args = strings.Fields(unquote(args[0]))
+			}
+			percentiles := make([]float64, len(args))
+			for i, arg := range args {
+				p, err := strconv.ParseFloat(arg, 64)
+				if err != nil || p < 0 || p > 100 {
+					return &CfgError{
+						message: fmt.Sprintf("latency-tracking-info-percentiles should be percentiles between 0 and 100, but %s is given.", arg),
+					}
+				}
+				percentiles[i] = p
+			}
+			cfg.LatencyPercentiles = percentiles
+			return nil
+		},
+	},
+	{
+		name: "aclfile",
+		get:  func(cfg *Config) string { return cfg.AclFile },
+		set: func(cfg *Config, args []string) error {
+			cfg.AclFile = unquote(args[0])
+			return nil
+		},
+	},
+}
+
+func findParam(name string) *param {
+	for _, p := range params {
+		if p.name == name {
+			return p
 		}
-		if ioErr == io.EOF {
+	}
+	return nil
+}
+
+// unquote removes the quotes around a value of the config file, such as notify-keyspace-events ""
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// parseMemory parses a size in bytes such as 1gb, 64k or 100, k is 1000 bytes and kb is 1024 bytes like Redis
+func parseMemory(value string) (int64, error) {
+	value = strings.ToLower(value)
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	unit := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSuffix(value, u.suffix), u.size
 			break
 		}
 	}
-	return nil
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %s", value)
+	}
+	return n * unit, nil
+}
+
+// QueryLimits returns proto-max-bulk-len and client-query-buffer-limit, they may be changed by CONFIG SET at any time
+func (cfg *Config) QueryLimits() (protoMaxBulkLen, clientQueryBufferLimit int64) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.ProtoMaxBulkLen, cfg.ClientQueryBufferLimit
+}
+
+// ClientLimits returns maxclients, timeout and tcp-keepalive, they may be changed by CONFIG SET at any time
+func (cfg *Config) ClientLimits() (maxClients, timeout, tcpKeepalive int) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.MaxClients, cfg.Timeout, cfg.TcpKeepalive
+}
+
+// SlowlogLimits returns slowlog-log-slower-than and slowlog-max-len, they may be changed by CONFIG SET at any time
+func (cfg *Config) SlowlogLimits() (slowerThan int64, maxLen int) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.SlowlogLogSlowerThan, cfg.SlowlogMaxLen
+}
+
+// LatencyLimits returns latency-monitor-threshold, latency-tracking and latency-tracking-info-percentiles,
+// they may be changed by CONFIG SET at any time and the percentiles must not be modified
+func (cfg *Config) LatencyLimits() (threshold int64, tracking bool, percentiles []float64) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.LatencyMonitorThreshold, cfg.LatencyTracking, cfg.LatencyPercentiles
+}
+
+// ShutdownTimeoutSeconds returns shutdown-timeout, it may be changed by CONFIG SET at any time
+func (cfg *Config) ShutdownTimeoutSeconds() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.ShutdownTimeout
+}
+
+// MasterCredentials returns masteruser and masterauth, they may be changed by CONFIG SET at any time
+func (cfg *Config) MasterCredentials() (user, password string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.MasterUser, cfg.MasterAuth
+}
+
+// OutputBufferLimits returns client-output-buffer-limit by client class, the map must not be modified
+func (cfg *Config) OutputBufferLimits() map[string]OutputBufferLimit {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cfg.ClientOutputBufferLimit
+}
+
+// set sets a parameter read from the config file, unknown parameters are ignored
+func (cfg *Config) set(name string, args []string) error {
+	p := findParam(name)
+	if p == nil {
+		return nil
+	}
+	return p.set(cfg, args)
+}
+
+// Set sets a mutable parameter at runtime, it's used by CONFIG SET
+func (cfg *Config) Set(name, value string) error {
+	p := findParam(strings.ToLower(name))
+	if p == nil {
+		return &CfgError{message: fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", name)}
+	}
+	if !p.mutable {
+		return &CfgError{message: fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name)}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return p.set(cfg, []string{value})
+}
+
+// Get returns the names and values of the parameters matching pattern, it's used by CONFIG GET
+func (cfg *Config) Get(pattern string) [][2]string {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := make([][2]string, 0)
+	for _, p := range params {
+		if util.PatternMatch(strings.ToLower(pattern), p.name) {
+			res = append(res, [2]string{p.name, p.get(cfg)})
+		}
+	}
+	return res
 }
 
 func NewDefaultConfig() *Config {
@@ -146,5 +882,27 @@ func NewDefaultConfig() *Config {
 		LogDir:   DefaultLogDir,
 		LogLevel: DefaultLogLevel,
 		ShardNum: DefaultShardNum,
+		AofFile:  DefaultAofFile,
+		NodeID:   DefaultNodeID,
+
+		ShutdownTimeout:    DefaultShutdownTimeout,
+		TlsAuthClients:     DefaultTlsAuthClients,
+		TlsAuthClientsUser: DefaultTlsAuthClientsUser,
+		Reactor:            DefaultReactor,
+
+		MaxClients:   DefaultMaxClients,
+		TcpKeepalive: DefaultTcpKeepalive,
+		TcpBacklog:   DefaultTcpBacklog,
+
+		ProtoMaxBulkLen:        DefaultProtoMaxBulkLen,
+		ClientQueryBufferLimit: DefaultClientQueryBufferLimit,
+
+		ClientOutputBufferLimit: DefaultClientOutputBufferLimit,
+
+		SlowlogLogSlowerThan: DefaultSlowlogLogSlowerThan,
+		SlowlogMaxLen:        DefaultSlowlogMaxLen,
+
+		LatencyTracking:    DefaultLatencyTracking,
+		LatencyPercentiles: DefaultLatencyPercentiles,
 	}
 }
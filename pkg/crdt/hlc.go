@@ -0,0 +1,101 @@
+package crdt
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock timestamp.
+// Wall is the physical time in milliseconds, Logical orders events that share the same Wall,
+// and Node breaks the remaining ties so that every timestamp is totally ordered.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+	Node    string
+}
+
+// Compare returns -1, 0 or 1 if t is before, equal to or after o.
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.Wall < o.Wall:
+		return -1
+	case t.Wall > o.Wall:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	case t.Node < o.Node:
+		return -1
+	case t.Node > o.Node:
+		return 1
+	}
+	return 0
+}
+
+// After reports whether t happened after o.
+func (t Timestamp) After(o Timestamp) bool {
+	return t.Compare(o) > 0
+}
+
+// IsZero reports whether t has never been set.
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0 && t.Node == ""
+}
+
+func (t Timestamp) String() string {
+	return t.Node + "@" + strconv.FormatInt(t.Wall, 10) + "." + strconv.FormatUint(uint64(t.Logical), 10)
+}
+
+// Clock is a hybrid logical clock.
+// It never goes backwards even if the physical clock does, and it moves past every remote timestamp it observes.
+type Clock struct {
+	mu   sync.Mutex
+	node string
+	last Timestamp
+	now  func() int64
+}
+
+func NewClock(node string) *Clock {
+	return &Clock{
+		node: node,
+		now: func() int64 {
+			return time.Now().UnixMilli()
+		},
+	}
+}
+
+// Now returns a new timestamp for a local event.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.now()
+	if pt > c.last.Wall {
+		c.last = Timestamp{Wall: pt, Node: c.node}
+	} else {
+		c.last = Timestamp{Wall: c.last.Wall, Logical: c.last.Logical + 1, Node: c.node}
+	}
+	return c.last
+}
+
+// Observe merges a remote timestamp into the clock.
+func (c *Clock) Observe(remote Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.now()
+	switch {
+	case pt > c.last.Wall && pt > remote.Wall:
+		c.last = Timestamp{Wall: pt, Node: c.node}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1, Node: c.node}
+	case c.last.Wall > remote.Wall:
+		c.last = Timestamp{Wall: c.last.Wall, Logical: c.last.Logical + 1, Node: c.node}
+	default:
+		logical := c.last.Logical
+		if remote.Logical > logical {
+			logical = remote.Logical
+		}
+		c.last = Timestamp{Wall: c.last.Wall, Logical: logical + 1, Node: c.node}
+	}
+}
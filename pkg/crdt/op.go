@@ -0,0 +1,96 @@
+package crdt
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ApplyCommand is the command name peers use to ship operations to each other over the RESP port.
+const ApplyCommand = "crdt.apply"
+
+// SyncCommand asks a peer for the last sequence number it has applied from an origin.
+const SyncCommand = "crdt.sync"
+
+type OpType string
+
+const (
+	OpSet   OpType = "set"   // register write
+	OpDel   OpType = "del"   // register tombstone
+	OpIncr  OpType = "incr"  // counter delta
+	OpReset OpType = "reset" // counter observed reset
+	OpSAdd  OpType = "sadd"  // or-set add
+	OpSRem  OpType = "srem"  // or-set observed remove
+	OpHSet  OpType = "hset"  // map field write
+	OpHDel  OpType = "hdel"  // map field tombstone
+)
+
+// Op is a single replicated operation.
+// Origin identifies the node incarnation that issued the op and Seq orders the ops of one origin.
+type Op struct {
+	Origin string
+	Seq    uint64
+	Ts     Timestamp
+	Type   OpType
+	Key    string
+	Field  string // set member or hash field
+	Value  []byte
+	Delta  int64
+	Tags   []string
+}
+
+// Encode returns op as a crdt.apply command.
+func (op Op) Encode() [][]byte {
+	// an empty value is an empty string rather than a null bulk, which isn't a valid argument
+	value := op.Value
+	if value == nil {
+		value = []byte{}
+	}
+	return [][]byte{
+		[]byte(ApplyCommand),
+		[]byte(op.Origin),
+		[]byte(strconv.FormatUint(op.Seq, 10)),
+		[]byte(strconv.FormatInt(op.Ts.Wall, 10)),
+		[]byte(strconv.FormatUint(uint64(op.Ts.Logical), 10)),
+		[]byte(op.Ts.Node),
+		[]byte(op.Type),
+		[]byte(op.Key),
+		[]byte(op.Field),
+		value,
+		[]byte(strconv.FormatInt(op.Delta, 10)),
+		[]byte(strings.Join(op.Tags, ",")),
+	}
+}
+
+// DecodeOp parses a crdt.apply command.
+func DecodeOp(cmd [][]byte) (Op, error) {
+	var op Op
+	if len(cmd) != 12 || strings.ToLower(string(cmd[0])) != ApplyCommand {
+		return op, errors.New("invalid crdt.apply command")
+	}
+	var err error
+	op.Origin = string(cmd[1])
+	if op.Seq, err = strconv.ParseUint(string(cmd[2]), 10, 64); err != nil {
+		return op, err
+	}
+	if op.Ts.Wall, err = strconv.ParseInt(string(cmd[3]), 10, 64); err != nil {
+		return op, err
+	}
+	logical, err := strconv.ParseUint(string(cmd[4]), 10, 32)
+	if err != nil {
+		return op, err
+	}
+	op.Ts.Logical = uint32(logical)
+	op.Ts.Node = string(cmd[5])
+	op.Type = OpType(cmd[6])
+	op.Key = string(cmd[7])
+	op.Field = string(cmd[8])
+	op.Value = cmd[9]
+	if op.Delta, err = strconv.ParseInt(string(cmd[10]), 10, 64); err != nil {
+		return op, err
+	}
+	if len(cmd[11]) > 0 {
+		op.Tags = strings.Split(string(cmd[11]), ",")
+	}
+	return op, nil
+}
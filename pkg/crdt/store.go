@@ -0,0 +1,544 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindNone Kind = iota
+	KindString
+	KindCounter
+	KindSet
+	KindHash
+)
+
+var (
+	ErrWrongType   = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrUnsupported = errors.New("ERR command is not supported in active-active mode")
+	errNotInteger  = errors.New("ERR value is not an integer or out of range")
+)
+
+// Commands are the write commands supported in active-active mode, the other writes are rejected with ErrUnsupported.
+// Keys never expire, so the writes setting a ttl are rejected too, and lists and sorted sets are not replicated.
+var Commands = []string{"set", "setnx", "mset", "del", "incr", "decr", "incrby", "decrby",
+	"sadd", "srem", "hset", "hsetnx", "hdel"}
+
+// Check returns ErrUnsupported if the write command cmd can't be executed in active-active mode,
+// so that it's rejected before being queued by MULTI.
+func Check(cmd [][]byte) error {
+	name := strings.ToLower(string(cmd[0]))
+	if name == "set" {
+		if _, _, err := setOptions(cmd); errors.Is(err, ErrUnsupported) {
+			return err
+		}
+		return nil
+	}
+	for _, command := range Commands {
+		if name == command {
+			return nil
+		}
+	}
+	return unsupported(name)
+}
+
+func unsupported(name string) error {
+	return fmt.Errorf("%w: %s", ErrUnsupported, name)
+}
+
+// entry holds the replicated state of a key.
+// A key can briefly carry state of several kinds when nodes write different types concurrently,
+// the visible kind is the live one with the latest timestamp.
+type entry struct {
+	reg     *Register
+	counter *Counter
+	set     *ORSet
+	hash    *LWWMap
+}
+
+func (e *entry) kind() Kind {
+	kind := KindNone
+	var ts Timestamp
+	if e.reg != nil && e.reg.Exists() && e.reg.Ts.After(ts) {
+		kind, ts = KindString, e.reg.Ts
+	}
+	if e.counter != nil && e.counter.Exists() && e.counter.Ts.After(ts) {
+		kind, ts = KindCounter, e.counter.Ts
+	}
+	if e.set != nil && e.set.Exists() && e.set.Ts.After(ts) {
+		kind, ts = KindSet, e.set.Ts
+	}
+	if e.hash != nil && e.hash.Exists() && e.hash.Ts.After(ts) {
+		kind = KindHash
+	}
+	return kind
+}
+
+// Store is the replicated state of one active-active node.
+// Local write commands are turned into ops, applied to the state and appended to the op log,
+// ops from peers are merged with Apply and appended to the op log too, so that they are forwarded to the other peers.
+// Applying the same set of ops in any order gives the same state.
+// The ops are numbered by their position in the op log, from 1, and the head of the log is dropped by Compact.
+type Store struct {
+	mu        sync.Mutex
+	node      string
+	origin    string
+	clock     *Clock
+	seq       uint64
+	log       []Op
+	compacted uint64 // the position of the latest op dropped from the log
+	ops       []Op   // ops emitted by the running Local call
+	applied   map[string]uint64
+	keys      map[string]*entry
+}
+
+// NewStore creates the state of node.
+// Every store gets a fresh origin, so a restarted node never reuses sequence numbers its peers have seen.
+func NewStore(node string) *Store {
+	return &Store{
+		node:    node,
+		origin:  node + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		clock:   NewClock(node),
+		applied: make(map[string]uint64),
+		keys:    make(map[string]*entry),
+	}
+}
+
+func (s *Store) Origin() string {
+	return s.origin
+}
+
+// OpsSince returns the ops of every origin logged after the position pos, the op at index i has the position pos+i+1.
+// The compacted ops are gone, so pos is raised to the position given to Compact.
+func (s *Store) OpsSince(pos uint64) []Op {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos = max(pos, s.compacted)
+	if pos >= s.compacted+uint64(len(s.log)) {
+		return nil
+	}
+	from := s.log[pos-s.compacted:]
+	res := make([]Op, len(from))
+	copy(res, from)
+	return res
+}
+
+// LogEnd returns the position of the latest op of the log.
+func (s *Store) LogEnd() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compacted + uint64(len(s.log))
+}
+
+// Compact drops the ops up to the position pos from the log, once every peer has received them.
+func (s *Store) Compact(pos uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pos <= s.compacted {
+		return
+	}
+	n := min(pos-s.compacted, uint64(len(s.log)))
+	// the dropped ops are cleared so that their values are freed before the log grows into a new array
+	clear(s.log[:n])
+	s.log = s.log[n:]
+	s.compacted += n
+}
+
+// LastSeq returns the sequence number of the latest local op.
+func (s *Store) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+// Applied returns the last sequence number applied from origin, the latest local op for the origin of the store.
+func (s *Store) Applied(origin string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if origin == s.origin {
+		return s.seq
+	}
+	return s.applied[origin]
+}
+
+// Apply merges an op received from a peer and logs it, return false if it has been applied before.
+func (s *Store) Apply(op Op) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if op.Origin == s.origin || op.Seq <= s.applied[op.Origin] {
+		return false
+	}
+	s.applied[op.Origin] = op.Seq
+	s.clock.Observe(op.Ts)
+	s.apply(op)
+	s.log = append(s.log, op)
+	return true
+}
+
+// Local executes a write command issued on this node, cmd is one of Commands.
+// It returns the integer reply of the command and the ops that have to be shipped to peers,
+// SET returns 1 if it has written the key, and 0 if NX or XX prevented it.
+func (s *Store) Local(cmd [][]byte) (int64, []Op, error) {
+	if len(cmd) < 2 {
+		return 0, nil, fmt.Errorf("ERR wrong number of arguments for '%s' command", string(cmd[0]))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = s.ops[:0]
+	key := string(cmd[1])
+	var res int64
+	switch name := strings.ToLower(string(cmd[0])); name {
+	case "set", "setnx":
+		nx, xx := name == "setnx", false
+		if name == "setnx" && len(cmd) != 3 {
+			return 0, nil, errors.New("ERR wrong number of arguments for 'setnx' command")
+		}
+		if name == "set" {
+			var err error
+			if nx, xx, err = setOptions(cmd); err != nil {
+				return 0, nil, err
+			}
+		}
+		if exists := s.kind(key) != KindNone; (nx && exists) || (xx && !exists) {
+			break
+		}
+		s.clear(key, KindString)
+		s.emit(Op{Type: OpSet, Key: key, Value: cmd[2]})
+		res = 1
+	case "mset":
+		if len(cmd)%2 != 1 {
+			return 0, nil, errors.New("ERR wrong number of arguments for 'mset' command")
+		}
+		for i := 1; i < len(cmd); i += 2 {
+			s.clear(string(cmd[i]), KindString)
+			s.emit(Op{Type: OpSet, Key: string(cmd[i]), Value: cmd[i+1]})
+		}
+	case "del":
+		for _, k := range cmd[1:] {
+			if s.kind(string(k)) != KindNone {
+				s.clear(string(k), KindNone)
+				res++
+			}
+		}
+	case "incr", "decr", "incrby", "decrby":
+		delta, err := counterDelta(cmd)
+		if err != nil {
+			return 0, nil, err
+		}
+		if res, err = s.incr(key, delta); err != nil {
+			return 0, nil, err
+		}
+	case "sadd":
+		if len(cmd) < 3 {
+			return 0, nil, errors.New("ERR wrong number of arguments for 'sadd' command")
+		}
+		if kind := s.kind(key); kind != KindNone && kind != KindSet {
+			return 0, nil, ErrWrongType
+		}
+		for _, member := range cmd[2:] {
+			e := s.keys[key]
+			if e != nil && e.set != nil && e.set.Has(string(member)) {
+				continue
+			}
+			s.emit(Op{Type: OpSAdd, Key: key, Field: string(member)})
+			res++
+		}
+	case "srem":
+		if len(cmd) < 3 {
+			return 0, nil, errors.New("ERR wrong number of arguments for 'srem' command")
+		}
+		if kind := s.kind(key); kind != KindSet {
+			if kind != KindNone {
+				return 0, nil, ErrWrongType
+			}
+			break
+		}
+		e := s.keys[key]
+		for _, member := range cmd[2:] {
+			if !e.set.Has(string(member)) {
+				continue
+			}
+			s.emit(Op{Type: OpSRem, Key: key, Field: string(member), Tags: e.set.Tags(string(member))})
+			res++
+		}
+	case "hset", "hsetnx":
+		if (name == "hset" && (len(cmd) < 4 || len(cmd)%2 != 0)) || (name == "hsetnx" && len(cmd) != 4) {
+			return 0, nil, fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+		}
+		if kind := s.kind(key); kind != KindNone && kind != KindHash {
+			return 0, nil, ErrWrongType
+		}
+		for i := 2; i < len(cmd); i += 2 {
+			var exists bool
+			if e := s.keys[key]; e != nil && e.hash != nil {
+				_, exists = e.hash.Get(string(cmd[i]))
+			}
+			if exists && name == "hsetnx" {
+				continue
+			}
+			if !exists {
+				res++
+			}
+			s.emit(Op{Type: OpHSet, Key: key, Field: string(cmd[i]), Value: cmd[i+1]})
+		}
+	case "hdel":
+		if len(cmd) < 3 {
+			return 0, nil, errors.New("ERR wrong number of arguments for 'hdel' command")
+		}
+		if kind := s.kind(key); kind != KindHash {
+			if kind != KindNone {
+				return 0, nil, ErrWrongType
+			}
+			break
+		}
+		e := s.keys[key]
+		for _, field := range cmd[2:] {
+			if _, ok := e.hash.Get(string(field)); !ok {
+				continue
+			}
+			s.emit(Op{Type: OpHDel, Key: key, Field: string(field)})
+			res++
+		}
+	default:
+		return 0, nil, unsupported(name)
+	}
+	ops := make([]Op, len(s.ops))
+	copy(ops, s.ops)
+	return res, ops, nil
+}
+
+// incr adds delta to the counter of key and returns its new value.
+// A string holding an integer is the base of the counter, like INCR on a string in Redis. The base is added
+// under the timestamp of the string write, so the nodes converting the same string concurrently count it once.
+func (s *Store) incr(key string, delta int64) (int64, error) {
+	var base int64
+	var baseTs Timestamp
+	switch kind := s.kind(key); kind {
+	case KindString:
+		e := s.keys[key]
+		var err error
+		if base, err = strconv.ParseInt(string(e.reg.Value), 10, 64); err != nil {
+			return 0, errNotInteger
+		}
+		baseTs = e.reg.Ts
+	case KindNone, KindCounter:
+	default:
+		return 0, ErrWrongType
+	}
+	var value int64
+	visible := false
+	if e := s.keys[key]; e != nil && e.counter != nil {
+		value, visible = e.counter.Value(), e.counter.Exists()
+	}
+	if !visible {
+		// the counter starts again from its base, a reset counter hides the value it had
+		value = base
+	}
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return 0, errors.New("ERR increment or decrement would overflow")
+	}
+	if !baseTs.IsZero() {
+		s.clear(key, KindCounter)
+		s.emit(Op{Type: OpIncr, Key: key, Field: "base/" + baseTs.String(), Delta: base})
+	}
+	if e := s.keys[key]; !visible && e != nil && e.counter != nil {
+		delta += value - e.counter.Value()
+	}
+	s.emit(Op{Type: OpIncr, Key: key, Delta: delta})
+	return s.keys[key].counter.Value(), nil
+}
+
+// setOptions parses the options of SET, the options setting a ttl and GET are unsupported
+func setOptions(cmd [][]byte) (nx, xx bool, err error) {
+	if len(cmd) < 3 {
+		return false, false, errors.New("ERR wrong number of arguments for 'set' command")
+	}
+	for _, arg := range cmd[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "keepttl":
+			// keys never expire in active-active mode
+		case "ex", "px", "exat", "pxat", "get":
+			return false, false, unsupported("set " + strings.ToLower(string(arg)))
+		default:
+			return false, false, errors.New("ERR syntax error")
+		}
+	}
+	if nx && xx {
+		return false, false, errors.New("ERR syntax error")
+	}
+	return nx, xx, nil
+}
+
+// Materialize returns the commands that rebuild key in the local database from the replicated state.
+func (s *Store) Materialize(key string) [][][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := [][][]byte{{[]byte("del"), []byte(key)}}
+	e, ok := s.keys[key]
+	if !ok {
+		return res
+	}
+	switch e.kind() {
+	case KindString:
+		res = append(res, [][]byte{[]byte("set"), []byte(key), e.reg.Value})
+	case KindCounter:
+		res = append(res, [][]byte{[]byte("set"), []byte(key), []byte(strconv.FormatInt(e.counter.Value(), 10))})
+	case KindSet:
+		cmd := [][]byte{[]byte("sadd"), []byte(key)}
+		for _, member := range e.set.Members() {
+			cmd = append(cmd, []byte(member))
+		}
+		res = append(res, cmd)
+	case KindHash:
+		cmd := [][]byte{[]byte("hset"), []byte(key)}
+		for _, field := range e.hash.Fields() {
+			value, _ := e.hash.Get(field)
+			cmd = append(cmd, []byte(field), value)
+		}
+		res = append(res, cmd)
+	}
+	return res
+}
+
+// Keys returns all keys that have replicated state, in sorted order.
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// kind returns the visible kind of key without creating its entry
+func (s *Store) kind(key string) Kind {
+	if e, ok := s.keys[key]; ok {
+		return e.kind()
+	}
+	return KindNone
+}
+
+func (s *Store) entry(key string) *entry {
+	e, ok := s.keys[key]
+	if !ok {
+		e = &entry{}
+		s.keys[key] = e
+	}
+	return e
+}
+
+// emit stamps a local op, applies it and appends it to the op log.
+func (s *Store) emit(op Op) {
+	s.seq++
+	op.Origin = s.origin
+	op.Seq = s.seq
+	op.Ts = s.clock.Now()
+	if op.Type == OpSAdd {
+		op.Tags = []string{s.origin + "/" + strconv.FormatUint(op.Seq, 10)}
+	}
+	s.apply(op)
+	s.log = append(s.log, op)
+	s.ops = append(s.ops, op)
+}
+
+// clear emits the ops removing everything this node has observed of key, except the state of kind keep.
+func (s *Store) clear(key string, keep Kind) {
+	e := s.entry(key)
+	if keep != KindString && e.reg != nil && e.reg.Exists() {
+		s.emit(Op{Type: OpDel, Key: key})
+	}
+	if keep != KindCounter && e.counter != nil && e.counter.Exists() {
+		s.emit(Op{Type: OpReset, Key: key, Delta: -e.counter.Value()})
+	}
+	if keep != KindSet && e.set != nil {
+		for _, member := range e.set.Members() {
+			s.emit(Op{Type: OpSRem, Key: key, Field: member, Tags: e.set.Tags(member)})
+		}
+	}
+	if keep != KindHash && e.hash != nil {
+		for _, field := range e.hash.Fields() {
+			s.emit(Op{Type: OpHDel, Key: key, Field: field})
+		}
+	}
+}
+
+func (s *Store) apply(op Op) {
+	e := s.entry(op.Key)
+	switch op.Type {
+	case OpSet, OpDel:
+		if e.reg == nil {
+			e.reg = &Register{}
+		}
+		if op.Type == OpSet {
+			e.reg.Set(op.Value, op.Ts)
+		} else {
+			e.reg.Delete(op.Ts)
+		}
+	case OpIncr, OpReset:
+		if e.counter == nil {
+			e.counter = NewCounter()
+		}
+		if op.Type == OpIncr && op.Field != "" {
+			e.counter.SetBase(op.Field, op.Delta, op.Ts)
+		} else if op.Type == OpIncr {
+			e.counter.Add(op.Origin, op.Delta, op.Ts)
+		} else {
+			e.counter.Reset(op.Origin, op.Delta, op.Ts)
+		}
+	case OpSAdd, OpSRem:
+		if e.set == nil {
+			e.set = NewORSet()
+		}
+		if op.Type == OpSAdd {
+			for _, tag := range op.Tags {
+				e.set.Add(op.Field, tag, op.Ts)
+			}
+		} else {
+			e.set.Remove(op.Field, op.Tags)
+		}
+	case OpHSet, OpHDel:
+		if e.hash == nil {
+			e.hash = NewLWWMap()
+		}
+		if op.Type == OpHSet {
+			e.hash.Set(op.Field, op.Value, op.Ts)
+		} else {
+			e.hash.Delete(op.Field, op.Ts)
+		}
+	}
+}
+
+func counterDelta(cmd [][]byte) (int64, error) {
+	name := strings.ToLower(string(cmd[0]))
+	var delta int64 = 1
+	if name == "incrby" || name == "decrby" {
+		if len(cmd) != 3 {
+			return 0, fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+		}
+		var err error
+		delta, err = strconv.ParseInt(string(cmd[2]), 10, 64)
+		if err != nil {
+			return 0, errors.New("ERR value is not an integer or out of range")
+		}
+	} else if len(cmd) != 2 {
+		return 0, fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+	}
+	if name == "decr" || name == "decrby" {
+		delta = -delta
+	}
+	return delta, nil
+}
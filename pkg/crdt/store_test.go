@@ -0,0 +1,359 @@
+package crdt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// network connects local stores and lets tests cut and heal links between them.
+type network struct {
+	nodes []*Store
+	acked map[[2]int]uint64 // {from, to} -> position of the last delivered op in the log of from
+	cut   map[[2]int]bool
+}
+
+func newNetwork(n int) *network {
+	nw := &network{
+		acked: make(map[[2]int]uint64),
+		cut:   make(map[[2]int]bool),
+	}
+	for i := 0; i < n; i++ {
+		nw.nodes = append(nw.nodes, NewStore("node"+strconv.Itoa(i)))
+	}
+	return nw
+}
+
+func (nw *network) partition(a, b int) {
+	nw.cut[[2]int{a, b}] = true
+	nw.cut[[2]int{b, a}] = true
+}
+
+func (nw *network) heal() {
+	nw.cut = make(map[[2]int]bool)
+}
+
+// deliver ships pending ops on every link that is not cut.
+func (nw *network) deliver() {
+	for from := range nw.nodes {
+		for to := range nw.nodes {
+			link := [2]int{from, to}
+			if from == to || nw.cut[link] {
+				continue
+			}
+			for _, op := range nw.nodes[from].OpsSince(nw.acked[link]) {
+				encoded, err := DecodeOp(op.Encode())
+				if err != nil {
+					panic(err)
+				}
+				nw.nodes[to].Apply(encoded)
+				nw.acked[link]++
+			}
+		}
+	}
+}
+
+// compact drops the ops every other node has received from the log of every node.
+func (nw *network) compact() {
+	for from, node := range nw.nodes {
+		pos := node.LogEnd()
+		for to := range nw.nodes {
+			if from != to {
+				pos = min(pos, nw.acked[[2]int{from, to}])
+			}
+		}
+		node.Compact(pos)
+	}
+}
+
+func (nw *network) exec(t *testing.T, node int, args ...string) int64 {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	res, _, err := nw.nodes[node].Local(cmd)
+	if err != nil {
+		t.Fatalf("node%d %v: %v", node, args, err)
+	}
+	return res
+}
+
+func (nw *network) assertConverged(t *testing.T) {
+	keys := make(map[string]struct{})
+	for _, node := range nw.nodes {
+		for _, key := range node.Keys() {
+			keys[key] = struct{}{}
+		}
+	}
+	for key := range keys {
+		expect := fmt.Sprint(nw.nodes[0].Materialize(key))
+		for i, node := range nw.nodes[1:] {
+			if got := fmt.Sprint(node.Materialize(key)); got != expect {
+				t.Errorf("key %s diverged: node0 %s, node%d %s", key, expect, i+1, got)
+			}
+		}
+	}
+}
+
+func materialized(s *Store, key string) [][]byte {
+	cmds := s.Materialize(key)
+	if len(cmds) < 2 {
+		return nil
+	}
+	return cmds[1][2:]
+}
+
+func TestRegisterLastWriterWins(t *testing.T) {
+	nw := newNetwork(2)
+	nw.nodes[0].clock.now = func() int64 { return 100 }
+	nw.nodes[1].clock.now = func() int64 { return 200 }
+	nw.partition(0, 1)
+	nw.exec(t, 1, "set", "k", "late")
+	nw.exec(t, 0, "set", "k", "early")
+	nw.deliver()
+	if v := materialized(nw.nodes[0], "k"); len(v) != 1 || string(v[0]) != "early" {
+		t.Errorf("partitioned node0 should keep its own write, got %s", v)
+	}
+	nw.heal()
+	nw.deliver()
+	nw.assertConverged(t)
+	if v := materialized(nw.nodes[0], "k"); len(v) != 1 || string(v[0]) != "late" {
+		t.Errorf("set with the later timestamp should win, got %s", v)
+	}
+}
+
+func TestCounterMergesIncrements(t *testing.T) {
+	nw := newNetwork(2)
+	nw.partition(0, 1)
+	nw.exec(t, 0, "incrby", "c", "10")
+	nw.exec(t, 1, "incrby", "c", "5")
+	nw.exec(t, 1, "decr", "c")
+	nw.heal()
+	nw.deliver()
+	nw.assertConverged(t)
+	if res := nw.exec(t, 0, "incrby", "c", "0"); res != 14 {
+		t.Errorf("counter value is %d, expect 14", res)
+	}
+}
+
+func TestORSetAddWins(t *testing.T) {
+	nw := newNetwork(2)
+	nw.exec(t, 0, "sadd", "s", "a", "b")
+	nw.deliver()
+	nw.partition(0, 1)
+	nw.exec(t, 0, "srem", "s", "a")
+	nw.exec(t, 1, "srem", "s", "a")
+	nw.exec(t, 1, "sadd", "s", "a")
+	nw.exec(t, 0, "sadd", "s", "c")
+	nw.heal()
+	nw.deliver()
+	nw.assertConverged(t)
+	v := materialized(nw.nodes[0], "s")
+	if !bytes.Equal(bytes.Join(v, []byte(",")), []byte("a,b,c")) {
+		t.Errorf("set members are %s, expect [a b c]", v)
+	}
+}
+
+func TestHashMergesPerField(t *testing.T) {
+	nw := newNetwork(2)
+	nw.exec(t, 0, "hset", "h", "f1", "v1", "f2", "v2")
+	nw.deliver()
+	nw.partition(0, 1)
+	nw.exec(t, 0, "hset", "h", "f1", "x")
+	nw.exec(t, 1, "hset", "h", "f3", "v3")
+	nw.exec(t, 1, "hdel", "h", "f2")
+	nw.heal()
+	nw.deliver()
+	nw.assertConverged(t)
+	v := materialized(nw.nodes[1], "h")
+	if !bytes.Equal(bytes.Join(v, []byte(",")), []byte("f1,x,f3,v3")) {
+		t.Errorf("hash is %s, expect [f1 x f3 v3]", v)
+	}
+}
+
+func TestDeleteAndWrongType(t *testing.T) {
+	nw := newNetwork(2)
+	nw.exec(t, 0, "sadd", "k", "a")
+	if _, _, err := nw.nodes[0].Local([][]byte{[]byte("incr"), []byte("k")}); err != ErrWrongType {
+		t.Errorf("incr on a set should fail with WRONGTYPE, got %v", err)
+	}
+	nw.exec(t, 0, "set", "k", "v")
+	nw.deliver()
+	if res := nw.exec(t, 1, "del", "k", "missing"); res != 1 {
+		t.Errorf("del returned %d, expect 1", res)
+	}
+	nw.deliver()
+	nw.assertConverged(t)
+	if v := nw.nodes[0].Materialize("k"); len(v) != 1 {
+		t.Errorf("deleted key should not be materialized, got %s", v)
+	}
+	if _, _, err := nw.nodes[0].Local([][]byte{[]byte("lpush"), []byte("l"), []byte("a")}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("lpush should be unsupported, got %v", err)
+	}
+	nw.exec(t, 0, "del", "missing")
+	nw.exec(t, 0, "srem", "missing", "a")
+	nw.exec(t, 0, "hdel", "missing", "f")
+	for _, key := range nw.nodes[0].Keys() {
+		if key == "missing" {
+			t.Error("commands on a missing key should not create its entry")
+		}
+	}
+}
+
+func TestStringCounterBase(t *testing.T) {
+	nw := newNetwork(2)
+	nw.exec(t, 0, "set", "c", "10")
+	nw.deliver()
+	nw.partition(0, 1)
+	if res := nw.exec(t, 0, "incr", "c"); res != 11 {
+		t.Errorf("incr on a numeric string returned %d, expect 11", res)
+	}
+	nw.exec(t, 1, "incrby", "c", "5")
+	nw.heal()
+	nw.deliver()
+	nw.assertConverged(t)
+	// the string is the base of the counter once, even converted by both nodes
+	if v := materialized(nw.nodes[1], "c"); len(v) != 1 || string(v[0]) != "16" {
+		t.Errorf("counter is %s, expect 16", v)
+	}
+	nw.exec(t, 0, "set", "s", "abc")
+	if _, _, err := nw.nodes[0].Local([][]byte{[]byte("incr"), []byte("s")}); err == nil || err.Error() != errNotInteger.Error() {
+		t.Errorf("incr on a string that is not an integer: %v", err)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	nw := newNetwork(2)
+	if res := nw.exec(t, 0, "set", "k", "v", "xx"); res != 0 {
+		t.Errorf("set xx on a missing key returned %d", res)
+	}
+	if res := nw.exec(t, 0, "setnx", "k", "v"); res != 1 {
+		t.Errorf("setnx on a missing key returned %d", res)
+	}
+	if res := nw.exec(t, 0, "set", "k", "w", "nx"); res != 0 {
+		t.Errorf("set nx on an existing key returned %d", res)
+	}
+	nw.exec(t, 0, "mset", "k", "x", "k2", "y")
+	nw.exec(t, 0, "hsetnx", "h", "f", "v")
+	if res := nw.exec(t, 0, "hsetnx", "h", "f", "w"); res != 0 {
+		t.Errorf("hsetnx on an existing field returned %d", res)
+	}
+	nw.deliver()
+	nw.assertConverged(t)
+	if v := materialized(nw.nodes[1], "k"); len(v) != 1 || string(v[0]) != "x" {
+		t.Errorf("k is %s, expect x", v)
+	}
+	for _, args := range []string{"set k v ex 10", "set k v get", "expire k 10", "lpush l a", "flushdb"} {
+		var cmd [][]byte
+		for _, arg := range strings.Fields(args) {
+			cmd = append(cmd, []byte(arg))
+		}
+		if err := Check(cmd); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s should be unsupported, got %v", args, err)
+		}
+	}
+	if err := Check([][]byte{[]byte("set"), []byte("k"), []byte("v"), []byte("nx")}); err != nil {
+		t.Errorf("set nx should be supported, got %v", err)
+	}
+}
+
+func TestRandomPartitionsConverge(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	nw := newNetwork(3)
+	keys := []string{"str", "cnt", "set", "hash"}
+	for round := 0; round < 200; round++ {
+		switch r.Intn(10) {
+		case 0:
+			nw.partition(r.Intn(3), r.Intn(3))
+		case 1:
+			nw.heal()
+		case 2:
+			nw.deliver()
+		case 3:
+			nw.compact()
+		}
+		node := r.Intn(3)
+		member := strconv.Itoa(r.Intn(5))
+		var cmd []string
+		switch key := keys[r.Intn(len(keys))]; key {
+		case "str":
+			cmd = []string{"set", key, member}
+			if r.Intn(3) == 0 {
+				cmd = []string{"incr", key}
+			}
+		case "cnt":
+			cmd = []string{"incrby", key, strconv.Itoa(r.Intn(10) - 5)}
+		case "set":
+			cmd = []string{[]string{"sadd", "srem"}[r.Intn(2)], key, member}
+		case "hash":
+			if r.Intn(2) == 0 {
+				cmd = []string{"hset", key, member, strconv.Itoa(round)}
+			} else {
+				cmd = []string{"hdel", key, member}
+			}
+		}
+		if r.Intn(20) == 0 {
+			cmd = []string{"del", cmd[1]}
+		}
+		args := make([][]byte, len(cmd))
+		for i, arg := range cmd {
+			args[i] = []byte(arg)
+		}
+		// commands may hit WRONGTYPE after a delete raced with a write of another kind
+		_, _, _ = nw.nodes[node].Local(args)
+	}
+	nw.heal()
+	nw.deliver()
+	nw.assertConverged(t)
+}
+
+func TestOpsAreForwarded(t *testing.T) {
+	nw := newNetwork(3)
+	nw.partition(0, 2)
+	nw.exec(t, 0, "sadd", "s", "a")
+	nw.exec(t, 2, "set", "k", "v")
+	// the ops of node2 reach node0 in the second round, once node1 has applied them
+	nw.deliver()
+	nw.deliver()
+	// node1 forwards the ops of node0 to node2, and the ops of node2 to node0
+	nw.assertConverged(t)
+	if v := materialized(nw.nodes[2], "s"); len(v) != 1 || string(v[0]) != "a" {
+		t.Errorf("node2 should get the ops of node0 through node1, got %s", v)
+	}
+	if seq := nw.nodes[2].Applied(nw.nodes[0].Origin()); seq != 1 {
+		t.Errorf("node2 applied %d ops of node0, expect 1", seq)
+	}
+	if seq := nw.nodes[0].Applied(nw.nodes[0].Origin()); seq != 1 {
+		t.Errorf("node0 gives %d for its own origin, expect its last seq 1", seq)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	nw := newNetwork(2)
+	nw.exec(t, 0, "sadd", "s", "a", "b")
+	nw.deliver()
+	nw.partition(0, 1)
+	nw.exec(t, 0, "sadd", "s", "c")
+	nw.compact()
+	node := nw.nodes[0]
+	if end := node.LogEnd(); end != 3 {
+		t.Fatalf("log end %d, expect 3", end)
+	}
+	if ops := node.OpsSince(0); len(ops) != 1 || ops[0].Field != "c" {
+		t.Errorf("the ops received by node1 should be compacted, got %v", ops)
+	}
+	if len(node.log) != 1 || node.compacted != 2 {
+		t.Errorf("log of %d ops compacted up to %d, expect 1 op after 2", len(node.log), node.compacted)
+	}
+	nw.heal()
+	nw.deliver()
+	nw.compact()
+	nw.assertConverged(t)
+	if ops := node.OpsSince(3); len(ops) != 0 || node.LogEnd() != 3 || len(node.log) != 0 {
+		t.Errorf("the log should be empty once every op is received, got %v", ops)
+	}
+}
@@ -0,0 +1,246 @@
+package crdt
+
+import "sort"
+
+// Register is a last-writer-wins register used for strings.
+// A delete is a write of a tombstone, so it competes with concurrent sets by timestamp.
+type Register struct {
+	Value   []byte
+	Deleted bool
+	Ts      Timestamp
+}
+
+// Set writes value if ts is newer than the current write, return true if the register changed.
+func (r *Register) Set(value []byte, ts Timestamp) bool {
+	if !ts.After(r.Ts) {
+		return false
+	}
+	r.Value = value
+	r.Deleted = false
+	r.Ts = ts
+	return true
+}
+
+// Delete writes a tombstone if ts is newer than the current write.
+func (r *Register) Delete(ts Timestamp) bool {
+	if !ts.After(r.Ts) {
+		return false
+	}
+	r.Value = nil
+	r.Deleted = true
+	r.Ts = ts
+	return true
+}
+
+func (r *Register) Exists() bool {
+	return !r.Ts.IsZero() && !r.Deleted
+}
+
+// Counter is a PN-counter.
+// Every node only ever grows its own P and N entries, so merging is a plain sum.
+// A delete is an observed reset: it subtracts the value seen by the deleting node
+// and hides the counter until a later increment.
+type Counter struct {
+	P     map[string]int64
+	N     map[string]int64
+	Ts    Timestamp // timestamp of the latest increment
+	DelTs Timestamp // timestamp of the latest reset
+}
+
+func NewCounter() *Counter {
+	return &Counter{
+		P: make(map[string]int64),
+		N: make(map[string]int64),
+	}
+}
+
+// Add applies a delta issued by node.
+func (c *Counter) Add(node string, delta int64, ts Timestamp) {
+	if delta >= 0 {
+		c.P[node] += delta
+	} else {
+		c.N[node] -= delta
+	}
+	if ts.After(c.Ts) {
+		c.Ts = ts
+	}
+}
+
+// SetBase sets the base of the counter named id, such as the integer of a string turned into a counter.
+// Every node converting the same string sets the same base, so it's counted once however many nodes do it.
+func (c *Counter) SetBase(id string, base int64, ts Timestamp) {
+	if base >= 0 {
+		c.P[id] = base
+	} else {
+		c.N[id] = -base
+	}
+	if ts.After(c.Ts) {
+		c.Ts = ts
+	}
+}
+
+// Reset applies an observed reset issued by node.
+func (c *Counter) Reset(node string, delta int64, ts Timestamp) {
+	if delta >= 0 {
+		c.P[node] += delta
+	} else {
+		c.N[node] -= delta
+	}
+	if ts.After(c.DelTs) {
+		c.DelTs = ts
+	}
+}
+
+func (c *Counter) Value() int64 {
+	var res int64
+	for _, v := range c.P {
+		res += v
+	}
+	for _, v := range c.N {
+		res -= v
+	}
+	return res
+}
+
+func (c *Counter) Exists() bool {
+	return !c.Ts.IsZero() && c.Ts.After(c.DelTs)
+}
+
+// ORSet is an observed-remove set with add-wins semantics.
+// Every add carries a unique tag, and a remove only deletes the tags its issuer has observed,
+// so an add concurrent with a remove survives.
+type ORSet struct {
+	elems map[string]map[string]struct{} // member -> live tags
+	tombs map[string]struct{}            // removed tags
+	Ts    Timestamp                      // timestamp of the latest add
+}
+
+func NewORSet() *ORSet {
+	return &ORSet{
+		elems: make(map[string]map[string]struct{}),
+		tombs: make(map[string]struct{}),
+	}
+}
+
+// Add adds member with tag, return true if the member is new.
+func (s *ORSet) Add(member, tag string, ts Timestamp) bool {
+	if ts.After(s.Ts) {
+		s.Ts = ts
+	}
+	if _, ok := s.tombs[tag]; ok {
+		return false
+	}
+	tags, ok := s.elems[member]
+	if !ok {
+		tags = make(map[string]struct{})
+		s.elems[member] = tags
+	}
+	tags[tag] = struct{}{}
+	return len(tags) == 1
+}
+
+// Remove removes the observed tags of member, return true if the member is gone.
+func (s *ORSet) Remove(member string, tags []string) bool {
+	live := s.elems[member]
+	for _, tag := range tags {
+		s.tombs[tag] = struct{}{}
+		delete(live, tag)
+	}
+	if live != nil && len(live) == 0 {
+		delete(s.elems, member)
+		return true
+	}
+	return false
+}
+
+// Tags returns the live tags of member.
+func (s *ORSet) Tags(member string) []string {
+	res := make([]string, 0, len(s.elems[member]))
+	for tag := range s.elems[member] {
+		res = append(res, tag)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *ORSet) Has(member string) bool {
+	return len(s.elems[member]) > 0
+}
+
+// Members returns all live members in sorted order.
+func (s *ORSet) Members() []string {
+	res := make([]string, 0, len(s.elems))
+	for member := range s.elems {
+		res = append(res, member)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *ORSet) Exists() bool {
+	return len(s.elems) > 0
+}
+
+// LWWMap is a hash whose fields are merged independently as last-writer-wins registers.
+type LWWMap struct {
+	fields map[string]*Register
+	Ts     Timestamp // timestamp of the latest field write
+}
+
+func NewLWWMap() *LWWMap {
+	return &LWWMap{fields: make(map[string]*Register)}
+}
+
+// Set writes a field, return true if the field did not exist before.
+func (h *LWWMap) Set(field string, value []byte, ts Timestamp) bool {
+	if ts.After(h.Ts) {
+		h.Ts = ts
+	}
+	reg, ok := h.fields[field]
+	if !ok {
+		reg = &Register{}
+		h.fields[field] = reg
+	}
+	existed := reg.Exists()
+	return reg.Set(value, ts) && !existed
+}
+
+// Delete deletes a field, return true if the field existed before.
+func (h *LWWMap) Delete(field string, ts Timestamp) bool {
+	reg, ok := h.fields[field]
+	if !ok {
+		reg = &Register{}
+		h.fields[field] = reg
+	}
+	existed := reg.Exists()
+	return reg.Delete(ts) && existed
+}
+
+func (h *LWWMap) Get(field string) ([]byte, bool) {
+	reg, ok := h.fields[field]
+	if !ok || !reg.Exists() {
+		return nil, false
+	}
+	return reg.Value, true
+}
+
+// Fields returns all live fields in sorted order.
+func (h *LWWMap) Fields() []string {
+	res := make([]string, 0, len(h.fields))
+	for field, reg := range h.fields {
+		if reg.Exists() {
+			res = append(res, field)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (h *LWWMap) Exists() bool {
+	for _, reg := range h.fields {
+		if reg.Exists() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,891 @@
+package memdb
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"github.com/hsn/tiny-redis/pkg/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUser = "default"
+	// aclLogMaxLen is the number of entries kept by ACL LOG
+	aclLogMaxLen = 128
+	// aclLogGroupTime is the time within which a denial of the same kind updates the previous entry
+	aclLogGroupTime = 60 * time.Second
+)
+
+// containerCommands are the commands whose first argument is a subcommand
+var containerCommands = map[string]bool{"acl": true, "client": true, "config": true, "pubsub": true}
+
+// aclName returns the name of cmd used by the ACL rules and ACL LOG, command|subcommand for a container command
+func aclName(cmdName string, cmd [][]byte) string {
+	if containerCommands[cmdName] && len(cmd) > 1 {
+		return cmdName + "|" + strings.ToLower(string(cmd[1]))
+	}
+	return cmdName
+}
+
+// categoryByName returns the ACL category named name, 0 if there is none
+func categoryByName(name string) aclCategory {
+	for _, cat := range categoryNames {
+		if cat.name == name {
+			return cat.category
+		}
+	}
+	return 0
+}
+
+// isCategory reports whether category is a known ACL category other than all
+func isCategory(category string) bool {
+	return categoryByName(category) != 0
+}
+
+// commandCategories returns the ACL categories of the command or subcommand name, written as command|subcommand
+func commandCategories(name string) aclCategory {
+	base, sub, isSub := strings.Cut(name, "|")
+	command, ok := CmdTable[base]
+	if !ok {
+		return 0
+	}
+	if categories, ok := command.subcommands[sub]; isSub && ok {
+		return categories
+	}
+	return command.categories
+}
+
+// categoryCommands returns the commands and subcommands in category
+func categoryCommands(category string) []string {
+	cat := categoryByName(category)
+	var names []string
+	for name, command := range CmdTable {
+		if command.categories&cat != 0 {
+			names = append(names, name)
+		}
+		for sub, categories := range command.subcommands {
+			if categories&cat != 0 {
+				names = append(names, name+"|"+sub)
+			}
+		}
+	}
+	return names
+}
+
+// inCategory reports whether the command or subcommand name is in category
+func inCategory(name, category string) bool {
+	if category == "all" {
+		return true
+	}
+	return commandCategories(name)&categoryByName(category) != 0
+}
+
+// keyPattern is a key pattern of a user, with the access it grants
+type keyPattern struct {
+	pattern string
+	read    bool
+	write   bool
+}
+
+func (k keyPattern) String() string {
+	switch {
+	case k.read && k.write:
+		return "~" + k.pattern
+	case k.read:
+		return "%R~" + k.pattern
+	default:
+		return "%W~" + k.pattern
+	}
+}
+
+// User is an ACL user.
+// A User is never modified after it's stored in ACL, ACL SETUSER replaces it with a modified copy,
+// so the permissions can be checked without holding the lock of ACL.
+type User struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords map[string]struct{} // SHA256 hex digests of the passwords
+	cmdRules  []string            // command rules in order, like +@all -flushall
+	keys      []keyPattern
+	channels  []string // channel patterns
+}
+
+func newUser(name string) *User {
+	return &User{
+		name:      name,
+		passwords: make(map[string]struct{}),
+		cmdRules:  []string{"-@all"},
+	}
+}
+
+func (u *User) clone() *User {
+	res := *u
+	res.passwords = make(map[string]struct{}, len(u.passwords))
+	for hash := range u.passwords {
+		res.passwords[hash] = struct{}{}
+	}
+	res.cmdRules = append([]string(nil), u.cmdRules...)
+	res.keys = append([]keyPattern(nil), u.keys...)
+	res.channels = append([]string(nil), u.channels...)
+	return &res
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if !(hash[i] >= '0' && hash[i] <= '9' || hash[i] >= 'a' && hash[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRule applies a rule of ACL SETUSER to the user
+func (u *User) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+	case lower == "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+	case lower == "allkeys":
+		u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+	case lower == "resetkeys":
+		u.keys = nil
+	case lower == "allchannels":
+		u.channels = []string{"*"}
+	case lower == "resetchannels":
+		u.channels = nil
+	case lower == "allcommands":
+		u.cmdRules = []string{"+@all"}
+	case lower == "nocommands":
+		u.cmdRules = []string{"-@all"}
+	case lower == "reset":
+		*u = *newUser(u.name)
+	case rule[0] == '>':
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+	case rule[0] == '<':
+		hash := hashPassword(rule[1:])
+		if _, ok := u.passwords[hash]; !ok {
+			return fmt.Errorf("The password you are trying to remove from the user does not exist")
+		}
+		delete(u.passwords, hash)
+	case rule[0] == '#':
+		if !isPasswordHash(rule[1:]) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.passwords[rule[1:]] = struct{}{}
+		u.nopass = false
+	case rule[0] == '!':
+		if _, ok := u.passwords[rule[1:]]; !ok {
+			return fmt.Errorf("The password you are trying to remove from the user does not exist")
+		}
+		delete(u.passwords, rule[1:])
+	case rule[0] == '~':
+		u.keys = append(u.keys, keyPattern{pattern: rule[1:], read: true, write: true})
+	case rule[0] == '%':
+		i := strings.IndexByte(rule, '~')
+		if i < 2 {
+			return fmt.Errorf("Syntax error")
+		}
+		k := keyPattern{pattern: rule[i+1:]}
+		for _, flag := range strings.ToUpper(rule[1:i]) {
+			switch flag {
+			case 'R':
+				k.read = true
+			case 'W':
+				k.write = true
+			default:
+				return fmt.Errorf("Syntax error")
+			}
+		}
+		u.keys = append(u.keys, k)
+	case rule[0] == '&':
+		u.channels = append(u.channels, rule[1:])
+	case rule[0] == '+' || rule[0] == '-':
+		name := lower[1:]
+		if name == "@all" {
+			u.cmdRules = []string{lower}
+			return nil
+		}
+		if strings.HasPrefix(name, "@") {
+			if !isCategory(name[1:]) {
+				return fmt.Errorf("Unknown command or category name in ACL")
+			}
+		} else {
+			base := name
+			if i := strings.IndexByte(name, '|'); i >= 0 {
+				base = name[:i]
+			}
+			if _, ok := CmdTable[base]; !ok {
+				return fmt.Errorf("Unknown command or category name in ACL")
+			}
+		}
+		u.cmdRules = append(u.cmdRules, lower)
+	default:
+		return fmt.Errorf("Syntax error")
+	}
+	return nil
+}
+
+// canRun reports whether the user can run the command or subcommand name, the last matching rule wins
+func (u *User) canRun(name string) bool {
+	allowed := false
+	for _, rule := range u.cmdRules {
+		target := rule[1:]
+		var match bool
+		if strings.HasPrefix(target, "@") {
+			match = inCategory(name, target[1:])
+		} else {
+			// +command allows all of its subcommands
+			match = target == name || strings.HasPrefix(name, target+"|")
+		}
+		if match {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// canAccessKey reports whether the user has the access to key
+func (u *User) canAccessKey(key string, read, write bool) bool {
+	for _, k := range u.keys {
+		if (!read || k.read) && (!write || k.write) && util.PatternMatch(k.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChannel reports whether the user can access channel.
+// A pattern subscribed by PSUBSCRIBE must be one of the channel patterns of the user.
+func (u *User) canAccessChannel(channel string, isPattern bool) bool {
+	for _, pattern := range u.channels {
+		if pattern == "*" || (isPattern && pattern == channel) || (!isPattern && util.PatternMatch(pattern, channel)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	_, ok := u.passwords[hashPassword(password)]
+	return ok
+}
+
+func (u *User) sortedPasswords() []string {
+	res := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		res = append(res, hash)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (u *User) keysString() string {
+	res := make([]string, len(u.keys))
+	for i, k := range u.keys {
+		res[i] = k.String()
+	}
+	return strings.Join(res, " ")
+}
+
+func (u *User) channelsString() string {
+	res := make([]string, len(u.channels))
+	for i, channel := range u.channels {
+		res[i] = "&" + channel
+	}
+	return strings.Join(res, " ")
+}
+
+// describe returns the rules of the user as written by ACL LIST and the aclfile, they can be applied by ACL SETUSER
+func (u *User) describe() string {
+	fields := []string{"user", u.name}
+	if u.enabled {
+		fields = append(fields, "on")
+	} else {
+		fields = append(fields, "off")
+	}
+	if u.nopass {
+		fields = append(fields, "nopass")
+	}
+	for _, hash := range u.sortedPasswords() {
+		fields = append(fields, "#"+hash)
+	}
+	if len(u.keys) > 0 {
+		fields = append(fields, u.keysString())
+	}
+	if len(u.channels) > 0 {
+		fields = append(fields, u.channelsString())
+	} else {
+		fields = append(fields, "resetchannels")
+	}
+	fields = append(fields, u.cmdRules...)
+	return strings.Join(fields, " ")
+}
+
+// aclLogEntry is an entry of ACL LOG, the same denial repeated in a short time is counted in one entry
+type aclLogEntry struct {
+	id         int64
+	count      int
+	reason     string // command, key, channel or auth
+	context    string // toplevel or multi
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+// ACL holds the users and the log of denied commands and authentications
+type ACL struct {
+	mu     sync.RWMutex
+	users  map[string]*User
+	log    []*aclLogEntry // newest first
+	nextID int64
+}
+
+func NewACL() *ACL {
+	acl := &ACL{users: make(map[string]*User)}
+	acl.users[defaultUser] = newDefaultUser()
+	return acl
+}
+
+// newDefaultUser returns the default user which can do everything without password
+func newDefaultUser() *User {
+	u := newUser(defaultUser)
+	u.enabled = true
+	u.nopass = true
+	u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+	u.channels = []string{"*"}
+	u.cmdRules = []string{"+@all"}
+	return u
+}
+
+// User returns the user of name, nil if it doesn't exist
+func (acl *ACL) User(name string) *User {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	return acl.users[name]
+}
+
+// SetUser creates or modifies the user of name with rules, the user is unchanged if any rule is invalid
+func (acl *ACL) SetUser(name string, rules []string) error {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	u, ok := acl.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if rule == "" {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': Syntax error", rule)
+		}
+		if err := u.applyRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err.Error())
+		}
+	}
+	acl.users[name] = u
+	return nil
+}
+
+// SetRequirePass sets the password of the default user, an empty password makes it passwordless
+func (acl *ACL) SetRequirePass(password string) {
+	rules := []string{"nopass"}
+	if password != "" {
+		rules = []string{"resetpass", ">" + password}
+	}
+	_ = acl.SetUser(defaultUser, rules)
+}
+
+// deleteUser deletes the user of name, return false if it doesn't exist
+func (acl *ACL) deleteUser(name string) bool {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	if _, ok := acl.users[name]; !ok {
+		return false
+	}
+	delete(acl.users, name)
+	return true
+}
+
+// sortedUsers returns all users ordered by name
+func (acl *ACL) sortedUsers() []*User {
+	acl.mu.RLock()
+	res := make([]*User, 0, len(acl.users))
+	for _, u := range acl.users {
+		res = append(res, u)
+	}
+	acl.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// Load replaces all users with the users of the aclfile.
+// Nothing is changed if the file has any error. The default user is created if the file doesn't define it.
+func (acl *ACL) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d should start with user keyword", path, lineNum)
+		}
+		if _, ok := users[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineNum, fields[1])
+		}
+		u := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: %s. ", path, lineNum, err.Error())
+			}
+		}
+		users[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[defaultUser]; !ok {
+		users[defaultUser] = newDefaultUser()
+	}
+	acl.mu.Lock()
+	acl.users = users
+	acl.mu.Unlock()
+	return nil
+}
+
+// Save writes all users to the aclfile, the file is replaced atomically
+func (acl *ACL) Save(path string) error {
+	var sb strings.Builder
+	for _, u := range acl.sortedUsers() {
+		sb.WriteString(u.describe())
+		sb.WriteByte('\n')
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.WriteString(sb.String()); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// addLog records a denial in ACL LOG
+func (acl *ACL) addLog(c *Client, reason, context, object, username string) {
+	now := time.Now()
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	for _, e := range acl.log {
+		if e.reason == reason && e.context == context && e.object == object && e.username == username &&
+			now.Sub(e.updated) < aclLogGroupTime {
+			e.count++
+			e.updated = now
+			e.clientInfo = c.info()
+			return
+		}
+	}
+	acl.nextID++
+	entry := &aclLogEntry{
+		id:         acl.nextID - 1,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: c.info(),
+		created:    now,
+		updated:    now,
+	}
+	acl.log = append([]*aclLogEntry{entry}, acl.log...)
+	if len(acl.log) > aclLogMaxLen {
+		acl.log = acl.log[:aclLogMaxLen]
+	}
+}
+
+// isNoAuthCommand reports whether the command can be executed before the client is authenticated
+func isNoAuthCommand(cmdName string) bool {
+	switch cmdName {
+	case "auth", "hello", "quit":
+		return true
+	}
+	return false
+}
+
+// checkACL checks the permissions of the client to run cmd, it returns the error replied to the client if it's denied.
+// context is toplevel, or multi when the command is executed by EXEC.
+func (m *MemDb) checkACL(c *Client, cmdName string, command *command, cmd [][]byte, context string) RESP.RedisData {
+	// clients of the server itself, like the AOF loader, can do everything
+	if c.conn == nil {
+		return nil
+	}
+	c.mu.Lock()
+	username := c.username
+	c.mu.Unlock()
+	u := m.acl.User(username)
+	if u == nil {
+		return RESP.MakeErrorData(fmt.Sprintf("NOPERM User %s has been deleted", username))
+	}
+	name := aclName(cmdName, cmd)
+	if !u.canRun(name) {
+		m.acl.addLog(c, "command", context, name, username)
+		return RESP.MakeErrorData(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", username, name))
+	}
+	if inCategory(cmdName, "pubsub") {
+		if channel, ok := deniedChannel(u, cmdName, cmd); !ok {
+			m.acl.addLog(c, "channel", context, channel, username)
+			return RESP.MakeErrorData("NOPERM No permissions to access a channel")
+		}
+		return nil
+	}
+	isWrite := inCategory(cmdName, "write")
+	// the source keys of a *STORE command are only read
+	sourcesRead := isWrite && strings.HasSuffix(cmdName, "store")
+	// the first key of a read-modify-write command, such as INCR, needs both permissions like in Redis
+	firstRead := isWrite && command.readsFirstKey(cmd)
+	for i, key := range command.keys(cmd) {
+		read, write := !isWrite, isWrite
+		if sourcesRead && i > 0 {
+			read, write = true, false
+		}
+		if firstRead && i == 0 {
+			read = true
+		}
+		if !u.canAccessKey(key, read, write) {
+			m.acl.addLog(c, "key", context, key, username)
+			return RESP.MakeErrorData("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+// deniedChannel returns the first channel of a pub/sub command the user can't access
+func deniedChannel(u *User, cmdName string, cmd [][]byte) (string, bool) {
+	var channels [][]byte
+	isPattern := false
+	switch cmdName {
+	case "publish", "spublish":
+		if len(cmd) > 1 {
+			channels = cmd[1:2]
+		}
+	case "subscribe", "ssubscribe":
+		channels = cmd[1:]
+	case "psubscribe":
+		channels = cmd[1:]
+		isPattern = true
+	}
+	for _, channel := range channels {
+		if !u.canAccessChannel(string(channel), isPattern) {
+			return string(channel), false
+		}
+	}
+	return "", true
+}
+
+// authenticate authenticates the client as username with password, and logs the failure in ACL LOG.
+func (m *MemDb) authenticate(c *Client, username, password string) *RESP.ErrorData {
+	u := m.acl.User(username)
+	if u == nil || !u.enabled || !u.checkPassword(password) {
+		m.acl.addLog(c, "auth", "toplevel", "AUTH", username)
+		return RESP.MakeErrorData("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.mu.Lock()
+	c.username = username
+	c.authenticated = true
+	c.mu.Unlock()
+	return nil
+}
+
+// AuthenticateUser authenticates the client as username without password,
+// it's used for the clients identified by their TLS certificates.
+// It returns false if the user doesn't exist or is disabled.
+func (m *MemDb) AuthenticateUser(c *Client, username string) bool {
+	u := m.acl.User(username)
+	if u == nil || !u.enabled {
+		return false
+	}
+	c.mu.Lock()
+	c.username = username
+	c.authenticated = true
+	c.mu.Unlock()
+	return true
+}
+
+// killDeletedUserClients closes the connections authenticated as the users which no longer exist
+func (m *MemDb) killDeletedUserClients(caller *Client) {
+	for _, cli := range m.clients.List() {
+		cli.mu.Lock()
+		username := cli.username
+		cli.mu.Unlock()
+		if m.acl.User(username) == nil {
+			cli.kill(caller)
+		}
+	}
+}
+
+func RegisterACLCommands() {
+	RegisterCommand("auth", authCmd, -2, flagNoScript, catConnection, 0, 0, 0,
+		commandDoc{"Authenticates the connection.", "1.0.0", "connection"})
+	RegisterCommand("acl", aclCmd, -2, flagAdmin|flagNoScript, 0, 0, 0, 0,
+		commandDoc{"A container for Access List Control commands.", "6.0.0", "server"})
+	registerSubcommands("acl", map[string]aclCategory{
+		"whoami": catConnection, "cat": catConnection,
+		"setuser": catAdmin | catDangerous, "getuser": catAdmin | catDangerous, "deluser": catAdmin | catDangerous,
+		"list": catAdmin | catDangerous, "users": catAdmin | catDangerous, "load": catAdmin | catDangerous,
+		"save": catAdmin | catDangerous, "log": catAdmin | catDangerous,
+	})
+}
+
+// authCmd
+// AUTH [username] password
+func authCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 || len(cmd) > 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'auth' command")
+	}
+	username, password := defaultUser, string(cmd[1])
+	if len(cmd) == 3 {
+		username, password = string(cmd[1]), string(cmd[2])
+	} else if u := m.acl.User(defaultUser); u != nil && u.nopass {
+		return RESP.MakeErrorData("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if err := m.authenticate(c, username, password); err != nil {
+		return err
+	}
+	return RESP.MakeStringData("OK")
+}
+
+// aclCmd
+// ACL SETUSER|GETUSER|DELUSER|LIST|USERS|WHOAMI|CAT|LOG|LOAD|SAVE
+func aclCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'acl' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	switch subCmd {
+	case "setuser":
+		if len(cmd) < 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'acl|setuser' command")
+		}
+		rules := make([]string, 0, len(cmd)-3)
+		for _, rule := range cmd[3:] {
+			rules = append(rules, string(rule))
+		}
+		if err := m.acl.SetUser(string(cmd[2]), rules); err != nil {
+			return RESP.MakeErrorData("ERR " + err.Error())
+		}
+		return RESP.MakeStringData("OK")
+	case "getuser":
+		if len(cmd) != 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'acl|getuser' command")
+		}
+		u := m.acl.User(string(cmd[2]))
+		if u == nil {
+			return RESP.MakeBulkData(nil)
+		}
+		return aclGetUser(u)
+	case "deluser":
+		if len(cmd) < 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'acl|deluser' command")
+		}
+		for _, name := range cmd[2:] {
+			if string(name) == defaultUser {
+				return RESP.MakeErrorData("ERR The 'default' user cannot be removed")
+			}
+		}
+		deleted := 0
+		for _, name := range cmd[2:] {
+			if m.acl.deleteUser(string(name)) {
+				deleted++
+			}
+		}
+		m.killDeletedUserClients(c)
+		return RESP.MakeIntData(int64(deleted))
+	case "list", "users":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'acl|%s' command", subCmd))
+		}
+		res := make([]RESP.RedisData, 0)
+		for _, u := range m.acl.sortedUsers() {
+			if subCmd == "list" {
+				res = append(res, RESP.MakeBulkData([]byte(u.describe())))
+			} else {
+				res = append(res, RESP.MakeBulkData([]byte(u.name)))
+			}
+		}
+		return RESP.MakeArrayData(res)
+	case "whoami":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'acl|whoami' command")
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return RESP.MakeBulkData([]byte(c.user()))
+	case "cat":
+		return aclCat(cmd)
+	case "log":
+		return aclLog(m, cmd)
+	case "load", "save":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'acl|%s' command", subCmd))
+		}
+		path := config.Configures.AclFile
+		if path == "" {
+			return RESP.MakeErrorData("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if subCmd == "save" {
+			if err := m.acl.Save(path); err != nil {
+				return RESP.MakeErrorData("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+			}
+			return RESP.MakeStringData("OK")
+		}
+		if err := m.acl.Load(path); err != nil {
+			return RESP.MakeErrorData("ERR Error loading ACLs: " + err.Error())
+		}
+		m.killDeletedUserClients(c)
+		return RESP.MakeStringData("OK")
+	}
+	return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try ACL HELP.", string(cmd[1])))
+}
+
+// aclGetUser describes the user as a map
+func aclGetUser(u *User) RESP.RedisData {
+	flags := make([]RESP.RedisData, 0)
+	if u.enabled {
+		flags = append(flags, RESP.MakeBulkData([]byte("on")))
+	} else {
+		flags = append(flags, RESP.MakeBulkData([]byte("off")))
+	}
+	if u.nopass {
+		flags = append(flags, RESP.MakeBulkData([]byte("nopass")))
+	}
+	passwords := make([]RESP.RedisData, 0)
+	for _, hash := range u.sortedPasswords() {
+		passwords = append(passwords, RESP.MakeBulkData([]byte(hash)))
+	}
+	return RESP.MakeMapData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte("flags")), RESP.MakeArrayData(flags),
+		RESP.MakeBulkData([]byte("passwords")), RESP.MakeArrayData(passwords),
+		RESP.MakeBulkData([]byte("commands")), RESP.MakeBulkData([]byte(strings.Join(u.cmdRules, " "))),
+		RESP.MakeBulkData([]byte("keys")), RESP.MakeBulkData([]byte(u.keysString())),
+		RESP.MakeBulkData([]byte("channels")), RESP.MakeBulkData([]byte(u.channelsString())),
+		RESP.MakeBulkData([]byte("selectors")), RESP.MakeArrayData([]RESP.RedisData{}),
+	})
+}
+
+// aclCat
+// ACL CAT [category] lists the categories, or the commands in category
+func aclCat(cmd [][]byte) RESP.RedisData {
+	if len(cmd) > 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'acl|cat' command")
+	}
+	var names []string
+	if len(cmd) == 2 {
+		for _, cat := range categoryNames {
+			names = append(names, cat.name)
+		}
+	} else {
+		category := strings.ToLower(string(cmd[2]))
+		if !isCategory(category) {
+			return RESP.MakeErrorData(fmt.Sprintf("ERR Unknown category '%s'", string(cmd[2])))
+		}
+		names = append(names, categoryCommands(category)...)
+	}
+	sort.Strings(names)
+	res := make([]RESP.RedisData, len(names))
+	for i, name := range names {
+		res[i] = RESP.MakeBulkData([]byte(name))
+	}
+	return RESP.MakeArrayData(res)
+}
+
+// aclLog
+// ACL LOG [count|RESET]
+func aclLog(m *MemDb, cmd [][]byte) RESP.RedisData {
+	if len(cmd) > 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'acl|log' command")
+	}
+	count := aclLogMaxLen
+	if len(cmd) == 3 {
+		if strings.ToLower(string(cmd[2])) == "reset" {
+			m.acl.mu.Lock()
+			m.acl.log = nil
+			m.acl.mu.Unlock()
+			return RESP.MakeStringData("OK")
+		}
+		n, err := strconv.Atoi(string(cmd[2]))
+		if err != nil || n < 0 {
+			return RESP.MakeErrorData("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	now := time.Now()
+	m.acl.mu.RLock()
+	defer m.acl.mu.RUnlock()
+	res := make([]RESP.RedisData, 0)
+	for _, e := range m.acl.log {
+		if len(res) >= count {
+			break
+		}
+		res = append(res, RESP.MakeMapData([]RESP.RedisData{
+			RESP.MakeBulkData([]byte("count")), RESP.MakeIntData(int64(e.count)),
+			RESP.MakeBulkData([]byte("reason")), RESP.MakeBulkData([]byte(e.reason)),
+			RESP.MakeBulkData([]byte("context")), RESP.MakeBulkData([]byte(e.context)),
+			RESP.MakeBulkData([]byte("object")), RESP.MakeBulkData([]byte(e.object)),
+			RESP.MakeBulkData([]byte("username")), RESP.MakeBulkData([]byte(e.username)),
+			RESP.MakeBulkData([]byte("age-seconds")), RESP.MakeDoubleData(now.Sub(e.created).Seconds()),
+			RESP.MakeBulkData([]byte("client-info")), RESP.MakeBulkData([]byte(e.clientInfo)),
+			RESP.MakeBulkData([]byte("entry-id")), RESP.MakeIntData(e.id),
+			RESP.MakeBulkData([]byte("timestamp-created")), RESP.MakeIntData(e.created.UnixMilli()),
+			RESP.MakeBulkData([]byte("timestamp-last-updated")), RESP.MakeIntData(e.updated.UnixMilli()),
+		}))
+	}
+	return RESP.MakeArrayData(res)
+}
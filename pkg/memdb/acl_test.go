@@ -0,0 +1,221 @@
+package memdb
+
+import (
+	"bytes"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newACLTestDb() *MemDb {
+	RegisterSetCommands()
+	RegisterMultiCommands()
+	RegisterACLCommands()
+	return newNotifyTestDb()
+}
+
+// newACLTestClient returns a client with a connection, the clients without connection skip the ACL checks
+func newACLTestClient(m *MemDb) *Client {
+	server, _ := net.Pipe()
+	c := NewClient(server)
+	m.AddClient(c)
+	return c
+}
+
+func expectReply(t *testing.T, m *MemDb, c *Client, expect string, args ...string) {
+	t.Helper()
+	if res := execArgs(m, c, args...); string(res) != expect {
+		t.Errorf("%v: expect %q, get %q", args, expect, res)
+	}
+}
+
+func TestRequirePass(t *testing.T) {
+	m := newACLTestDb()
+	c := newACLTestClient(m)
+	expectReply(t, m, c, "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n", "auth", "pw")
+	expectReply(t, m, c, "+OK\r\n", "config", "set", "requirepass", "secret")
+	defer func() { _ = config.Configures.Set("requirepass", "") }()
+
+	// connections authenticated before requirepass stay authenticated
+	expectReply(t, m, c, "+OK\r\n", "set", "a", "1")
+	c = newACLTestClient(m)
+	expectReply(t, m, c, "-NOAUTH Authentication required.\r\n", "get", "a")
+	if res := execArgs(m, c, "hello", "3"); !bytes.HasPrefix(res, []byte("-NOAUTH ")) {
+		t.Errorf("hello without auth should be rejected: %q", res)
+	}
+	expectReply(t, m, c, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", "auth", "wrong")
+	expectReply(t, m, c, "+OK\r\n", "auth", "secret")
+	expectReply(t, m, c, "$1\r\n1\r\n", "get", "a")
+
+	c = newACLTestClient(m)
+	if res := execArgs(m, c, "hello", "3", "auth", "default", "secret"); res[0] != '%' {
+		t.Errorf("hello auth reply error: %q", res)
+	}
+	expectReply(t, m, c, "$7\r\ndefault\r\n", "acl", "whoami")
+}
+
+func TestACLPermissions(t *testing.T) {
+	m := newACLTestDb()
+	admin := newACLTestClient(m)
+	for _, args := range [][]string{
+		{"acl", "setuser", "bad", "+nosuchcommand"},
+		{"acl", "setuser", "bad", "+@nosuchcategory"},
+		{"acl", "setuser", "bad", "#1234"},
+		{"acl", "setuser", "bad", "%X~a"},
+		{"acl", "deluser", "default"},
+	} {
+		if res := execArgs(m, admin, args...); res[0] != '-' {
+			t.Errorf("%v should be rejected: %q", args, res)
+		}
+	}
+	if res := execArgs(m, admin, "acl", "getuser", "bad"); !bytes.Equal(res, []byte("$-1\r\n")) {
+		t.Errorf("a user with an invalid rule should not be created: %q", res)
+	}
+
+	expectReply(t, m, admin, "+OK\r\n", "acl", "setuser", "alice", "on", ">pw", "~app:*", "%R~shared:*", "&news.*", "+@read", "+@write", "+@pubsub", "+@transaction", "-del", "+acl|whoami")
+	alice := newACLTestClient(m)
+	expectReply(t, m, alice, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", "auth", "alice", "wrong")
+	expectReply(t, m, alice, "+OK\r\n", "auth", "alice", "pw")
+	expectReply(t, m, alice, "$5\r\nalice\r\n", "acl", "whoami")
+
+	expectReply(t, m, alice, "+OK\r\n", "set", "app:1", "v")
+	expectReply(t, m, alice, "$1\r\nv\r\n", "get", "app:1")
+	expectReply(t, m, alice, "-NOPERM No permissions to access a key\r\n", "set", "other", "v")
+	expectReply(t, m, alice, "-NOPERM No permissions to access a key\r\n", "set", "shared:1", "v")
+	expectReply(t, m, alice, "$-1\r\n", "get", "shared:1")
+	expectReply(t, m, alice, "-NOPERM User alice has no permissions to run the 'del' command\r\n", "del", "app:1")
+	expectReply(t, m, alice, "-NOPERM User alice has no permissions to run the 'config|get' command\r\n", "config", "get", "*")
+	expectReply(t, m, alice, "-NOPERM User alice has no permissions to run the 'acl|setuser' command\r\n", "acl", "setuser", "alice", "+@all")
+	// the source keys of a store command only need the read permission
+	execArgs(m, admin, "sadd", "shared:s", "x")
+	expectReply(t, m, alice, ":1\r\n", "sunionstore", "app:s", "shared:s")
+	expectReply(t, m, alice, "-NOPERM No permissions to access a key\r\n", "sunionstore", "shared:s", "app:s")
+
+	expectReply(t, m, alice, ":0\r\n", "publish", "news.today", "hi")
+	expectReply(t, m, alice, "-NOPERM No permissions to access a channel\r\n", "publish", "sports", "hi")
+	expectReply(t, m, alice, "-NOPERM No permissions to access a channel\r\n", "psubscribe", "news.*.x")
+
+	// a denied command inside MULTI aborts EXEC
+	expectReply(t, m, alice, "+OK\r\n", "multi")
+	expectReply(t, m, alice, "-NOPERM No permissions to access a key\r\n", "set", "other", "v")
+	expectReply(t, m, alice, "-EXECABORT Transaction discarded because of previous errors.\r\n", "exec")
+	// permissions are checked again by EXEC
+	expectReply(t, m, alice, "+OK\r\n", "multi")
+	expectReply(t, m, alice, "+QUEUED\r\n", "set", "app:2", "v")
+	expectReply(t, m, admin, "+OK\r\n", "acl", "setuser", "alice", "-set")
+	expectReply(t, m, alice, "*1\r\n-NOPERM User alice has no permissions to run the 'set' command\r\n", "exec")
+
+	log := string(execArgs(m, admin, "acl", "log"))
+	for _, field := range []string{"$6\r\nreason\r\n$7\r\ncommand\r\n", "$7\r\ncontext\r\n$5\r\nmulti\r\n", "$6\r\nobject\r\n$5\r\nother\r\n", "$6\r\nreason\r\n$4\r\nauth\r\n"} {
+		if !strings.Contains(log, field) {
+			t.Errorf("acl log %q should contain %q", log, field)
+		}
+	}
+	// the same denial is counted in one entry
+	if !strings.Contains(log, "$5\r\ncount\r\n:2\r\n$6\r\nreason\r\n$3\r\nkey\r\n$7\r\ncontext\r\n$8\r\ntoplevel\r\n$6\r\nobject\r\n$5\r\nother\r\n") {
+		t.Errorf("acl log should group the same denials: %q", log)
+	}
+	expectReply(t, m, admin, "+OK\r\n", "acl", "log", "reset")
+	expectReply(t, m, admin, "*0\r\n", "acl", "log")
+
+	expectReply(t, m, admin, ":1\r\n", "acl", "deluser", "alice", "nobody")
+	if !alice.Killed() {
+		t.Errorf("the clients of a deleted user should be killed")
+	}
+}
+
+func TestACLReadModifyWrite(t *testing.T) {
+	m := newACLTestDb()
+	admin := newACLTestClient(m)
+	expectReply(t, m, admin, "+OK\r\n", "acl", "setuser", "writer", "on", "nopass", "%W~w:*", "~rw:*", "+@all")
+	writer := newACLTestClient(m)
+	expectReply(t, m, writer, "+OK\r\n", "auth", "writer", "pw")
+
+	expectReply(t, m, writer, "+OK\r\n", "set", "w:a", "1")
+	expectReply(t, m, writer, ":1\r\n", "rpush", "w:l", "x")
+	expectReply(t, m, writer, ":1\r\n", "sadd", "w:s", "x")
+	// the replies of these commands give the values of their first keys
+	for _, args := range [][]string{
+		{"incr", "w:a"},
+		{"incrby", "w:a", "2"},
+		{"decrby", "w:a", "2"},
+		{"incrbyfloat", "w:a", "1.5"},
+		{"append", "w:a", "x"},
+		{"setrange", "w:a", "0", "x"},
+		{"set", "w:a", "2", "get"},
+		{"lpop", "w:l"},
+		{"rpop", "w:l"},
+		{"lmove", "w:l", "rw:l", "left", "right"},
+		{"spop", "w:s"},
+		{"hincrby", "w:h", "f", "1"},
+	} {
+		expectReply(t, m, writer, "-NOPERM No permissions to access a key\r\n", args...)
+	}
+	// the destination of LMOVE is only written
+	expectReply(t, m, writer, ":1\r\n", "rpush", "rw:l", "x")
+	expectReply(t, m, writer, "$1\r\nx\r\n", "lmove", "rw:l", "w:l", "left", "right")
+	expectReply(t, m, writer, ":2\r\n", "incrby", "rw:a", "2")
+}
+
+func TestACLAdminCommands(t *testing.T) {
+	m := newACLTestDb()
+	for name, command := range CmdTable {
+		if command.flags&flagAdmin != 0 && !containerCommands[name] && (!inCategory(name, "admin") || !inCategory(name, "dangerous")) {
+			t.Errorf("the admin command %s should be in @admin and @dangerous", name)
+		}
+	}
+
+	admin := newACLTestClient(m)
+	for _, category := range []string{"admin", "dangerous"} {
+		expectReply(t, m, admin, "+OK\r\n", "acl", "setuser", "bob", "reset", "on", "nopass", "~*", "+@all", "-@"+category)
+		bob := newACLTestClient(m)
+		expectReply(t, m, bob, "+OK\r\n", "auth", "bob", "pw")
+		for name, args := range map[string][]string{
+			"monitor":        {"monitor"},
+			"slowlog":        {"slowlog", "get"},
+			"latency":        {"latency", "latest"},
+			"client|pause":   {"client", "pause", "10"},
+			"client|unpause": {"client", "unpause"},
+			"crdt.sync":      {"crdt.sync", "node"},
+		} {
+			expectReply(t, m, bob, "-NOPERM User bob has no permissions to run the '"+name+"' command\r\n", args...)
+		}
+		// the connection subcommands of client stay allowed
+		expectReply(t, m, bob, "+OK\r\n", "client", "setname", "bob")
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	m := newACLTestDb()
+	c := newACLTestClient(m)
+	expectReply(t, m, c, "-ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.\r\n", "acl", "save")
+
+	path := filepath.Join(t.TempDir(), "users.acl")
+	config.Configures.AclFile = path
+	defer func() { config.Configures.AclFile = "" }()
+	expectReply(t, m, c, "+OK\r\n", "acl", "setuser", "bob", "on", ">pw", "%W~logs:*", "&*", "+@all", "-@dangerous")
+	expectReply(t, m, c, "+OK\r\n", "acl", "save")
+	list := execArgs(m, c, "acl", "list")
+
+	expectReply(t, m, c, "+OK\r\n", "acl", "setuser", "carol", "on")
+	expectReply(t, m, c, "+OK\r\n", "acl", "load")
+	if res := execArgs(m, c, "acl", "list"); !bytes.Equal(res, list) {
+		t.Errorf("acl load should restore the saved users, expect %q, get %q", list, res)
+	}
+	if !strings.Contains(string(list), "user bob on #"+hashPassword("pw")+" %W~logs:* &* +@all -@dangerous") {
+		t.Errorf("acl list error: %q", list)
+	}
+
+	if err := os.WriteFile(path, []byte("user bob on\nbob off\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res := execArgs(m, c, "acl", "load"); res[0] != '-' {
+		t.Errorf("acl load should reject an invalid file: %q", res)
+	}
+	if res := execArgs(m, c, "acl", "list"); !bytes.Equal(res, list) {
+		t.Errorf("a failed acl load should keep the users: %q", res)
+	}
+}
@@ -0,0 +1,620 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var nextClientID int64
+
+// closeFlushTimeout bounds the time spent writing the pending output of a closing client
+const closeFlushTimeout = 5 * time.Second
+
+// maxPooledOutBuf is the largest reply buffer kept for reuse, the buffers grown by large replies are dropped
+const maxPooledOutBuf = 64 << 10
+
+// outBufPool keeps the reply buffers written by writeLoop for the next batches of replies
+var outBufPool = sync.Pool{New: func() any { return make([]byte, 0, 1024) }}
+
+// Client is the server side state of a connection.
+// It's created by the handler for every accepted connection and passed to command executors.
+type Client struct {
+	id         int64
+	conn       net.Conn
+	addr       string
+	laddr      string
+	unixSocket bool
+	createTime time.Time
+
+	mu              sync.Mutex
+	name            string
+	username        string // ACL user of the connection
+	authenticated   bool
+	proto           int // protocol version negotiated by HELLO
+	db              int
+	lastInteraction time.Time
+	lastCmd         string
+	noEvict         bool
+	noTouch         bool
+	closeAfterReply bool
+	killed          bool
+	replica         bool // the replication link of an active-active peer
+	replyOff        bool // CLIENT REPLY OFF
+	replySkip       int  // number of the next commands whose replies are skipped, set by CLIENT REPLY SKIP
+	monitor         bool // receives the executed commands, set by MONITOR
+
+	multi      bool              // inside MULTI
+	multiDirty bool              // a command failed to be queued, EXEC will abort
+	multiQueue [][][]byte        // commands queued by MULTI
+	watched    map[string]uint64 // watched keys and their versions at WATCH time
+
+	channels      map[string]struct{} // subscribed channels
+	patterns      map[string]struct{} // subscribed channel patterns
+	shardChannels map[string]struct{} // subscribed shard channels
+
+	commands         int64    // number of commands executed
+	tracking         bool     // CLIENT TRACKING ON
+	trackingRedirect int64    // id of the client receiving invalidation messages, 0 for itself
+	trackingBcast    bool     // notified of every modified key matching trackingPrefixes
+	trackingOptIn    bool     // only track the keys read after CLIENT CACHING YES
+	trackingOptOut   bool     // don't track the keys read after CLIENT CACHING NO
+	trackingNoLoop   bool     // not notified of the keys modified by itself
+	trackingPrefixes []string // prefixes of BCAST mode
+	cachingCmd       int64    // number of the command affected by CLIENT CACHING
+
+	// replies and pushed messages waiting to be written by writeLoop,
+	// so that a slow connection never blocks the goroutine producing its output.
+	// writeLoop is started when output is queued and exits once it's all written, an idle client has no writer goroutine.
+	outMu      sync.Mutex
+	outBuf     []byte     // replies encoded while the client is corked, queued as a single block by Uncork
+	outQueue   []outBlock // blocks waiting for writeLoop
+	outWriting []outBlock // blocks being written by writeLoop
+	outBytes   int64      // bytes queued and not written yet
+	writing    bool       // writeLoop is running
+	corked     bool       // output is buffered without being written until Uncork
+	outIdle    *sync.Cond // signaled when writeLoop exits
+	closed     bool
+	outLimits  *atomic.Value // client-output-buffer-limit by client class set by AddClient, nil if unlimited
+	softSince  time.Time     // when the output reached the soft limit, zero if it's below
+	stats      *Stats        // counts the output written, set by AddClient
+}
+
+// outBlock is a block of output queued for writeLoop
+type outBlock struct {
+	data   []byte
+	pooled bool // data is a reply buffer of outBufPool
+}
+
+// NewClient creates a client for conn.
+func NewClient(conn net.Conn) *Client {
+	now := time.Now()
+	c := &Client{
+		id:              atomic.AddInt64(&nextClientID, 1),
+		conn:            conn,
+		createTime:      now,
+		lastInteraction: now,
+		lastCmd:         "NULL",
+		username:        defaultUser,
+		authenticated:   conn == nil,
+		proto:           RESP.RESP2,
+	}
+	if conn != nil {
+		c.addr = conn.RemoteAddr().String()
+		c.laddr = conn.LocalAddr().String()
+		// the peer of a Unix socket has no address, both are shown as the socket path like Redis
+		if conn.LocalAddr().Network() == "unix" {
+			c.unixSocket = true
+			c.laddr += ":0"
+			c.addr = c.laddr
+		}
+		c.outIdle = sync.NewCond(&c.outMu)
+	}
+	return c
+}
+
+// NewFakeClient creates a client without connection, used to execute commands issued by the server itself,
+// such as AOF loading.
+func NewFakeClient() *Client {
+	return NewClient(nil)
+}
+
+func (c *Client) ID() int64 {
+	return c.id
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// CloseAfterReply reports whether the connection should be closed once the pending reply is written.
+func (c *Client) CloseAfterReply() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeAfterReply
+}
+
+// Killed reports whether the client has been closed by CLIENT KILL.
+func (c *Client) Killed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.killed
+}
+
+// Protocol returns the RESP version spoken by the client
+func (c *Client) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proto
+}
+
+// WriteData queues data encoded in the protocol of the client.
+// While the client is corked, data is encoded straight into its reply buffer.
+func (c *Client) WriteData(data RESP.RedisData) {
+	if c == nil || c.conn == nil {
+		return
+	}
+	proto, class := c.outputProfile()
+	c.outMu.Lock()
+	if c.corked && !c.closed {
+		if c.outBuf == nil {
+			c.outBuf = outBufPool.Get().([]byte)
+		}
+		c.outBuf = RESP.AppendEncode(c.outBuf, data, proto)
+		exceeded := c.checkOutputLimit(class)
+		c.outMu.Unlock()
+		if exceeded {
+			c.closeForOutputLimit(class)
+		}
+		return
+	}
+	c.outMu.Unlock()
+	c.write(RESP.Encode(data, proto), class)
+}
+
+// Write queues data to be sent to the client without waiting for the connection.
+// Output of a fake client or a closed client is dropped.
+func (c *Client) Write(data []byte) {
+	if c == nil || c.conn == nil {
+		return
+	}
+	_, class := c.outputProfile()
+	c.write(data, class)
+}
+
+// write is Write for a client of class
+func (c *Client) write(data []byte, class string) {
+	c.outMu.Lock()
+	if c.closed {
+		c.outMu.Unlock()
+		return
+	}
+	if c.corked {
+		// keep the order of the replies buffered before
+		if c.outBuf == nil {
+			c.outBuf = outBufPool.Get().([]byte)
+		}
+		c.outBuf = append(c.outBuf, data...)
+	} else {
+		c.queue(outBlock{data: data})
+	}
+	exceeded := c.checkOutputLimit(class)
+	c.outMu.Unlock()
+	if exceeded {
+		c.closeForOutputLimit(class)
+	}
+}
+
+// outputProfile returns the protocol and the class of client-output-buffer-limit of the client.
+// A monitor is listed as a normal client but gets the limits of pubsub, its output is pushed like messages.
+func (c *Client) outputProfile() (proto int, class string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.monitor {
+		return c.proto, "pubsub"
+	}
+	return c.proto, c.clientType()
+}
+
+// checkOutputLimit reports whether the output exceeds client-output-buffer-limit of class,
+// the output of such a client is dropped and no more output is accepted. Callers must hold c.outMu.
+func (c *Client) checkOutputLimit(class string) bool {
+	if c.outLimits == nil {
+		return false
+	}
+	limits, _ := c.outLimits.Load().(map[string]config.OutputBufferLimit)
+	limit, ok := limits[class]
+	if !ok {
+		return false
+	}
+	size := c.outBytes + int64(len(c.outBuf))
+	exceeded := limit.Hard > 0 && size >= limit.Hard
+	if limit.Soft > 0 && size >= limit.Soft {
+		now := time.Now()
+		if c.softSince.IsZero() {
+			c.softSince = now
+		} else if now.Sub(c.softSince) >= time.Duration(limit.SoftSeconds)*time.Second {
+			exceeded = true
+		}
+	} else {
+		c.softSince = time.Time{}
+	}
+	if !exceeded {
+		return false
+	}
+	// the blocks being written are accounted by writeLoop
+	for _, block := range c.outQueue {
+		c.outBytes -= int64(len(block.data))
+	}
+	c.closed = true
+	c.outQueue = nil
+	c.outBuf = nil
+	// the block being written is abandoned too, a client that stopped reading would block writeLoop forever
+	_ = c.conn.SetWriteDeadline(time.Now())
+	return true
+}
+
+// closeForOutputLimit closes the client that exceeded client-output-buffer-limit of class
+func (c *Client) closeForOutputLimit(class string) {
+	logger.Warning("Client", c.addr, "closed for overcoming of output buffer limits of class", class)
+	c.kill(nil)
+}
+
+// queue adds a block to the output and starts writeLoop if needed, callers must hold c.outMu.
+func (c *Client) queue(block outBlock) {
+	c.outQueue = append(c.outQueue, block)
+	c.outBytes += int64(len(block.data))
+	if !c.writing && !c.corked {
+		c.writing = true
+		go c.writeLoop()
+	}
+}
+
+// flushBuf queues the reply buffer, callers must hold c.outMu.
+func (c *Client) flushBuf() {
+	if len(c.outBuf) > 0 {
+		c.queue(outBlock{data: c.outBuf, pooled: true})
+	} else if c.outBuf != nil {
+		outBufPool.Put(c.outBuf)
+	}
+	c.outBuf = nil
+}
+
+// Cork buffers the output until Uncork, so that the replies of pipelined commands are written together
+func (c *Client) Cork() {
+	if c == nil || c.conn == nil {
+		return
+	}
+	c.outMu.Lock()
+	c.corked = true
+	c.outMu.Unlock()
+}
+
+// Uncork writes the output buffered since Cork
+func (c *Client) Uncork() {
+	if c == nil || c.conn == nil {
+		return
+	}
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if !c.corked {
+		return
+	}
+	c.corked = false
+	c.flushBuf()
+	if !c.writing && len(c.outQueue) > 0 {
+		c.writing = true
+		go c.writeLoop()
+	}
+}
+
+// Close stops the client writer after the queued output has been written.
+func (c *Client) Close() {
+	if c.conn == nil {
+		return
+	}
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		_ = c.conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+	}
+	if c.corked {
+		c.corked = false
+		c.flushBuf()
+		if !c.writing && len(c.outQueue) > 0 {
+			c.writing = true
+			go c.writeLoop()
+		}
+	}
+	for c.writing {
+		c.outIdle.Wait()
+	}
+}
+
+// writeLoop writes the queued output until the queue is empty.
+func (c *Client) writeLoop() {
+	var buffers net.Buffers
+	for {
+		c.outMu.Lock()
+		queue := c.outQueue
+		c.outQueue = nil
+		c.outWriting = queue
+		if len(queue) == 0 {
+			c.writing = false
+			c.outIdle.Broadcast()
+			c.outMu.Unlock()
+			return
+		}
+		c.outMu.Unlock()
+		buffers = buffers[:0]
+		size := 0
+		for _, block := range queue {
+			buffers = append(buffers, block.data)
+			size += len(block.data)
+		}
+		// WriteTo consumes the slice, so a copy of the header is given to it
+		pending := buffers
+		// a broken connection is reported by the reading side of the handler
+		written, err := pending.WriteTo(c.conn)
+		c.outMu.Lock()
+		if c.stats != nil {
+			c.stats.addNetOutput(written)
+		}
+		c.outBytes -= int64(size)
+		c.outWriting = nil
+		if err != nil {
+			c.closed = true
+			c.outQueue = nil
+			c.outBytes = 0
+		}
+		c.outMu.Unlock()
+		for i, block := range queue {
+			if block.pooled && cap(block.data) <= maxPooledOutBuf {
+				outBufPool.Put(block.data[:0])
+			}
+			buffers[i] = nil
+		}
+	}
+}
+
+// touch records the command the client is executing.
+func (c *Client) touch(cmdName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastInteraction = time.Now()
+	c.lastCmd = cmdName
+	c.commands++
+}
+
+// kill closes the connection of the client.
+// The calling client is closed after its reply is written, otherwise the reply would be lost.
+func (c *Client) kill(caller *Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.killed || c.closeAfterReply {
+		return
+	}
+	if c == caller {
+		c.closeAfterReply = true
+		return
+	}
+	c.killed = true
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+// user returns the ACL user of the client, callers must hold c.mu.
+func (c *Client) user() string {
+	return c.username
+}
+
+// Authenticated reports whether the client can run commands other than AUTH, HELLO and QUIT.
+func (c *Client) Authenticated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authenticated
+}
+
+// clientType returns the type of the client, callers must hold c.mu.
+func (c *Client) clientType() string {
+	if c.replica {
+		return "replica"
+	}
+	if c.subscriptions()+len(c.shardChannels) > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+func (c *Client) flags() string {
+	var flags string
+	if c.noEvict {
+		flags += "e"
+	}
+	if c.noTouch {
+		flags += "T"
+	}
+	if c.multi {
+		flags += "x"
+	}
+	if c.subscriptions()+len(c.shardChannels) > 0 {
+		flags += "P"
+	}
+	if c.tracking {
+		flags += "t"
+	}
+	if c.unixSocket {
+		flags += "U"
+	}
+	if c.replica {
+		flags += "S"
+	}
+	if c.trackingBcast {
+		flags += "B"
+	}
+	if c.monitor {
+		flags += "O"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// info returns the description of the client used by CLIENT LIST and CLIENT INFO.
+func (c *Client) info() string {
+	obl, oll, omem := c.outputStats()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	multi := -1
+	if c.multi {
+		multi = len(c.multiQueue)
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=%d multi=%d obl=%d oll=%d omem=%d cmd=%s user=%s resp=%d",
+		c.id, c.addr, c.laddr, c.name,
+		int64(now.Sub(c.createTime).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		c.flags(), c.db, len(c.channels), len(c.patterns), len(c.shardChannels), multi, obl, oll, omem, c.lastCmd, c.user(), c.proto)
+}
+
+// outputStats returns the bytes in the reply buffer, the number of blocks waiting to be written and their bytes
+func (c *Client) outputStats() (obl int, oll int, omem int64) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	return len(c.outBuf), len(c.outQueue) + len(c.outWriting), c.outBytes
+}
+
+// ReplyAllowed reports whether the reply of the command just executed should be sent, it's called once per command.
+// CLIENT REPLY OFF and SKIP suppress their own replies, CLIENT REPLY ON is replied.
+func (c *Client) ReplyAllowed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	allowed := !c.replyOff && c.replySkip == 0
+	if c.replySkip > 0 {
+		c.replySkip--
+	}
+	return allowed
+}
+
+// SetReplica marks the client as the replication link of a peer, it's limited by the replica class of
+// client-output-buffer-limit and listed by CLIENT LIST TYPE replica.
+func (c *Client) SetReplica() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replica = true
+}
+
+// Clients is the list of connected clients.
+type Clients struct {
+	mu      sync.RWMutex
+	clients map[int64]*Client
+}
+
+func NewClients() *Clients {
+	return &Clients{clients: make(map[int64]*Client)}
+}
+
+func (cs *Clients) Add(c *Client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.clients[c.id] = c
+}
+
+func (cs *Clients) Remove(c *Client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.clients, c.id)
+}
+
+// Get returns the client of id, nil if it's not connected.
+func (cs *Clients) Get(id int64) *Client {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.clients[id]
+}
+
+func (cs *Clients) Len() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return len(cs.clients)
+}
+
+// List returns all clients ordered by id.
+func (cs *Clients) List() []*Client {
+	cs.mu.RLock()
+	res := make([]*Client, 0, len(cs.clients))
+	for _, c := range cs.clients {
+		res = append(res, c)
+	}
+	cs.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].id < res[j].id
+	})
+	return res
+}
+
+// clientFilter selects the clients of CLIENT LIST and CLIENT KILL.
+type clientFilter struct {
+	ids    map[int64]struct{}
+	addr   string
+	laddr  string
+	user   string
+	typ    string
+	skipMe bool
+}
+
+func (f *clientFilter) match(c, caller *Client) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.ids != nil {
+		if _, ok := f.ids[c.id]; !ok {
+			return false
+		}
+	}
+	if f.addr != "" && f.addr != c.addr {
+		return false
+	}
+	if f.laddr != "" && f.laddr != c.laddr {
+		return false
+	}
+	if f.user != "" && f.user != c.user() {
+		return false
+	}
+	if f.typ != "" && f.typ != c.clientType() {
+		return false
+	}
+	if f.skipMe && c == caller {
+		return false
+	}
+	return true
+}
+
+// normalizeClientType maps the type names accepted by CLIENT LIST and CLIENT KILL, return "" if it's unknown.
+func normalizeClientType(typ string) string {
+	switch strings.ToLower(typ) {
+	case "normal":
+		return "normal"
+	case "master":
+		return "master"
+	case "replica", "slave":
+		return "replica"
+	case "pubsub":
+		return "pubsub"
+	}
+	return ""
+}
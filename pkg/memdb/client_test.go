@@ -0,0 +1,217 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	config.Configures = &config.Config{ShardNum: 100}
+}
+
+func newTestClient(m *MemDb) (*Client, net.Conn) {
+	RegisterInfoCommands()
+	server, peer := net.Pipe()
+	c := NewClient(server)
+	m.AddClient(c)
+	return c, peer
+}
+
+func TestClientNameAndInfo(t *testing.T) {
+	m := NewMemDb()
+	c, _ := newTestClient(m)
+
+	res := m.ExecCommand(c, [][]byte{[]byte("client"), []byte("getname")})
+	if !bytes.Equal(res.ToBytes(), []byte("$-1\r\n")) {
+		t.Error("client getname should reply null before setname")
+	}
+	res = m.ExecCommand(c, [][]byte{[]byte("client"), []byte("setname"), []byte("bad name")})
+	if _, ok := res.(interface{ Error() string }); !ok {
+		t.Error("client setname should reject names with spaces")
+	}
+	m.ExecCommand(c, [][]byte{[]byte("client"), []byte("setname"), []byte("worker")})
+	res = m.ExecCommand(c, [][]byte{[]byte("client"), []byte("getname")})
+	if !bytes.Equal(res.ToBytes(), []byte("$6\r\nworker\r\n")) {
+		t.Error("client getname reply error")
+	}
+	res = m.ExecCommand(c, [][]byte{[]byte("client"), []byte("id")})
+	if !bytes.Equal(res.ToBytes(), []byte(fmt.Sprintf(":%d\r\n", c.ID()))) {
+		t.Error("client id reply error")
+	}
+	m.ExecCommand(c, [][]byte{[]byte("client"), []byte("no-evict"), []byte("on")})
+	info := string(m.ExecCommand(c, [][]byte{[]byte("client"), []byte("info")}).ByteData())
+	for _, field := range []string{fmt.Sprintf("id=%d ", c.ID()), "name=worker ", "flags=e ", "cmd=client ", "user=default"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("client info %q should contain %q", info, field)
+		}
+	}
+}
+
+func TestClientListAndKill(t *testing.T) {
+	m := NewMemDb()
+	c1, _ := newTestClient(m)
+	c2, _ := newTestClient(m)
+	c3, _ := newTestClient(m)
+
+	list := string(m.ExecCommand(c1, [][]byte{[]byte("client"), []byte("list")}).ByteData())
+	if strings.Count(list, "\n") != 3 {
+		t.Errorf("client list should have 3 lines, get %q", list)
+	}
+	list = string(m.ExecCommand(c1, [][]byte{[]byte("client"), []byte("list"), []byte("id"), []byte(fmt.Sprint(c2.ID()))}).ByteData())
+	if strings.Count(list, "\n") != 1 || !strings.HasPrefix(list, fmt.Sprintf("id=%d ", c2.ID())) {
+		t.Errorf("client list id should only return client %d, get %q", c2.ID(), list)
+	}
+
+	res := m.ExecCommand(c1, [][]byte{[]byte("client"), []byte("kill"), []byte("id"), []byte(fmt.Sprint(c2.ID()))})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) || !c2.Killed() {
+		t.Error("client kill id error")
+	}
+	// skipme defaults to yes
+	res = m.ExecCommand(c1, [][]byte{[]byte("client"), []byte("kill"), []byte("type"), []byte("normal")})
+	if !bytes.Equal(res.ToBytes(), []byte(":2\r\n")) || !c3.Killed() || c1.Killed() {
+		t.Errorf("client kill type error: %s", res.ToBytes())
+	}
+	res = m.ExecCommand(c1, [][]byte{[]byte("client"), []byte("kill"), []byte("127.0.0.1:1")})
+	if !bytes.Equal(res.ToBytes(), []byte("-ERR No such client\r\n")) {
+		t.Error("client kill addr should fail for unknown address")
+	}
+	res = m.ExecCommand(c1, [][]byte{[]byte("client"), []byte("kill"), []byte("id"), []byte(fmt.Sprint(c1.ID())), []byte("skipme"), []byte("no")})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) || c1.Killed() || !c1.CloseAfterReply() {
+		t.Error("killing the calling client should close it after the reply")
+	}
+}
+
+func TestClientCorkedOutput(t *testing.T) {
+	m := NewMemDb()
+	c, peer := newTestClient(m)
+	observer, _ := newTestClient(m)
+	clientInfo := func() string {
+		return string(m.ExecCommand(observer, [][]byte{[]byte("client"), []byte("list"), []byte("id"), []byte(fmt.Sprint(c.ID()))}).ByteData())
+	}
+
+	// the replies are encoded into the reply buffer while the client is corked
+	c.Cork()
+	c.WriteData(m.ExecCommand(c, [][]byte{[]byte("client"), []byte("getname")}))
+	c.Write([]byte("+pushed\r\n"))
+	c.WriteData(m.ExecCommand(c, [][]byte{[]byte("client"), []byte("id")}))
+	expect := fmt.Sprintf("$-1\r\n+pushed\r\n:%d\r\n", c.ID())
+	if info := clientInfo(); !strings.Contains(info, fmt.Sprintf(" obl=%d oll=0 ", len(expect))) {
+		t.Errorf("the corked replies should be in the reply buffer: %q", info)
+	}
+
+	// net.Pipe blocks the writer until the peer reads, the buffer is a single block being written
+	c.Uncork()
+	if info := clientInfo(); !strings.Contains(info, " obl=0 oll=1 ") {
+		t.Errorf("the uncorked replies should be queued as one block: %q", info)
+	}
+	buf := make([]byte, 1024)
+	n, err := peer.Read(buf)
+	if err != nil || string(buf[:n]) != expect {
+		t.Errorf("the replies should be written at once: %q %v | expect: %q", buf[:n], err, expect)
+	}
+
+	// the replies buffered when the client is closed are written before it's closed
+	c.Cork()
+	c.WriteData(m.ExecCommand(c, [][]byte{[]byte("client"), []byte("getname")}))
+	go m.CloseClient(c)
+	if n, err = peer.Read(buf); err != nil || string(buf[:n]) != "$-1\r\n" {
+		t.Errorf("the buffered replies should be written on close: %q %v", buf[:n], err)
+	}
+}
+
+func TestCloseIdleClients(t *testing.T) {
+	m := NewMemDb()
+	idle, _ := newTestClient(m)
+	subscriber, _ := newTestClient(m)
+	active, _ := newTestClient(m)
+	for _, c := range []*Client{idle, subscriber} {
+		c.lastInteraction = time.Now().Add(-time.Hour)
+	}
+	subscriber.channels = map[string]struct{}{"news": {}}
+
+	if closed := m.CloseIdleClients(time.Minute); closed != 1 {
+		t.Errorf("only the idle client should be closed, %d are closed", closed)
+	}
+	if !idle.Killed() || subscriber.Killed() || active.Killed() {
+		t.Errorf("killed: idle %v subscriber %v active %v", idle.Killed(), subscriber.Killed(), active.Killed())
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	m := NewMemDb()
+	c, _ := newTestClient(m)
+	exec := func(args ...string) string {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return string(m.ExecCommand(c, cmd).ToBytes())
+	}
+	held := func(args ...string) bool {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return m.PauseWait(c, cmd) != nil
+	}
+
+	for _, args := range [][]string{{"client", "pause"}, {"client", "pause", "-1"}, {"client", "pause", "10", "read"}} {
+		if res := exec(args...); !strings.HasPrefix(res, "-ERR") {
+			t.Errorf("%v should be rejected: %q", args, res)
+		}
+	}
+	if res := exec("client", "pause", "60000", "write"); res != "+OK\r\n" {
+		t.Fatalf("client pause reply error: %q", res)
+	}
+	if held("get", "k") || !held("set", "k", "v") {
+		t.Error("only the write commands should be held by CLIENT PAUSE WRITE")
+	}
+	c.multiQueue = [][][]byte{{[]byte("get"), []byte("k")}}
+	if held("exec") {
+		t.Error("a read only transaction should not be held")
+	}
+	c.multiQueue = append(c.multiQueue, [][]byte{[]byte("incr"), []byte("k")})
+	if !held("exec") {
+		t.Error("a transaction with a write command should be held")
+	}
+	c.multiQueue = nil
+
+	// a shorter pause doesn't shorten the one in progress, ALL is more restrictive than WRITE
+	changed := m.PauseWait(c, [][]byte{[]byte("set"), []byte("k"), []byte("v")})
+	exec("client", "pause", "10", "all")
+	select {
+	case <-changed:
+	default:
+		t.Error("the held commands should be woken up when the pause changes")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !held("get", "k") {
+		t.Error("the longer pause should be kept with the more restrictive mode")
+	}
+	changed = m.PauseWait(c, [][]byte{[]byte("get"), []byte("k")})
+	if res := exec("client", "unpause"); res != "+OK\r\n" {
+		t.Errorf("client unpause reply error: %q", res)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("the held commands should be woken up by CLIENT UNPAUSE")
+	}
+	if held("set", "k", "v") {
+		t.Error("no command should be held after CLIENT UNPAUSE")
+	}
+
+	// the pause ends by itself
+	exec("client", "pause", "10")
+	changed = m.PauseWait(c, [][]byte{[]byte("get"), []byte("k")})
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Error("the held commands should be woken up at the end of the pause")
+	}
+}
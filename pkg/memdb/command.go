@@ -1,17 +1,377 @@
 package memdb
 
 import (
+	"fmt"
 	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/util"
+	"sort"
+	"strings"
 )
 
-type cmdExecutor func(m *MemDb, cmd [][]byte) RESP.RedisData
+type cmdExecutor func(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData
 
 var CmdTable = make(map[string]*command)
 
+// commandFlags describe the behavior of a command, they're given by COMMAND INFO
+type commandFlags int
+
+const (
+	flagWrite    commandFlags = 1 << iota // modifies the database
+	flagReadonly                          // only reads the database
+	flagDenyOOM                           // may use more memory
+	flagAdmin                             // administers the server
+	flagPubSub                            // pub/sub command
+	flagNoScript                          // can't be called by scripts
+	flagBlocking                          // may block the client
+	// the reply of a write command gives the value of its first key, such as INCR, so the key is read too.
+	// Redis tells it by the key specs rather than a flag, so it's not given by COMMAND INFO.
+	flagAccess
+)
+
+// flagNames are the names of the flags in the replies of COMMAND, in their order,
+// with the ACL category a flag puts its commands in, such as @write for the write commands
+var flagNames = []struct {
+	flag     commandFlags
+	name     string
+	category aclCategory
+}{
+	{flagWrite, "write", catWrite},
+	{flagReadonly, "readonly", catRead},
+	{flagDenyOOM, "denyoom", 0},
+	{flagAdmin, "admin", 0},
+	{flagPubSub, "pubsub", catPubSub},
+	{flagNoScript, "noscript", 0},
+	{flagBlocking, "blocking", catBlocking},
+}
+
+// aclCategory is a set of ACL categories of a command, every command is in @all as well
+type aclCategory int
+
+const (
+	catKeyspace aclCategory = 1 << iota
+	catRead
+	catWrite
+	catString
+	catHash
+	catList
+	catSet
+	catSortedSet
+	catPubSub
+	catBlocking
+	catTransaction
+	catConnection
+	catAdmin
+	catDangerous
+)
+
+// categoryNames are the names of the ACL categories
+var categoryNames = []struct {
+	category aclCategory
+	name     string
+}{
+	{catKeyspace, "keyspace"},
+	{catRead, "read"},
+	{catWrite, "write"},
+	{catString, "string"},
+	{catHash, "hash"},
+	{catList, "list"},
+	{catSet, "set"},
+	{catSortedSet, "sortedset"},
+	{catPubSub, "pubsub"},
+	{catBlocking, "blocking"},
+	{catTransaction, "transaction"},
+	{catConnection, "connection"},
+	{catAdmin, "admin"},
+	{catDangerous, "dangerous"},
+}
+
+// commandDoc is the documentation of a command given by COMMAND DOCS, like the docs of Redis
+type commandDoc struct {
+	summary string
+	since   string // version of Redis the command appeared in
+	group   string
+}
+
+// command is an entry of CmdTable
+// arity is the number of arguments including the command name, a negative arity -n means at least n arguments.
+// categories are the ACL categories of the command, including the categories of its flags.
+// subcommands are the categories of the subcommands of a container command, such as CLIENT KILL,
+// a subcommand missing in it has the categories of its command.
+// firstKey, lastKey and keyStep give the positions of the keys in the arguments:
+// firstKey is the index of the first key, 0 if the command has no key,
+// lastKey is the index of the last key, negative index counts from the end,
+// keyStep is the distance between two keys, such as 2 for MSET key value [key value ...]
+// doc is given by COMMAND DOCS.
 type command struct {
-	executor cmdExecutor
+	name        string
+	executor    cmdExecutor
+	arity       int
+	flags       commandFlags
+	categories  aclCategory
+	subcommands map[string]aclCategory
+	firstKey    int
+	lastKey     int
+	keyStep     int
+	doc         commandDoc
+}
+
+func RegisterCommand(cmdName string, executor cmdExecutor, arity int, flags commandFlags, categories aclCategory,
+	firstKey, lastKey, keyStep int, doc commandDoc) {
+	CmdTable[cmdName] = &command{
+		name:       cmdName,
+		executor:   executor,
+		arity:      arity,
+		flags:      flags,
+		categories: categories | flagCategories(flags),
+		firstKey:   firstKey,
+		lastKey:    lastKey,
+		keyStep:    keyStep,
+		doc:        doc,
+	}
+}
+
+// registerSubcommands sets the categories of the subcommands of the container command cmdName,
+// a subcommand is in the categories of the flags of its command too
+func registerSubcommands(cmdName string, subcommands map[string]aclCategory) {
+	command := CmdTable[cmdName]
+	command.subcommands = make(map[string]aclCategory, len(subcommands))
+	for name, categories := range subcommands {
+		command.subcommands[name] = categories | flagCategories(command.flags)
+	}
+}
+
+// flagCategories returns the ACL categories given by flags
+func flagCategories(flags commandFlags) aclCategory {
+	var res aclCategory
+	for _, f := range flagNames {
+		if flags&f.flag != 0 {
+			res |= f.category
+		}
+	}
+	return res
+}
+
+// checkArity reports whether n arguments including the command name match the arity of c
+func (c *command) checkArity(n int) bool {
+	if c.arity >= 0 {
+		return n == c.arity
+	}
+	return n >= -c.arity
+}
+
+// arityError is the error of a call of c with a number of arguments not matching its arity
+func (c *command) arityError() RESP.RedisData {
+	return RESP.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", c.name))
+}
+
+// readsFirstKey reports whether the write command cmd reads its first key, as the flagAccess commands
+// and SET with the GET option do
+func (c *command) readsFirstKey(cmd [][]byte) bool {
+	if c.flags&flagAccess != 0 {
+		return true
+	}
+	// the arity of cmd is checked after the ACL
+	if c.name == "set" && len(cmd) > 3 {
+		for _, arg := range cmd[3:] {
+			if strings.EqualFold(string(arg), "get") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keys returns the keys in the arguments of cmd
+func (c *command) keys(cmd [][]byte) []string {
+	if c.firstKey <= 0 || c.firstKey >= len(cmd) {
+		return nil
+	}
+	last := c.lastKey
+	if last < 0 {
+		last += len(cmd)
+	}
+	if last >= len(cmd) {
+		last = len(cmd) - 1
+	}
+	step := c.keyStep
+	if step <= 0 {
+		step = 1
+	}
+	keys := make([]string, 0, (last-c.firstKey)/step+1)
+	for i := c.firstKey; i <= last; i += step {
+		keys = append(keys, string(cmd[i]))
+	}
+	return keys
+}
+
+// IsWriteCommand reports whether cmd modifies the database, by the write flag of its command
+func IsWriteCommand(cmd [][]byte) bool {
+	if len(cmd) == 0 {
+		return false
+	}
+	command, ok := CmdTable[strings.ToLower(string(cmd[0]))]
+	return ok && command.flags&flagWrite != 0
+}
+
+// categoryList returns the names of the ACL categories of the command, sorted
+func (c *command) categoryList() []string {
+	var res []string
+	for _, cat := range categoryNames {
+		if c.categories&cat.category != 0 {
+			res = append(res, cat.name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// info replies the entry of the command in COMMAND INFO:
+// its name, arity, flags, first key, last key, key step and ACL categories
+func (c *command) info() RESP.RedisData {
+	flags := make([]RESP.RedisData, 0, len(flagNames))
+	for _, f := range flagNames {
+		if c.flags&f.flag != 0 {
+			flags = append(flags, RESP.MakeStringData(f.name))
+		}
+	}
+	categories := c.categoryList()
+	cats := make([]RESP.RedisData, len(categories))
+	for i, category := range categories {
+		cats[i] = RESP.MakeStringData("@" + category)
+	}
+	return RESP.MakeArrayData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte(c.name)),
+		RESP.MakeIntData(int64(c.arity)),
+		RESP.MakeSetData(flags),
+		RESP.MakeIntData(int64(c.firstKey)),
+		RESP.MakeIntData(int64(c.lastKey)),
+		RESP.MakeIntData(int64(c.keyStep)),
+		RESP.MakeSetData(cats),
+	})
+}
+
+// docs replies the entry of the command in COMMAND DOCS
+func (c *command) docs() RESP.RedisData {
+	return RESP.MakeMapData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte("summary")), RESP.MakeBulkData([]byte(c.doc.summary)),
+		RESP.MakeBulkData([]byte("since")), RESP.MakeBulkData([]byte(c.doc.since)),
+		RESP.MakeBulkData([]byte("group")), RESP.MakeBulkData([]byte(c.doc.group)),
+	})
+}
+
+// commandNames returns the names of the commands in CmdTable, sorted
+func commandNames() []string {
+	names := make([]string, 0, len(CmdTable))
+	for name := range CmdTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandCmd
+// COMMAND [COUNT | INFO [command ...] | DOCS [command ...] | GETKEYS command [arg ...] |
+// LIST [FILTERBY MODULE name | ACLCAT category | PATTERN pattern]]
+func commandCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) == 1 {
+		return commandInfo(nil)
+	}
+	switch strings.ToLower(string(cmd[1])) {
+	case "count":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'command|count' command")
+		}
+		return RESP.MakeIntData(int64(len(CmdTable)))
+	case "info":
+		return commandInfo(cmd[2:])
+	case "docs":
+		names := commandNames()
+		if len(cmd) > 2 {
+			names = names[:0]
+			for _, name := range cmd[2:] {
+				if _, ok := CmdTable[strings.ToLower(string(name))]; ok {
+					names = append(names, strings.ToLower(string(name)))
+				}
+			}
+		}
+		res := make([]RESP.RedisData, 0, len(names)*2)
+		for _, name := range names {
+			res = append(res, RESP.MakeBulkData([]byte(name)), CmdTable[name].docs())
+		}
+		return RESP.MakeMapData(res)
+	case "getkeys":
+		if len(cmd) < 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'command|getkeys' command")
+		}
+		command, ok := CmdTable[strings.ToLower(string(cmd[2]))]
+		if !ok {
+			return RESP.MakeErrorData("ERR Invalid command specified")
+		}
+		if !command.checkArity(len(cmd) - 2) {
+			return RESP.MakeErrorData("ERR Invalid number of arguments specified for command")
+		}
+		keys := command.keys(cmd[2:])
+		if len(keys) == 0 {
+			return RESP.MakeErrorData("ERR The command has no key arguments")
+		}
+		res := make([]RESP.RedisData, len(keys))
+		for i, key := range keys {
+			res[i] = RESP.MakeBulkData([]byte(key))
+		}
+		return RESP.MakeArrayData(res)
+	case "list":
+		return commandList(cmd[2:])
+	default:
+		return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", string(cmd[1])))
+	}
+}
+
+// commandInfo replies COMMAND INFO of names, all the commands if none is given, an unknown command is null
+func commandInfo(names [][]byte) RESP.RedisData {
+	if len(names) == 0 {
+		all := commandNames()
+		res := make([]RESP.RedisData, len(all))
+		for i, name := range all {
+			res[i] = CmdTable[name].info()
+		}
+		return RESP.MakeArrayData(res)
+	}
+	res := make([]RESP.RedisData, len(names))
+	for i, name := range names {
+		if command, ok := CmdTable[strings.ToLower(string(name))]; ok {
+			res[i] = command.info()
+		} else {
+			res[i] = RESP.MakeNullData()
+		}
+	}
+	return RESP.MakeArrayData(res)
 }
 
-func RegisterCommand(cmdName string, executor cmdExecutor) {
-	CmdTable[cmdName] = &command{executor: executor}
+// commandList replies COMMAND LIST with the filter in args, there is no module so FILTERBY MODULE is always empty
+func commandList(args [][]byte) RESP.RedisData {
+	if len(args) != 0 && (len(args) != 3 || strings.ToLower(string(args[0])) != "filterby") {
+		return RESP.MakeErrorData("ERR syntax error")
+	}
+	match := func(name string) bool { return true }
+	if len(args) == 3 {
+		value := string(args[2])
+		switch strings.ToLower(string(args[1])) {
+		case "module":
+			match = func(name string) bool { return false }
+		case "aclcat":
+			category := strings.ToLower(value)
+			match = func(name string) bool { return inCategory(name, category) }
+		case "pattern":
+			match = func(name string) bool { return util.PatternMatch(strings.ToLower(value), name) }
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	res := make([]RESP.RedisData, 0, len(CmdTable))
+	for _, name := range commandNames() {
+		if match(name) {
+			res = append(res, RESP.MakeBulkData([]byte(name)))
+		}
+	}
+	return RESP.MakeArrayData(res)
 }
@@ -0,0 +1,108 @@
+package memdb
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func registerAllCommands() {
+	RegisterKeyCommand()
+	RegisterStringCommands()
+	RegisterHashCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+	RegisterZSetCommands()
+	RegisterInfoCommands()
+	RegisterMultiCommands()
+	RegisterPubSubCommands()
+	RegisterACLCommands()
+}
+
+func TestCommandTable(t *testing.T) {
+	registerAllCommands()
+	for name, command := range CmdTable {
+		if command.doc.summary == "" || command.doc.since == "" || command.doc.group == "" {
+			t.Errorf("%s has no docs", name)
+		}
+		if command.flags&flagWrite != 0 && command.flags&flagReadonly != 0 {
+			t.Errorf("%s is both write and readonly", name)
+		}
+		if command.flags&flagDenyOOM != 0 && command.flags&flagWrite == 0 {
+			t.Errorf("%s is denyoom but not write", name)
+		}
+		if command.arity == 0 {
+			t.Errorf("%s has no arity", name)
+		}
+	}
+	for _, name := range []string{"setrange", "incrbyfloat", "lpushx", "rpushx", "lmove", "sdiffstore", "sinterstore", "sunionstore"} {
+		if !IsWriteCommand([][]byte{[]byte(strings.ToUpper(name))}) || !inCategory(name, "write") {
+			t.Errorf("%s should be a write command", name)
+		}
+	}
+	for _, name := range []string{"get", "command", "nosuchcommand"} {
+		if IsWriteCommand([][]byte{[]byte(name)}) {
+			t.Errorf("%s should not be a write command", name)
+		}
+	}
+	if !inCategory("get", "read") || inCategory("get", "write") || !inCategory("publish", "pubsub") || !inCategory("client|kill", "admin") {
+		t.Error("the categories of the flags are wrong")
+	}
+}
+
+func TestCommandCmd(t *testing.T) {
+	registerAllCommands()
+	m := NewMemDb()
+	c, _ := newTestClient(m)
+	exec := func(args ...string) string {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return string(m.ExecCommand(c, cmd).ToBytes())
+	}
+
+	if res := exec("command", "count"); res != ":"+strconv.Itoa(len(CmdTable))+"\r\n" {
+		t.Errorf("command count %q", res)
+	}
+	if res := exec("command"); !strings.HasPrefix(res, "*"+strconv.Itoa(len(CmdTable))+"\r\n") {
+		t.Errorf("command %q", res[:20])
+	}
+	res := exec("command", "info", "GET", "mset", "nosuchcommand")
+	want := "*3\r\n" +
+		"*7\r\n$3\r\nget\r\n:2\r\n~1\r\n+readonly\r\n:1\r\n:1\r\n:1\r\n~2\r\n+@read\r\n+@string\r\n" +
+		"*7\r\n$4\r\nmset\r\n:-3\r\n~2\r\n+write\r\n+denyoom\r\n:1\r\n:-1\r\n:2\r\n~2\r\n+@string\r\n+@write\r\n" +
+		"_\r\n"
+	if res != want {
+		t.Errorf("command info %q", res)
+	}
+	if res = exec("command", "docs", "get", "nosuchcommand"); res != "%1\r\n$3\r\nget\r\n%3\r\n$7\r\nsummary\r\n$34\r\nReturns the string value of a key.\r\n"+
+		"$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$6\r\nstring\r\n" {
+		t.Errorf("command docs %q", res)
+	}
+
+	for args, want := range map[string]string{
+		"mset a 1 b 2":             "*2\r\n$1\r\na\r\n$1\r\nb\r\n",
+		"lmove src dst LEFT RIGHT": "*2\r\n$3\r\nsrc\r\n$3\r\ndst\r\n",
+		"get":                      "-ERR Invalid number of arguments specified for command\r\n",
+		"ping":                     "-ERR The command has no key arguments\r\n",
+		"nosuchcommand k":          "-ERR Invalid command specified\r\n",
+	} {
+		if res = exec(append([]string{"command", "getkeys"}, strings.Fields(args)...)...); res != want {
+			t.Errorf("command getkeys %s %q, expected %q", args, res, want)
+		}
+	}
+
+	if res = exec("command", "list", "filterby", "pattern", "h*et*"); res != "*5\r\n$4\r\nhget\r\n$7\r\nhgetall\r\n$5\r\nhmget\r\n$4\r\nhset\r\n$6\r\nhsetnx\r\n" {
+		t.Errorf("command list pattern %q", res)
+	}
+	if res = exec("command", "list", "filterby", "aclcat", "pubsub"); !strings.HasPrefix(res, "*9\r\n") {
+		t.Errorf("command list aclcat %q", res)
+	}
+	if res = exec("command", "list", "filterby", "module", "x"); res != "*0\r\n" {
+		t.Errorf("command list module %q", res)
+	}
+	if res = exec("command", "list", "filterby", "pattern"); res != "-ERR syntax error\r\n" {
+		t.Errorf("command list syntax %q", res)
+	}
+}
@@ -2,7 +2,9 @@ package memdb
 
 import (
 	"github.com/hsn/tiny-redis/pkg/util"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 
 	"github.com/emirpasic/gods/trees/redblacktree"
 )
@@ -32,6 +34,9 @@ type ConcurrentMap struct {
 	table []*shard // 哈希表分片数组
 	size  int      // 哈希表的大小
 	count int      // 所有分片中键值对的总数
+	// typeCounts counts the values of every type of valueTypes, accessed atomically,
+	// so that the keys are counted by type without walking the shards
+	typeCounts [len(valueTypes)]int64
 }
 
 // NewConcurrentMap 创建一个新的 ConcurrentMap 实例
@@ -72,9 +77,12 @@ func (m *ConcurrentMap) Set(key string, value any) int {
 
 	if shard.tree != nil {
 		// 红黑树已存在，直接使用树插入
-		_, exists := shard.tree.Get(key)
+		old, exists := shard.tree.Get(key)
 		shard.tree.Put(key, value)
-		if !exists {
+		m.countType(value, 1)
+		if exists {
+			m.countType(old, -1)
+		} else {
 			m.count++
 			shard.count++
 			added = 1
@@ -84,6 +92,8 @@ func (m *ConcurrentMap) Set(key string, value any) int {
 		node := shard.head
 		for node != nil {
 			if node.key == key {
+				m.countType(node.value, -1)
+				m.countType(value, 1)
 				node.value = value
 				return added
 			}
@@ -93,6 +103,7 @@ func (m *ConcurrentMap) Set(key string, value any) int {
 		shard.head = &listNode{key: key, value: value, next: shard.head}
 		shard.count++
 		m.count++
+		m.countType(value, 1)
 		added = 1
 
 		// 检查是否需要将链表转换为红黑树
@@ -149,11 +160,12 @@ func (m *ConcurrentMap) Delete(key string) int {
 
 	if shard.tree != nil {
 		// 从红黑树中删除
-		_, found := shard.tree.Get(key)
+		value, found := shard.tree.Get(key)
 		if found {
 			shard.tree.Remove(key)
 			shard.count--
 			m.count--
+			m.countType(value, -1)
 			return 1
 		}
 	} else {
@@ -169,6 +181,7 @@ func (m *ConcurrentMap) Delete(key string) int {
 				}
 				shard.count--
 				m.count--
+				m.countType(node.value, -1)
 				return 1
 			}
 			prev = node
@@ -186,14 +199,18 @@ func (m *ConcurrentMap) SetIfExist(key string, value any) int {
 	defer shard.rwMu.Unlock()
 
 	if shard.tree != nil {
-		if _, found := shard.tree.Get(key); found {
+		if old, found := shard.tree.Get(key); found {
 			shard.tree.Put(key, value)
+			m.countType(old, -1)
+			m.countType(value, 1)
 			return 1
 		}
 	} else {
 		node := shard.head
 		for node != nil {
 			if node.key == key {
+				m.countType(node.value, -1)
+				m.countType(value, 1)
 				node.value = value
 				return 1
 			}
@@ -215,6 +232,7 @@ func (m *ConcurrentMap) SetIfNotExist(key string, value any) int {
 			shard.tree.Put(key, value)
 			shard.count++
 			m.count++
+			m.countType(value, 1)
 			return 1
 		}
 	} else {
@@ -229,6 +247,7 @@ func (m *ConcurrentMap) SetIfNotExist(key string, value any) int {
 		shard.head = &listNode{key: key, value: value, next: shard.head}
 		shard.count++
 		m.count++
+		m.countType(value, 1)
 
 		// 检查是否需要将链表转换为红黑树
 		if shard.count >= TreeifyThreshold {
@@ -244,6 +263,23 @@ func (m *ConcurrentMap) Len() int {
 	return m.count
 }
 
+// countType adds delta to the count of the type of value, the values of no type of valueTypes aren't counted
+func (m *ConcurrentMap) countType(value any, delta int64) {
+	if i := valueTypeIndex(value); i >= 0 {
+		atomic.AddInt64(&m.typeCounts[i], delta)
+	}
+}
+
+// TypeCount returns the number of values of the type named kind, such as "string"
+func (m *ConcurrentMap) TypeCount(kind string) int64 {
+	for i, name := range valueTypes {
+		if name == kind {
+			return atomic.LoadInt64(&m.typeCounts[i])
+		}
+	}
+	return 0
+}
+
 // Clear 清空 ConcurrentMap 中的所有键值对
 func (m *ConcurrentMap) Clear() {
 	*m = *NewConcurrentMap(m.size)
@@ -251,21 +287,19 @@ func (m *ConcurrentMap) Clear() {
 
 // Keys 返回 ConcurrentMap 中所有的键
 func (m *ConcurrentMap) Keys() []string {
-	keys := make([]string, m.count)
-	i := 0
+	// the keys may change while the shards are walked, so count is only a hint
+	keys := make([]string, 0, m.count)
 	for _, shard := range m.table {
 		shard.rwMu.RLock()
 		if shard.tree != nil {
 			it := shard.tree.Iterator()
 			for it.Next() {
-				keys[i] = it.Key().(string)
-				i++
+				keys = append(keys, it.Key().(string))
 			}
 		} else {
 			node := shard.head
 			for node != nil {
-				keys[i] = node.key
-				i++
+				keys = append(keys, node.key)
 				node = node.next
 			}
 		}
@@ -273,3 +307,58 @@ func (m *ConcurrentMap) Keys() []string {
 	}
 	return keys
 }
+
+// Range 依次遍历所有分片，对每个键值对调用 fn，fn 返回 false 时停止遍历。遍历某个分片时持有它的读锁，fn 不能修改 ConcurrentMap
+func (m *ConcurrentMap) Range(fn func(key string, value any) bool) {
+	for _, shard := range m.table {
+		shard.rwMu.RLock()
+		next := true
+		if shard.tree != nil {
+			it := shard.tree.Iterator()
+			for next && it.Next() {
+				next = fn(it.Key().(string), it.Value())
+			}
+		} else {
+			for node := shard.head; next && node != nil; node = node.next {
+				next = fn(node.key, node.value)
+			}
+		}
+		shard.rwMu.RUnlock()
+		if !next {
+			return
+		}
+	}
+}
+
+// RandomKeys 从随机位置开始依次遍历分片，每个非空分片随机取一个键，最多返回 n 个键，用于定期删除过期键时抽样
+func (m *ConcurrentMap) RandomKeys(n int) []string {
+	keys := make([]string, 0, n)
+	start := rand.Intn(m.size)
+	for i := 0; i < m.size && len(keys) < n; i++ {
+		shard := m.table[(start+i)%m.size]
+		shard.rwMu.RLock()
+		if shard.count > 0 {
+			idx := rand.Intn(shard.count)
+			if shard.tree != nil {
+				it := shard.tree.Iterator()
+				for j := 0; it.Next(); j++ {
+					if j == idx {
+						keys = append(keys, it.Key().(string))
+						break
+					}
+				}
+			} else {
+				node := shard.head
+				for j := 0; node != nil; j++ {
+					if j == idx {
+						keys = append(keys, node.key)
+						break
+					}
+					node = node.next
+				}
+			}
+		}
+		shard.rwMu.RUnlock()
+	}
+	return keys
+}
@@ -95,3 +95,45 @@ func TestConcurrentMap_ManyHashCollisions_ListNode(t *testing.T) {
 		assert.Nil(t, value, "Deleted key should return nil value")
 	}
 }
+
+func TestConcurrentMapRandomKeys(t *testing.T) {
+	cmap := NewConcurrentMap(4)
+	assert.Empty(t, cmap.RandomKeys(3), "Empty map should return no key")
+
+	// 使用足够多的键，使分片转换为红黑树
+	for i := 0; i < 100; i++ {
+		cmap.Set(fmt.Sprintf("key%d", i), i)
+	}
+	keys := cmap.RandomKeys(3)
+	assert.Equal(t, 3, len(keys), "Should return 3 keys")
+	for _, key := range keys {
+		_, found := cmap.Get(key)
+		assert.True(t, found, "Random key should exist")
+	}
+
+	// 只有一个键时，无论从哪个分片开始都能取到
+	cmap = NewConcurrentMap(64)
+	cmap.Set("only", 1)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []string{"only"}, cmap.RandomKeys(20), "The only key should be returned")
+	}
+}
+
+func TestConcurrentMapTypeCount(t *testing.T) {
+	cmap := NewConcurrentMap(4)
+	// 使用足够多的键，使分片转换为红黑树
+	for i := 0; i < 100; i++ {
+		cmap.Set(fmt.Sprintf("key%d", i), []byte("v"))
+	}
+	cmap.Set("key0", NewSet())
+	cmap.SetIfExist("key1", NewSet())
+	cmap.SetIfExist("missing", NewSet())
+	cmap.SetIfNotExist("key2", NewSet())
+	cmap.SetIfNotExist("set", NewSet())
+	cmap.Delete("key3")
+	cmap.Delete("missing")
+	cmap.Set("ttl", int64(1))
+	assert.Equal(t, int64(97), cmap.TypeCount("string"), "Strings should be counted")
+	assert.Equal(t, int64(3), cmap.TypeCount("set"), "Sets should be counted")
+	assert.Equal(t, int64(0), cmap.TypeCount("list"), "There should be no list")
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package memdb
+
+// cpuUsage is not reported without getrusage
+func cpuUsage() (sys, user, sysChildren, userChildren float64) {
+	return 0, 0, 0, 0
+}
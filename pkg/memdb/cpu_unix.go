@@ -0,0 +1,17 @@
+//go:build unix
+
+package memdb
+
+import "syscall"
+
+// cpuUsage returns the seconds of system and user CPU used by the process and by its terminated children
+func cpuUsage() (sys, user, sysChildren, userChildren float64) {
+	var self, children syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+	return seconds(self.Stime), seconds(self.Utime), seconds(children.Stime), seconds(children.Utime)
+}
+
+func seconds(tv syscall.Timeval) float64 {
+	return float64(tv.Nano()) / 1e9
+}
@@ -1,10 +1,13 @@
 package memdb
 
 import (
+	"fmt"
 	"github.com/hsn/tiny-redis/pkg/RESP"
 	"github.com/hsn/tiny-redis/pkg/config"
 	"github.com/hsn/tiny-redis/pkg/logger"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,35 +15,281 @@ import (
 // All key:value pairs are stored in db
 // All ttl keys are stored in ttlKeys
 // locks is used to lock a key for db to ensure some atomic operations
+// txLocks is used to run EXEC atomically: EXEC locks the keys of a transaction exclusively,
+// other commands lock their keys shared, so they can't run in the middle of a transaction,
+// and the commands of keyspaceCommands lock all keys exclusively
+// clients holds all connected clients
+// watches holds the modification versions of keys watched by clients
+// pubsub holds the subscribers of channels
+// tracking holds the keys and prefixes tracked by clients for client-side caching
+// acl holds the users and their permissions
+// notifyFlags holds the classes of keyspace events published to pubsub
+// propagator receives every write command that has been executed, such as the AOF writer
+// shutdown stops the server, it's set by the server and called by SHUTDOWN
+// outputLimits holds client-output-buffer-limit, it's replaced by CONFIG SET
+// pause holds the commands of the clients during CLIENT PAUSE
+// monitors receive the commands executed by the other clients
+// slowlog keeps the commands running longer than slowlog-log-slower-than
+// latencyMonitor samples the slow events and latencyStats keeps the latency histograms of the commands
+// stats holds the counters reported by INFO
+// replication executes the writes in active-active mode, it's set by the server, rebuilder rebuilds its keys
 type MemDb struct {
-	db      *ConcurrentMap
-	ttlKeys *ConcurrentMap
-	locks   *Locks
+	db          *ConcurrentMap
+	ttlKeys     *ConcurrentMap
+	locks       *Locks
+	txLocks     *Locks
+	clients     *Clients
+	watchMu     sync.RWMutex
+	watches     map[string]*watchedKey
+	pubsub      *PubSub
+	tracking    *Tracking
+	acl         *ACL
+	notifyFlags int32
+	propagator  func(cmd [][]byte)
+	shutdown    func(c *Client, opts ShutdownOptions) error
+
+	outputLimits   atomic.Value
+	pause          *ClientPause
+	monitors       *Monitors
+	slowlog        *SlowLog
+	latencyMonitor *LatencyMonitor
+	latencyStats   *LatencyStats
+
+	stats       *Stats
+	replication Replication
+	rebuilder   *Client
 }
 
 func NewMemDb() *MemDb {
-	return &MemDb{
-		db:      NewConcurrentMap(config.Configures.ShardNum),
-		ttlKeys: NewConcurrentMap(config.Configures.ShardNum),
-		locks:   NewLocks(config.Configures.ShardNum * 2),
+	m := &MemDb{
+		db:       NewConcurrentMap(config.Configures.ShardNum),
+		ttlKeys:  NewConcurrentMap(config.Configures.ShardNum),
+		locks:    NewLocks(config.Configures.ShardNum * 2),
+		txLocks:  NewLocks(config.Configures.ShardNum * 2),
+		clients:  NewClients(),
+		watches:  make(map[string]*watchedKey),
+		pubsub:   NewPubSub(),
+		tracking: NewTracking(),
+		acl:      NewACL(),
+		pause:    NewClientPause(),
+		monitors: NewMonitors(),
+		stats:    NewStats(),
+		slowlog:  NewSlowLog(config.Configures.SlowlogLimits()),
+		// a fake client, so that the rebuilt keys are neither counted nor sent to the replication
+		rebuilder: NewFakeClient(),
+	}
+	threshold, tracking, percentiles := config.Configures.LatencyLimits()
+	m.latencyMonitor = NewLatencyMonitor(threshold)
+	m.latencyStats = NewLatencyStats(tracking, percentiles)
+	// the users of the aclfile replace the default user set by requirepass
+	if config.Configures.RequirePass != "" {
+		m.acl.SetRequirePass(config.Configures.RequirePass)
+	}
+	if config.Configures.AclFile != "" {
+		if err := m.acl.Load(config.Configures.AclFile); err != nil {
+			logger.Error("aclfile:", err.Error())
+		}
+	}
+	m.outputLimits.Store(config.Configures.OutputBufferLimits())
+	if flags, err := ParseNotifyFlags(config.Configures.NotifyKeyspaceEvents); err != nil {
+		logger.Error("notify-keyspace-events:", err.Error())
+	} else {
+		m.SetNotifyFlags(flags)
+	}
+	return m
+}
+
+// Clients returns the list of connected clients
+func (m *MemDb) Clients() *Clients {
+	return m.clients
+}
+
+// AddClient registers a new connected client,
+// it's authenticated as the default user at once if the default user needs no password.
+func (m *MemDb) AddClient(c *Client) {
+	if u := m.acl.User(defaultUser); u != nil && u.enabled && u.nopass {
+		c.mu.Lock()
+		c.authenticated = true
+		c.mu.Unlock()
+	}
+	c.outMu.Lock()
+	c.outLimits = &m.outputLimits
+	c.stats = m.stats
+	c.outMu.Unlock()
+	m.clients.Add(c)
+}
+
+// CloseClient releases everything held by a disconnected client,
+// and waits until its pending output has been written.
+func (m *MemDb) CloseClient(c *Client) {
+	m.unwatchAll(c)
+	m.pubsub.unsubscribeAll(c)
+	m.trackingOff(c)
+	m.monitors.remove(c)
+	m.clients.Remove(c)
+	c.Close()
+}
+
+// RejectConnection counts a connection rejected by maxclients, it's reported by INFO
+func (m *MemDb) RejectConnection() {
+	atomic.AddInt64(&m.stats.rejectedConns, 1)
+}
+
+// CloseIdleClients closes the clients idle for longer than timeout and returns their number.
+// The subscribers and the monitors are never closed, they wait for messages without sending commands like Redis.
+func (m *MemDb) CloseIdleClients(timeout time.Duration) int {
+	closed := 0
+	now := time.Now()
+	for _, c := range m.clients.List() {
+		c.mu.Lock()
+		idle := now.Sub(c.lastInteraction)
+		subscriber := c.subscriptions()+len(c.shardChannels) > 0 || c.monitor
+		c.mu.Unlock()
+		if subscriber || idle <= timeout {
+			continue
+		}
+		c.kill(nil)
+		closed++
 	}
+	return closed
+}
+
+// PubSub returns the channel subscribers of the database
+func (m *MemDb) PubSub() *PubSub {
+	return m.pubsub
+}
+
+// Stats returns the counters reported by INFO, the server counts the connections, the input and the AOF with them
+func (m *MemDb) Stats() *Stats {
+	return m.stats
+}
+
+// LatencyMonitor returns the latency monitor, the server samples the events of the persistence with it
+func (m *MemDb) LatencyMonitor() *LatencyMonitor {
+	return m.latencyMonitor
 }
-func (m *MemDb) ExecCommand(cmd [][]byte) RESP.RedisData {
+
+// SetPropagator sets the function receiving executed write commands
+func (m *MemDb) SetPropagator(propagator func(cmd [][]byte)) {
+	m.propagator = propagator
+}
+
+func (m *MemDb) ExecCommand(c *Client, cmd [][]byte) RESP.RedisData {
 	if len(cmd) == 0 {
 		return nil
 	}
-	var res RESP.RedisData
 	cmdName := strings.ToLower(string(cmd[0]))
+	c.touch(cmdName)
 	command, ok := CmdTable[cmdName]
+	if !c.Authenticated() && !isNoAuthCommand(cmdName) {
+		c.markMultiDirty()
+		return m.reject(cmdName, ok, RESP.MakeErrorData("NOAUTH Authentication required."))
+	}
+	if c.InMulti() && !isMultiControl(cmdName) {
+		if ok {
+			if err := m.checkACL(c, cmdName, command, cmd, "toplevel"); err != nil {
+				c.markMultiDirty()
+				return m.reject(cmdName, ok, err)
+			}
+			if m.replicated(c, command) {
+				if err := m.replication.Check(cmd); err != nil {
+					c.markMultiDirty()
+					return m.reject(cmdName, ok, err)
+				}
+			}
+		}
+		return m.reject(cmdName, ok, c.queueMulti(command, cmd))
+	}
 	if !ok {
-		res = RESP.MakeErrorData("error: unsupported command")
+		return m.reject(cmdName, ok, RESP.MakeErrorData("error: unsupported command"))
+	}
+	if err := m.checkACL(c, cmdName, command, cmd, "toplevel"); err != nil {
+		return m.reject(cmdName, ok, err)
+	}
+	if c.InPubSubContext() && !isPubSubAllowed(cmdName) {
+		return m.reject(cmdName, ok, RESP.MakeErrorData(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", cmdName)))
+	}
+	keys := command.keys(cmd)
+	if keyspaceCommands[cmdName] {
+		m.txLocks.LockAll()
+		defer m.txLocks.UnLockAll()
 	} else {
-		execFunc := command.executor
-		res = execFunc(m, cmd)
+		m.txLocks.RLockMulti(keys)
+		defer m.txLocks.RUnLockMulti(keys)
 	}
+	return m.call(c, command, cmd, keys)
+}
+
+// reject returns the reply of cmdName given without executing it, an error is counted by INFO errorstats
+// and by rejected_calls if the command is known
+func (m *MemDb) reject(cmdName string, known bool, res RESP.RedisData) RESP.RedisData {
+	m.stats.rejected(cmdName, known, res)
 	return res
 }
 
+// call runs the executor of cmd, then signals the modification of its keys and propagates it if it's a write command.
+// In active-active mode the writes of the clients are executed by the replication instead, which rebuilds
+// their keys with Rebuild.
+// The command is fed to the monitors with the time it started, MONITOR itself is not.
+// A command whose number of arguments doesn't match its arity is rejected before running.
+// The keys read by a command are counted as keyspace hits or misses before it runs.
+// Its call is counted by INFO commandstats, its duration is recorded by the latency histograms and the latency
+// monitor, and by the slowlog except for EXEC
+// whose queued commands are recorded one by one.
+// Callers must hold txLocks of keys.
+func (m *MemDb) call(c *Client, command *command, cmd [][]byte, keys []string) RESP.RedisData {
+	if !command.checkArity(len(cmd)) {
+		return m.reject(command.name, true, command.arityError())
+	}
+	// the commands of the fake clients, such as loading the AOF file, are not counted
+	counted := c.conn != nil
+	if counted && command.flags&flagReadonly != 0 {
+		for _, key := range keys {
+			// an expired key is a miss, it's deleted as the command would
+			_, ok := m.db.Get(key)
+			m.stats.lookup(ok && m.CheckTTL(key))
+		}
+	}
+	replicated := m.replicated(c, command)
+	start := time.Now()
+	var res RESP.RedisData
+	if replicated {
+		res = m.replication.Write(cmd)
+	} else {
+		res = command.executor(m, c, cmd)
+	}
+	duration := time.Since(start)
+	name := strings.ToLower(string(cmd[0]))
+	if counted {
+		m.stats.called(name, duration, res)
+		m.latencyStats.record(name, duration)
+		m.latencyMonitor.AddSample(LatencyCommand, duration)
+	}
+	if name != "exec" {
+		m.slowlog.record(c, cmd, start, duration)
+	}
+	if name != "monitor" {
+		m.monitors.feed(c, cmd, start)
+	}
+	if _, failed := res.(*RESP.ErrorData); failed || replicated || command.flags&flagWrite == 0 {
+		return res
+	}
+	for _, key := range keys {
+		m.signalModifiedKey(c, key)
+	}
+	// the replication propagates the ops rebuilding a key rather than the commands
+	if c != m.rebuilder {
+		m.propagate(cmd)
+	}
+	return res
+}
+
+func (m *MemDb) propagate(cmd [][]byte) {
+	if m.propagator != nil {
+		m.propagator(cmd)
+	}
+}
+
 // CheckTTL checks ttl keys and delete expired keys
 // return false if key is expired,else true
 // Attention: Don't lock this function because it has called locks.Lock(key) for atomic deleting expired key.
@@ -58,11 +307,67 @@ func (m *MemDb) CheckTTL(key string) bool {
 
 	m.locks.Lock(key)
 	defer m.locks.UnLock(key)
-	m.db.Delete(key)
+	// the key may have been deleted by another caller
+	if m.db.Delete(key) == 1 {
+		atomic.AddInt64(&m.stats.expiredKeys, 1)
+		m.notify(notifyExpired, "expired", key)
+	}
 	m.ttlKeys.Delete(key)
+	m.signalModifiedKey(nil, key)
 	return false
 }
 
+const (
+	activeExpireInterval   = 100 * time.Millisecond
+	activeExpireSample     = 20
+	activeExpireTimeBudget = 25 * time.Millisecond
+)
+
+// ActiveExpire deletes expired keys in background until stopCh is closed,
+// so that expired keys are removed even if they are never accessed again.
+// Like Redis, every cycle samples keys with ttl and repeats while more than 1/4 of the sample is expired.
+func (m *MemDb) ActiveExpire(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			// the dataset must not change while the clients are paused, such as during a failover
+			if !m.pause.Active() {
+				start := time.Now()
+				m.activeExpireCycle()
+				m.latencyMonitor.AddSample(LatencyExpireCycle, time.Since(start))
+			}
+		}
+	}
+}
+
+func (m *MemDb) activeExpireCycle() {
+	start := time.Now()
+	for time.Since(start) < activeExpireTimeBudget {
+		keys := m.ttlKeys.RandomKeys(activeExpireSample)
+		expired := 0
+		for _, key := range keys {
+			if !m.expireIfNeeded(key) {
+				expired++
+			}
+		}
+		if expired*4 <= len(keys) {
+			return
+		}
+	}
+}
+
+// expireIfNeeded is CheckTTL for a command-less caller, it takes txLocks so that a key is not expired in the
+// middle of a transaction.
+func (m *MemDb) expireIfNeeded(key string) bool {
+	m.txLocks.RLock(key)
+	defer m.txLocks.RUnLock(key)
+	return m.CheckTTL(key)
+}
+
 // SetTTL sets ttl for keys
 // return bool to check if ttl set success
 // return int to check if the key is a new ttl key
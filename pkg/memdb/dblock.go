@@ -73,7 +73,7 @@ func (l *Locks) sortedLockPoses(keys []string) []int {
 	}
 	poses := make([]int, len(set))
 	i := 0
-	for pos := range poses {
+	for pos := range set {
 		poses[i] = pos
 		i++
 	}
@@ -116,3 +116,15 @@ func (l *Locks) RUnLockMulti(keys []string) {
 		l.locks[pos].RUnlock()
 	}
 }
+
+// LockAll locks every position exclusively, in the order of LockMulti
+func (l *Locks) LockAll() {
+	for _, lock := range l.locks {
+		lock.Lock()
+	}
+}
+func (l *Locks) UnLockAll() {
+	for _, lock := range l.locks {
+		lock.Unlock()
+	}
+}
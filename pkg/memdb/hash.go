@@ -1,6 +1,7 @@
 package memdb
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/hsn/tiny-redis/pkg/RESP"
 	"github.com/hsn/tiny-redis/pkg/logger"
@@ -9,23 +10,37 @@ import (
 )
 
 func RegisterHashCommands() {
-	RegisterCommand("hdel", hDelHash)
-	RegisterCommand("hexists", hExistsHash)
-	RegisterCommand("hget", hGetHash)
-	RegisterCommand("hgetall", hGetAllHash)
-	RegisterCommand("hincrby", hIncrByHash)
-	RegisterCommand("hincrbyfloat", hIncrByFloatHash)
-	RegisterCommand("hkeys", hKeysHash)
-	RegisterCommand("hlen", hLenHash)
-	RegisterCommand("hmget", hMGetHash)
-	RegisterCommand("hset", hSetHash)
-	RegisterCommand("hsetnx", hSetNxHash)
-	RegisterCommand("hvals", hValsHash)
-	RegisterCommand("hstrlen", hStrLenHash)
-	RegisterCommand("hrandfield", hRandFieldHash)
+	RegisterCommand("hdel", hDelHash, -3, flagWrite, catHash, 1, 1, 1,
+		commandDoc{"Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", "2.0.0", "hash"})
+	RegisterCommand("hexists", hExistsHash, 3, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Determines whether a field exists in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hget", hGetHash, 3, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns the value of a field in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hgetall", hGetAllHash, 2, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns all fields and values in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hincrby", hIncrByHash, 4, flagWrite|flagDenyOOM|flagAccess, catHash, 1, 1, 1,
+		commandDoc{"Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", "2.0.0", "hash"})
+	RegisterCommand("hincrbyfloat", hIncrByFloatHash, 4, flagWrite|flagDenyOOM|flagAccess, catHash, 1, 1, 1,
+		commandDoc{"Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.", "2.6.0", "hash"})
+	RegisterCommand("hkeys", hKeysHash, 2, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns all fields in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hlen", hLenHash, 2, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns the number of fields in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hmget", hMGetHash, -3, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns the values of all fields in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hset", hSetHash, -4, flagWrite|flagDenyOOM, catHash, 1, 1, 1,
+		commandDoc{"Creates or modifies the value of a field in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hsetnx", hSetNxHash, 4, flagWrite|flagDenyOOM, catHash, 1, 1, 1,
+		commandDoc{"Sets the value of a field in a hash only when the field doesn't exist.", "2.0.0", "hash"})
+	RegisterCommand("hvals", hValsHash, 2, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns all values in a hash.", "2.0.0", "hash"})
+	RegisterCommand("hstrlen", hStrLenHash, 3, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns the length of the value of a field.", "3.2.0", "hash"})
+	RegisterCommand("hrandfield", hRandFieldHash, -2, flagReadonly, catHash, 1, 1, 1,
+		commandDoc{"Returns one or more random fields from a hash.", "6.2.0", "hash"})
 }
 
-func hRandFieldHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hRandFieldHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hrandfield" {
 		logger.Error("hRandFieldHash Function: cmdName is not hrandfield")
 		return RESP.MakeErrorData("server error")
@@ -51,6 +66,7 @@ func hRandFieldHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 			return RESP.MakeErrorData(fmt.Sprintf("command option error, not support %s option", string(cmd[3])))
 		}
 	}
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeEmptyArrayData()
 	}
@@ -79,7 +95,7 @@ func hRandFieldHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeArrayData(res)
 }
 
-func hStrLenHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hStrLenHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hstrlen" {
 		logger.Error("hStrLenHash Function: cmdName is not hstrlen")
 		return RESP.MakeErrorData("server error")
@@ -90,6 +106,7 @@ func hStrLenHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	key := string(cmd[1])
 	field := string(cmd[2])
 
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeIntData(0)
 	}
@@ -110,7 +127,7 @@ func hStrLenHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeIntData(int64(res))
 }
 
-func hSetNxHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hSetNxHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hsetnx" {
 		logger.Error("hSetNxHash Function: cmdName is not hsetnx")
 		return RESP.MakeErrorData("server error")
@@ -120,7 +137,7 @@ func hSetNxHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	key := string(cmd[1])
 	field := string(cmd[2])
-	value := cmd[3]
+	value := bytes.Clone(cmd[3])
 	m.CheckTTL(key)
 
 	m.locks.Lock(key)
@@ -142,11 +159,12 @@ func hSetNxHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 
 	hash.Set(field, value)
+	m.notify(notifyHash, "hset", key)
 	return RESP.MakeIntData(1)
 
 }
 
-func hIncrByFloatHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hIncrByFloatHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hincrbyfloat" {
 		logger.Error("hIncrByFloatHash Function: cmdName is not hincrbyfloat")
 		return RESP.MakeErrorData("server error")
@@ -182,11 +200,12 @@ func hIncrByFloatHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if !ok {
 		return RESP.MakeErrorData("value is not a float")
 	}
+	m.notify(notifyHash, "hincrbyfloat", key)
 
 	return RESP.MakeBulkData([]byte(strconv.FormatFloat(res, 'f', -1, 64)))
 }
 
-func hIncrByHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hIncrByHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hincrby" {
 		logger.Error("hIncrByHash Function: cmdName is not hincrby")
 		return RESP.MakeErrorData("server error")
@@ -220,10 +239,11 @@ func hIncrByHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if !ok {
 		return RESP.MakeErrorData("value is not an integer")
 	}
+	m.notify(notifyHash, "hincrby", key)
 	return RESP.MakeIntData(int64(res))
 }
 
-func hValsHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hValsHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hvals" {
 		logger.Error("hValsHash Function: cmdName is not hvals")
 		return RESP.MakeErrorData("server error")
@@ -232,6 +252,7 @@ func hValsHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'hvals' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeEmptyArrayData()
 	}
@@ -253,7 +274,7 @@ func hValsHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeArrayData(res)
 }
 
-func hKeysHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hKeysHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hkeys" {
 		logger.Error("hKeysHash Function: cmdName is not hkeys")
 		return RESP.MakeErrorData("server error")
@@ -262,6 +283,7 @@ func hKeysHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'hkeys' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeEmptyArrayData()
 	}
@@ -282,7 +304,7 @@ func hKeysHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeArrayData(res)
 }
-func hLenHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hLenHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hlen" {
 		logger.Error("hLenHash Function: cmdName is not hlen")
 		return RESP.MakeErrorData("Server error")
@@ -291,6 +313,7 @@ func hLenHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'hlen' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeIntData(0)
 	}
@@ -308,7 +331,7 @@ func hLenHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	res := hash.Len()
 	return RESP.MakeIntData(int64(res))
 }
-func hSetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hSetHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hset" {
 		logger.Error("hMSetHash Function: cmdName is not hset")
 		return RESP.MakeErrorData("Server error")
@@ -334,12 +357,13 @@ func hSetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	for i := 2; i < len(cmd); i += 2 {
 		field := string(cmd[i])
-		value := cmd[i+1]
+		value := bytes.Clone(cmd[i+1])
 		hash.Set(field, value)
 	}
+	m.notify(notifyHash, "hset", key)
 	return RESP.MakeStringData("OK")
 }
-func hGetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hGetHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hget" {
 		logger.Error("hGetHash Function: command name is not hget")
 		return RESP.MakeErrorData("Server error")
@@ -348,6 +372,7 @@ func hGetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'hget' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeNullBulkData()
 	}
@@ -367,7 +392,7 @@ func hGetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeBulkData(res)
 }
-func hMGetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hMGetHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hmget" {
 		logger.Error("hMGetHash Function: cmdName is not hmget")
 		return RESP.MakeErrorData("Server error")
@@ -376,6 +401,7 @@ func hMGetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'hmget' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeEmptyArrayData()
 	}
@@ -401,7 +427,7 @@ func hMGetHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeArrayData(res)
 }
-func hDelHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hDelHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hdel" {
 		logger.Error("hDelHash Function: cmdName is not hdel")
 		return RESP.MakeErrorData("Server error")
@@ -427,16 +453,20 @@ func hDelHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if hash.IsEmpty() {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 	res := 0
 	for i := 2; i < len(cmd); i++ {
 		res += hash.Del(string(cmd[i]))
 	}
+	if res > 0 {
+		m.notify(notifyHash, "hdel", key)
+	}
 
 	return RESP.MakeIntData(int64(res))
 }
-func hExistsHash(m *MemDb, cmd [][]byte) RESP.RedisData {
+func hExistsHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "hexist" {
 		logger.Error("hExistsHash Function: cmdName is not hexist")
 		return RESP.MakeErrorData("Server error")
@@ -445,6 +475,7 @@ func hExistsHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		RESP.MakeErrorData("wrong number of arguments for 'hexists' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeIntData(0)
 	}
@@ -463,8 +494,8 @@ func hExistsHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeIntData(0)
 }
-func hGetAllHash(m *MemDb, cmd [][]byte) RESP.RedisData {
-	if strings.ToLower(string(cmd[0])) != "hget" {
+func hGetAllHash(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hgetall" {
 		logger.Error("hGetAllHash Function: cmdName is not hgetall")
 		return RESP.MakeErrorData("Server error")
 	}
@@ -472,14 +503,15 @@ func hGetAllHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'hgetall' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
-		return RESP.MakeEmptyArrayData()
+		return RESP.MakeMapData([]RESP.RedisData{})
 	}
 	m.locks.RLock(key)
 	defer m.locks.RUnLock(key)
 	tem, ok := m.db.Get(key)
 	if !ok {
-		return RESP.MakeEmptyArrayData()
+		return RESP.MakeMapData([]RESP.RedisData{})
 	}
 	hash, ok := tem.(*Hash)
 	if !ok {
@@ -491,5 +523,5 @@ func hGetAllHash(m *MemDb, cmd [][]byte) RESP.RedisData {
 	for k, v := range table {
 		res = append(res, RESP.MakeBulkData([]byte(k)), RESP.MakeBulkData(v))
 	}
-	return RESP.MakeArrayData(res)
+	return RESP.MakeMapData(res)
 }
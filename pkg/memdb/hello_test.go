@@ -0,0 +1,90 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestHelloResp3(t *testing.T) {
+	RegisterZSetCommands()
+	m := newNotifyTestDb()
+	server, peer := net.Pipe()
+	c := NewClient(server)
+	m.AddClient(c)
+	r := bufio.NewReader(peer)
+
+	for _, args := range [][]string{
+		{"hello", "4"},
+		{"hello", "x"},
+		{"hello", "3", "auth", "nobody", "secret"},
+		{"hello", "3", "setname"},
+	} {
+		if res := execArgs(m, c, args...); res[0] != '-' {
+			t.Errorf("%v should be rejected: %q", args, res)
+		}
+	}
+	if c.Protocol() != 2 {
+		t.Fatalf("a rejected HELLO should not switch the protocol")
+	}
+
+	res := execArgs(m, c, "hello", "3", "auth", "default", "secret", "setname", "conn")
+	if !bytes.HasPrefix(res, []byte("%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n")) || !bytes.Contains(res, []byte("$5\r\nproto\r\n:3\r\n")) {
+		t.Errorf("hello reply error: %q", res)
+	}
+	if c.Name() != "conn" {
+		t.Errorf("hello setname error: %q", c.Name())
+	}
+
+	execArgs(m, c, "hset", "h", "f", "v")
+	if res := execArgs(m, c, "hgetall", "h"); !bytes.Equal(res, []byte("%1\r\n$1\r\nf\r\n$1\r\nv\r\n")) {
+		t.Errorf("hgetall reply error: %q", res)
+	}
+	if res := execArgs(m, c, "hgetall", "none"); !bytes.Equal(res, []byte("%0\r\n")) {
+		t.Errorf("hgetall reply error: %q", res)
+	}
+	execArgs(m, c, "zadd", "z", "1.5", "a")
+	if res := execArgs(m, c, "zscore", "z", "a"); !bytes.Equal(res, []byte(",1.5\r\n")) {
+		t.Errorf("zscore reply error: %q", res)
+	}
+	if res := execArgs(m, c, "zscore", "z", "b"); !bytes.Equal(res, []byte("_\r\n")) {
+		t.Errorf("zscore reply error: %q", res)
+	}
+	if res := execArgs(m, c, "get", "none"); !bytes.Equal(res, []byte("_\r\n")) {
+		t.Errorf("get reply error: %q", res)
+	}
+
+	// messages are pushed, and any command can be executed in subscribed mode
+	if res := execArgs(m, c, "subscribe", "ch"); !bytes.Equal(res, []byte(">3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n")) {
+		t.Errorf("subscribe reply error: %q", res)
+	}
+	if res := execArgs(m, c, "hget", "h", "f"); !bytes.Equal(res, []byte("$1\r\nv\r\n")) {
+		t.Errorf("hget reply error in subscribed mode: %q", res)
+	}
+	if res := execArgs(m, c, "ping"); !bytes.Equal(res, []byte("+PONG\r\n")) {
+		t.Errorf("ping reply error in subscribed mode: %q", res)
+	}
+	execArgs(m, c, "publish", "ch", "hi")
+	expect := ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n"
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("expect push %q, get %q", expect, got)
+	}
+
+	// invalidation messages are pushed without subscribing
+	execArgs(m, c, "client", "tracking", "on")
+	execArgs(m, c, "hget", "h", "f")
+	execArgs(m, NewFakeClient(), "hset", "h", "f", "w")
+	expect = ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nh\r\n"
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("expect push %q, get %q", expect, got)
+	}
+
+	execArgs(m, c, "unsubscribe")
+	if res := execArgs(m, c, "hello", "2"); !bytes.HasPrefix(res, []byte("*14\r\n")) {
+		t.Errorf("hello reply error: %q", res)
+	}
+	if res := execArgs(m, c, "zscore", "z", "a"); !bytes.Equal(res, []byte("$3\r\n1.5\r\n")) {
+		t.Errorf("zscore reply error: %q", res)
+	}
+}
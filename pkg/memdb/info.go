@@ -1,60 +1,614 @@
 package memdb
 
 import (
+	"fmt"
 	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/config"
 	"github.com/hsn/tiny-redis/pkg/logger"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 func RegisterInfoCommands() {
-	RegisterCommand("client", client)
-	RegisterCommand("config", infoConfig)
-	RegisterCommand("scan", scan)
-	RegisterCommand("info", info)
-	RegisterCommand("quit", quit)
+	RegisterCommand("client", client, -2, flagAdmin|flagNoScript, catConnection, 0, 0, 0,
+		commandDoc{"A container for client connection commands.", "2.4.0", "connection"})
+	registerSubcommands("client", map[string]aclCategory{
+		"id": catConnection, "setname": catConnection, "getname": catConnection, "info": catConnection,
+		"tracking": catConnection, "caching": catConnection, "getredirect": catConnection,
+		"trackinginfo": catConnection, "no-touch": catConnection,
+		"kill": catAdmin | catDangerous, "list": catAdmin | catDangerous, "no-evict": catAdmin | catDangerous,
+		"pause": catAdmin | catDangerous, "unpause": catAdmin | catDangerous,
+	})
+	RegisterCommand("config", infoConfig, -2, flagAdmin|flagNoScript, catAdmin|catDangerous, 0, 0, 0,
+		commandDoc{"A container for server configuration commands.", "2.0.0", "server"})
+	RegisterCommand("scan", scan, -2, flagReadonly, catKeyspace, 0, 0, 0,
+		commandDoc{"Iterates over the key names in the database.", "2.8.0", "generic"})
+	RegisterCommand("info", info, -1, 0, catDangerous, 0, 0, 0,
+		commandDoc{"Returns information and statistics about the server.", "1.0.0", "server"})
+	RegisterCommand("quit", quit, -1, 0, catConnection, 0, 0, 0,
+		commandDoc{"Closes the connection.", "1.0.0", "connection"})
+	RegisterCommand("hello", hello, -1, flagNoScript, catConnection, 0, 0, 0,
+		commandDoc{"Handshakes with the Redis server.", "6.0.0", "connection"})
+	RegisterCommand("shutdown", shutdownCmd, -1, flagAdmin|flagNoScript, catAdmin|catDangerous, 0, 0, 0,
+		commandDoc{"Synchronously saves the database(s) to disk and shuts down the Redis server.", "1.0.0", "server"})
+	RegisterCommand("monitor", monitorCmd, 1, flagAdmin|flagNoScript, catAdmin|catDangerous, 0, 0, 0,
+		commandDoc{"Listens for all requests received by the server in real-time.", "1.0.0", "server"})
+	RegisterCommand("slowlog", slowlog, -2, flagAdmin, catAdmin|catDangerous, 0, 0, 0,
+		commandDoc{"A container for slow log commands.", "2.2.12", "server"})
+	RegisterCommand("latency", latency, -2, flagAdmin|flagNoScript, catAdmin|catDangerous, 0, 0, 0,
+		commandDoc{"A container for latency diagnostics commands.", "2.8.13", "server"})
+	RegisterCommand("command", commandCmd, -1, 0, catConnection, 0, 0, 0,
+		commandDoc{"Returns detailed information about all commands.", "2.8.13", "server"})
+	// active-active replication of tiny-redis, since the redis_version reported by INFO
+	RegisterCommand("crdt.apply", crdtApply, 12, flagAdmin|flagNoScript, catAdmin|catDangerous, 7, 7, 1,
+		commandDoc{"Merges an operation shipped by an active-active peer.", redisVersion, "server"})
+	RegisterCommand("crdt.sync", crdtSync, 2, flagAdmin|flagNoScript, catAdmin|catDangerous, 0, 0, 0,
+		commandDoc{"Starts the replication link of an active-active peer.", redisVersion, "server"})
 
 }
 
 // client
-func client(m *MemDb, cmd [][]byte) RESP.RedisData {
-	//fmt.Println("client setname 127.0.0.1@6379 done")
-	return RESP.MakeBulkData([]byte("OK"))
+// CLIENT ID|SETNAME|GETNAME|LIST|INFO|KILL|TRACKING|CACHING|GETREDIRECT|TRACKINGINFO|NO-EVICT|NO-TOUCH|PAUSE|UNPAUSE|REPLY
+func client(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	switch subCmd {
+	case "id":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'client|id' command")
+		}
+		return RESP.MakeIntData(c.id)
+	case "setname":
+		if len(cmd) != 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'client|setname' command")
+		}
+		for _, ch := range cmd[2] {
+			if ch <= ' ' || ch > '~' {
+				return RESP.MakeErrorData("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		c.mu.Lock()
+		c.name = string(cmd[2])
+		c.mu.Unlock()
+		return RESP.MakeStringData("OK")
+	case "getname":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'client|getname' command")
+		}
+		name := c.Name()
+		if name == "" {
+			return RESP.MakeBulkData(nil)
+		}
+		return RESP.MakeBulkData([]byte(name))
+	case "info":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'client|info' command")
+		}
+		return RESP.MakeBulkData([]byte(c.info() + "\n"))
+	case "list":
+		return clientList(m, c, cmd)
+	case "kill":
+		return clientKill(m, c, cmd)
+	case "pause":
+		return clientPause(m, c, cmd)
+	case "unpause":
+		return clientUnpause(m, c, cmd)
+	case "reply":
+		return clientReply(m, c, cmd)
+	case "tracking":
+		return clientTracking(m, c, cmd)
+	case "caching":
+		return clientCaching(m, c, cmd)
+	case "getredirect":
+		return clientGetRedirect(m, c, cmd)
+	case "trackinginfo":
+		return clientTrackingInfo(m, c, cmd)
+	case "no-evict", "no-touch":
+		if len(cmd) != 3 {
+			return RESP.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'client|%s' command", subCmd))
+		}
+		var on bool
+		switch strings.ToLower(string(cmd[2])) {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+		c.mu.Lock()
+		if subCmd == "no-evict" {
+			c.noEvict = on
+		} else {
+			c.noTouch = on
+		}
+		c.mu.Unlock()
+		return RESP.MakeStringData("OK")
+	}
+	return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", string(cmd[1])))
+}
+
+// clientList
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id [client-id ...]]
+func clientList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	filter := &clientFilter{}
+	for i := 2; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "type":
+			if i+1 >= len(cmd) {
+				return RESP.MakeErrorData("ERR syntax error")
+			}
+			i++
+			filter.typ = normalizeClientType(string(cmd[i]))
+			if filter.typ == "" {
+				return RESP.MakeErrorData(fmt.Sprintf("ERR Unknown client type '%s'", string(cmd[i])))
+			}
+		case "id":
+			if i+1 >= len(cmd) {
+				return RESP.MakeErrorData("ERR syntax error")
+			}
+			filter.ids = make(map[int64]struct{})
+			for i++; i < len(cmd); i++ {
+				id, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+				if err != nil || id <= 0 {
+					return RESP.MakeErrorData(fmt.Sprintf("ERR Invalid client ID '%s'", string(cmd[i])))
+				}
+				filter.ids[id] = struct{}{}
+			}
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	var sb strings.Builder
+	for _, cli := range m.clients.List() {
+		if filter.match(cli, c) {
+			sb.WriteString(cli.info())
+			sb.WriteByte('\n')
+		}
+	}
+	return RESP.MakeBulkData([]byte(sb.String()))
+}
+
+// clientKill
+// CLIENT KILL ip:port
+// CLIENT KILL [ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username] [TYPE type] [SKIPME yes|no]
+func clientKill(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|kill' command")
+	}
+	// old style: kill the client with the given address and reply OK or error
+	if len(cmd) == 3 {
+		filter := &clientFilter{addr: string(cmd[2])}
+		for _, cli := range m.clients.List() {
+			if filter.match(cli, c) {
+				cli.kill(c)
+				return RESP.MakeStringData("OK")
+			}
+		}
+		return RESP.MakeErrorData("ERR No such client")
+	}
+	if len(cmd)%2 != 0 {
+		return RESP.MakeErrorData("ERR syntax error")
+	}
+	filter := &clientFilter{skipMe: true}
+	for i := 2; i < len(cmd); i += 2 {
+		val := string(cmd[i+1])
+		switch strings.ToLower(string(cmd[i])) {
+		case "id":
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || id <= 0 {
+				return RESP.MakeErrorData("ERR client-id should be greater than 0")
+			}
+			filter.ids = map[int64]struct{}{id: {}}
+		case "addr":
+			filter.addr = val
+		case "laddr":
+			filter.laddr = val
+		case "user":
+			filter.user = val
+		case "type":
+			filter.typ = normalizeClientType(val)
+			if filter.typ == "" {
+				return RESP.MakeErrorData(fmt.Sprintf("ERR Unknown client type '%s'", val))
+			}
+		case "skipme":
+			switch strings.ToLower(val) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return RESP.MakeErrorData("ERR syntax error")
+			}
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	killed := 0
+	for _, cli := range m.clients.List() {
+		if filter.match(cli, c) {
+			cli.kill(c)
+			killed++
+		}
+	}
+	return RESP.MakeIntData(int64(killed))
+}
+
+// clientReply
+// CLIENT REPLY ON|OFF|SKIP
+func clientReply(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|reply' command")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch strings.ToLower(string(cmd[2])) {
+	case "on":
+		c.replyOff, c.replySkip = false, 0
+	case "off":
+		c.replyOff = true
+	case "skip":
+		// the reply of SKIP itself and the one of the next command
+		if !c.replyOff {
+			c.replySkip = 2
+		}
+	default:
+		return RESP.MakeErrorData("ERR syntax error")
+	}
+	return RESP.MakeStringData("OK")
 }
 
 // config
-func infoConfig(m *MemDb, cmd [][]byte) RESP.RedisData {
-	//todo:config
-	//fmt.Println("config")
-	return RESP.MakeBulkData([]byte("OK"))
+// CONFIG GET parameter [parameter ...]
+// CONFIG SET parameter value [parameter value ...]
+func infoConfig(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'config' command")
+	}
+	switch sub := strings.ToLower(string(cmd[1])); sub {
+	case "get":
+		if len(cmd) < 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'config|get' command")
+		}
+		res := make([]RESP.RedisData, 0)
+		seen := make(map[string]bool)
+		for _, pattern := range cmd[2:] {
+			for _, kv := range config.Configures.Get(string(pattern)) {
+				if seen[kv[0]] {
+					continue
+				}
+				seen[kv[0]] = true
+				res = append(res, RESP.MakeBulkData([]byte(kv[0])), RESP.MakeBulkData([]byte(kv[1])))
+			}
+		}
+		return RESP.MakeMapData(res)
+	case "set":
+		if len(cmd) < 4 || len(cmd)%2 != 0 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'config|set' command")
+		}
+		for i := 2; i < len(cmd); i += 2 {
+			if err := m.setConfig(strings.ToLower(string(cmd[i])), string(cmd[i+1])); err != nil {
+				return RESP.MakeErrorData("ERR " + err.Error())
+			}
+		}
+		return RESP.MakeStringData("OK")
+	default:
+		return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", sub))
+	}
+}
+
+// setConfig validates and applies a parameter changed by CONFIG SET
+func (m *MemDb) setConfig(name, value string) error {
+	switch name {
+	case "notify-keyspace-events":
+		flags, err := ParseNotifyFlags(value)
+		if err != nil {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", name, err.Error())
+		}
+		if err = config.Configures.Set(name, notifyFlagsString(flags)); err != nil {
+			return err
+		}
+		m.SetNotifyFlags(flags)
+		return nil
+	case "requirepass":
+		if err := config.Configures.Set(name, value); err != nil {
+			return err
+		}
+		m.acl.SetRequirePass(value)
+		return nil
+	case "client-output-buffer-limit":
+		if err := config.Configures.Set(name, value); err != nil {
+			return err
+		}
+		m.outputLimits.Store(config.Configures.OutputBufferLimits())
+		return nil
+	case "slowlog-log-slower-than", "slowlog-max-len":
+		if err := config.Configures.Set(name, value); err != nil {
+			return err
+		}
+		m.slowlog.setLimits(config.Configures.SlowlogLimits())
+		return nil
+	case "latency-monitor-threshold", "latency-tracking", "latency-tracking-info-percentiles":
+		if err := config.Configures.Set(name, value); err != nil {
+			return err
+		}
+		threshold, tracking, percentiles := config.Configures.LatencyLimits()
+		m.latencyMonitor.setThreshold(threshold)
+		m.latencyStats.setConfig(tracking, percentiles)
+		return nil
+	}
+	return config.Configures.Set(name, value)
 }
 
 // scan
-func scan(m *MemDb, cmd [][]byte) RESP.RedisData {
+func scan(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	//todo:scan
 	//fmt.Println("scan")
 	return RESP.MakeNullBulkData()
 }
 
 // quit
-func quit(m *MemDb, cmd [][]byte) RESP.RedisData {
-	//todo:quit
+// reply OK and close the connection
+func quit(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	c.mu.Lock()
+	c.closeAfterReply = true
+	c.mu.Unlock()
+	return RESP.MakeStringData("OK")
+}
+
+// hello
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// switches the protocol of the connection and returns the server information as a map
+func hello(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	proto := c.Protocol()
+	if len(cmd) > 1 {
+		ver, err := strconv.Atoi(string(cmd[1]))
+		if err != nil {
+			return RESP.MakeErrorData("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != RESP.RESP2 && ver != RESP.RESP3 {
+			return RESP.MakeErrorData("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+	}
+	var name []byte
+	for i := 2; i < len(cmd); i++ {
+		switch opt := strings.ToLower(string(cmd[i])); {
+		case opt == "auth" && i+2 < len(cmd):
+			if err := m.authenticate(c, string(cmd[i+1]), string(cmd[i+2])); err != nil {
+				return err
+			}
+			i += 2
+		case opt == "setname" && i+1 < len(cmd):
+			name = cmd[i+1]
+			for _, ch := range name {
+				if ch <= ' ' || ch > '~' {
+					return RESP.MakeErrorData("ERR Client names cannot contain spaces, newlines or special characters.")
+				}
+			}
+			i++
+		default:
+			return RESP.MakeErrorData(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", string(cmd[i])))
+		}
+	}
+	if !c.Authenticated() {
+		return RESP.MakeErrorData("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	c.mu.Lock()
+	c.proto = proto
+	if name != nil {
+		c.name = string(name)
+	}
+	c.mu.Unlock()
+	return RESP.MakeMapData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte("server")), RESP.MakeBulkData([]byte("redis")),
+		RESP.MakeBulkData([]byte("version")), RESP.MakeBulkData([]byte(redisVersion)),
+		RESP.MakeBulkData([]byte("proto")), RESP.MakeIntData(int64(proto)),
+		RESP.MakeBulkData([]byte("id")), RESP.MakeIntData(c.id),
+		RESP.MakeBulkData([]byte("mode")), RESP.MakeBulkData([]byte("standalone")),
+		RESP.MakeBulkData([]byte("role")), RESP.MakeBulkData([]byte("master")),
+		RESP.MakeBulkData([]byte("modules")), RESP.MakeArrayData([]RESP.RedisData{}),
+	})
+}
 
-	return RESP.MakeBulkData([]byte("OK"))
+// redisVersion is the version of Redis the server is compatible with, reported by HELLO and INFO
+const redisVersion = "6.2.6"
+
+// infoSection is a section of INFO, generate returns its lines after its header
+type infoSection struct {
+	name     string
+	title    string
+	all      bool // only given by INFO all and everything, or when it's named
+	generate func(m *MemDb) string
+}
+
+// infoSections are the sections of INFO in the order they're given
+var infoSections = []infoSection{
+	{name: "server", title: "Server", generate: infoServer},
+	{name: "clients", title: "Clients", generate: infoClients},
+	{name: "memory", title: "Memory", generate: infoMemory},
+	{name: "persistence", title: "Persistence", generate: infoPersistence},
+	{name: "stats", title: "Stats", generate: infoStats},
+	{name: "replication", title: "Replication", generate: infoReplication},
+	{name: "cpu", title: "CPU", generate: infoCPU},
+	{name: "commandstats", title: "Commandstats", all: true, generate: infoCommandStats},
+	{name: "errorstats", title: "Errorstats", generate: infoErrorStats},
+	{name: "latencystats", title: "Latencystats", all: true, generate: infoLatencyStats},
+	{name: "cluster", title: "Cluster", generate: func(m *MemDb) string { return "cluster_enabled:0\r\n" }},
+	{name: "keyspace", title: "Keyspace", generate: infoKeyspace},
 }
 
 // info
-func info(m *MemDb, cmd [][]byte) RESP.RedisData {
+// INFO [section [section ...]]
+func info(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "info" {
 		logger.Error("info Function: cmdName is not info")
 		return RESP.MakeErrorData("server error")
 	}
-	if len(cmd) > 2 {
-		return RESP.MakeErrorData("error: command args number is invalid")
+	requested := make(map[string]bool, len(cmd)-1)
+	for _, arg := range cmd[1:] {
+		requested[strings.ToLower(string(arg))] = true
+	}
+	all := requested["all"] || requested["everything"]
+	// the default sections are given without argument or with default
+	defaults := len(requested) == 0 || requested["default"] || all
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !requested[section.name] && !all && (section.all || !defaults) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + section.title + "\r\n")
+		sb.WriteString(section.generate(m))
+	}
+	return RESP.MakeBulkData([]byte(sb.String()))
+}
+
+func infoServer(m *MemDb) string {
+	executable, _ := os.Executable()
+	now := time.Now()
+	uptime := int64(now.Sub(m.stats.startTime).Seconds())
+	return fmt.Sprintf("redis_version:%s\r\nredis_mode:standalone\r\nos:%s %s\r\narch_bits:%d\r\nmultiplexing_api:%s\r\n"+
+		"go_version:%s\r\nprocess_id:%d\r\nrun_id:%s\r\ntcp_port:%d\r\nserver_time_usec:%d\r\n"+
+		"uptime_in_seconds:%d\r\nuptime_in_days:%d\r\nhz:%d\r\nexecutable:%s\r\nconfig_file:%s\r\n",
+		redisVersion, runtime.GOOS, runtime.GOARCH, strconv.IntSize, config.Configures.Reactor,
+		runtime.Version(), os.Getpid(), m.stats.runID, config.Configures.Port, now.UnixMicro(),
+		uptime, uptime/(24*3600), time.Second/activeExpireInterval, executable, config.Configures.ConfFile)
+}
+
+func infoClients(m *MemDb) string {
+	maxClients, _, _ := config.Configures.ClientLimits()
+	// there is no blocking command, so no client is ever blocked
+	return fmt.Sprintf("connected_clients:%d\r\nmaxclients:%d\r\nblocked_clients:0\r\ntracking_clients:%d\r\n",
+		m.clients.Len(), maxClients, atomic.LoadInt64(&m.tracking.clients))
+}
+
+func infoMemory(m *MemDb) string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	used := mem.HeapAlloc
+	// the memory obtained from the OS and not released to it, Go doesn't report the resident set
+	rss := mem.Sys - mem.HeapReleased
+	peak := m.stats.peak(used)
+	return fmt.Sprintf("used_memory:%d\r\nused_memory_human:%s\r\nused_memory_rss:%d\r\nused_memory_rss_human:%s\r\n"+
+		"used_memory_peak:%d\r\nused_memory_peak_human:%s\r\nmem_fragmentation_ratio:%.2f\r\nmem_allocator:%s\r\n"+
+		"gc_cycles:%d\r\n",
+		used, bytesToHuman(used), rss, bytesToHuman(rss), peak, bytesToHuman(peak), float64(rss)/float64(used),
+		runtime.Version(), mem.NumGC)
+}
+
+// bytesToHuman formats a number of bytes like Redis, such as 1.50M
+func bytesToHuman(n uint64) string {
+	const units = "KMGTP"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	size := float64(n) / 1024
+	i := 0
+	for ; size >= 1024 && i < len(units)-1; i++ {
+		size /= 1024
+	}
+	return fmt.Sprintf("%.2f%c", size, units[i])
+}
+
+func infoPersistence(m *MemDb) string {
+	status := "ok"
+	if atomic.LoadInt32(&m.stats.aofLastWriteErr) != 0 {
+		status = "err"
+	}
+	return fmt.Sprintf("loading:%d\r\naof_enabled:%d\r\naof_last_write_status:%s\r\naof_current_size:%d\r\naof_buffer_length:%d\r\n",
+		atomic.LoadInt32(&m.stats.loading), atomic.LoadInt32(&m.stats.aofEnabled), status,
+		atomic.LoadInt64(&m.stats.aofSize), atomic.LoadInt64(&m.stats.aofPending))
+}
+
+func infoStats(m *MemDb) string {
+	s := m.stats
+	ops, input, output := s.instantaneous()
+	m.pubsub.mu.RLock()
+	channels, patterns, shardChannels := len(m.pubsub.channels), len(m.pubsub.patterns), len(m.pubsub.shardChannels)
+	m.pubsub.mu.RUnlock()
+	// there is no maxmemory, so no key is ever evicted
+	return fmt.Sprintf("total_connections_received:%d\r\ntotal_commands_processed:%d\r\ninstantaneous_ops_per_sec:%d\r\n"+
+		"total_net_input_bytes:%d\r\ntotal_net_output_bytes:%d\r\ninstantaneous_input_kbps:%.2f\r\ninstantaneous_output_kbps:%.2f\r\n"+
+		"rejected_connections:%d\r\nexpired_keys:%d\r\nevicted_keys:0\r\nkeyspace_hits:%d\r\nkeyspace_misses:%d\r\npubsub_channels:%d\r\npubsub_patterns:%d\r\npubsub_shardchannels:%d\r\n"+
+		"total_error_replies:%d\r\n",
+		atomic.LoadInt64(&s.connectionsReceived), atomic.LoadInt64(&s.commandsProcessed), int64(ops),
+		atomic.LoadInt64(&s.netInputBytes), atomic.LoadInt64(&s.netOutputBytes), input/1024, output/1024,
+		atomic.LoadInt64(&s.rejectedConns), atomic.LoadInt64(&s.expiredKeys),
+		atomic.LoadInt64(&s.keyspaceHits), atomic.LoadInt64(&s.keyspaceMisses), channels, patterns, shardChannels,
+		atomic.LoadInt64(&s.errorReplies))
+}
+
+func infoReplication(m *MemDb) string {
+	if m.replication != nil {
+		return m.replication.Info()
+	}
+	return "role:master\r\nconnected_slaves:0\r\n"
+}
+
+func infoCPU(m *MemDb) string {
+	sys, user, sysChildren, userChildren := cpuUsage()
+	return fmt.Sprintf("used_cpu_sys:%.6f\r\nused_cpu_user:%.6f\r\nused_cpu_sys_children:%.6f\r\nused_cpu_user_children:%.6f\r\n",
+		sys, user, sysChildren, userChildren)
+}
+
+func infoCommandStats(m *MemDb) string {
+	var sb strings.Builder
+	for _, name := range sortedKeys(&m.stats.commands) {
+		cs := m.stats.command(name)
+		calls, usec := atomic.LoadInt64(&cs.calls), atomic.LoadInt64(&cs.usec)
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		sb.WriteString(fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			name, calls, usec, perCall, atomic.LoadInt64(&cs.rejected), atomic.LoadInt64(&cs.failed)))
+	}
+	return sb.String()
+}
+
+func infoErrorStats(m *MemDb) string {
+	var sb strings.Builder
+	for _, code := range sortedKeys(&m.stats.errors) {
+		count, _ := m.stats.errors.Load(code)
+		sb.WriteString(fmt.Sprintf("errorstat_%s:count=%d\r\n", code, atomic.LoadInt64(count.(*int64))))
+	}
+	return sb.String()
+}
+
+func infoLatencyStats(m *MemDb) string {
+	return m.latencyStats.info()
+}
+
+// infoKeyspaceSample is the number of keys with ttl sampled to estimate avg_ttl
+const infoKeyspaceSample = 100
+
+func infoKeyspace(m *MemDb) string {
+	keys := m.db.Len()
+	if keys == 0 {
+		return ""
+	}
+	// avg_ttl is estimated from a sample of the keys with ttl like Redis, in milliseconds
+	var total, sampled int64
+	now := time.Now()
+	for _, key := range m.ttlKeys.RandomKeys(infoKeyspaceSample) {
+		if ttl, ok := m.ttlKeys.Get(key); ok {
+			total += max(time.Unix(ttl.(int64), 0).Sub(now).Milliseconds(), 0)
+			sampled++
+		}
 	}
-	var infoStr = "# Server\nredis_version:6.2.6\nredis_git_sha1:00000000\nredis_git_dirty:0\nredis_build_id:b61f37314a089f19\nredis_mode:standalone\nos:Linux 5.4.0-163-generic x86_64\narch_bits:64\nmultiplexing_api:epoll\natomicvar_api:atomic-builtin\ngcc_version:10.2.1\nprocess_id:1\nprocess_supervised:no\nrun_id:5821cfc903a866e3bfed875c7fa62433739af927\ntcp_port:6379\nserver_time_usec:1711703004547834\nuptime_in_seconds:323959\nuptime_in_days:3\nhz:10\nconfigured_hz:10\nlru_clock:426972\nexecutable:/data/redis-server\nconfig_file:/etc/redis/redis.conf\nio_threads_active:0\n\n# Clients\nconnected_clients:2\ncluster_connections:0\nmaxclients:10000\nclient_recent_max_input_buffer:56\nclient_recent_max_output_buffer:0\nblocked_clients:0\ntracking_clients:0\nclients_in_timeout_table:0\n\n# Memory\nused_memory:904768\nused_memory_human:883.56K\nused_memory_rss:7176192\nused_memory_rss_human:6.84M\nused_memory_peak:964896\nused_memory_peak_human:942.28K\nused_memory_peak_perc:93.77%\nused_memory_overhead:851560\nused_memory_startup:810144\nused_memory_dataset:53208\nused_memory_dataset_perc:56.23%\nallocator_allocated:936424\nallocator_active:1261568\nallocator_resident:4075520\ntotal_system_memory:16773562368\ntotal_system_memory_human:15.62G\nused_memory_lua:37888\nused_memory_lua_human:37.00K\nused_memory_scripts:0\nused_memory_scripts_human:0B\nnumber_of_cached_scripts:0\nmaxmemory:0\nmaxmemory_human:0B\nmaxmemory_policy:noeviction\nallocator_frag_ratio:1.35\nallocator_frag_bytes:325144\nallocator_rss_ratio:3.23\nallocator_rss_bytes:2813952\nrss_overhead_ratio:1.76\nrss_overhead_bytes:3100672\nmem_fragmentation_ratio:8.32\nmem_fragmentation_bytes:6314152\nmem_not_counted_for_evict:4\nmem_replication_backlog:0\nmem_clients_slaves:0\nmem_clients_normal:41032\nmem_aof_buffer:8\nmem_allocator:jemalloc-5.1.0\nactive_defrag_running:0\nlazyfree_pending_objects:0\nlazyfreed_objects:0\n\n# Persistence\nloading:0\ncurrent_cow_size:0\ncurrent_cow_size_age:0\ncurrent_fork_perc:0.00\ncurrent_save_keys_processed:0\ncurrent_save_keys_total:0\nrdb_changes_since_last_save:0\nrdb_bgsave_in_progress:0\nrdb_last_save_time:1711382646\nrdb_last_bgsave_status:ok\nrdb_last_bgsave_time_sec:0\nrdb_current_bgsave_time_sec:-1\nrdb_last_cow_size:315392\naof_enabled:1\naof_rewrite_in_progress:0\naof_rewrite_scheduled:0\naof_last_rewrite_time_sec:-1\naof_current_rewrite_time_sec:-1\naof_last_bgrewrite_status:ok\naof_last_write_status:ok\naof_last_cow_size:0\nmodule_fork_in_progress:0\nmodule_fork_last_cow_size:0\naof_current_size:665\naof_base_size:665\naof_pending_rewrite:0\naof_buffer_length:0\naof_rewrite_buffer_length:0\naof_pending_bio_fsync:0\naof_delayed_fsync:0\n\n# Stats\ntotal_connections_received:320\ntotal_commands_processed:1837\ninstantaneous_ops_per_sec:0\ntotal_net_input_bytes:32814\ntotal_net_output_bytes:907585\ninstantaneous_input_kbps:0.00\ninstantaneous_output_kbps:0.00\nrejected_connections:0\nsync_full:0\nsync_partial_ok:0\nsync_partial_err:0\nexpired_keys:0\nexpired_stale_perc:0.00\nexpired_time_cap_reached_count:0\nexpire_cycle_cpu_milliseconds:9807\nevicted_keys:0\nkeyspace_hits:20\nkeyspace_misses:0\npubsub_channels:0\npubsub_patterns:0\nlatest_fork_usec:716\ntotal_forks:1\nmigrate_cached_sockets:0\nslave_expires_tracked_keys:0\nactive_defrag_hits:0\nactive_defrag_misses:0\nactive_defrag_key_hits:0\nactive_defrag_key_misses:0\ntracking_total_keys:0\ntracking_total_items:0\ntracking_total_prefixes:0\nunexpected_error_replies:0\ntotal_error_replies:1757\ndump_payload_sanitizations:0\ntotal_reads_processed:2383\ntotal_writes_processed:2069\nio_threaded_reads_processed:0\nio_threaded_writes_processed:0\n\n# Replication\nrole:master\nconnected_slaves:0\nmaster_failover_state:no-failover\nmaster_replid:691ddf41902e6b7f474c89322ee984e920efc8f3\nmaster_replid2:0000000000000000000000000000000000000000\nmaster_repl_offset:0\nsecond_repl_offset:-1\nrepl_backlog_active:0\nrepl_backlog_size:1048576\nrepl_backlog_first_byte_offset:0\nrepl_backlog_histlen:0\n\n# CPU\nused_cpu_sys:341.905416\nused_cpu_user:372.496196\nused_cpu_sys_children:0.010041\nused_cpu_user_children:0.002399\nused_cpu_sys_main_thread:341.816908\nused_cpu_user_main_thread:372.468378\n\n# Modules\n\n# Errorstats\nerrorstat_ERR:count=8\nerrorstat_NOAUTH:count=233\nerrorstat_WRONGPASS:count=1516\n\n# Cluster\ncluster_enabled:0\n\n# Keyspace\ndb0:keys=2,expires=0,avg_ttl=0\ndb2:keys=5,expires=0,avg_ttl=0:/Users/ming/Desktop/godis/redis.conf\n# Clients\nconnected_clients:1\n# Cluster\ncluster_enabled:0\n# Keyspace\ndb0:keys=5,expires=0,avg_ttl=0\n\n# Server\ngodis_version:1.2.8\ngodis_mode:standalone\nos:darwin arm64\narch_bits:64\ngo_version:go1.21.6\nprocess_id:69684\nrun_id:lPepFMBbQtEYt3MD5x712p4rCQHClYU2G1xM6k5t\ntcp_port:6399\nuptime_in_seconds:5\nuptime_in_days:0\nconfig_file:/Users/redis.conf\n# Clients\nconnected_clients:1\n# Cluster\ncluster_enabled:0\n# Keyspace\ndb0:keys=5,expires=0,avg_ttl=0\n"
-	if len(cmd) == 1 {
-		return RESP.MakeBulkData([]byte(infoStr))
+	var avgTTL int64
+	if sampled > 0 {
+		avgTTL = total / sampled
 	}
-	return RESP.MakeBulkData([]byte(infoStr))
+	return fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=%d\r\n", keys, m.ttlKeys.Len(), avgTTL)
 }
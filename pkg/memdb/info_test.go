@@ -0,0 +1,96 @@
+package memdb
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfoSections(t *testing.T) {
+	RegisterStringCommands()
+	RegisterKeyCommand()
+	m := NewMemDb()
+	c, _ := newTestClient(m)
+	exec := func(args ...string) string {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return string(m.ExecCommand(c, cmd).ByteData())
+	}
+	headers := func(info string) []string {
+		return regexp.MustCompile(`(?m)^# (\w+)\r$`).FindAllString(info, -1)
+	}
+
+	exec("set", "k", "v")
+	exec("set", "t", "v")
+	exec("expire", "t", "100")
+	exec("incr", "k")
+	exec("nosuchcommand")
+
+	defaults := headers(exec("info"))
+	want := []string{"# Server\r", "# Clients\r", "# Memory\r", "# Persistence\r", "# Stats\r", "# Replication\r",
+		"# CPU\r", "# Errorstats\r", "# Cluster\r", "# Keyspace\r"}
+	if strings.Join(defaults, ",") != strings.Join(want, ",") {
+		t.Errorf("default sections %v", defaults)
+	}
+	if all := headers(exec("info", "all")); len(all) != len(want)+2 {
+		t.Errorf("all sections %v", all)
+	}
+	// the sections are given in their order, whatever the order of the arguments
+	if named := headers(exec("info", "KEYSPACE", "clients", "unknown")); strings.Join(named, ",") != "# Clients\r,# Keyspace\r" {
+		t.Errorf("named sections %v", named)
+	}
+
+	stats := exec("info", "commandstats", "errorstats", "keyspace", "stats")
+	for _, pattern := range []string{
+		`cmdstat_set:calls=2,usec=\d+,usec_per_call=[\d.]+,rejected_calls=0,failed_calls=0\r\n`,
+		`cmdstat_incr:calls=1,usec=\d+,usec_per_call=[\d.]+,rejected_calls=0,failed_calls=1\r\n`,
+		// neither of the errors has a code
+		`errorstat_ERR:count=2\r\n`,
+		`total_error_replies:2\r\n`,
+		`db0:keys=2,expires=1,avg_ttl=(9\d{4}|100000)\r\n`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(stats) {
+			t.Errorf("info should match %q:\n%s", pattern, stats)
+		}
+	}
+	// commands sent before authentication are rejected
+	m.acl.SetRequirePass("secret")
+	other, _ := newTestClient(m)
+	m.ExecCommand(other, [][]byte{[]byte("get"), []byte("k")})
+	if stats = exec("info", "commandstats"); !strings.Contains(stats, "cmdstat_get:calls=0,usec=0,usec_per_call=0.00,rejected_calls=1,failed_calls=0") {
+		t.Errorf("rejected calls:\n%s", stats)
+	}
+
+	m.ttlKeys.Set("t", time.Now().Add(-time.Second).Unix())
+	m.CheckTTL("t")
+	if stats = exec("info", "stats"); !strings.Contains(stats, "\r\nexpired_keys:1\r\n") {
+		t.Errorf("expired keys:\n%s", stats)
+	}
+}
+
+func TestStatsSample(t *testing.T) {
+	s := NewStats()
+	s.lastSample.time = time.Now().Add(-time.Second)
+	s.commandsProcessed = 500
+	s.AddNetInput(1024 * 10)
+	s.Sample()
+	ops, input, _ := s.instantaneous()
+	// a single sample of about 500 ops/s is averaged with the empty ones
+	if ops < 90 || ops > 100 || input < 1024*10/statsSamples-100 || input > 1024*10/statsSamples {
+		t.Errorf("instantaneous ops %f input %f", ops, input)
+	}
+	if s.peakMemory == 0 {
+		t.Error("the peak of the memory should be sampled")
+	}
+}
+
+func TestBytesToHuman(t *testing.T) {
+	for n, want := range map[uint64]string{0: "0B", 1023: "1023B", 1536: "1.50K", 5 << 20: "5.00M", 3 << 30: "3.00G"} {
+		if got := bytesToHuman(n); got != want {
+			t.Errorf("bytesToHuman(%d) = %s, expected %s", n, got, want)
+		}
+	}
+}
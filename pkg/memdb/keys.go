@@ -13,20 +13,37 @@ import (
 // RegisterKeyCommand
 // Register command
 func RegisterKeyCommand() {
-	RegisterCommand("ping", pingKeys)
-	RegisterCommand("del", delKey)
-	RegisterCommand("exists", existsKey)
-	RegisterCommand("keys", keysKey)
-	RegisterCommand("expire", expireKey)
-	RegisterCommand("persist", persistKey)
-	RegisterCommand("ttl", ttlKey)
-	RegisterCommand("type", typeKey)
-	RegisterCommand("rename", renameKey)
+	RegisterCommand("ping", pingKeys, -1, 0, catConnection, 0, 0, 0,
+		commandDoc{"Returns the server's liveliness response.", "1.0.0", "connection"})
+	RegisterCommand("del", delKey, -2, flagWrite, catKeyspace, 1, -1, 1,
+		commandDoc{"Deletes one or more keys.", "1.0.0", "generic"})
+	RegisterCommand("exists", existsKey, -2, flagReadonly, catKeyspace, 1, -1, 1,
+		commandDoc{"Determines whether one or more keys exist.", "1.0.0", "generic"})
+	RegisterCommand("keys", keysKey, 2, flagReadonly, catKeyspace|catDangerous, 0, 0, 0,
+		commandDoc{"Returns all key names that match a pattern.", "1.0.0", "generic"})
+	RegisterCommand("expire", expireKey, -3, flagWrite, catKeyspace, 1, 1, 1,
+		commandDoc{"Sets the expiration time of a key in seconds.", "1.0.0", "generic"})
+	RegisterCommand("persist", persistKey, 2, flagWrite, catKeyspace, 1, 1, 1,
+		commandDoc{"Removes the expiration time of a key.", "2.2.0", "generic"})
+	RegisterCommand("ttl", ttlKey, 2, flagReadonly, catKeyspace, 1, 1, 1,
+		commandDoc{"Returns the expiration time in seconds of a key.", "1.0.0", "generic"})
+	RegisterCommand("type", typeKey, 2, flagReadonly, catKeyspace, 1, 1, 1,
+		commandDoc{"Determines the type of value stored at a key.", "1.0.0", "generic"})
+	RegisterCommand("rename", renameKey, 3, flagWrite, catKeyspace, 1, 2, 1,
+		commandDoc{"Renames a key and overwrites the destination.", "1.0.0", "generic"})
+	RegisterCommand("flushdb", flushDbKey, -1, flagWrite, catKeyspace|catDangerous, 0, 0, 0,
+		commandDoc{"Remove all keys from the current database.", "1.0.0", "server"})
+	RegisterCommand("flushall", flushDbKey, -1, flagWrite, catKeyspace|catDangerous, 0, 0, 0,
+		commandDoc{"Removes all keys from all databases.", "1.0.0", "server"})
 }
 
+// keyspaceCommands are the commands writing the whole keyspace rather than the keys in their arguments,
+// they lock all txLocks exclusively so that they can't run in the middle of a transaction
+var keyspaceCommands = map[string]bool{"flushdb": true, "flushall": true}
+
 // pingKeys
 // if ping return pong
-func pingKeys(m *MemDb, cmd [][]byte) RESP.RedisData {
+func pingKeys(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "ping" {
 		logger.Error("pingKeys Function: cmdName is not ping")
@@ -35,12 +52,20 @@ func pingKeys(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if len(cmd) > 2 {
 		return RESP.MakeErrorData("error: command args number is invalid")
 	}
+	// a subscribed client can't tell a simple string reply from a message, so it gets an array
+	if c.InPubSubContext() {
+		msg := []byte{}
+		if len(cmd) == 2 {
+			msg = cmd[1]
+		}
+		return RESP.MakeArrayData([]RESP.RedisData{RESP.MakeBulkData([]byte("pong")), RESP.MakeBulkData(msg)})
+	}
 	if len(cmd) == 1 {
 		return RESP.MakeStringData("PONG")
 	}
 	return RESP.MakeBulkData(cmd[1])
 }
-func delKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func delKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "del" {
 		logger.Error("delKey Function: cmdName is not del")
@@ -52,13 +77,16 @@ func delKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 	dKeyCount := 0
 	for _, key := range cmd[1:] {
 		m.locks.Lock(string(key))
-		dKeyCount += m.db.Delete(string(key))
+		if m.db.Delete(string(key)) == 1 {
+			dKeyCount++
+			m.notify(notifyGeneric, "del", string(key))
+		}
 		m.ttlKeys.Delete(string(key))
 		m.locks.UnLock(string(key))
 	}
 	return RESP.MakeIntData(int64(dKeyCount))
 }
-func existsKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func existsKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "exists" || len(cmd) < 2 {
 		logger.Error("existsKey Function: cmdName is not exists")
@@ -67,6 +95,7 @@ func existsKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 	eKeyCount := 0
 	for _, keyByte := range cmd[1:] {
 		key := string(keyByte)
+		m.trackRead(c, key)
 		if m.CheckTTL(key) {
 			m.locks.RLock(key)
 			if _, ok := m.db.Get(key); ok {
@@ -78,7 +107,7 @@ func existsKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 	return RESP.MakeIntData(int64(eKeyCount))
 }
-func keysKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func keysKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if len(cmd) != 2 {
 		logger.Error("keysKey Function: cmd length is not 2")
 		return RESP.MakeErrorData(fmt.Sprintf("error: keys function requires exactly 2 arguments, got %d", len(cmd)))
@@ -101,7 +130,7 @@ func keysKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeArrayData(res)
 }
-func expireKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func expireKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "expire" || len(cmd) < 3 || len(cmd) > 4 {
 		logger.Error("expireKey Function: cmdName is not expire or command args number is invalid")
@@ -150,9 +179,12 @@ func expireKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 		res = m.SetTTL(key, ttl)
 	}
+	if res == 1 {
+		m.notify(notifyGeneric, "expire", key)
+	}
 	return RESP.MakeIntData(int64(res))
 }
-func persistKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func persistKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "persist" || len(cmd) != 2 {
 		logger.Error("persistKey Function: cmdName is not persist or command args number is invalid")
@@ -165,15 +197,19 @@ func persistKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 	m.locks.Lock(key)
 	defer m.locks.UnLock(key)
 	res := m.DelTTL(key)
+	if res == 1 {
+		m.notify(notifyGeneric, "persist", key)
+	}
 	return RESP.MakeIntData(int64(res))
 }
-func ttlKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func ttlKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "ttl" || len(cmd) != 2 {
 		logger.Error("ttlKey Function: cmdName is not ttl or command args number is invalid")
 		return RESP.MakeErrorData("error: cmdName is not ttl or command args number is invalid")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	m.locks.RLock(key)
 	defer m.locks.RUnLock(key)
 	if _, ok := m.db.Get(key); !ok {
@@ -188,13 +224,14 @@ func ttlKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 }
 
-func typeKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func typeKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "type" || len(cmd) != 2 {
 		logger.Error("typeKey Function: cmdName is not type or command args number is invalid")
 		return RESP.MakeErrorData("error: cmdName is not type or command args number is invalid")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeBulkData([]byte("none"))
 	}
@@ -204,24 +241,41 @@ func typeKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if !ok {
 		return RESP.MakeStringData("none")
 	}
+	if name := valueType(v); name != "" {
+		return RESP.MakeStringData(name)
+	}
+	logger.Error("typeKey Function: type func error, not in string|list|set|hash")
+	return RESP.MakeErrorData("unknown error: server error")
+}
+
+// valueType returns the name of the type of a value given by TYPE, or an empty string for an unknown type
+func valueType(v any) string {
+	if i := valueTypeIndex(v); i >= 0 {
+		return valueTypes[i]
+	}
+	return ""
+}
+
+// valueTypes are the types of the values of the keys
+var valueTypes = [...]string{"string", "list", "set", "hash", "zset"}
+
+// valueTypeIndex returns the index of the type of v in valueTypes, -1 if v is not a value of a key
+func valueTypeIndex(v any) int {
 	switch v.(type) {
 	case []byte:
-		return RESP.MakeStringData("string")
+		return 0
 	case *List:
-		return RESP.MakeStringData("list")
+		return 1
 	case *Set:
-		return RESP.MakeStringData("set")
+		return 2
 	case *Hash:
-		return RESP.MakeStringData("hash")
+		return 3
 	case *ZSet:
-		return RESP.MakeStringData("zset")
-
-	default:
-		logger.Error("typeKey Function: type func error, not in string|list|set|hash")
+		return 4
 	}
-	return RESP.MakeErrorData("unknown error: server error")
+	return -1
 }
-func renameKey(m *MemDb, cmd [][]byte) RESP.RedisData {
+func renameKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "rename" || len(cmd) != 3 {
 		logger.Error("renameKey Function: cmdName is not rename or command args number is not invalid")
@@ -242,5 +296,29 @@ func renameKey(m *MemDb, cmd [][]byte) RESP.RedisData {
 	m.db.Delete(newName)
 	m.ttlKeys.Delete(newName)
 	m.db.Set(newName, oldValue)
+	m.notify(notifyGeneric, "rename_from", oldName)
+	m.notify(notifyGeneric, "rename_to", newName)
+	return RESP.MakeStringData("OK")
+}
+
+// flushDbKey
+// FLUSHDB and FLUSHALL delete all keys, there is only one database
+func flushDbKey(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) > 2 {
+		return RESP.MakeErrorData("error: command args number is invalid")
+	}
+	if len(cmd) == 2 {
+		opt := strings.ToLower(string(cmd[1]))
+		if opt != "sync" && opt != "async" {
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	for _, key := range m.db.Keys() {
+		m.locks.Lock(key)
+		m.db.Delete(key)
+		m.ttlKeys.Delete(key)
+		m.locks.UnLock(key)
+		m.signalModifiedKey(c, key)
+	}
 	return RESP.MakeStringData("OK")
 }
@@ -16,7 +16,7 @@ func TestDelkey(t *testing.T) {
 	memdb.db.Set("b", "b")
 	memdb.ttlKeys.Set("b", time.Now().Unix()+10)
 
-	del_a := delKey(memdb, [][]byte{[]byte("del"), []byte("a"), []byte("b")})
+	del_a := delKey(memdb, nil, [][]byte{[]byte("del"), []byte("a"), []byte("b")})
 
 	if !bytes.Equal(del_a.ToBytes(), []byte(":2\r\n")) {
 		t.Error("del reply is not correct")
@@ -34,7 +34,7 @@ func TestExpireKey(t *testing.T) {
 	memdb.db.Set("a", "a")
 	memdb.db.Set("b", "b")
 
-	expire_a := expireKey(memdb, [][]byte{[]byte("expire"), []byte("a"), []byte("100"), []byte("nx")})
+	expire_a := expireKey(memdb, nil, [][]byte{[]byte("expire"), []byte("a"), []byte("100"), []byte("nx")})
 	if !bytes.Equal(expire_a.ToBytes(), []byte(":1\r\n")) {
 		t.Error("expire reply is not correct")
 	}
@@ -42,7 +42,7 @@ func TestExpireKey(t *testing.T) {
 	if attl.(int64)-time.Now().Unix() > 100 || attl.(int64)-time.Now().Unix() < 99 {
 		t.Error("ttl set incorrect")
 	}
-	expire_a1 := expireKey(memdb, [][]byte{[]byte("expire"), []byte("a"), []byte("1000"), []byte("xx")})
+	expire_a1 := expireKey(memdb, nil, [][]byte{[]byte("expire"), []byte("a"), []byte("1000"), []byte("xx")})
 	if !bytes.Equal(expire_a1.ToBytes(), []byte(":1\r\n")) {
 		t.Error("expire reply is not correct")
 	}
@@ -51,7 +51,7 @@ func TestExpireKey(t *testing.T) {
 		t.Error("ttl set incorrect")
 	}
 
-	expire_b := expireKey(memdb, [][]byte{[]byte("expire"), []byte("b"), []byte("100")})
+	expire_b := expireKey(memdb, nil, [][]byte{[]byte("expire"), []byte("b"), []byte("100")})
 	if !bytes.Equal(expire_b.ToBytes(), []byte(":1\r\n")) {
 		t.Error("expire reply is not correct")
 	}
@@ -60,7 +60,7 @@ func TestExpireKey(t *testing.T) {
 		t.Error("ttl set incorrect")
 	}
 
-	expire_b1 := expireKey(memdb, [][]byte{[]byte("expire"), []byte("b"), []byte("1000"), []byte("gt")})
+	expire_b1 := expireKey(memdb, nil, [][]byte{[]byte("expire"), []byte("b"), []byte("1000"), []byte("gt")})
 	if !bytes.Equal(expire_b1.ToBytes(), []byte(":1\r\n")) {
 		t.Error("expire reply is not correct")
 	}
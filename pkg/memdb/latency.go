@@ -0,0 +1,337 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Events sampled by the latency monitor, named like Redis
+const (
+	LatencyCommand     = "command"
+	LatencyExpireCycle = "expire-cycle"
+	LatencyAofWrite    = "aof-write"
+	LatencyAofFsync    = "aof-fsync"
+)
+
+// latencySamples is the number of the latest samples kept by an event
+const latencySamples = 160
+
+// LatencyMonitor samples the events lasting longer than latency-monitor-threshold, served by LATENCY LATEST and HISTORY.
+// An event keeps one sample per second, the largest latency of that second.
+type LatencyMonitor struct {
+	threshold int64 // milliseconds, read without mu by every sample, 0 disables the monitor
+	mu        sync.Mutex
+	events    map[string]*latencyEvent
+}
+
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+// latencyEvent is a ring of the latest samples of an event
+type latencyEvent struct {
+	samples [latencySamples]latencySample
+	next    int
+	max     int64
+}
+
+func NewLatencyMonitor(threshold int64) *LatencyMonitor {
+	return &LatencyMonitor{threshold: threshold, events: make(map[string]*latencyEvent)}
+}
+
+func (lm *LatencyMonitor) setThreshold(threshold int64) {
+	atomic.StoreInt64(&lm.threshold, threshold)
+}
+
+// AddSample records that event lasted for d if it reaches latency-monitor-threshold
+func (lm *LatencyMonitor) AddSample(event string, d time.Duration) {
+	threshold := atomic.LoadInt64(&lm.threshold)
+	latency := d.Milliseconds()
+	if threshold == 0 || latency < threshold {
+		return
+	}
+	now := time.Now().Unix()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	e, ok := lm.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		lm.events[event] = e
+	}
+	e.max = max(e.max, latency)
+	if prev := &e.samples[(e.next+latencySamples-1)%latencySamples]; prev.time == now {
+		prev.latency = max(prev.latency, latency)
+		return
+	}
+	e.samples[e.next] = latencySample{time: now, latency: latency}
+	e.next = (e.next + 1) % latencySamples
+}
+
+// history returns the samples of e from the oldest, callers must hold lm.mu.
+func (e *latencyEvent) history() []latencySample {
+	res := make([]latencySample, 0, latencySamples)
+	for i := 0; i < latencySamples; i++ {
+		if sample := e.samples[(e.next+i)%latencySamples]; sample.time != 0 {
+			res = append(res, sample)
+		}
+	}
+	return res
+}
+
+// latest replies LATENCY LATEST, the event name, the time and latency of its latest sample and its largest latency
+func (lm *LatencyMonitor) latest() RESP.RedisData {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	names := make([]string, 0, len(lm.events))
+	for name := range lm.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]RESP.RedisData, len(names))
+	for i, name := range names {
+		e := lm.events[name]
+		last := e.samples[(e.next+latencySamples-1)%latencySamples]
+		res[i] = RESP.MakeArrayData([]RESP.RedisData{
+			RESP.MakeBulkData([]byte(name)),
+			RESP.MakeIntData(last.time),
+			RESP.MakeIntData(last.latency),
+			RESP.MakeIntData(e.max),
+		})
+	}
+	return RESP.MakeArrayData(res)
+}
+
+// history replies LATENCY HISTORY of event
+func (lm *LatencyMonitor) history(event string) RESP.RedisData {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	e, ok := lm.events[event]
+	if !ok {
+		return RESP.MakeArrayData([]RESP.RedisData{})
+	}
+	samples := e.history()
+	res := make([]RESP.RedisData, len(samples))
+	for i, sample := range samples {
+		res[i] = RESP.MakeArrayData([]RESP.RedisData{RESP.MakeIntData(sample.time), RESP.MakeIntData(sample.latency)})
+	}
+	return RESP.MakeArrayData(res)
+}
+
+// reset drops the samples of events, all of them if none is given, and returns the number of dropped events
+func (lm *LatencyMonitor) reset(events []string) int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if len(events) == 0 {
+		n := len(lm.events)
+		clear(lm.events)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := lm.events[event]; ok {
+			delete(lm.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+// The latency histograms of the commands record nanoseconds from 1ns to 1s with the precision of
+// 2 significant digits like the HDR histograms of Redis: the values are split by their power of 2
+// into buckets of histogramSubBuckets linear sub-buckets, the first bucket being twice as large.
+const (
+	histogramMaxValue      = int64(time.Second)
+	histogramSubBucketBits = 8
+	histogramSubBuckets    = 1 << histogramSubBucketBits
+	histogramHalfBuckets   = histogramSubBuckets / 2
+	histogramLen           = (30-histogramSubBucketBits)*histogramHalfBuckets + histogramSubBuckets // 1s < 2^30ns
+)
+
+// latencyHistogram counts the durations of a command, it's updated without locks by concurrent calls
+type latencyHistogram struct {
+	counts [histogramLen]uint64
+	sum    int64 // nanoseconds
+}
+
+// histogramIndex returns the index of the sub-bucket of v
+func histogramIndex(v int64) int {
+	v = min(max(v, 1), histogramMaxValue)
+	bucket := bits.Len64(uint64(v)|(histogramSubBuckets-1)) - histogramSubBucketBits
+	return bucket*histogramHalfBuckets + int(v>>bucket)
+}
+
+// histogramHighest returns the largest value counted by the sub-bucket at index
+func histogramHighest(index int) int64 {
+	bucket := 0
+	if index >= histogramSubBuckets {
+		bucket = (index-histogramSubBuckets)/histogramHalfBuckets + 1
+	}
+	sub := int64(index - bucket*histogramHalfBuckets)
+	return (sub+1)<<bucket - 1
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	atomic.AddUint64(&h.counts[histogramIndex(int64(d))], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// snapshot copies the counts and returns the number of calls counted, so that a report is consistent
+func (h *latencyHistogram) snapshot() (calls uint64, counts []uint64) {
+	counts = make([]uint64, histogramLen)
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		calls += counts[i]
+	}
+	return calls, counts
+}
+
+// percentile returns the nanoseconds below which p percent of the calls ran
+func percentile(calls uint64, counts []uint64, p float64) int64 {
+	target := uint64(math.Ceil(p / 100 * float64(calls)))
+	target = max(target, 1)
+	var total uint64
+	for i, count := range counts {
+		total += count
+		if total >= target {
+			return histogramHighest(i)
+		}
+	}
+	return 0
+}
+
+// LatencyStats keeps the latency histograms of the commands while latency-tracking is on
+type LatencyStats struct {
+	tracking    int32 // read without locks by every command
+	percentiles atomic.Value
+	histograms  sync.Map // command name -> *latencyHistogram
+}
+
+func NewLatencyStats(tracking bool, percentiles []float64) *LatencyStats {
+	s := &LatencyStats{}
+	s.setConfig(tracking, percentiles)
+	return s
+}
+
+func (s *LatencyStats) setConfig(tracking bool, percentiles []float64) {
+	var on int32
+	if tracking {
+		on = 1
+	}
+	atomic.StoreInt32(&s.tracking, on)
+	s.percentiles.Store(percentiles)
+}
+
+// record counts a call of command lasting for d
+func (s *LatencyStats) record(command string, d time.Duration) {
+	if atomic.LoadInt32(&s.tracking) == 0 {
+		return
+	}
+	h, ok := s.histograms.Load(command)
+	if !ok {
+		h, _ = s.histograms.LoadOrStore(command, &latencyHistogram{})
+	}
+	h.(*latencyHistogram).record(d)
+}
+
+// commands returns the names of the commands with a histogram, sorted
+func (s *LatencyStats) commands() []string {
+	return sortedKeys(&s.histograms)
+}
+
+// info returns the lines of the latencystats section of INFO, the percentiles of latency-tracking-info-percentiles in microseconds
+func (s *LatencyStats) info() string {
+	percentiles := s.percentiles.Load().([]float64)
+	var sb strings.Builder
+	for _, name := range s.commands() {
+		h, _ := s.histograms.Load(name)
+		calls, counts := h.(*latencyHistogram).snapshot()
+		if calls == 0 {
+			continue
+		}
+		values := make([]string, len(percentiles))
+		for i, p := range percentiles {
+			values[i] = fmt.Sprintf("p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64), float64(percentile(calls, counts, p))/1000)
+		}
+		sb.WriteString(fmt.Sprintf("latency_percentiles_usec_%s:%s\r\n", name, strings.Join(values, ",")))
+	}
+	return sb.String()
+}
+
+// histogram replies the cumulative distribution of the calls of a command in microseconds,
+// for the powers of 2 from 1024ns like LATENCY HISTOGRAM of Redis
+func (h *latencyHistogram) histogram() RESP.RedisData {
+	calls, counts := h.snapshot()
+	var dist []RESP.RedisData
+	var total, prev uint64
+	index := 0
+	for bound := int64(1024); total < calls; bound *= 2 {
+		last := histogramIndex(bound)
+		for ; index <= last && index < histogramLen; index++ {
+			total += counts[index]
+		}
+		if total > prev {
+			dist = append(dist, RESP.MakeIntData(histogramHighest(last)/1000), RESP.MakeIntData(int64(total)))
+		}
+		prev = total
+	}
+	return RESP.MakeMapData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte("calls")), RESP.MakeIntData(int64(calls)),
+		RESP.MakeBulkData([]byte("histogram_usec")), RESP.MakeMapData(dist),
+	})
+}
+
+// latency
+// LATENCY LATEST | HISTORY event | RESET [event ...] | HISTOGRAM [command ...]
+func latency(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'latency' command")
+	}
+	switch strings.ToLower(string(cmd[1])) {
+	case "latest":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'latency|latest' command")
+		}
+		return m.latencyMonitor.latest()
+	case "history":
+		if len(cmd) != 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'latency|history' command")
+		}
+		return m.latencyMonitor.history(string(cmd[2]))
+	case "reset":
+		events := make([]string, 0, len(cmd)-2)
+		for _, event := range cmd[2:] {
+			events = append(events, string(event))
+		}
+		return RESP.MakeIntData(int64(m.latencyMonitor.reset(events)))
+	case "histogram":
+		names := m.latencyStats.commands()
+		if len(cmd) > 2 {
+			requested := make([]string, 0, len(cmd)-2)
+			for _, name := range cmd[2:] {
+				name := strings.ToLower(string(name))
+				if slices.Contains(names, name) && !slices.Contains(requested, name) {
+					requested = append(requested, name)
+				}
+			}
+			names = requested
+		}
+		res := make([]RESP.RedisData, 0, len(names)*2)
+		for _, name := range names {
+			h, _ := m.latencyStats.histograms.Load(name)
+			res = append(res, RESP.MakeBulkData([]byte(name)), h.(*latencyHistogram).histogram())
+		}
+		return RESP.MakeMapData(res)
+	default:
+		return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try LATENCY HELP.", string(cmd[1])))
+	}
+}
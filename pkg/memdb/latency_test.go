@@ -0,0 +1,122 @@
+package memdb
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	prev := -1
+	for _, v := range []int64{1, 2, 255, 256, 258, 1000, 1024, 99999, 1e6, 1e9} {
+		index := histogramIndex(v)
+		if index <= prev || index >= histogramLen {
+			t.Errorf("index of %d is %d after %d", v, index, prev)
+		}
+		prev = index
+		highest := histogramHighest(index)
+		// 2 significant digits
+		if highest < v || float64(highest-v) > float64(v)/100 {
+			t.Errorf("highest value of %d is %d", v, highest)
+		}
+	}
+	if histogramIndex(0) != histogramIndex(1) || histogramIndex(1e10) != histogramIndex(1e9) {
+		t.Error("values out of range should be clamped")
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	s := NewLatencyStats(true, []float64{50, 99.9})
+	for i := 1; i <= 100; i++ {
+		s.record("get", time.Duration(i)*time.Microsecond)
+	}
+	s.record("set", time.Millisecond)
+	info := s.info()
+	lines := strings.Split(strings.TrimSpace(info), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("info %q", info)
+	}
+	if !regexp.MustCompile(`^latency_percentiles_usec_get:p50=50\.\d{3},p99\.9=100\.\d{3}$`).MatchString(lines[0]) {
+		t.Errorf("get percentiles %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "latency_percentiles_usec_set:p50=1003.519,") {
+		t.Errorf("set percentiles %q", lines[1])
+	}
+
+	s.setConfig(false, nil)
+	s.record("del", time.Millisecond)
+	if _, ok := s.histograms.Load("del"); ok {
+		t.Error("nothing should be recorded without latency-tracking")
+	}
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	lm := NewLatencyMonitor(0)
+	lm.AddSample(LatencyCommand, time.Second)
+	if len(lm.events) != 0 {
+		t.Fatal("the monitor should be disabled by a threshold of 0")
+	}
+	lm.setThreshold(10)
+	lm.AddSample(LatencyCommand, 5*time.Millisecond)
+	lm.AddSample(LatencyCommand, 20*time.Millisecond)
+	lm.AddSample(LatencyCommand, 30*time.Millisecond)
+	lm.AddSample(LatencyExpireCycle, 15*time.Millisecond)
+	// the samples of the same second are merged
+	if history := lm.events[LatencyCommand].history(); len(history) != 1 || history[0].latency != 30 {
+		t.Errorf("history %v", history)
+	}
+	// samples wrap around the ring
+	e := lm.events[LatencyExpireCycle]
+	for i := 0; i < latencySamples+10; i++ {
+		e.samples[e.next] = latencySample{time: int64(i + 1), latency: int64(i)}
+		e.next = (e.next + 1) % latencySamples
+	}
+	if history := e.history(); len(history) != latencySamples || history[0].time != 11 {
+		t.Errorf("history len %d starts at %d", len(history), history[0].time)
+	}
+	if n := lm.reset([]string{LatencyCommand, "missing"}); n != 1 || len(lm.events) != 1 {
+		t.Errorf("reset %d events, %d left", n, len(lm.events))
+	}
+}
+
+func TestLatencyCommand(t *testing.T) {
+	RegisterStringCommands()
+	m := NewMemDb()
+	m.latencyStats.setConfig(true, []float64{50})
+	c, _ := newTestClient(m)
+	exec := func(args ...string) string {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return string(m.ExecCommand(c, cmd).ToBytes())
+	}
+
+	exec("set", "k", "v")
+	exec("get", "k")
+	res := exec("latency", "histogram", "set", "missing")
+	if !regexp.MustCompile(`^%1\r\n\$3\r\nset\r\n%2\r\n\$5\r\ncalls\r\n:1\r\n\$14\r\nhistogram_usec\r\n%1\r\n:\d+\r\n:1\r\n$`).MatchString(res) {
+		t.Errorf("latency histogram %q", res)
+	}
+	if res = exec("info", "latencystats"); !strings.Contains(res, "latency_percentiles_usec_get:p50=") {
+		t.Errorf("info latencystats %q", res)
+	}
+
+	// the commands are not sampled while the monitor is disabled again
+	m.latencyMonitor.setThreshold(1)
+	m.latencyMonitor.AddSample(LatencyExpireCycle, 5*time.Millisecond)
+	m.latencyMonitor.setThreshold(0)
+	if res = exec("latency", "latest"); !regexp.MustCompile(`^\*1\r\n\*4\r\n\$12\r\nexpire-cycle\r\n:\d+\r\n:5\r\n:5\r\n$`).MatchString(res) {
+		t.Errorf("latency latest %q", res)
+	}
+	if res = exec("latency", "history", "expire-cycle"); !strings.HasPrefix(res, "*1\r\n*2\r\n:") || !strings.HasSuffix(res, "\r\n:5\r\n") {
+		t.Errorf("latency history %q", res)
+	}
+	if res = exec("latency", "reset"); res != ":1\r\n" {
+		t.Errorf("latency reset %q", res)
+	}
+	if res = exec("latency", "history", "expire-cycle"); res != "*0\r\n" {
+		t.Errorf("latency history after reset %q", res)
+	}
+}
@@ -10,25 +10,39 @@ import (
 )
 
 func RegisterListCommands() {
-	RegisterCommand("llen", lLenList)
-	RegisterCommand("lindex", lIndexList)
-	RegisterCommand("lpos", lPosList)
-	RegisterCommand("lpop", lPopList)
-	RegisterCommand("rpop", rPopList)
-	RegisterCommand("lpush", lPushList)
-	RegisterCommand("lpushx", lPushXList)
-	RegisterCommand("rpush", rPushList)
-	RegisterCommand("rpushx", rPushXList)
-	RegisterCommand("lset", lSetList)
-	RegisterCommand("lrem", lRemList)
-	RegisterCommand("ltrim", lTrimList)
-	RegisterCommand("lrange", lRangeList)
-	RegisterCommand("lmove", lMoveList)
-	//RegisterCommand("blpop", blPopList)
-	//RegisterCommand("brpop", brPopList)
+	RegisterCommand("llen", lLenList, 2, flagReadonly, catList, 1, 1, 1,
+		commandDoc{"Returns the length of a list.", "1.0.0", "list"})
+	RegisterCommand("lindex", lIndexList, 3, flagReadonly, catList, 1, 1, 1,
+		commandDoc{"Returns an element from a list by its index.", "1.0.0", "list"})
+	RegisterCommand("lpos", lPosList, -3, flagReadonly, catList, 1, 1, 1,
+		commandDoc{"Returns the index of matching elements in a list.", "6.0.6", "list"})
+	RegisterCommand("lpop", lPopList, -2, flagWrite|flagAccess, catList, 1, 1, 1,
+		commandDoc{"Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", "1.0.0", "list"})
+	RegisterCommand("rpop", rPopList, -2, flagWrite|flagAccess, catList, 1, 1, 1,
+		commandDoc{"Returns and removes the last elements of a list. Deletes the list if the last element was popped.", "1.0.0", "list"})
+	RegisterCommand("lpush", lPushList, -3, flagWrite|flagDenyOOM, catList, 1, 1, 1,
+		commandDoc{"Prepends one or more elements to a list. Creates the key if it doesn't exist.", "1.0.0", "list"})
+	RegisterCommand("lpushx", lPushXList, -3, flagWrite|flagDenyOOM, catList, 1, 1, 1,
+		commandDoc{"Prepends one or more elements to a list only when the list exists.", "2.2.0", "list"})
+	RegisterCommand("rpush", rPushList, -3, flagWrite|flagDenyOOM, catList, 1, 1, 1,
+		commandDoc{"Appends one or more elements to a list. Creates the key if it doesn't exist.", "1.0.0", "list"})
+	RegisterCommand("rpushx", rPushXList, -3, flagWrite|flagDenyOOM, catList, 1, 1, 1,
+		commandDoc{"Appends an element to a list only when the list exists.", "2.2.0", "list"})
+	RegisterCommand("lset", lSetList, 4, flagWrite|flagDenyOOM, catList, 1, 1, 1,
+		commandDoc{"Sets the value of an element in a list by its index.", "1.0.0", "list"})
+	RegisterCommand("lrem", lRemList, 4, flagWrite, catList, 1, 1, 1,
+		commandDoc{"Removes elements from a list. Deletes the list if the last element was removed.", "1.0.0", "list"})
+	RegisterCommand("ltrim", lTrimList, 4, flagWrite, catList, 1, 1, 1,
+		commandDoc{"Removes elements from both ends a list. Deletes the list if all elements were trimmed.", "1.0.0", "list"})
+	RegisterCommand("lrange", lRangeList, 4, flagReadonly, catList, 1, 1, 1,
+		commandDoc{"Returns a range of elements from a list.", "1.0.0", "list"})
+	RegisterCommand("lmove", lMoveList, 5, flagWrite|flagDenyOOM|flagAccess, catList, 1, 2, 1,
+		commandDoc{"Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", "6.2.0", "list"})
+	//RegisterCommand("blpop", blPopList, 1, 1, 1)
+	//RegisterCommand("brpop", brPopList, 1, 1, 1)
 }
 
-func lMoveList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lMoveList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lmove" {
 		logger.Error("lMoveList Function : cmdName is not lmove")
 		return RESP.MakeErrorData("Server error")
@@ -67,6 +81,7 @@ func lMoveList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if srcList.Len == 0 {
 			m.db.Delete(src)
 			m.DelTTL(src)
+			m.notify(notifyGeneric, "del", src)
 		}
 	}()
 	if srcList.Len == 0 {
@@ -96,10 +111,20 @@ func lMoveList(m *MemDb, cmd [][]byte) RESP.RedisData {
 	} else {
 		desList.RPush(popElem.Val)
 	}
+	if srcDrc == "left" {
+		m.notify(notifyList, "lpop", src)
+	} else {
+		m.notify(notifyList, "rpop", src)
+	}
+	if desDrc == "left" {
+		m.notify(notifyList, "lpush", des)
+	} else {
+		m.notify(notifyList, "rpush", des)
+	}
 	return RESP.MakeBulkData(popElem.Val)
 
 }
-func lRangeList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lRangeList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lrange" {
 		logger.Error("lRangeList Function : cmdName is not lrange")
 		return RESP.MakeErrorData("Server error")
@@ -115,6 +140,7 @@ func lRangeList(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeEmptyArrayData()
 	}
@@ -141,7 +167,7 @@ func lRangeList(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeArrayData(res)
 }
 
-func lTrimList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lTrimList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "ltrim" {
 		logger.Error("lTrimList Function : cmdName is not ltrim")
 		return RESP.MakeErrorData("Server error")
@@ -173,14 +199,16 @@ func lTrimList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
 	list.Trim(start, end)
+	m.notify(notifyList, "ltrim", key)
 	return RESP.MakeStringData("OK")
 }
 
-func lRemList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lRemList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lrem" {
 		logger.Error("lRemList Function : cmdName is not lrem")
 		return RESP.MakeErrorData("Server error")
@@ -216,15 +244,19 @@ func lRemList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 	res := list.RemoveElement(cmd[3], count)
+	if res > 0 {
+		m.notify(notifyList, "lrem", key)
+	}
 
 	return RESP.MakeIntData(int64(res))
 
 }
 
-func lSetList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lSetList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lset" {
 		logger.Error("lSetList Function : cmdName is not lset")
 		return RESP.MakeErrorData("server error")
@@ -255,15 +287,16 @@ func lSetList(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if !ok {
 		return RESP.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	success := list.Set(index, cmd[3])
+	success := list.Set(index, bytes.Clone(cmd[3]))
 	if !success {
 		return RESP.MakeErrorData("index out of range")
 	}
+	m.notify(notifyList, "lset", key)
 	return RESP.MakeStringData("OK")
 
 }
 
-func rPushXList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func rPushXList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "rpushx" {
 		logger.Error("rPushXList Function : cmdName is not rpushx")
 		return RESP.MakeErrorData("server error")
@@ -288,12 +321,13 @@ func rPushXList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 	}
 	for i := 2; i < len(cmd); i++ {
-		list.RPush(cmd[i])
+		list.RPush(bytes.Clone(cmd[i]))
 	}
+	m.notify(notifyList, "rpush", key)
 	return RESP.MakeIntData(int64(list.Len))
 }
 
-func rPushList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func rPushList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "rpush" {
 		logger.Error("rPushList Function : cmdName is not rpush")
 		return RESP.MakeErrorData("Server error")
@@ -321,12 +355,13 @@ func rPushList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 	}
 	for i := 2; i < len(cmd); i++ {
-		list.RPush(cmd[i])
+		list.RPush(bytes.Clone(cmd[i]))
 	}
+	m.notify(notifyList, "rpush", key)
 	return RESP.MakeIntData(int64(list.Len))
 }
 
-func lPushXList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lPushXList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lpushx" {
 		logger.Error("lPushXList Function : cmdName is not lpushx")
 		return RESP.MakeErrorData("Server Error")
@@ -351,11 +386,12 @@ func lPushXList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 	}
 	for i := 2; i < len(cmd); i++ {
-		list.LPush(cmd[i])
+		list.LPush(bytes.Clone(cmd[i]))
 	}
+	m.notify(notifyList, "lpush", key)
 	return RESP.MakeIntData(int64(list.Len))
 }
-func lPushList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lPushList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lpush" {
 		logger.Error("lPushList Function : cmdName is not lpush")
 		return RESP.MakeErrorData("Server Error")
@@ -381,11 +417,12 @@ func lPushList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 	}
 	for i := 2; i < len(cmd); i++ {
-		list.LPush(cmd[i])
+		list.LPush(bytes.Clone(cmd[i]))
 	}
+	m.notify(notifyList, "lpush", key)
 	return RESP.MakeIntData(int64(list.Len))
 }
-func rPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func rPopList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "rpop" {
 		logger.Error("rPopList: command is not rpop")
 		return RESP.MakeErrorData("Server error")
@@ -421,6 +458,7 @@ func rPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 	// if cnt is not set, return last element
@@ -429,6 +467,7 @@ func rPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if e == nil {
 			return RESP.MakeNullBulkData()
 		}
+		m.notify(notifyList, "rpop", key)
 		return RESP.MakeBulkData(e.Val)
 	}
 
@@ -441,10 +480,13 @@ func rPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 		res = append(res, RESP.MakeBulkData(e.Val))
 	}
+	if len(res) > 0 {
+		m.notify(notifyList, "rpop", key)
+	}
 	return RESP.MakeArrayData(res)
 }
 
-func lPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lPopList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lpop" {
 		logger.Error("lPopList: command is not lpop")
 		return RESP.MakeErrorData("Server error")
@@ -480,6 +522,7 @@ func lPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 	// if cnt is not set, return first element
@@ -488,6 +531,7 @@ func lPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if e == nil {
 			return RESP.MakeBulkData(nil)
 		}
+		m.notify(notifyList, "lpop", key)
 		return RESP.MakeBulkData(e.Val)
 	}
 	// return cnt number elements as array
@@ -499,11 +543,14 @@ func lPopList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		}
 		res = append(res, RESP.MakeBulkData(e.Val))
 	}
+	if len(res) > 0 {
+		m.notify(notifyList, "lpop", key)
+	}
 	return RESP.MakeArrayData(res)
 
 }
 
-func lPosList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lPosList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lpos" {
 		logger.Error("lPosList Function: cmdName is not lpos")
 		return RESP.MakeErrorData("Server error")
@@ -545,6 +592,7 @@ func lPosList(m *MemDb, cmd [][]byte) RESP.RedisData {
 			return RESP.MakeErrorData(fmt.Sprintf("unsupported option %s", string(cmd[i])))
 		}
 	}
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeNullBulkData()
 	}
@@ -688,7 +736,7 @@ func lPosList(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 }
 
-func lIndexList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lIndexList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lindex" {
 		logger.Error("lIndexList Function: cmdName is not lindex")
 		return RESP.MakeErrorData("Server error")
@@ -701,6 +749,7 @@ func lIndexList(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if err != nil {
 		return RESP.MakeErrorData("index is not an integer")
 	}
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeBulkData(nil)
 	}
@@ -722,7 +771,7 @@ func lIndexList(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeBulkData(resNode.Val)
 }
 
-func lLenList(m *MemDb, cmd [][]byte) RESP.RedisData {
+func lLenList(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "llen" {
 		logger.Error("lLenList Function: cmdName is not llen")
 		return RESP.MakeErrorData("Server error")
@@ -731,6 +780,7 @@ func lLenList(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'llen' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeIntData(0)
 	}
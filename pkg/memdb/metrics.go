@@ -0,0 +1,165 @@
+package memdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The latency histograms of the metrics have a bucket per power of 2 nanoseconds from about 1us to 1s.
+// A bucket counts the durations below its bound, since the sub-buckets of the histograms end right before
+// the powers of 2.
+const (
+	metricsMinBucketBits = 10
+	metricsMaxBucketBits = 30
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes the metrics in the text exposition format of Prometheus
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+// family writes the HELP and TYPE lines of the metric name
+func (mw *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample of the metric name, labels are pairs of label names and values
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			fmt.Fprintf(mw.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(formatMetric(value))
+	mw.w.WriteByte('\n')
+}
+
+// single writes a metric with a single sample
+func (mw *metricsWriter) single(name, kind, help string, value float64) {
+	mw.family(name, kind, help)
+	mw.sample(name, value)
+}
+
+// histogram writes the buckets, the sum and the count of h in seconds, labels are shared by its samples
+func (mw *metricsWriter) histogram(name string, h *latencyHistogram, labels ...string) {
+	calls, counts := h.snapshot()
+	var total uint64
+	index := 0
+	for bits := metricsMinBucketBits; bits <= metricsMaxBucketBits; bits++ {
+		bound := int64(1) << bits
+		for last := histogramIndex(bound - 1); index <= last; index++ {
+			total += counts[index]
+		}
+		mw.sample(name+"_bucket", float64(total), append(labels, "le", formatMetric(float64(bound)/1e9))...)
+	}
+	mw.sample(name+"_bucket", float64(calls), append(labels, "le", "+Inf")...)
+	mw.sample(name+"_sum", float64(atomic.LoadInt64(&h.sum))/1e9, labels...)
+	mw.sample(name+"_count", float64(calls), labels...)
+}
+
+// formatMetric formats the integers without exponent, such as the sizes in bytes
+func formatMetric(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteMetrics writes the metrics of the server in the text exposition format of Prometheus, served on /metrics.
+// The keys are counted by type by the counters of the keyspace, which are kept as the keys are set and deleted.
+func (m *MemDb) WriteMetrics(w io.Writer) error {
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+	s := m.stats
+
+	mw.single("redis_uptime_seconds", "gauge", "Seconds since the server started.", time.Since(s.startTime).Seconds())
+	mw.single("redis_connected_clients", "gauge", "Number of connected clients.", float64(m.clients.Len()))
+	mw.single("redis_connections_received_total", "counter", "Connections accepted by the server.",
+		float64(atomic.LoadInt64(&s.connectionsReceived)))
+	mw.single("redis_rejected_connections_total", "counter", "Connections rejected because of maxclients.",
+		float64(atomic.LoadInt64(&s.rejectedConns)))
+	mw.single("redis_net_input_bytes_total", "counter", "Bytes read from the clients.", float64(atomic.LoadInt64(&s.netInputBytes)))
+	mw.single("redis_net_output_bytes_total", "counter", "Bytes written to the clients.", float64(atomic.LoadInt64(&s.netOutputBytes)))
+
+	mw.single("redis_commands_processed_total", "counter", "Commands processed by the server.",
+		float64(atomic.LoadInt64(&s.commandsProcessed)))
+	commands := sortedKeys(&s.commands)
+	mw.family("redis_commands_total", "counter", "Calls of a command.")
+	for _, name := range commands {
+		mw.sample("redis_commands_total", float64(atomic.LoadInt64(&s.command(name).calls)), "cmd", name)
+	}
+	mw.family("redis_commands_duration_seconds_total", "counter", "Seconds spent running a command.")
+	for _, name := range commands {
+		mw.sample("redis_commands_duration_seconds_total", float64(atomic.LoadInt64(&s.command(name).usec))/1e6, "cmd", name)
+	}
+	mw.family("redis_commands_rejected_calls_total", "counter", "Calls of a command replied an error before being executed.")
+	for _, name := range commands {
+		mw.sample("redis_commands_rejected_calls_total", float64(atomic.LoadInt64(&s.command(name).rejected)), "cmd", name)
+	}
+	mw.family("redis_commands_failed_calls_total", "counter", "Calls of a command replied an error by its execution.")
+	for _, name := range commands {
+		mw.sample("redis_commands_failed_calls_total", float64(atomic.LoadInt64(&s.command(name).failed)), "cmd", name)
+	}
+	mw.family("redis_errors_total", "counter", "Error replies by error code.")
+	for _, code := range sortedKeys(&s.errors) {
+		count, _ := s.errors.Load(code)
+		mw.sample("redis_errors_total", float64(atomic.LoadInt64(count.(*int64))), "err", code)
+	}
+	mw.family("redis_command_duration_seconds", "histogram", "Latency of a command, kept while latency-tracking is on.")
+	for _, name := range m.latencyStats.commands() {
+		h, _ := m.latencyStats.histograms.Load(name)
+		mw.histogram("redis_command_duration_seconds", h.(*latencyHistogram), "cmd", name)
+	}
+
+	mw.family("redis_keys", "gauge", "Number of keys by type.")
+	for _, kind := range valueTypes {
+		mw.sample("redis_keys", float64(m.db.TypeCount(kind)), "type", kind)
+	}
+	mw.single("redis_expiring_keys", "gauge", "Number of keys with a ttl.", float64(m.ttlKeys.Len()))
+	mw.single("redis_expired_keys_total", "counter", "Keys deleted because their ttl was reached.",
+		float64(atomic.LoadInt64(&s.expiredKeys)))
+	mw.single("redis_keyspace_hits_total", "counter", "Keys found by the read commands.", float64(atomic.LoadInt64(&s.keyspaceHits)))
+	mw.single("redis_keyspace_misses_total", "counter", "Keys missed by the read commands.", float64(atomic.LoadInt64(&s.keyspaceMisses)))
+
+	mw.single("redis_loading", "gauge", "Whether the AOF file is being loaded.", float64(atomic.LoadInt32(&s.loading)))
+	mw.single("redis_aof_enabled", "gauge", "Whether the AOF writer is running.", float64(atomic.LoadInt32(&s.aofEnabled)))
+	mw.single("redis_aof_current_size_bytes", "gauge", "Size of the AOF file.", float64(atomic.LoadInt64(&s.aofSize)))
+	mw.single("redis_aof_buffer_length_bytes", "gauge", "Bytes queued to the AOF writer.", float64(atomic.LoadInt64(&s.aofPending)))
+	mw.single("redis_aof_last_write_status", "gauge", "Whether the last write to the AOF file succeeded.",
+		float64(1-atomic.LoadInt32(&s.aofLastWriteErr)))
+	mw.family("redis_aof_fsync_duration_seconds", "histogram", "Latency of the fsyncs of the AOF file.")
+	mw.histogram("redis_aof_fsync_duration_seconds", &s.aofFsync)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	sys, user, _, _ := cpuUsage()
+	mw.single("process_cpu_seconds_total", "counter", "User and system CPU seconds used by the server.", sys+user)
+	mw.family("go_info", "gauge", "Version of Go the server is built with.")
+	mw.sample("go_info", 1, "version", runtime.Version())
+	mw.single("go_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	mw.single("go_memstats_alloc_bytes", "gauge", "Bytes of the allocated heap objects.", float64(mem.HeapAlloc))
+	mw.single("go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(mem.Sys))
+	mw.single("go_memstats_heap_inuse_bytes", "gauge", "Bytes of the heap spans in use.", float64(mem.HeapInuse))
+	mw.single("go_memstats_heap_released_bytes", "gauge", "Bytes of the heap released to the OS.", float64(mem.HeapReleased))
+	mw.single("go_memstats_heap_objects", "gauge", "Number of allocated heap objects.", float64(mem.HeapObjects))
+	mw.single("go_memstats_next_gc_bytes", "gauge", "Heap size targeted by the next GC cycle.", float64(mem.NextGC))
+	mw.single("go_memstats_last_gc_time_seconds", "gauge", "Unix time of the last GC cycle.", float64(mem.LastGC)/1e9)
+	mw.single("go_gc_cycles_total", "counter", "Completed GC cycles.", float64(mem.NumGC))
+	mw.single("go_gc_pause_seconds_total", "counter", "Seconds the world was stopped by the GC.", float64(mem.PauseTotalNs)/1e9)
+	return mw.w.Flush()
+}
@@ -0,0 +1,40 @@
+package memdb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHistogram(t *testing.T) {
+	h := &latencyHistogram{}
+	h.record(time.Microsecond)
+	h.record(1024 * time.Nanosecond)
+	h.record(3 * time.Millisecond)
+	var sb strings.Builder
+	mw := &metricsWriter{w: bufio.NewWriter(&sb)}
+	mw.histogram("d", h, "cmd", `a"b`)
+	_ = mw.w.Flush()
+	lines := strings.Split(sb.String(), "\n")
+	// the buckets of 2^10 to 2^30ns, +Inf, the sum and the count
+	if len(lines) != metricsMaxBucketBits-metricsMinBucketBits+5 {
+		t.Fatalf("histogram %q", sb.String())
+	}
+	for i, want := range map[int]string{
+		0:  `d_bucket{cmd="a\"b",le="1.024e-06"} 1`,
+		1:  `d_bucket{cmd="a\"b",le="2.048e-06"} 2`,
+		11: `d_bucket{cmd="a\"b",le="0.002097152"} 2`,
+		12: `d_bucket{cmd="a\"b",le="0.004194304"} 3`,
+		21: `d_bucket{cmd="a\"b",le="+Inf"} 3`,
+		22: `d_sum{cmd="a\"b"} 0.003002024`,
+		23: `d_count{cmd="a\"b"} 3`,
+	} {
+		if lines[i] != want {
+			t.Errorf("line %d %q, expected %q", i, lines[i], want)
+		}
+	}
+	if got := formatMetric(8083720); got != "8083720" {
+		t.Errorf("integers should be formatted without exponent: %s", got)
+	}
+}
@@ -0,0 +1,166 @@
+package memdb
+
+import (
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// redacted replaces the sensitive arguments in the feed of MONITOR
+const redacted = "(redacted)"
+
+// Monitors are the clients receiving the commands executed by the server, registered by MONITOR.
+// The line of a command is encoded once and queued to every monitor without waiting for its connection,
+// so a slow monitor never holds the command, its output is bounded by client-output-buffer-limit instead.
+type Monitors struct {
+	count   int64 // number of monitors, checked without mu so that no line is encoded while nobody monitors
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+}
+
+func NewMonitors() *Monitors {
+	return &Monitors{clients: make(map[*Client]struct{})}
+}
+
+func (ms *Monitors) add(c *Client) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.clients[c]; !ok {
+		ms.clients[c] = struct{}{}
+		atomic.AddInt64(&ms.count, 1)
+	}
+}
+
+func (ms *Monitors) remove(c *Client) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.clients[c]; ok {
+		delete(ms.clients, c)
+		atomic.AddInt64(&ms.count, -1)
+	}
+}
+
+// feed sends the line of cmd executed by c at start to the monitors
+func (ms *Monitors) feed(c *Client, cmd [][]byte, start time.Time) {
+	if atomic.LoadInt64(&ms.count) == 0 || c.conn == nil {
+		return
+	}
+	line := monitorLine(c, cmd, start)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for monitor := range ms.clients {
+		monitor.Write(line)
+	}
+}
+
+// monitorLine encodes the feed line of cmd like Redis: +1339518083.107412 [0 127.0.0.1:60866] "set" "k" "v"
+func monitorLine(c *Client, cmd [][]byte, start time.Time) []byte {
+	c.mu.Lock()
+	db := c.db
+	c.mu.Unlock()
+	addr := c.addr
+	if c.unixSocket {
+		addr = "unix:" + strings.TrimSuffix(c.laddr, ":0")
+	}
+	usec := start.UnixMicro()
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '+')
+	buf = strconv.AppendInt(buf, usec/1e6, 10)
+	buf = append(buf, '.')
+	frac := strconv.FormatInt(usec%1e6, 10)
+	buf = append(buf, strings.Repeat("0", 6-len(frac))...)
+	buf = append(buf, frac...)
+	buf = append(buf, " ["...)
+	buf = strconv.AppendInt(buf, int64(db), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, addr...)
+	buf = append(buf, ']')
+	sensitive := sensitiveArgs(cmd)
+	for i, arg := range cmd {
+		buf = append(buf, ' ')
+		if sensitive(i) {
+			arg = []byte(redacted)
+		}
+		buf = appendRepr(buf, arg)
+	}
+	return append(buf, "\r\n"...)
+}
+
+// sensitiveArgs returns whether the i-th argument of cmd is a secret hidden from the monitors,
+// such as the password of AUTH, HELLO AUTH, ACL SETUSER and CONFIG SET requirepass or masterauth.
+func sensitiveArgs(cmd [][]byte) func(i int) bool {
+	none := func(i int) bool { return false }
+	switch strings.ToLower(string(cmd[0])) {
+	case "auth":
+		return func(i int) bool { return i > 0 }
+	case "hello":
+		for i := 2; i < len(cmd); i++ {
+			if strings.EqualFold(string(cmd[i]), "auth") {
+				auth := i
+				return func(i int) bool { return i == auth+1 || i == auth+2 }
+			}
+		}
+	case "acl":
+		if len(cmd) > 3 && strings.EqualFold(string(cmd[1]), "setuser") {
+			return func(i int) bool {
+				return i > 2 && len(cmd[i]) > 0 && strings.IndexByte("><#!", cmd[i][0]) >= 0
+			}
+		}
+	case "config":
+		if len(cmd) > 3 && strings.EqualFold(string(cmd[1]), "set") {
+			return func(i int) bool {
+				return i > 2 && i%2 == 1 && (strings.EqualFold(string(cmd[i-1]), "requirepass") ||
+					strings.EqualFold(string(cmd[i-1]), "masterauth"))
+			}
+		}
+	}
+	return none
+}
+
+// appendRepr appends arg quoted and escaped like sdscatrepr of Redis
+func appendRepr(buf []byte, arg []byte) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for _, ch := range arg {
+		switch ch {
+		case '\\', '"':
+			buf = append(buf, '\\', ch)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\a':
+			buf = append(buf, '\\', 'a')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		default:
+			if ch < ' ' || ch > '~' {
+				buf = append(buf, '\\', 'x', hex[ch>>4], hex[ch&0xf])
+			} else {
+				buf = append(buf, ch)
+			}
+		}
+	}
+	return append(buf, '"')
+}
+
+// monitorCmd
+// MONITOR
+func monitorCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 1 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'monitor' command")
+	}
+	if c.conn == nil {
+		return RESP.MakeErrorData("ERR MONITOR isn't allowed for this client")
+	}
+	c.mu.Lock()
+	c.monitor = true
+	c.mu.Unlock()
+	m.monitors.add(c)
+	return RESP.MakeStringData("OK")
+}
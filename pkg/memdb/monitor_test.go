@@ -0,0 +1,90 @@
+package memdb
+
+import (
+	"bufio"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	RegisterStringCommands()
+	RegisterACLCommands()
+	m := NewMemDb()
+	monitor, peer := newTestClient(m)
+	c, _ := newTestClient(m)
+	exec := func(c *Client, args ...string) string {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return string(m.ExecCommand(c, cmd).ToBytes())
+	}
+	reader := bufio.NewReader(peer)
+	next := func() string {
+		_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal("read monitor feed:", err)
+		}
+		return line
+	}
+
+	// nothing is encoded before a monitor is registered
+	exec(c, "set", "before", "1")
+	if res := exec(monitor, "monitor"); res != "+OK\r\n" {
+		t.Fatalf("monitor reply %q", res)
+	}
+	if !monitor.monitor {
+		t.Error("the client should be flagged as a monitor")
+	}
+
+	exec(c, "set", "k", "a \"b\"\r\n\x01")
+	line := next()
+	format := regexp.MustCompile(`^\+\d+\.\d{6} \[0 [^ \]]+\] "set" "k" "a \\"b\\"\\r\\n\\x01"\r\n$`)
+	if !format.MatchString(line) {
+		t.Errorf("feed line %q", line)
+	}
+
+	exec(c, "auth", "user", "secret")
+	if line = next(); !regexp.MustCompile(`\] "auth" "\(redacted\)" "\(redacted\)"\r\n$`).MatchString(line) {
+		t.Errorf("auth should be redacted, got %q", line)
+	}
+	exec(c, "acl", "setuser", "alice", "on", ">secret", "~*")
+	if line = next(); !regexp.MustCompile(`\] "acl" "setuser" "alice" "on" "\(redacted\)" "~\*"\r\n$`).MatchString(line) {
+		t.Errorf("acl setuser passwords should be redacted, got %q", line)
+	}
+
+	m.CloseClient(monitor)
+	if n := m.monitors.count; n != 0 {
+		t.Errorf("closed monitor should be removed, %d monitors", n)
+	}
+}
+
+func TestSensitiveArgs(t *testing.T) {
+	tests := []struct {
+		cmd       []string
+		sensitive []int
+	}{
+		{[]string{"get", "auth"}, nil},
+		{[]string{"auth", "pass"}, []int{1}},
+		{[]string{"hello", "3", "auth", "user", "pass", "setname", "n"}, []int{3, 4}},
+		{[]string{"config", "set", "maxclients", "10", "requirepass", "pass"}, []int{5}},
+	}
+	for _, tt := range tests {
+		cmd := make([][]byte, len(tt.cmd))
+		for i, arg := range tt.cmd {
+			cmd[i] = []byte(arg)
+		}
+		sensitive := sensitiveArgs(cmd)
+		for i := range cmd {
+			want := false
+			for _, j := range tt.sensitive {
+				want = want || i == j
+			}
+			if sensitive(i) != want {
+				t.Errorf("%v: argument %d sensitive %v", tt.cmd, i, !want)
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strings"
+	"sync/atomic"
+)
+
+// watchedKey is the modification version of a key watched by at least one client
+type watchedKey struct {
+	version uint64
+	clients int
+}
+
+func RegisterMultiCommands() {
+	RegisterCommand("multi", multiCmd, 1, flagNoScript, catTransaction, 0, 0, 0,
+		commandDoc{"Starts a transaction.", "1.2.0", "transactions"})
+	RegisterCommand("exec", execCmd, 1, flagNoScript, catTransaction, 0, 0, 0,
+		commandDoc{"Executes all commands in a transaction.", "1.2.0", "transactions"})
+	RegisterCommand("discard", discardCmd, 1, flagNoScript, catTransaction, 0, 0, 0,
+		commandDoc{"Discards a transaction.", "2.0.0", "transactions"})
+	RegisterCommand("watch", watchCmd, -2, flagNoScript, catTransaction, 1, -1, 1,
+		commandDoc{"Monitors changes to keys to determine the execution of a transaction.", "2.2.0", "transactions"})
+	RegisterCommand("unwatch", unwatchCmd, 1, flagNoScript, catTransaction, 0, 0, 0,
+		commandDoc{"Forgets about watched keys of a transaction.", "2.2.0", "transactions"})
+}
+
+// isMultiControl reports whether the command is executed immediately instead of being queued inside MULTI
+func isMultiControl(cmdName string) bool {
+	switch cmdName {
+	case "multi", "exec", "discard", "watch", "quit":
+		return true
+	}
+	return false
+}
+
+// multiCmd
+// MULTI starts a transaction, following commands are queued until EXEC or DISCARD
+func multiCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 1 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'multi' command")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.multi {
+		return RESP.MakeErrorData("ERR MULTI calls can not be nested")
+	}
+	c.multi = true
+	c.multiDirty = false
+	c.multiQueue = nil
+	return RESP.MakeStringData("OK")
+}
+
+// execCmd
+// EXEC runs the queued commands atomically.
+// It replies a null array if a watched key has been modified since WATCH.
+func execCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 1 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'exec' command")
+	}
+	c.mu.Lock()
+	inMulti, dirty, queue := c.multi, c.multiDirty, c.multiQueue
+	c.multi, c.multiDirty, c.multiQueue = false, false, nil
+	watched := c.watched
+	c.mu.Unlock()
+	if !inMulti {
+		return RESP.MakeErrorData("ERR EXEC without MULTI")
+	}
+	defer m.unwatchAll(c)
+	if dirty {
+		return RESP.MakeErrorData("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	keys := make([]string, 0, len(watched))
+	for key := range watched {
+		keys = append(keys, key)
+	}
+	commands := make([]*command, len(queue))
+	lockAll := false
+	for i, args := range queue {
+		name := strings.ToLower(string(args[0]))
+		commands[i] = CmdTable[name]
+		keys = append(keys, commands[i].keys(args)...)
+		lockAll = lockAll || keyspaceCommands[name]
+	}
+	if lockAll {
+		m.txLocks.LockAll()
+		defer m.txLocks.UnLockAll()
+	} else {
+		m.txLocks.LockMulti(keys)
+		defer m.txLocks.UnLockMulti(keys)
+	}
+
+	for key, version := range watched {
+		// an expired key counts as modified
+		m.CheckTTL(key)
+		if m.watchedVersion(key) != version {
+			return RESP.MakeEmptyArrayData()
+		}
+	}
+	res := make([]RESP.RedisData, len(queue))
+	propagated := false
+	for i, args := range queue {
+		if !propagated && IsWriteCommand(args) {
+			m.propagate([][]byte{[]byte("multi")})
+			propagated = true
+		}
+		// the permissions may have been changed since the command was queued
+		if err := m.checkACL(c, strings.ToLower(string(args[0])), commands[i], args, "multi"); err != nil {
+			res[i] = err
+			continue
+		}
+		res[i] = m.call(c, commands[i], args, commands[i].keys(args))
+		if res[i] == nil {
+			res[i] = RESP.MakeErrorData("unknown error")
+		}
+	}
+	if propagated {
+		m.propagate([][]byte{[]byte("exec")})
+	}
+	return RESP.MakeArrayData(res)
+}
+
+// discardCmd
+// DISCARD drops the queued commands and unwatches all keys
+func discardCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 1 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'discard' command")
+	}
+	c.mu.Lock()
+	inMulti := c.multi
+	c.multi, c.multiDirty, c.multiQueue = false, false, nil
+	c.mu.Unlock()
+	if !inMulti {
+		return RESP.MakeErrorData("ERR DISCARD without MULTI")
+	}
+	m.unwatchAll(c)
+	return RESP.MakeStringData("OK")
+}
+
+// watchCmd
+// WATCH key [key ...] makes the next EXEC fail if any key is modified before it
+func watchCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'watch' command")
+	}
+	if c.InMulti() {
+		return RESP.MakeErrorData("ERR WATCH inside MULTI is not allowed")
+	}
+	for _, key := range cmd[1:] {
+		// drop an already expired key first, so its deletion doesn't count as a modification
+		m.CheckTTL(string(key))
+		m.watch(c, string(key))
+	}
+	return RESP.MakeStringData("OK")
+}
+
+// unwatchCmd
+// UNWATCH forgets all watched keys
+func unwatchCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 1 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'unwatch' command")
+	}
+	m.unwatchAll(c)
+	return RESP.MakeStringData("OK")
+}
+
+// InMulti reports whether the client is queuing commands inside MULTI
+func (c *Client) InMulti() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.multi
+}
+
+// markMultiDirty makes the following EXEC abort if the client is inside MULTI
+func (c *Client) markMultiDirty() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.multi {
+		c.multiDirty = true
+	}
+}
+
+// queueMulti queues a command inside MULTI.
+// An unknown command is a queue-time error, it makes the following EXEC abort.
+func (c *Client) queueMulti(command *command, cmd [][]byte) RESP.RedisData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if command == nil {
+		c.multiDirty = true
+		return RESP.MakeErrorData(fmt.Sprintf("ERR unknown command '%s'", string(cmd[0])))
+	}
+	if !command.checkArity(len(cmd)) {
+		c.multiDirty = true
+		return command.arityError()
+	}
+	// the arguments may point into the input buffer of the client, which is reused by the following commands
+	c.multiQueue = append(c.multiQueue, RESP.CloneCommand(cmd))
+	return RESP.MakeStringData("QUEUED")
+}
+
+func (m *MemDb) watch(c *Client, key string) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watched == nil {
+		c.watched = make(map[string]uint64)
+	}
+	if _, ok := c.watched[key]; ok {
+		return
+	}
+	wk, ok := m.watches[key]
+	if !ok {
+		wk = &watchedKey{}
+		m.watches[key] = wk
+	}
+	wk.clients++
+	c.watched[key] = atomic.LoadUint64(&wk.version)
+}
+
+func (m *MemDb) unwatchAll(c *Client) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.watched {
+		if wk, ok := m.watches[key]; ok {
+			wk.clients--
+			if wk.clients <= 0 {
+				delete(m.watches, key)
+			}
+		}
+	}
+	c.watched = nil
+}
+
+// touchWatched bumps the version of a watched key after it has been modified
+func (m *MemDb) touchWatched(key string) {
+	m.watchMu.RLock()
+	defer m.watchMu.RUnlock()
+	if wk, ok := m.watches[key]; ok {
+		atomic.AddUint64(&wk.version, 1)
+	}
+}
+
+func (m *MemDb) watchedVersion(key string) uint64 {
+	m.watchMu.RLock()
+	defer m.watchMu.RUnlock()
+	if wk, ok := m.watches[key]; ok {
+		return atomic.LoadUint64(&wk.version)
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+package memdb
+
+import (
+	"bytes"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strings"
+	"testing"
+	"time"
+)
+
+func execArgs(m *MemDb, c *Client, args ...string) []byte {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	// encoded like the handler does
+	return RESP.Encode(m.ExecCommand(c, cmd), c.Protocol())
+}
+
+func newMultiTestDb() *MemDb {
+	RegisterKeyCommand()
+	RegisterStringCommands()
+	RegisterMultiCommands()
+	return NewMemDb()
+}
+
+func TestMultiExec(t *testing.T) {
+	m := newMultiTestDb()
+	c := NewFakeClient()
+	var propagated []string
+	m.SetPropagator(func(cmd [][]byte) {
+		propagated = append(propagated, string(cmd[0]))
+	})
+
+	if res := execArgs(m, c, "multi"); !bytes.Equal(res, []byte("+OK\r\n")) {
+		t.Errorf("multi reply error: %q", res)
+	}
+	if res := execArgs(m, c, "multi"); !bytes.HasPrefix(res, []byte("-ERR MULTI calls can not be nested")) {
+		t.Errorf("nested multi should fail: %q", res)
+	}
+	if res := execArgs(m, c, "set", "a", "1"); !bytes.Equal(res, []byte("+QUEUED\r\n")) {
+		t.Errorf("set should be queued: %q", res)
+	}
+	execArgs(m, c, "incr", "a")
+	execArgs(m, c, "get", "a")
+	if _, ok := m.db.Get("a"); ok {
+		t.Error("queued command should not be executed before exec")
+	}
+	res := execArgs(m, c, "exec")
+	if !bytes.Equal(res, []byte("*3\r\n+OK\r\n:2\r\n$1\r\n2\r\n")) {
+		t.Errorf("exec reply error: %q", res)
+	}
+	expect := []string{"multi", "set", "incr", "exec"}
+	if len(propagated) != len(expect) {
+		t.Fatalf("propagated %v, expect %v", propagated, expect)
+	}
+	for i := range expect {
+		if propagated[i] != expect[i] {
+			t.Errorf("propagated %v, expect %v", propagated, expect)
+		}
+	}
+	if res := execArgs(m, c, "exec"); !bytes.HasPrefix(res, []byte("-ERR EXEC without MULTI")) {
+		t.Errorf("exec without multi should fail: %q", res)
+	}
+}
+
+func TestMultiExecAbort(t *testing.T) {
+	m := newMultiTestDb()
+	c := NewFakeClient()
+	execArgs(m, c, "multi")
+	execArgs(m, c, "set", "a", "1")
+	if res := execArgs(m, c, "nosuchcommand"); !bytes.HasPrefix(res, []byte("-ERR unknown command")) {
+		t.Errorf("unknown command should fail when queued: %q", res)
+	}
+	if res := execArgs(m, c, "exec"); !bytes.HasPrefix(res, []byte("-EXECABORT")) {
+		t.Errorf("exec should abort: %q", res)
+	}
+	if _, ok := m.db.Get("a"); ok {
+		t.Error("aborted transaction should not be executed")
+	}
+
+	// a command with a wrong number of arguments is rejected when queued
+	execArgs(m, c, "multi")
+	execArgs(m, c, "set", "a", "1")
+	if res := execArgs(m, c, "get", "a", "b"); !bytes.Equal(res, []byte("-ERR wrong number of arguments for 'get' command\r\n")) {
+		t.Errorf("wrong arity should fail when queued: %q", res)
+	}
+	if res := execArgs(m, c, "exec"); !bytes.HasPrefix(res, []byte("-EXECABORT")) {
+		t.Errorf("exec should abort: %q", res)
+	}
+	if _, ok := m.db.Get("a"); ok {
+		t.Error("aborted transaction should not be executed")
+	}
+	if res := execArgs(m, c, "incr"); !bytes.Equal(res, []byte("-ERR wrong number of arguments for 'incr' command\r\n")) {
+		t.Errorf("wrong arity should fail: %q", res)
+	}
+
+	execArgs(m, c, "multi")
+	execArgs(m, c, "set", "a", "1")
+	if res := execArgs(m, c, "discard"); !bytes.Equal(res, []byte("+OK\r\n")) || c.InMulti() {
+		t.Errorf("discard reply error: %q", res)
+	}
+	if _, ok := m.db.Get("a"); ok {
+		t.Error("discarded transaction should not be executed")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	m := newMultiTestDb()
+	c1 := NewFakeClient()
+	c2 := NewFakeClient()
+
+	// unmodified watched key
+	execArgs(m, c1, "set", "a", "1")
+	execArgs(m, c1, "watch", "a")
+	execArgs(m, c1, "multi")
+	execArgs(m, c1, "incr", "a")
+	if res := execArgs(m, c1, "exec"); !bytes.Equal(res, []byte("*1\r\n:2\r\n")) {
+		t.Errorf("exec reply error: %q", res)
+	}
+
+	// modified by another client
+	execArgs(m, c1, "watch", "a")
+	execArgs(m, c2, "set", "a", "10")
+	execArgs(m, c1, "multi")
+	execArgs(m, c1, "incr", "a")
+	if res := execArgs(m, c1, "exec"); !bytes.Equal(res, []byte("*-1\r\n")) {
+		t.Errorf("exec should fail after a watched key is modified: %q", res)
+	}
+	if res := execArgs(m, c1, "get", "a"); !bytes.Equal(res, []byte("$2\r\n10\r\n")) {
+		t.Errorf("failed transaction should not be executed: %q", res)
+	}
+	if len(m.watches) != 0 {
+		t.Error("exec should unwatch all keys")
+	}
+
+	// read by another client
+	execArgs(m, c1, "watch", "a")
+	execArgs(m, c2, "get", "a")
+	execArgs(m, c1, "multi")
+	if res := execArgs(m, c1, "exec"); !bytes.Equal(res, []byte("*0\r\n")) {
+		t.Errorf("reading a watched key should not fail exec: %q", res)
+	}
+
+	// expired
+	execArgs(m, c1, "set", "b", "1")
+	m.ttlKeys.Set("b", time.Now().Unix()+1)
+	execArgs(m, c1, "watch", "b")
+	m.ttlKeys.Set("b", time.Now().Unix()-1)
+	execArgs(m, c1, "multi")
+	if res := execArgs(m, c1, "exec"); !bytes.Equal(res, []byte("*-1\r\n")) {
+		t.Errorf("exec should fail after a watched key expired: %q", res)
+	}
+
+	// flushdb
+	execArgs(m, c1, "watch", "a")
+	execArgs(m, c2, "flushdb")
+	execArgs(m, c1, "multi")
+	if res := execArgs(m, c1, "exec"); !bytes.Equal(res, []byte("*-1\r\n")) {
+		t.Errorf("exec should fail after flushdb: %q", res)
+	}
+
+	// unwatch
+	execArgs(m, c1, "watch", "a")
+	execArgs(m, c2, "set", "a", "1")
+	execArgs(m, c1, "unwatch")
+	execArgs(m, c1, "multi")
+	if res := execArgs(m, c1, "exec"); !bytes.Equal(res, []byte("*0\r\n")) {
+		t.Errorf("exec should succeed after unwatch: %q", res)
+	}
+}
+
+func TestFlushDbDuringExec(t *testing.T) {
+	m := newMultiTestDb()
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		c := NewFakeClient()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				execArgs(m, c, "flushdb")
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	c := NewFakeClient()
+	expect := "*11\r\n+OK\r\n" + strings.Repeat("$1\r\n1\r\n", 10)
+	for i := 0; i < 1000; i++ {
+		execArgs(m, c, "multi")
+		execArgs(m, c, "set", "a", "1")
+		for j := 0; j < 10; j++ {
+			execArgs(m, c, "get", "a")
+		}
+		if res := execArgs(m, c, "exec"); string(res) != expect {
+			t.Fatalf("flushdb should not run in the middle of exec: %q", res)
+		}
+	}
+}
+
+func TestLockMultiPositions(t *testing.T) {
+	l := NewLocks(16)
+	keys := []string{"a", "b", "c"}
+	poses := l.sortedLockPoses(keys)
+	for _, key := range keys {
+		found := false
+		for _, pos := range poses {
+			if pos == l.GetKeyPos(key) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("lock position of %s is missing in %v", key, poses)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Classes of keyspace notifications, selected by the characters of notify-keyspace-events:
+// K keyspace events, published to __keyspace@<db>__:<key> with the event as message
+// E keyevent events, published to __keyevent@<db>__:<event> with the key as message
+// g generic commands like DEL, EXPIRE and RENAME
+// $ string commands, l list commands, s set commands, h hash commands, z sorted set commands
+// x expired events, fired when an expired key is deleted
+// e evicted events, fired when a key is evicted for maxmemory
+// A alias of g$lshzxe
+// At least one of K and E must be present, otherwise no event is delivered.
+const (
+	notifyKeyspace = 1 << iota
+	notifyKeyevent
+	notifyGeneric
+	notifyString
+	notifyList
+	notifySet
+	notifyHash
+	notifyZSet
+	notifyExpired
+	notifyEvicted
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted
+)
+
+var notifyFlagChars = []struct {
+	char byte
+	flag int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZSet},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+// ParseNotifyFlags parses the value of notify-keyspace-events
+func ParseNotifyFlags(value string) (int, error) {
+	flags := 0
+outer:
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		for _, fc := range notifyFlagChars {
+			if fc.char == value[i] {
+				flags |= fc.flag
+				continue outer
+			}
+		}
+		return 0, fmt.Errorf("invalid event class character '%c'", value[i])
+	}
+	return flags, nil
+}
+
+// notifyFlagsString returns the canonical value of notify-keyspace-events for flags
+func notifyFlagsString(flags int) string {
+	var sb strings.Builder
+	if flags&notifyAll == notifyAll {
+		sb.WriteByte('A')
+	}
+	for _, fc := range notifyFlagChars {
+		if flags&fc.flag == 0 || (fc.flag&notifyAll != 0 && flags&notifyAll == notifyAll) {
+			continue
+		}
+		sb.WriteByte(fc.char)
+	}
+	return sb.String()
+}
+
+// SetNotifyFlags sets the classes of keyspace events to publish
+func (m *MemDb) SetNotifyFlags(flags int) {
+	atomic.StoreInt32(&m.notifyFlags, int32(flags))
+}
+
+// notify publishes a keyspace event of key if class is enabled.
+// There is only one database, so db is always 0 in the channel names.
+func (m *MemDb) notify(class int, event, key string) {
+	flags := int(atomic.LoadInt32(&m.notifyFlags))
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		m.pubsub.Publish([]byte("__keyspace@0__:"+key), []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		m.pubsub.Publish([]byte("__keyevent@0__:"+event), []byte(key))
+	}
+}
@@ -0,0 +1,113 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"net"
+	"testing"
+	"time"
+)
+
+func newNotifyTestDb() *MemDb {
+	RegisterKeyCommand()
+	RegisterStringCommands()
+	RegisterListCommands()
+	RegisterHashCommands()
+	RegisterPubSubCommands()
+	RegisterInfoCommands()
+	return NewMemDb()
+}
+
+// expectMessages reads the pushed pub/sub messages and checks their channels and payloads
+func expectMessages(t *testing.T, r *bufio.Reader, messages ...[2]string) {
+	t.Helper()
+	for _, msg := range messages {
+		expect := "*3\r\n$7\r\nmessage\r\n" + bulkString(msg[0]) + bulkString(msg[1])
+		if got := readPushed(t, r, len(expect)); string(got) != expect {
+			t.Errorf("expect message %q, get %q", expect, got)
+		}
+	}
+}
+
+func bulkString(s string) string {
+	return string(RESP.MakeBulkData([]byte(s)).ToBytes())
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	m := newNotifyTestDb()
+	server, peer := net.Pipe()
+	sub := NewClient(server)
+	m.AddClient(sub)
+	c := NewFakeClient()
+	r := bufio.NewReader(peer)
+
+	if res := execArgs(m, c, "config", "set", "notify-keyspace-events", "KEy"); !bytes.HasPrefix(res, []byte("-ERR CONFIG SET failed")) {
+		t.Errorf("invalid notify-keyspace-events should be rejected: %q", res)
+	}
+	if res := execArgs(m, c, "config", "set", "notify-keyspace-events", "KEA"); !bytes.Equal(res, []byte("+OK\r\n")) {
+		t.Errorf("config set reply error: %q", res)
+	}
+	if res := execArgs(m, c, "config", "get", "notify-*"); !bytes.Equal(res, []byte("*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n")) {
+		t.Errorf("config get reply error: %q", res)
+	}
+
+	execArgs(m, sub, "subscribe", "__keyspace@0__:a", "__keyevent@0__:del", "__keyevent@0__:expired")
+	readPushed(t, r, len("*3\r\n$9\r\nsubscribe\r\n$16\r\n__keyspace@0__:a\r\n:1\r\n"))
+	readPushed(t, r, len("*3\r\n$9\r\nsubscribe\r\n$18\r\n__keyevent@0__:del\r\n:2\r\n"))
+
+	execArgs(m, c, "set", "a", "1")
+	execArgs(m, c, "incr", "a")
+	execArgs(m, c, "expire", "a", "100")
+	execArgs(m, c, "del", "a", "b")
+	expectMessages(t, r,
+		[2]string{"__keyspace@0__:a", "set"},
+		[2]string{"__keyspace@0__:a", "incrby"},
+		[2]string{"__keyspace@0__:a", "expire"},
+		[2]string{"__keyspace@0__:a", "del"},
+		[2]string{"__keyevent@0__:del", "a"},
+	)
+
+	// a list deleted after its last element is popped
+	execArgs(m, c, "lpush", "a", "x")
+	execArgs(m, c, "rpop", "a")
+	expectMessages(t, r,
+		[2]string{"__keyspace@0__:a", "lpush"},
+		[2]string{"__keyspace@0__:a", "rpop"},
+		[2]string{"__keyspace@0__:a", "del"},
+		[2]string{"__keyevent@0__:del", "a"},
+	)
+
+	// expired by lazy deletion
+	execArgs(m, c, "hset", "a", "f", "v")
+	m.ttlKeys.Set("a", time.Now().Unix()-1)
+	execArgs(m, c, "hget", "a", "f")
+	expectMessages(t, r,
+		[2]string{"__keyspace@0__:a", "hset"},
+		[2]string{"__keyspace@0__:a", "expired"},
+		[2]string{"__keyevent@0__:expired", "a"},
+	)
+
+	// expired by the background cycle
+	execArgs(m, c, "set", "b", "1")
+	m.ttlKeys.Set("b", time.Now().Unix()-1)
+	m.activeExpireCycle()
+	expectMessages(t, r, [2]string{"__keyevent@0__:expired", "b"})
+	if _, ok := m.db.Get("b"); ok {
+		t.Error("expired key should be deleted by the background cycle")
+	}
+
+	// only list events as keyspace events
+	execArgs(m, c, "config", "set", "notify-keyspace-events", "Kl")
+	execArgs(m, c, "set", "a", "1")
+	execArgs(m, c, "del", "a")
+	execArgs(m, c, "rpush", "a", "x")
+	expectMessages(t, r, [2]string{"__keyspace@0__:a", "rpush"})
+
+	execArgs(m, c, "config", "set", "notify-keyspace-events", "")
+	execArgs(m, c, "lpush", "a", "y")
+	if res := execArgs(m, c, "publish", "__keyspace@0__:a", "end"); !bytes.Equal(res, []byte(":1\r\n")) {
+		t.Errorf("publish reply error: %q", res)
+	}
+	expectMessages(t, r, [2]string{"__keyspace@0__:a", "end"})
+}
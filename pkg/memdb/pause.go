@@ -0,0 +1,153 @@
+package memdb
+
+import (
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientPause is the state of CLIENT PAUSE.
+// The commands held by a pause wait in the handler before they are executed, until the pause ends or is changed.
+// The replication links of the peers are never paused, and the keys are not expired in background meanwhile.
+type ClientPause struct {
+	mu      sync.Mutex
+	end     time.Time
+	all     bool          // ALL holds every command, WRITE only holds the commands modifying the database
+	timer   *time.Timer   // ends the pause at end
+	changed chan struct{} // closed when the pause ends or is changed
+}
+
+func NewClientPause() *ClientPause {
+	return &ClientPause{changed: make(chan struct{})}
+}
+
+// pause holds the commands until end, a longer or more restrictive pause in progress is kept
+func (p *ClientPause) pause(end time.Time, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.activeLocked() {
+		if p.end.After(end) {
+			end = p.end
+		}
+		all = all || p.all
+	}
+	p.end, p.all = end, all
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(time.Until(end), p.expire)
+	p.notifyLocked()
+}
+
+// unpause ends the pause at once
+func (p *ClientPause) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.end = time.Time{}
+	p.notifyLocked()
+}
+
+// expire is called by the timer at the end of the pause, the timer of a replaced pause finds it active
+func (p *ClientPause) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.activeLocked() {
+		p.notifyLocked()
+	}
+}
+
+// notifyLocked wakes up the held commands to check the pause again, callers must hold p.mu.
+func (p *ClientPause) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *ClientPause) activeLocked() bool {
+	return time.Now().Before(p.end)
+}
+
+// Active reports whether a pause is in progress
+func (p *ClientPause) Active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.activeLocked()
+}
+
+// PauseWait returns a channel closed when the pause holding cmd of c ends or is changed, nil if cmd is not held.
+// The command should be checked again once the channel is closed.
+func (m *MemDb) PauseWait(c *Client, cmd [][]byte) <-chan struct{} {
+	p := m.pause
+	p.mu.Lock()
+	if !p.activeLocked() {
+		p.mu.Unlock()
+		return nil
+	}
+	all, changed := p.all, p.changed
+	p.mu.Unlock()
+	c.mu.Lock()
+	replica := c.replica
+	c.mu.Unlock()
+	if replica || (!all && !isPausedWrite(c, cmd)) {
+		return nil
+	}
+	return changed
+}
+
+// isPausedWrite reports whether cmd is held by CLIENT PAUSE WRITE, such as a write command or EXEC of a transaction
+// queuing one. A write command is held even inside MULTI, like Redis.
+func isPausedWrite(c *Client, cmd [][]byte) bool {
+	if IsWriteCommand(cmd) {
+		return true
+	}
+	if !strings.EqualFold(string(cmd[0]), "exec") {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, queued := range c.multiQueue {
+		if IsWriteCommand(queued) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientPause
+// CLIENT PAUSE timeout [WRITE|ALL]
+func clientPause(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 3 && len(cmd) != 4 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|pause' command")
+	}
+	timeout, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil || timeout < 0 {
+		return RESP.MakeErrorData("ERR timeout is not an integer or out of range")
+	}
+	all := true
+	if len(cmd) == 4 {
+		switch strings.ToLower(string(cmd[3])) {
+		case "all":
+		case "write":
+			all = false
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	m.pause.pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+	return RESP.MakeStringData("OK")
+}
+
+// clientUnpause
+// CLIENT UNPAUSE
+func clientUnpause(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|unpause' command")
+	}
+	m.pause.unpause()
+	return RESP.MakeStringData("OK")
+}
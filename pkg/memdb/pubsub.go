@@ -0,0 +1,451 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/util"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PubSub holds the subscribers of channels, channel patterns and shard channels.
+// Messages are queued to the output of every subscriber, so PUBLISH never waits for a slow subscriber.
+// Shard channels are a separate namespace, a shard channel is bound to the hash slot of its name like a key.
+type PubSub struct {
+	mu            sync.RWMutex
+	channels      map[string]map[*Client]struct{}
+	patterns      map[string]map[*Client]struct{}
+	shardChannels map[string]map[*Client]struct{}
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels:      make(map[string]map[*Client]struct{}),
+		patterns:      make(map[string]map[*Client]struct{}),
+		shardChannels: make(map[string]map[*Client]struct{}),
+	}
+}
+
+func RegisterPubSubCommands() {
+	RegisterCommand("subscribe", subscribeCmd, -2, flagPubSub|flagNoScript, 0, 0, 0, 0,
+		commandDoc{"Listens for messages published to channels.", "2.0.0", "pubsub"})
+	RegisterCommand("unsubscribe", unsubscribeCmd, -1, flagPubSub|flagNoScript, 0, 0, 0, 0,
+		commandDoc{"Stops listening to messages posted to channels.", "2.0.0", "pubsub"})
+	RegisterCommand("psubscribe", psubscribeCmd, -2, flagPubSub|flagNoScript, 0, 0, 0, 0,
+		commandDoc{"Listens for messages published to channels that match one or more patterns.", "2.0.0", "pubsub"})
+	RegisterCommand("punsubscribe", punsubscribeCmd, -1, flagPubSub|flagNoScript, 0, 0, 0, 0,
+		commandDoc{"Stops listening to messages published to channels that match one or more patterns.", "2.0.0", "pubsub"})
+	RegisterCommand("publish", publishCmd, 3, flagPubSub, 0, 0, 0, 0,
+		commandDoc{"Posts a message to a channel.", "2.0.0", "pubsub"})
+	RegisterCommand("pubsub", pubsubCmd, -2, flagPubSub, 0, 0, 0, 0,
+		commandDoc{"A container for Pub/Sub commands.", "2.8.0", "pubsub"})
+	// shard channels are at the key positions, so they can be routed by slot
+	RegisterCommand("ssubscribe", ssubscribeCmd, -2, flagPubSub|flagNoScript, 0, 1, -1, 1,
+		commandDoc{"Listens for messages published to shard channels.", "7.0.0", "pubsub"})
+	RegisterCommand("sunsubscribe", sunsubscribeCmd, -1, flagPubSub|flagNoScript, 0, 1, -1, 1,
+		commandDoc{"Stops listening to messages posted to shard channels.", "7.0.0", "pubsub"})
+	RegisterCommand("spublish", spublishCmd, 3, flagPubSub, 0, 1, 1, 1,
+		commandDoc{"Post a message to a shard channel", "7.0.0", "pubsub"})
+}
+
+// isPubSubAllowed reports whether the command can be executed by a client in subscribed mode
+func isPubSubAllowed(cmdName string) bool {
+	switch cmdName {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe", "ping", "quit":
+		return true
+	}
+	return false
+}
+
+// subscriptions returns the number of channels and patterns subscribed by the client, callers must hold c.mu.
+func (c *Client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// subscriptionCount returns the number reported by the confirmation of a (un)subscribing command:
+// shard channels are counted apart from channels and patterns.
+func (c *Client) subscriptionCount(shard bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if shard {
+		return len(c.shardChannels)
+	}
+	return c.subscriptions()
+}
+
+// Subscribed reports whether the client is in subscribed mode
+func (c *Client) Subscribed() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subscriptions()+len(c.shardChannels) > 0
+}
+
+// InPubSubContext reports whether the client is restricted to the subscribing commands.
+// A RESP3 client can execute any command in subscribed mode, because messages are pushed out of band.
+func (c *Client) InPubSubContext() bool {
+	return c.Subscribed() && c.Protocol() == RESP.RESP2
+}
+
+// subscribeReply makes the confirmation of (P)SUBSCRIBE and (P)UNSUBSCRIBE, it's a push for RESP3 clients
+func subscribeReply(kind string, channel []byte, count int) RESP.RedisData {
+	return RESP.MakePushData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte(kind)),
+		RESP.MakeBulkData(channel),
+		RESP.MakeIntData(int64(count)),
+	})
+}
+
+// replyEach sends every reply except the last one to the client directly, and returns the last one
+// as the reply of the command, because a command like SUBSCRIBE confirms each of its arguments.
+func replyEach(c *Client, replies []RESP.RedisData) RESP.RedisData {
+	for _, reply := range replies[:len(replies)-1] {
+		c.WriteData(reply)
+	}
+	return replies[len(replies)-1]
+}
+
+// pushMessage is a message pushed to many clients, it's encoded once for each protocol version.
+type pushMessage struct {
+	data    *RESP.PushData
+	encoded map[int][]byte
+}
+
+func newPushMessage(data ...RESP.RedisData) *pushMessage {
+	return &pushMessage{data: RESP.MakePushData(data), encoded: make(map[int][]byte, 2)}
+}
+
+func (msg *pushMessage) send(c *Client) {
+	proto := c.Protocol()
+	encoded, ok := msg.encoded[proto]
+	if !ok {
+		encoded = RESP.Encode(msg.data, proto)
+		msg.encoded[proto] = encoded
+	}
+	c.Write(encoded)
+}
+
+// subscribe adds c to the subscribers of name in table, and records it in the client subscriptions set.
+func (ps *PubSub) subscribe(table map[string]map[*Client]struct{}, subs *map[string]struct{}, c *Client, name string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if *subs == nil {
+		*subs = make(map[string]struct{})
+	}
+	if _, ok := (*subs)[name]; !ok {
+		(*subs)[name] = struct{}{}
+		clients, ok := table[name]
+		if !ok {
+			clients = make(map[*Client]struct{})
+			table[name] = clients
+		}
+		clients[c] = struct{}{}
+	}
+}
+
+// unsubscribe removes c from the subscribers of name in table.
+func (ps *PubSub) unsubscribe(table map[string]map[*Client]struct{}, subs *map[string]struct{}, c *Client, name string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := (*subs)[name]; ok {
+		delete(*subs, name)
+		if clients, ok := table[name]; ok {
+			delete(clients, c)
+			if len(clients) == 0 {
+				delete(table, name)
+			}
+		}
+	}
+}
+
+// subscribed returns the sorted names in the client subscriptions set
+func (c *Client) subscribed(subs *map[string]struct{}) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(*subs))
+	for name := range *subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([][]byte, len(names))
+	for i, name := range names {
+		res[i] = []byte(name)
+	}
+	return res
+}
+
+// unsubscribeAll removes all subscriptions of a disconnected client
+func (ps *PubSub) unsubscribeAll(c *Client) {
+	for _, channel := range c.subscribed(&c.channels) {
+		ps.unsubscribe(ps.channels, &c.channels, c, string(channel))
+	}
+	for _, pattern := range c.subscribed(&c.patterns) {
+		ps.unsubscribe(ps.patterns, &c.patterns, c, string(pattern))
+	}
+	for _, channel := range c.subscribed(&c.shardChannels) {
+		ps.unsubscribe(ps.shardChannels, &c.shardChannels, c, string(channel))
+	}
+}
+
+// Publish sends message to the subscribers of channel and the subscribers of the patterns matching channel.
+// It returns the number of clients receiving the message.
+func (ps *PubSub) Publish(channel, message []byte) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	count := 0
+	if clients, ok := ps.channels[string(channel)]; ok {
+		msg := newPushMessage(
+			RESP.MakeBulkData([]byte("message")),
+			RESP.MakeBulkData(channel),
+			RESP.MakeBulkData(message),
+		)
+		for c := range clients {
+			msg.send(c)
+			count++
+		}
+	}
+	for pattern, clients := range ps.patterns {
+		if !util.PatternMatch(pattern, string(channel)) {
+			continue
+		}
+		msg := newPushMessage(
+			RESP.MakeBulkData([]byte("pmessage")),
+			RESP.MakeBulkData([]byte(pattern)),
+			RESP.MakeBulkData(channel),
+			RESP.MakeBulkData(message),
+		)
+		for c := range clients {
+			msg.send(c)
+			count++
+		}
+	}
+	return count
+}
+
+// SPublish sends message to the subscribers of the shard channel.
+// It returns the number of clients receiving the message.
+func (ps *PubSub) SPublish(channel, message []byte) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	clients, ok := ps.shardChannels[string(channel)]
+	if !ok {
+		return 0
+	}
+	msg := newPushMessage(
+		RESP.MakeBulkData([]byte("smessage")),
+		RESP.MakeBulkData(channel),
+		RESP.MakeBulkData(message),
+	)
+	for c := range clients {
+		msg.send(c)
+	}
+	return len(clients)
+}
+
+// sameSlot reports whether all channels hash to the same slot
+func sameSlot(channels [][]byte) bool {
+	for _, channel := range channels[1:] {
+		if util.KeySlot(string(channel)) != util.KeySlot(string(channels[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribeCmd
+// SUBSCRIBE channel [channel ...]
+func subscribeCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'subscribe' command")
+	}
+	replies := make([]RESP.RedisData, 0, len(cmd)-1)
+	for _, channel := range cmd[1:] {
+		m.pubsub.subscribe(m.pubsub.channels, &c.channels, c, string(channel))
+		replies = append(replies, subscribeReply("subscribe", channel, c.subscriptionCount(false)))
+	}
+	return replyEach(c, replies)
+}
+
+// unsubscribeCmd
+// UNSUBSCRIBE [channel [channel ...]]
+// Unsubscribe all channels if no channel is given.
+func unsubscribeCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	channels := cmd[1:]
+	if len(channels) == 0 {
+		channels = c.subscribed(&c.channels)
+	}
+	if len(channels) == 0 {
+		return subscribeReply("unsubscribe", nil, c.subscriptionCount(false))
+	}
+	replies := make([]RESP.RedisData, 0, len(channels))
+	for _, channel := range channels {
+		m.pubsub.unsubscribe(m.pubsub.channels, &c.channels, c, string(channel))
+		replies = append(replies, subscribeReply("unsubscribe", channel, c.subscriptionCount(false)))
+	}
+	return replyEach(c, replies)
+}
+
+// psubscribeCmd
+// PSUBSCRIBE pattern [pattern ...]
+func psubscribeCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'psubscribe' command")
+	}
+	replies := make([]RESP.RedisData, 0, len(cmd)-1)
+	for _, pattern := range cmd[1:] {
+		m.pubsub.subscribe(m.pubsub.patterns, &c.patterns, c, string(pattern))
+		replies = append(replies, subscribeReply("psubscribe", pattern, c.subscriptionCount(false)))
+	}
+	return replyEach(c, replies)
+}
+
+// punsubscribeCmd
+// PUNSUBSCRIBE [pattern [pattern ...]]
+// Unsubscribe all patterns if no pattern is given.
+func punsubscribeCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	patterns := cmd[1:]
+	if len(patterns) == 0 {
+		patterns = c.subscribed(&c.patterns)
+	}
+	if len(patterns) == 0 {
+		return subscribeReply("punsubscribe", nil, c.subscriptionCount(false))
+	}
+	replies := make([]RESP.RedisData, 0, len(patterns))
+	for _, pattern := range patterns {
+		m.pubsub.unsubscribe(m.pubsub.patterns, &c.patterns, c, string(pattern))
+		replies = append(replies, subscribeReply("punsubscribe", pattern, c.subscriptionCount(false)))
+	}
+	return replyEach(c, replies)
+}
+
+// publishCmd
+// PUBLISH channel message
+// Return the number of clients receiving the message.
+func publishCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'publish' command")
+	}
+	return RESP.MakeIntData(int64(m.pubsub.Publish(cmd[1], cmd[2])))
+}
+
+// ssubscribeCmd
+// SSUBSCRIBE shardchannel [shardchannel ...]
+// All shard channels must be in the same hash slot.
+func ssubscribeCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'ssubscribe' command")
+	}
+	if !sameSlot(cmd[1:]) {
+		return RESP.MakeErrorData("CROSSSLOT Keys in request don't hash to the same slot")
+	}
+	replies := make([]RESP.RedisData, 0, len(cmd)-1)
+	for _, channel := range cmd[1:] {
+		m.pubsub.subscribe(m.pubsub.shardChannels, &c.shardChannels, c, string(channel))
+		replies = append(replies, subscribeReply("ssubscribe", channel, c.subscriptionCount(true)))
+	}
+	return replyEach(c, replies)
+}
+
+// sunsubscribeCmd
+// SUNSUBSCRIBE [shardchannel [shardchannel ...]]
+// Unsubscribe all shard channels if no shard channel is given.
+func sunsubscribeCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	channels := cmd[1:]
+	if len(channels) == 0 {
+		channels = c.subscribed(&c.shardChannels)
+	} else if !sameSlot(channels) {
+		return RESP.MakeErrorData("CROSSSLOT Keys in request don't hash to the same slot")
+	}
+	if len(channels) == 0 {
+		return subscribeReply("sunsubscribe", nil, c.subscriptionCount(true))
+	}
+	replies := make([]RESP.RedisData, 0, len(channels))
+	for _, channel := range channels {
+		m.pubsub.unsubscribe(m.pubsub.shardChannels, &c.shardChannels, c, string(channel))
+		replies = append(replies, subscribeReply("sunsubscribe", channel, c.subscriptionCount(true)))
+	}
+	return replyEach(c, replies)
+}
+
+// spublishCmd
+// SPUBLISH shardchannel message
+// Return the number of clients receiving the message.
+func spublishCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'spublish' command")
+	}
+	return RESP.MakeIntData(int64(m.pubsub.SPublish(cmd[1], cmd[2])))
+}
+
+// pubsubCmd
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel [channel ...]] | NUMPAT |
+// SHARDCHANNELS [pattern] | SHARDNUMSUB [shardchannel [shardchannel ...]]
+func pubsubCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'pubsub' command")
+	}
+	ps := m.pubsub
+	switch sub := strings.ToLower(string(cmd[1])); sub {
+	case "channels", "shardchannels":
+		if len(cmd) > 3 {
+			return RESP.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'pubsub|%s' command", sub))
+		}
+		pattern := "*"
+		if len(cmd) == 3 {
+			pattern = string(cmd[2])
+		}
+		if sub == "shardchannels" {
+			return activeChannels(ps, ps.shardChannels, pattern)
+		}
+		return activeChannels(ps, ps.channels, pattern)
+	case "numsub":
+		return numSub(ps, ps.channels, cmd[2:])
+	case "shardnumsub":
+		return numSub(ps, ps.shardChannels, cmd[2:])
+	case "numpat":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'pubsub|numpat' command")
+		}
+		ps.mu.RLock()
+		defer ps.mu.RUnlock()
+		return RESP.MakeIntData(int64(len(ps.patterns)))
+	default:
+		return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try PUBSUB HELP.", sub))
+	}
+}
+
+// activeChannels returns the sorted channels with at least one subscriber matching pattern
+func activeChannels(ps *PubSub, table map[string]map[*Client]struct{}, pattern string) RESP.RedisData {
+	ps.mu.RLock()
+	names := make([]string, 0)
+	for name := range table {
+		if util.PatternMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	ps.mu.RUnlock()
+	sort.Strings(names)
+	res := make([]RESP.RedisData, len(names))
+	for i, name := range names {
+		res[i] = RESP.MakeBulkData([]byte(name))
+	}
+	return RESP.MakeArrayData(res)
+}
+
+// numSub returns the channels and their numbers of subscribers
+func numSub(ps *PubSub, table map[string]map[*Client]struct{}, channels [][]byte) RESP.RedisData {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	res := make([]RESP.RedisData, 0, len(channels)*2)
+	for _, channel := range channels {
+		res = append(res, RESP.MakeBulkData(channel), RESP.MakeIntData(int64(len(table[string(channel)]))))
+	}
+	return RESP.MakeArrayData(res)
+}
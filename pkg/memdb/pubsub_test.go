@@ -0,0 +1,217 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newPubSubTestDb() *MemDb {
+	RegisterKeyCommand()
+	RegisterStringCommands()
+	RegisterPubSubCommands()
+	return NewMemDb()
+}
+
+// readPushed reads n bytes written to the client through its output queue
+func readPushed(t *testing.T, r *bufio.Reader, n int) []byte {
+	t.Helper()
+	buf := make([]byte, n)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(r, buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout reading pushed message")
+	}
+	return buf
+}
+
+func TestSubscribeAndPublish(t *testing.T) {
+	m := newPubSubTestDb()
+	server, peer := net.Pipe()
+	sub := NewClient(server)
+	m.AddClient(sub)
+	pub := NewFakeClient()
+	r := bufio.NewReader(peer)
+
+	res := execArgs(m, sub, "subscribe", "news", "sport")
+	expect := "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("first subscribe confirmation error: %q", got)
+	}
+	if !bytes.Equal(res, []byte("*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n")) {
+		t.Errorf("subscribe reply error: %q", res)
+	}
+	res = execArgs(m, sub, "psubscribe", "n*")
+	if !bytes.Equal(res, []byte("*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:3\r\n")) {
+		t.Errorf("psubscribe reply error: %q", res)
+	}
+
+	if res := execArgs(m, sub, "get", "a"); !bytes.HasPrefix(res, []byte("-ERR Can't execute 'get'")) {
+		t.Errorf("get should be rejected in subscribed mode: %q", res)
+	}
+	if res := execArgs(m, sub, "ping"); !bytes.Equal(res, []byte("*2\r\n$4\r\npong\r\n$0\r\n\r\n")) {
+		t.Errorf("ping reply error in subscribed mode: %q", res)
+	}
+
+	if res := execArgs(m, pub, "publish", "news", "hello"); !bytes.Equal(res, []byte(":2\r\n")) {
+		t.Errorf("publish should reach the channel and the pattern subscriber: %q", res)
+	}
+	msg := "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	pmsg := "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	if got := readPushed(t, r, len(msg)+len(pmsg)); string(got) != msg+pmsg {
+		t.Errorf("published messages error: %q", got)
+	}
+	if res := execArgs(m, pub, "publish", "nothing", "hello"); !bytes.Equal(res, []byte(":1\r\n")) {
+		t.Errorf("publish should only reach the pattern subscriber: %q", res)
+	}
+	readPushed(t, r, len("*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$7\r\nnothing\r\n$5\r\nhello\r\n"))
+
+	if res := execArgs(m, pub, "pubsub", "channels"); !bytes.Equal(res, []byte("*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n")) {
+		t.Errorf("pubsub channels error: %q", res)
+	}
+	if res := execArgs(m, pub, "pubsub", "channels", "s*"); !bytes.Equal(res, []byte("*1\r\n$5\r\nsport\r\n")) {
+		t.Errorf("pubsub channels pattern error: %q", res)
+	}
+	if res := execArgs(m, pub, "pubsub", "numsub", "news", "none"); !bytes.Equal(res, []byte("*4\r\n$4\r\nnews\r\n:1\r\n$4\r\nnone\r\n:0\r\n")) {
+		t.Errorf("pubsub numsub error: %q", res)
+	}
+	if res := execArgs(m, pub, "pubsub", "numpat"); !bytes.Equal(res, []byte(":1\r\n")) {
+		t.Errorf("pubsub numpat error: %q", res)
+	}
+
+	res = execArgs(m, sub, "unsubscribe")
+	expect = "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:2\r\n"
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("first unsubscribe confirmation error: %q", got)
+	}
+	if !bytes.Equal(res, []byte("*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:1\r\n")) {
+		t.Errorf("unsubscribe reply error: %q", res)
+	}
+	res = execArgs(m, sub, "punsubscribe", "n*")
+	if !bytes.Equal(res, []byte("*3\r\n$12\r\npunsubscribe\r\n$2\r\nn*\r\n:0\r\n")) {
+		t.Errorf("punsubscribe reply error: %q", res)
+	}
+	if res := execArgs(m, sub, "unsubscribe"); !bytes.Equal(res, []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")) {
+		t.Errorf("unsubscribe without subscription error: %q", res)
+	}
+	if res := execArgs(m, sub, "get", "a"); bytes.HasPrefix(res, []byte("-")) {
+		t.Errorf("client should leave subscribed mode: %q", res)
+	}
+	if res := execArgs(m, pub, "publish", "news", "hello"); !bytes.Equal(res, []byte(":0\r\n")) {
+		t.Errorf("publish without subscriber error: %q", res)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	m := newPubSubTestDb()
+	server, peer := net.Pipe()
+	slow := NewClient(server)
+	m.AddClient(slow)
+	pub := NewFakeClient()
+	execArgs(m, slow, "subscribe", "ch")
+
+	// nobody reads from the slow subscriber, publish must not block
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			execArgs(m, pub, "publish", "ch", "message")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish is blocked by a slow subscriber")
+	}
+
+	_ = peer.Close()
+	m.CloseClient(slow)
+	if res := execArgs(m, pub, "pubsub", "numsub", "ch"); !bytes.Equal(res, []byte("*2\r\n$2\r\nch\r\n:0\r\n")) {
+		t.Errorf("closed client should be unsubscribed: %q", res)
+	}
+}
+
+func TestShardedPubSub(t *testing.T) {
+	m := newPubSubTestDb()
+	server, peer := net.Pipe()
+	sub := NewClient(server)
+	m.AddClient(sub)
+	pub := NewFakeClient()
+	r := bufio.NewReader(peer)
+
+	if res := execArgs(m, sub, "ssubscribe", "{user1}.a", "user2"); !bytes.HasPrefix(res, []byte("-CROSSSLOT")) {
+		t.Errorf("ssubscribe should reject channels in different slots: %q", res)
+	}
+	res := execArgs(m, sub, "ssubscribe", "{user1}.a", "{user1}.b")
+	expect := "*3\r\n$10\r\nssubscribe\r\n$9\r\n{user1}.a\r\n:1\r\n"
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("first ssubscribe confirmation error: %q", got)
+	}
+	if !bytes.Equal(res, []byte("*3\r\n$10\r\nssubscribe\r\n$9\r\n{user1}.b\r\n:2\r\n")) {
+		t.Errorf("ssubscribe reply error: %q", res)
+	}
+	// shard channels don't count in the subscriptions of channels and patterns
+	if res := execArgs(m, sub, "subscribe", "{user1}.a"); !bytes.Equal(res, []byte("*3\r\n$9\r\nsubscribe\r\n$9\r\n{user1}.a\r\n:1\r\n")) {
+		t.Errorf("subscribe reply error: %q", res)
+	}
+
+	// shard channels are a separate namespace
+	if res := execArgs(m, pub, "spublish", "{user1}.a", "hi"); !bytes.Equal(res, []byte(":1\r\n")) {
+		t.Errorf("spublish reply error: %q", res)
+	}
+	msg := "*3\r\n$8\r\nsmessage\r\n$9\r\n{user1}.a\r\n$2\r\nhi\r\n"
+	if got := readPushed(t, r, len(msg)); string(got) != msg {
+		t.Errorf("sharded message error: %q", got)
+	}
+	if res := execArgs(m, pub, "publish", "{user1}.b", "hi"); !bytes.Equal(res, []byte(":0\r\n")) {
+		t.Errorf("publish should not reach shard channel subscribers: %q", res)
+	}
+
+	if res := execArgs(m, pub, "pubsub", "shardchannels"); !bytes.Equal(res, []byte("*2\r\n$9\r\n{user1}.a\r\n$9\r\n{user1}.b\r\n")) {
+		t.Errorf("pubsub shardchannels error: %q", res)
+	}
+	if res := execArgs(m, pub, "pubsub", "shardnumsub", "{user1}.b", "user2"); !bytes.Equal(res, []byte("*4\r\n$9\r\n{user1}.b\r\n:1\r\n$5\r\nuser2\r\n:0\r\n")) {
+		t.Errorf("pubsub shardnumsub error: %q", res)
+	}
+
+	execArgs(m, sub, "unsubscribe")
+	if res := execArgs(m, sub, "get", "a"); !bytes.HasPrefix(res, []byte("-ERR Can't execute")) {
+		t.Errorf("client should stay in subscribed mode with shard channels: %q", res)
+	}
+	res = execArgs(m, sub, "sunsubscribe")
+	expect = "*3\r\n$12\r\nsunsubscribe\r\n$9\r\n{user1}.a\r\n:1\r\n"
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("first sunsubscribe confirmation error: %q", got)
+	}
+	if !bytes.Equal(res, []byte("*3\r\n$12\r\nsunsubscribe\r\n$9\r\n{user1}.b\r\n:0\r\n")) {
+		t.Errorf("sunsubscribe reply error: %q", res)
+	}
+	if sub.Subscribed() {
+		t.Error("client should leave subscribed mode")
+	}
+}
+
+func TestPublishToMalformedPatterns(t *testing.T) {
+	m := newPubSubTestDb()
+	server, peer := net.Pipe()
+	sub := NewClient(server)
+	m.AddClient(sub)
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+	// unclosed classes and trailing escapes match nothing, a star may be followed by any special character
+	patterns := []string{"n*[", "n*\\", "*[", "*\\", "n*x", "*?s", "*[w]s", "*\\s"}
+	execArgs(m, sub, append([]string{"psubscribe"}, patterns...)...)
+	if res := execArgs(m, NewFakeClient(), "publish", "news", "hello"); !bytes.Equal(res, []byte(":3\r\n")) {
+		t.Errorf("publish should reach the 3 valid matching patterns: %q", res)
+	}
+}
@@ -0,0 +1,101 @@
+package memdb
+
+import (
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strings"
+)
+
+// Replication is the active-active replication of the server, it's set by the server when peers are configured.
+// Write executes a write command of a client through the replicated state instead of its executor,
+// it's called by call once the command has passed the authentication, ACL and MULTI checks.
+// Check returns the error of a write command the replication doesn't support, it's rejected before being queued.
+// Apply and Sync serve crdt.apply and crdt.sync sent by the peers, Info gives the replication section of INFO.
+type Replication interface {
+	Write(cmd [][]byte) RESP.RedisData
+	Check(cmd [][]byte) RESP.RedisData
+	Apply(cmd [][]byte) RESP.RedisData
+	Sync(cmd [][]byte) RESP.RedisData
+	Info() string
+}
+
+// SetReplication sets the active-active replication
+func (m *MemDb) SetReplication(r Replication) {
+	m.replication = r
+}
+
+// replicated reports whether cmd of c is executed by the replication, the commands of the fake clients,
+// such as rebuilding a replicated key or loading the AOF file, are executed by memDb itself
+func (m *MemDb) replicated(c *Client, command *command) bool {
+	return m.replication != nil && c.conn != nil && command.flags&flagWrite != 0
+}
+
+// Rebuild executes the commands rebuilding a key from the replicated state with a fake client,
+// they signal the modification of the key like the writes of the clients but aren't propagated:
+// the replication propagates its ops with Propagate instead, so that loading the AOF file rebuilds the replicated state.
+// Callers must hold txLocks of the key, such as Write and Apply of the replication called by call.
+func (m *MemDb) Rebuild(cmds [][][]byte) RESP.RedisData {
+	for _, cmd := range cmds {
+		command, ok := CmdTable[strings.ToLower(string(cmd[0]))]
+		if !ok {
+			return RESP.MakeErrorData("error: unsupported command")
+		}
+		if res, ok := m.call(m.rebuilder, command, cmd, command.keys(cmd)).(*RESP.ErrorData); ok {
+			return res
+		}
+	}
+	return nil
+}
+
+// Propagate sends cmd to the propagator like an executed write command, such as an op of the replication
+func (m *MemDb) Propagate(cmd [][]byte) {
+	m.propagate(cmd)
+}
+
+// KeyCommands returns the write commands recreating the strings, sets and hashes of the database, one per key.
+// The replication adopts with them the keys loaded from an AOF file written without it,
+// the lists and sorted sets aren't replicated.
+func (m *MemDb) KeyCommands() [][][]byte {
+	var res [][][]byte
+	m.db.Range(func(key string, value any) bool {
+		switch v := value.(type) {
+		case []byte:
+			res = append(res, [][]byte{[]byte("set"), []byte(key), v})
+		case *Set:
+			cmd := [][]byte{[]byte("sadd"), []byte(key)}
+			for _, member := range v.Members() {
+				cmd = append(cmd, []byte(member))
+			}
+			res = append(res, cmd)
+		case *Hash:
+			cmd := [][]byte{[]byte("hset"), []byte(key)}
+			for field, value := range v.Table() {
+				cmd = append(cmd, []byte(field), value)
+			}
+			res = append(res, cmd)
+		}
+		return true
+	})
+	return res
+}
+
+// crdtApply
+// CRDT.APPLY origin seq wall logical node type key field value delta tags
+func crdtApply(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if m.replication == nil {
+		return RESP.MakeErrorData("ERR active-active replication is not enabled")
+	}
+	return m.replication.Apply(cmd)
+}
+
+// crdtSync starts the replication link of a peer, the client is listed as a replica from then on
+// CRDT.SYNC origin
+func crdtSync(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if m.replication == nil {
+		return RESP.MakeErrorData("ERR active-active replication is not enabled")
+	}
+	res := m.replication.Sync(cmd)
+	if _, failed := res.(*RESP.ErrorData); !failed {
+		c.SetReplica()
+	}
+	return res
+}
@@ -9,21 +9,35 @@ import (
 )
 
 func RegisterSetCommands() {
-	RegisterCommand("sadd", sAddSet)
-	RegisterCommand("scard", sCardSet)
-	RegisterCommand("sdiff", sDiffSet)
-	RegisterCommand("sdiffstore", sDiffStoreSet)
-	RegisterCommand("sinter", sInterSet)
-	RegisterCommand("sinterstore", sInterStoreSet)
-	RegisterCommand("sismember", sIsMemberSet)
-	RegisterCommand("smembers", sMembersSet)
-	RegisterCommand("smove", sMoveSet)
-	RegisterCommand("spop", sPopSet)
-	RegisterCommand("srandmember", sRandMemberSet)
-	RegisterCommand("srem", sRemSet)
-	RegisterCommand("sunion", sUnionSet)
-	RegisterCommand("sunionstore", sUnionStoreSet)
-	//RegisterCommand("sscan", sScanSet)
+	RegisterCommand("sadd", sAddSet, -3, flagWrite|flagDenyOOM, catSet, 1, 1, 1,
+		commandDoc{"Adds one or more members to a set. Creates the key if it doesn't exist.", "1.0.0", "set"})
+	RegisterCommand("scard", sCardSet, 2, flagReadonly, catSet, 1, 1, 1,
+		commandDoc{"Returns the number of members in a set.", "1.0.0", "set"})
+	RegisterCommand("sdiff", sDiffSet, -2, flagReadonly, catSet, 1, -1, 1,
+		commandDoc{"Returns the difference of multiple sets.", "1.0.0", "set"})
+	RegisterCommand("sdiffstore", sDiffStoreSet, -3, flagWrite|flagDenyOOM, catSet, 1, -1, 1,
+		commandDoc{"Stores the difference of multiple sets in a key.", "1.0.0", "set"})
+	RegisterCommand("sinter", sInterSet, -2, flagReadonly, catSet, 1, -1, 1,
+		commandDoc{"Returns the intersect of multiple sets.", "1.0.0", "set"})
+	RegisterCommand("sinterstore", sInterStoreSet, -3, flagWrite|flagDenyOOM, catSet, 1, -1, 1,
+		commandDoc{"Stores the intersect of multiple sets in a key.", "1.0.0", "set"})
+	RegisterCommand("sismember", sIsMemberSet, 3, flagReadonly, catSet, 1, 1, 1,
+		commandDoc{"Determines whether a member belongs to a set.", "1.0.0", "set"})
+	RegisterCommand("smembers", sMembersSet, 2, flagReadonly, catSet, 1, 1, 1,
+		commandDoc{"Returns all members of a set.", "1.0.0", "set"})
+	RegisterCommand("smove", sMoveSet, 4, flagWrite, catSet, 1, 2, 1,
+		commandDoc{"Moves a member from one set to another.", "1.0.0", "set"})
+	RegisterCommand("spop", sPopSet, -2, flagWrite|flagAccess, catSet, 1, 1, 1,
+		commandDoc{"Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", "1.0.0", "set"})
+	RegisterCommand("srandmember", sRandMemberSet, -2, flagReadonly, catSet, 1, 1, 1,
+		commandDoc{"Get one or multiple random members from a set", "1.0.0", "set"})
+	RegisterCommand("srem", sRemSet, -3, flagWrite, catSet, 1, 1, 1,
+		commandDoc{"Removes one or more members from a set. Deletes the set if the last member was removed.", "1.0.0", "set"})
+	RegisterCommand("sunion", sUnionSet, -2, flagReadonly, catSet, 1, -1, 1,
+		commandDoc{"Returns the union of multiple sets.", "1.0.0", "set"})
+	RegisterCommand("sunionstore", sUnionStoreSet, -3, flagWrite|flagDenyOOM, catSet, 1, -1, 1,
+		commandDoc{"Stores the union of multiple sets in a key.", "1.0.0", "set"})
+	//RegisterCommand("sscan", sScanSet, 1, 1, 1)
 }
 
 // sScanSet
@@ -82,7 +96,7 @@ func RegisterSetCommands() {
 //	return RESP.MakeArrayData([]RESP.RedisData{RESP.MakeIntData(int64(nextCursor)), RESP.MakeArrayData(data)})
 //}
 
-func sUnionStoreSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sUnionStoreSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sunionstore" {
 		logger.Error("sUnionStoreSet Function: cmdName is not sunionstore")
 		return RESP.MakeErrorData("Server error")
@@ -145,12 +159,13 @@ func sUnionStoreSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	if resSet.Len() != 0 {
 		m.db.Set(desKey, resSet)
+		m.notify(notifySet, "sunionstore", desKey)
 	}
 	return RESP.MakeIntData(int64(resSet.Len()))
 
 }
 
-func sUnionSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sUnionSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sunion" {
 		logger.Error("sUnionSet Function: cmdName is not sunion")
 		return RESP.MakeErrorData("Server error")
@@ -163,6 +178,7 @@ func sUnionSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 	for i := 1; i < len(cmd); i++ {
 		key := string(cmd[i])
+		m.trackRead(c, key)
 		if m.CheckTTL(key) {
 			keys = append(keys, key)
 		}
@@ -198,7 +214,7 @@ func sUnionSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 }
 
-func sRemSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sRemSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "srem" {
 		logger.Error("sRemSet Function: cmdName is not srem")
 		return RESP.MakeErrorData("Server error")
@@ -229,6 +245,7 @@ func sRemSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if set.Len() == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
@@ -237,11 +254,14 @@ func sRemSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 		member := string(cmd[i])
 		res += set.Remove(member)
 	}
+	if res > 0 {
+		m.notify(notifySet, "srem", key)
+	}
 
 	return RESP.MakeIntData(int64(res))
 }
 
-func sRandMemberSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sRandMemberSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "srandmember" {
 		logger.Error("sRandMemberSet Function: cmdName is not srandmember")
 		return RESP.MakeErrorData("Server error")
@@ -260,6 +280,7 @@ func sRandMemberSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 		count = 1
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeNullBulkData()
 	}
@@ -289,7 +310,7 @@ func sRandMemberSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeArrayData(res)
 }
 
-func sPopSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sPopSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "spop" {
 		logger.Error("sPopSet Function: cmdName is not spop")
 		return RESP.MakeErrorData("Server error")
@@ -333,11 +354,13 @@ func sPopSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 		if set.Len() == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 	res := make([]RESP.RedisData, 0)
 	if count == 1 {
 		val := set.Pop()
+		m.notify(notifySet, "spop", key)
 		return RESP.MakeBulkData([]byte(val))
 	} else {
 		for i := 0; i < count; i++ {
@@ -348,10 +371,13 @@ func sPopSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 			res = append(res, RESP.MakeBulkData([]byte(val)))
 		}
 	}
+	if len(res) > 0 {
+		m.notify(notifySet, "spop", key)
+	}
 	return RESP.MakeArrayData(res)
 }
 
-func sMoveSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sMoveSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "smove" {
 		logger.Error("sMoveSet Function: cmdName is not smove")
 		return RESP.MakeErrorData("Server error")
@@ -404,10 +430,17 @@ func sMoveSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if !desExist {
 		m.db.Set(desKey, desSet)
 	}
+	m.notify(notifySet, "srem", srcKey)
+	if srcSet.Len() == 0 {
+		m.db.Delete(srcKey)
+		m.DelTTL(srcKey)
+		m.notify(notifyGeneric, "del", srcKey)
+	}
+	m.notify(notifySet, "sadd", desKey)
 	return RESP.MakeIntData(1)
 }
 
-func sMembersSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sMembersSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "smembers" {
 		logger.Error("sMembersSet Function: cmdName is not smembers")
 		return RESP.MakeErrorData("Server error")
@@ -417,6 +450,7 @@ func sMembersSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeEmptyArrayData()
 	}
@@ -438,7 +472,7 @@ func sMembersSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeArrayData(res)
 }
 
-func sIsMemberSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sIsMemberSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sismember" {
 		logger.Error("sIsMemberSet Function: cmdName is not sismember")
 		return RESP.MakeErrorData("Server error")
@@ -449,6 +483,7 @@ func sIsMemberSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 	key := string(cmd[1])
 	val := string(cmd[2])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeIntData(0)
 	}
@@ -469,7 +504,7 @@ func sIsMemberSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeIntData(0)
 }
 
-func sInterStoreSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sInterStoreSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sinterstore" {
 		logger.Error("sInterStoreSet Function: cmdName is not sinterstore")
 		return RESP.MakeErrorData("Server error")
@@ -540,12 +575,13 @@ func sInterStoreSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	if interSet.Len() != 0 {
 		m.db.Set(desKey, interSet)
+		m.notify(notifySet, "sinterstore", desKey)
 	}
 	return RESP.MakeIntData(int64(interSet.Len()))
 
 }
 
-func sInterSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sInterSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sinter" {
 		logger.Error("sInterSet Function: cmdName is not sinter")
 		return RESP.MakeErrorData("Server error")
@@ -560,6 +596,7 @@ func sInterSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 
 	for _, key := range keys {
+		m.trackRead(c, key)
 		m.CheckTTL(key)
 	}
 
@@ -597,7 +634,7 @@ func sInterSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 }
 
-func sDiffStoreSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sDiffStoreSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sdiffstore" {
 		logger.Error("sDiffStoreSet Function: cmdName is not sdiffstore")
 		return RESP.MakeErrorData("Server error")
@@ -669,12 +706,13 @@ func sDiffStoreSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	if diffRes.Len() != 0 {
 		m.db.Set(desKey, diffRes)
+		m.notify(notifySet, "sdiffstore", desKey)
 	}
 	return RESP.MakeIntData(int64(diffRes.Len()))
 
 }
 
-func sDiffSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sDiffSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sdiff" {
 		logger.Error("sDiffSet Function: cmdName is not sdiff")
 		return RESP.MakeErrorData("Server error")
@@ -689,6 +727,7 @@ func sDiffSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 
 	for _, key := range keys {
+		m.trackRead(c, key)
 		m.CheckTTL(key)
 	}
 
@@ -731,7 +770,7 @@ func sDiffSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	return RESP.MakeArrayData(res)
 }
 
-func sCardSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sCardSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "scard" {
 		logger.Error("sCardSet Function: cmdName is not scard")
 		return RESP.MakeErrorData("Server error")
@@ -740,6 +779,7 @@ func sCardSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("wrong number of arguments for 'scard' command")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeIntData(0)
 	}
@@ -761,7 +801,7 @@ func sCardSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 
 }
 
-func sAddSet(m *MemDb, cmd [][]byte) RESP.RedisData {
+func sAddSet(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sadd" {
 		logger.Error("sAddSet Function: cmdName is not sadd")
 		return RESP.MakeErrorData("Server error")
@@ -788,6 +828,9 @@ func sAddSet(m *MemDb, cmd [][]byte) RESP.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		res += sets.Add(string(cmd[i]))
 	}
+	if res > 0 {
+		m.notify(notifySet, "sadd", key)
+	}
 
 	return RESP.MakeIntData(int64(res))
 
@@ -0,0 +1,57 @@
+package memdb
+
+import (
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strings"
+)
+
+// ShutdownOptions are the modifiers of SHUTDOWN
+type ShutdownOptions struct {
+	NoSave bool // don't write a snapshot
+	Save   bool // write a snapshot even if no save point is configured
+	Now    bool // don't wait for lagging replicas
+	Force  bool // exit even if the data can't be persisted
+	Abort  bool // cancel a shutdown waiting for replicas
+}
+
+// SetShutdown sets the function stopping the server, it's called by SHUTDOWN.
+// c is the client running SHUTDOWN. It returns nil once the server is stopping,
+// the connection of c is closed then and no reply is written.
+func (m *MemDb) SetShutdown(shutdown func(c *Client, opts ShutdownOptions) error) {
+	m.shutdown = shutdown
+}
+
+// shutdownCmd
+// SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]
+func shutdownCmd(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	var opts ShutdownOptions
+	for _, arg := range cmd[1:] {
+		switch strings.ToLower(string(arg)) {
+		case "nosave":
+			opts.NoSave = true
+		case "save":
+			opts.Save = true
+		case "now":
+			opts.Now = true
+		case "force":
+			opts.Force = true
+		case "abort":
+			opts.Abort = true
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	if (opts.NoSave && opts.Save) || (opts.Abort && len(cmd) > 2) {
+		return RESP.MakeErrorData("ERR syntax error")
+	}
+	if m.shutdown == nil {
+		return RESP.MakeErrorData("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	if err := m.shutdown(c, opts); err != nil {
+		return RESP.MakeErrorData(err.Error())
+	}
+	if opts.Abort {
+		return RESP.MakeStringData("OK")
+	}
+	return nil
+}
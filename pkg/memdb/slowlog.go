@@ -0,0 +1,208 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// slowlogMaxArgs is the largest number of arguments kept by an entry, the others are summarized by the last one
+	slowlogMaxArgs = 32
+	// slowlogMaxArgLen is the largest length of an argument kept by an entry, the rest is summarized
+	slowlogMaxArgLen = 128
+	// slowlogDefaultCount is the number of entries replied by SLOWLOG GET without count
+	slowlogDefaultCount = 10
+)
+
+// SlowLog keeps the latest commands running longer than slowlog-log-slower-than, up to slowlog-max-len entries.
+// The entries copy the arguments of the commands, so they never refer to the buffers of the connections.
+type SlowLog struct {
+	slowerThan int64 // microseconds, read without mu by every command
+	mu         sync.Mutex
+	maxLen     int
+	nextID     int64
+	// entries is a ring of up to maxLen entries, the entry of id is at id % maxLen,
+	// it grows as the entries are recorded so that a large slowlog-max-len costs nothing until it is used
+	entries []slowlogEntry
+	count   int // the number of entries kept, the newest has the id nextID-1
+}
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     [][]byte
+	addr     string
+	name     string
+}
+
+func NewSlowLog(slowerThan int64, maxLen int) *SlowLog {
+	s := &SlowLog{}
+	s.setLimits(slowerThan, maxLen)
+	return s
+}
+
+// setLimits applies slowlog-log-slower-than and slowlog-max-len, the oldest entries beyond maxLen are dropped
+func (s *SlowLog) setLimits(slowerThan int64, maxLen int) {
+	atomic.StoreInt64(&s.slowerThan, slowerThan)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxLen == s.maxLen {
+		return
+	}
+	// the slots of the entries change with maxLen, so the kept ones are put again into a new ring
+	kept := s.latestLocked(min(s.count, maxLen))
+	s.maxLen = maxLen
+	s.entries = nil
+	s.count = 0
+	for i := len(kept) - 1; i >= 0; i-- {
+		s.putLocked(kept[i])
+	}
+}
+
+// putLocked puts entry into its slot of the ring, overwriting the oldest entry once the ring is full
+func (s *SlowLog) putLocked(entry slowlogEntry) {
+	slot := int(entry.id % int64(s.maxLen))
+	if slot >= len(s.entries) {
+		s.entries = append(s.entries, make([]slowlogEntry, slot+1-len(s.entries))...)
+	}
+	s.entries[slot] = entry
+	s.count = min(s.count+1, s.maxLen)
+}
+
+// record logs cmd of c if it ran for longer than slowlog-log-slower-than
+func (s *SlowLog) record(c *Client, cmd [][]byte, start time.Time, duration time.Duration) {
+	slowerThan := atomic.LoadInt64(&s.slowerThan)
+	if slowerThan < 0 || duration.Microseconds() < slowerThan || c.conn == nil {
+		return
+	}
+	entry := slowlogEntry{
+		time:     start,
+		duration: duration,
+		args:     slowlogArgs(cmd),
+		addr:     c.Addr(),
+		name:     c.Name(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxLen == 0 {
+		return
+	}
+	entry.id = s.nextID
+	s.nextID++
+	s.putLocked(entry)
+}
+
+// slowlogArgs copies the arguments of cmd kept by an entry, the sensitive ones are redacted like MONITOR
+func slowlogArgs(cmd [][]byte) [][]byte {
+	argc := min(len(cmd), slowlogMaxArgs)
+	args := make([][]byte, argc)
+	sensitive := sensitiveArgs(cmd)
+	for i := 0; i < argc; i++ {
+		switch {
+		case argc != len(cmd) && i == argc-1:
+			args[i] = []byte(fmt.Sprintf("... (%d more arguments)", len(cmd)-argc+1))
+		case sensitive(i):
+			args[i] = []byte(redacted)
+		case len(cmd[i]) > slowlogMaxArgLen:
+			args[i] = []byte(fmt.Sprintf("%s... (%d more bytes)", cmd[i][:slowlogMaxArgLen], len(cmd[i])-slowlogMaxArgLen))
+		default:
+			args[i] = append([]byte(nil), cmd[i]...)
+		}
+	}
+	return args
+}
+
+// get returns the latest count entries from the newest, a negative count returns all of them
+func (s *SlowLog) get(count int) []slowlogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > s.count {
+		count = s.count
+	}
+	return s.latestLocked(count)
+}
+
+// latestLocked returns the latest count entries from the newest, count must not exceed s.count
+func (s *SlowLog) latestLocked(count int) []slowlogEntry {
+	res := make([]slowlogEntry, count)
+	for i := range res {
+		res[i] = s.entries[(s.nextID-1-int64(i))%int64(s.maxLen)]
+	}
+	return res
+}
+
+func (s *SlowLog) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (s *SlowLog) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.count = 0
+}
+
+func (e *slowlogEntry) toRedisData() RESP.RedisData {
+	args := make([]RESP.RedisData, len(e.args))
+	for i, arg := range e.args {
+		args[i] = RESP.MakeBulkData(arg)
+	}
+	return RESP.MakeArrayData([]RESP.RedisData{
+		RESP.MakeIntData(e.id),
+		RESP.MakeIntData(e.time.Unix()),
+		RESP.MakeIntData(e.duration.Microseconds()),
+		RESP.MakeArrayData(args),
+		RESP.MakeBulkData([]byte(e.addr)),
+		RESP.MakeBulkData([]byte(e.name)),
+	})
+}
+
+// slowlog
+// SLOWLOG GET [count] | LEN | RESET
+func slowlog(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'slowlog' command")
+	}
+	sub := strings.ToLower(string(cmd[1]))
+	switch sub {
+	case "get":
+		if len(cmd) > 3 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'slowlog|get' command")
+		}
+		count := slowlogDefaultCount
+		if len(cmd) == 3 {
+			n, err := strconv.Atoi(string(cmd[2]))
+			if err != nil || n < -1 {
+				return RESP.MakeErrorData("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := m.slowlog.get(count)
+		res := make([]RESP.RedisData, len(entries))
+		for i := range entries {
+			res[i] = entries[i].toRedisData()
+		}
+		return RESP.MakeArrayData(res)
+	case "len":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'slowlog|len' command")
+		}
+		return RESP.MakeIntData(int64(m.slowlog.len()))
+	case "reset":
+		if len(cmd) != 2 {
+			return RESP.MakeErrorData("ERR wrong number of arguments for 'slowlog|reset' command")
+		}
+		m.slowlog.reset()
+		return RESP.MakeStringData("OK")
+	default:
+		return RESP.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", string(cmd[1])))
+	}
+}
@@ -0,0 +1,139 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	RegisterStringCommands()
+	RegisterACLCommands()
+	m := NewMemDb()
+	c, _ := newTestClient(m)
+	m.ExecCommand(c, [][]byte{[]byte("client"), []byte("setname"), []byte("worker")})
+	exec := func(args ...string) RESP.RedisData {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		return m.ExecCommand(c, cmd)
+	}
+
+	// nothing is logged with a negative threshold
+	m.slowlog.setLimits(-1, 2)
+	exec("set", "k", "v")
+	if n := m.slowlog.len(); n != 0 {
+		t.Fatalf("slowlog len %d, expected 0", n)
+	}
+
+	m.slowlog.setLimits(0, 2)
+	long := strings.Repeat("x", slowlogMaxArgLen+10)
+	exec("set", "k", long)
+	exec("auth", "secret")
+	exec("get", "k")
+	if n := m.slowlog.len(); n != 2 {
+		t.Errorf("slowlog len should be bounded by max-len, got %d", n)
+	}
+
+	entries := m.slowlog.get(-1)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	// the newest first
+	if entries[0].id != 2 || string(entries[0].args[0]) != "get" || entries[1].id != 1 {
+		t.Errorf("entries %d %s, %d", entries[0].id, entries[0].args[0], entries[1].id)
+	}
+	if string(entries[1].args[1]) != redacted {
+		t.Errorf("auth should be redacted, got %s", entries[1].args[1])
+	}
+	if entries[0].name != "worker" || entries[0].addr != c.Addr() || entries[0].time.After(time.Now()) {
+		t.Errorf("entry client %s %s", entries[0].addr, entries[0].name)
+	}
+
+	// SLOWLOG GET is logged after its reply is built
+	res := string(exec("slowlog", "get", "1").ToBytes())
+	if !strings.HasPrefix(res, "*1\r\n*6\r\n:2\r\n") || !strings.HasSuffix(res, "$6\r\nworker\r\n") {
+		t.Errorf("slowlog get reply %q", res)
+	}
+	if res = string(exec("slowlog", "get", "-2").ToBytes()); !strings.HasPrefix(res, "-ERR") {
+		t.Errorf("slowlog get -2 should fail, got %q", res)
+	}
+
+	m.slowlog.setLimits(-1, 2)
+	exec("slowlog", "reset")
+	if res = string(exec("slowlog", "len").ToBytes()); res != ":0\r\n" {
+		t.Errorf("slowlog len %q after reset", res)
+	}
+	if res = string(exec("slowlog", "foo").ToBytes()); !strings.HasPrefix(res, "-ERR unknown subcommand") {
+		t.Errorf("slowlog foo reply %q", res)
+	}
+}
+
+func TestSlowLogRing(t *testing.T) {
+	m := NewMemDb()
+	c, _ := newTestClient(m)
+	ids := func() []int64 {
+		var res []int64
+		for _, entry := range m.slowlog.get(-1) {
+			res = append(res, entry.id)
+		}
+		return res
+	}
+	expect := func(expected ...int64) {
+		t.Helper()
+		if res := ids(); fmt.Sprint(res) != fmt.Sprint(expected) {
+			t.Errorf("slowlog ids %v, expected %v", res, expected)
+		}
+	}
+
+	m.slowlog.setLimits(0, 3)
+	for i := 0; i < 7; i++ {
+		m.slowlog.record(c, [][]byte{[]byte("get"), []byte("k")}, time.Now(), time.Millisecond)
+	}
+	expect(6, 5, 4)
+	// a shorter max-len keeps the newest entries
+	m.slowlog.setLimits(0, 2)
+	expect(6, 5)
+	m.slowlog.setLimits(0, 5)
+	expect(6, 5)
+	for i := 0; i < 4; i++ {
+		m.slowlog.record(c, [][]byte{[]byte("get"), []byte("k")}, time.Now(), time.Millisecond)
+	}
+	expect(10, 9, 8, 7, 6)
+	if entries := m.slowlog.get(2); len(entries) != 2 || entries[1].id != 9 {
+		t.Errorf("slowlog get 2 %v", entries)
+	}
+
+	m.slowlog.reset()
+	expect()
+	m.slowlog.record(c, [][]byte{[]byte("get"), []byte("k")}, time.Now(), time.Millisecond)
+	expect(11)
+	m.slowlog.setLimits(0, 0)
+	expect()
+}
+
+func TestSlowLogArgs(t *testing.T) {
+	cmd := make([][]byte, slowlogMaxArgs+5)
+	for i := range cmd {
+		cmd[i] = []byte(fmt.Sprint(i))
+	}
+	cmd[1] = []byte(strings.Repeat("x", slowlogMaxArgLen+10))
+	args := slowlogArgs(cmd)
+	if len(args) != slowlogMaxArgs {
+		t.Fatalf("got %d arguments", len(args))
+	}
+	if last := string(args[slowlogMaxArgs-1]); last != "... (6 more arguments)" {
+		t.Errorf("last argument %q", last)
+	}
+	if arg := string(args[1]); arg != strings.Repeat("x", slowlogMaxArgLen)+"... (10 more bytes)" {
+		t.Errorf("truncated argument %q", arg)
+	}
+	// the arguments are copied
+	cmd[2][0] = 'y'
+	if string(args[2]) != "2" {
+		t.Error("arguments should be copied from the command")
+	}
+}
@@ -0,0 +1,234 @@
+package memdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsSamples is the number of the latest samples averaged by the instantaneous metrics of INFO
+const statsSamples = 5
+
+// Stats are the counters reported by INFO, they're updated by MemDb, the handler and the AOF writer.
+type Stats struct {
+	startTime time.Time
+	runID     string
+
+	connectionsReceived int64
+	rejectedConns       int64
+	commandsProcessed   int64
+	netInputBytes       int64
+	netOutputBytes      int64
+	expiredKeys         int64
+	errorReplies        int64
+	keyspaceHits        int64
+	keyspaceMisses      int64
+
+	loading         int32
+	aofEnabled      int32
+	aofSize         int64
+	aofPending      int64 // bytes queued to the AOF writer
+	aofLastWriteErr int32
+	aofFsync        latencyHistogram
+
+	commands sync.Map // command name -> *commandStats
+	errors   sync.Map // error code -> *int64
+
+	mu         sync.Mutex
+	lastSample statsSample
+	ops        [statsSamples]float64 // commands per second
+	input      [statsSamples]float64 // input bytes per second
+	output     [statsSamples]float64 // output bytes per second
+	sampleIdx  int
+	peakMemory uint64
+}
+
+// statsSample is the state of the counters sampled for the instantaneous metrics
+type statsSample struct {
+	time     time.Time
+	commands int64
+	input    int64
+	output   int64
+}
+
+// commandStats are the calls of a command reported by INFO commandstats
+type commandStats struct {
+	calls    int64
+	usec     int64
+	rejected int64 // replied an error before being executed, such as an ACL denial
+	failed   int64 // replied an error by its executor
+}
+
+func NewStats() *Stats {
+	id := make([]byte, 20)
+	_, _ = rand.Read(id)
+	now := time.Now()
+	return &Stats{startTime: now, runID: hex.EncodeToString(id), lastSample: statsSample{time: now}}
+}
+
+// ConnectionReceived counts an accepted connection
+func (s *Stats) ConnectionReceived() {
+	atomic.AddInt64(&s.connectionsReceived, 1)
+}
+
+// AddNetInput counts n bytes read from a client
+func (s *Stats) AddNetInput(n int) {
+	atomic.AddInt64(&s.netInputBytes, int64(n))
+}
+
+func (s *Stats) addNetOutput(n int64) {
+	atomic.AddInt64(&s.netOutputBytes, n)
+}
+
+// SetLoading reports whether the AOF file is being loaded
+func (s *Stats) SetLoading(loading bool) {
+	atomic.StoreInt32(&s.loading, boolToInt32(loading))
+}
+
+// SetAofEnabled reports that the AOF writer is running with a file of size bytes
+func (s *Stats) SetAofEnabled(size int64) {
+	atomic.StoreInt32(&s.aofEnabled, 1)
+	atomic.StoreInt64(&s.aofSize, size)
+}
+
+// AofQueued counts n bytes queued to the AOF writer, a negative n drops the bytes that could not be queued
+func (s *Stats) AofQueued(n int) {
+	atomic.AddInt64(&s.aofPending, int64(n))
+}
+
+// AofWritten counts n queued bytes taken by the AOF writer, written of them reached the file and err is the result
+func (s *Stats) AofWritten(n, written int, err error) {
+	atomic.AddInt64(&s.aofPending, -int64(n))
+	atomic.AddInt64(&s.aofSize, int64(written))
+	atomic.StoreInt32(&s.aofLastWriteErr, boolToInt32(err != nil))
+}
+
+// AofFsynced records that an fsync of the AOF file lasted for d
+func (s *Stats) AofFsynced(d time.Duration) {
+	s.aofFsync.record(d)
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// lookup counts a key read by a command as a keyspace hit if it exists, or a miss
+func (s *Stats) lookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&s.keyspaceHits, 1)
+	} else {
+		atomic.AddInt64(&s.keyspaceMisses, 1)
+	}
+}
+
+// command returns the stats of the command name
+func (s *Stats) command(name string) *commandStats {
+	cs, ok := s.commands.Load(name)
+	if !ok {
+		cs, _ = s.commands.LoadOrStore(name, &commandStats{})
+	}
+	return cs.(*commandStats)
+}
+
+// called counts a call of the command name lasting for d
+func (s *Stats) called(name string, d time.Duration, res RESP.RedisData) {
+	atomic.AddInt64(&s.commandsProcessed, 1)
+	cs := s.command(name)
+	atomic.AddInt64(&cs.calls, 1)
+	atomic.AddInt64(&cs.usec, d.Microseconds())
+	if s.countError(res) {
+		atomic.AddInt64(&cs.failed, 1)
+	}
+}
+
+// rejected counts the error replied to the command name without executing it, an unknown command has no stats
+func (s *Stats) rejected(name string, known bool, res RESP.RedisData) {
+	if s.countError(res) && known {
+		atomic.AddInt64(&s.command(name).rejected, 1)
+	}
+}
+
+// countError counts res by total_error_replies and errorstats if it's an error, and reports whether it is
+func (s *Stats) countError(res RESP.RedisData) bool {
+	errData, ok := res.(*RESP.ErrorData)
+	if !ok {
+		return false
+	}
+	atomic.AddInt64(&s.errorReplies, 1)
+	// the code of an error is its first word such as ERR or WRONGTYPE, an error without code is an ERR like Redis
+	code, _, _ := strings.Cut(string(errData.ByteData()), " ")
+	if code == "" || strings.IndexFunc(code, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		code = "ERR"
+	}
+	count, ok := s.errors.Load(code)
+	if !ok {
+		count, _ = s.errors.LoadOrStore(code, new(int64))
+	}
+	atomic.AddInt64(count.(*int64), 1)
+	return true
+}
+
+// Sample records the rates of the instantaneous metrics since the previous sample and the peak of the memory,
+// it's called periodically by the server.
+func (s *Stats) Sample() {
+	now := statsSample{
+		time:     time.Now(),
+		commands: atomic.LoadInt64(&s.commandsProcessed),
+		input:    atomic.LoadInt64(&s.netInputBytes),
+		output:   atomic.LoadInt64(&s.netOutputBytes),
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peakMemory = max(s.peakMemory, mem.HeapAlloc)
+	elapsed := now.time.Sub(s.lastSample.time).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	s.ops[s.sampleIdx] = float64(now.commands-s.lastSample.commands) / elapsed
+	s.input[s.sampleIdx] = float64(now.input-s.lastSample.input) / elapsed
+	s.output[s.sampleIdx] = float64(now.output-s.lastSample.output) / elapsed
+	s.sampleIdx = (s.sampleIdx + 1) % statsSamples
+	s.lastSample = now
+}
+
+// instantaneous returns the average rates of the latest samples
+func (s *Stats) instantaneous() (ops, input, output float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < statsSamples; i++ {
+		ops += s.ops[i]
+		input += s.input[i]
+		output += s.output[i]
+	}
+	return ops / statsSamples, input / statsSamples, output / statsSamples
+}
+
+// peak returns the peak of the memory used, updated by used
+func (s *Stats) peak(used uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peakMemory = max(s.peakMemory, used)
+	return s.peakMemory
+}
+
+// sortedKeys returns the keys of m, sorted
+func sortedKeys(m *sync.Map) []string {
+	var keys []string
+	m.Range(func(key, value any) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
@@ -1,6 +1,7 @@
 package memdb
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/hsn/tiny-redis/pkg/RESP"
 	"github.com/hsn/tiny-redis/pkg/logger"
@@ -10,23 +11,38 @@ import (
 )
 
 func RegisterStringCommands() {
-	RegisterCommand("set", setString)
-	RegisterCommand("get", getString)
-	RegisterCommand("setrange", setRangeString)
-	RegisterCommand("getrange", getRangeString)
-	RegisterCommand("mset", mSetString)
-	RegisterCommand("mget", mGetString)
-	RegisterCommand("setex", setExString)
-	RegisterCommand("setnx", setNxString)
-	RegisterCommand("strlen", strLenString)
-	RegisterCommand("incr", incrString)
-	RegisterCommand("incrby", incrByString)
-	RegisterCommand("decr", decrString)
-	RegisterCommand("decrby", decrByString)
-	RegisterCommand("incrbyfloat", incrByFloatString)
-	RegisterCommand("append", appendString)
+	RegisterCommand("set", setString, -3, flagWrite|flagDenyOOM, catString, 1, 1, 1,
+		commandDoc{"Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", "1.0.0", "string"})
+	RegisterCommand("get", getString, 2, flagReadonly, catString, 1, 1, 1,
+		commandDoc{"Returns the string value of a key.", "1.0.0", "string"})
+	RegisterCommand("setrange", setRangeString, 4, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.", "2.2.0", "string"})
+	RegisterCommand("getrange", getRangeString, 4, flagReadonly, catString, 1, 1, 1,
+		commandDoc{"Returns a substring of the string stored at a key.", "2.4.0", "string"})
+	RegisterCommand("mset", mSetString, -3, flagWrite|flagDenyOOM, catString, 1, -1, 2,
+		commandDoc{"Atomically creates or modifies the string values of one or more keys.", "1.0.1", "string"})
+	RegisterCommand("mget", mGetString, -2, flagReadonly, catString, 1, -1, 1,
+		commandDoc{"Atomically returns the string values of one or more keys.", "1.0.0", "string"})
+	RegisterCommand("setex", setExString, 4, flagWrite|flagDenyOOM, catString, 1, 1, 1,
+		commandDoc{"Sets the string value and expiration time of a key. Creates the key if it doesn't exist.", "2.0.0", "string"})
+	RegisterCommand("setnx", setNxString, 3, flagWrite|flagDenyOOM, catString, 1, 1, 1,
+		commandDoc{"Set the string value of a key only when the key doesn't exist.", "1.0.0", "string"})
+	RegisterCommand("strlen", strLenString, 2, flagReadonly, catString, 1, 1, 1,
+		commandDoc{"Returns the length of a string value.", "2.2.0", "string"})
+	RegisterCommand("incr", incrString, 2, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", "1.0.0", "string"})
+	RegisterCommand("incrby", incrByString, 3, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.", "1.0.0", "string"})
+	RegisterCommand("decr", decrString, 2, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", "1.0.0", "string"})
+	RegisterCommand("decrby", decrByString, 3, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.", "1.0.0", "string"})
+	RegisterCommand("incrbyfloat", incrByFloatString, 3, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.", "2.6.0", "string"})
+	RegisterCommand("append", appendString, 3, flagWrite|flagDenyOOM|flagAccess, catString, 1, 1, 1,
+		commandDoc{"Appends a string to the value of a key. Creates the key if it doesn't exist.", "2.0.0", "string"})
 }
-func setString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func setString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	cmdName := string(cmd[0])
 	if strings.ToLower(cmdName) != "set" {
 		logger.Error("setString Function: is not set")
@@ -76,6 +92,7 @@ func setString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	m.locks.Lock(string(cmd[1]))
 	defer m.locks.UnLock(string(cmd[1]))
 	var res RESP.RedisData
+	written := false
 
 	oldVal, oldOk := m.db.Get(string(cmd[1]))
 	// check is string
@@ -90,21 +107,24 @@ func setString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if nx || xx {
 		if nx {
 			if oldOk {
-				m.db.Set(string(cmd[1]), cmd[2])
+				m.db.Set(string(cmd[1]), bytes.Clone(cmd[2]))
+				written = true
 				res = RESP.MakeStringData("OK")
 			} else {
 				res = RESP.MakeNullBulkData()
 			}
 		} else {
 			if oldOk {
-				m.db.Set(string(cmd[1]), cmd[2])
+				m.db.Set(string(cmd[1]), bytes.Clone(cmd[2]))
+				written = true
 				res = RESP.MakeStringData("OK")
 			} else {
 				res = RESP.MakeNullBulkData()
 			}
 		}
 	} else {
-		m.db.Set(string(cmd[1]), cmd[2])
+		m.db.Set(string(cmd[1]), bytes.Clone(cmd[2]))
+		written = true
 		res = RESP.MakeStringData("OK")
 	}
 	if get {
@@ -120,9 +140,15 @@ func setString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	if ex {
 		m.SetTTL(string(cmd[1]), exval+time.Now().Unix())
 	}
+	if written {
+		m.notify(notifyString, "set", string(cmd[1]))
+		if ex {
+			m.notify(notifyGeneric, "expire", string(cmd[1]))
+		}
+	}
 	return res
 }
-func getString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func getString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "get" {
 		logger.Error("getString Function: cmdName is not get")
 		return RESP.MakeErrorData("server error")
@@ -131,6 +157,7 @@ func getString(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("error: commands is invalid")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeNullBulkData()
 	}
@@ -146,7 +173,7 @@ func getString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeBulkData(byteVal)
 }
-func setRangeString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func setRangeString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "setrange" {
 		logger.Error("setRangeString Function: cmdName is not setrange")
 		return RESP.MakeErrorData("server error")
@@ -185,9 +212,10 @@ func setRangeString(m *MemDb, cmd [][]byte) RESP.RedisData {
 		newVal = append(newVal, cmd[3]...)
 	}
 	m.db.Set(key, newVal)
+	m.notify(notifyString, "setrange", key)
 	return RESP.MakeIntData(int64(len(newVal)))
 }
-func getRangeString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func getRangeString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "getrange" {
 		logger.Error("getRangeString Function: cmdName is not getrange")
 		return RESP.MakeErrorData("Server error")
@@ -196,6 +224,7 @@ func getRangeString(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("error: commands is not invalid")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	if !m.CheckTTL(key) {
 		return RESP.MakeNullBulkData()
 	}
@@ -232,7 +261,7 @@ func getRangeString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeBulkData(byteVal[start:end])
 }
-func mSetString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func mSetString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "mset" {
 		logger.Error("mSetString Function: cmdName is not mset")
 		return RESP.MakeErrorData("Server error")
@@ -244,17 +273,18 @@ func mSetString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	vals := make([][]byte, 0)
 	for i := 1; i < len(cmd); i += 2 {
 		keys = append(keys, string(cmd[i]))
-		vals = append(vals, cmd[i+1])
+		vals = append(vals, bytes.Clone(cmd[i+1]))
 	}
 	m.locks.LockMulti(keys)
 	defer m.locks.UnLockMulti(keys)
 	for i := 0; i < len(keys); i++ {
 		m.DelTTL(keys[i])
 		m.db.Set(keys[i], vals[i])
+		m.notify(notifyString, "set", keys[i])
 	}
 	return RESP.MakeStringData("OK")
 }
-func mGetString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func mGetString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "mget" {
 		logger.Error("mGetString Function: cmdName is not mget")
 		return RESP.MakeErrorData("Server error")
@@ -265,6 +295,7 @@ func mGetString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	res := make([]RESP.RedisData, 0)
 	for i := 1; i < len(cmd); i++ {
 		key := string(cmd[i])
+		m.trackRead(c, key)
 		if !m.CheckTTL(key) {
 			res = append(res, RESP.MakeNullBulkData())
 			continue
@@ -286,7 +317,7 @@ func mGetString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeArrayData(res)
 }
-func setExString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func setExString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "setex" {
 		logger.Error("setExString Function: cmdName is not setEx")
 		return RESP.MakeErrorData("Server error")
@@ -300,14 +331,16 @@ func setExString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	ttl := time.Now().Unix() + ex
 	key := string(cmd[1])
-	val := cmd[3]
+	val := bytes.Clone(cmd[3])
 	m.locks.Lock(key)
 	defer m.locks.UnLock(key)
 	m.db.Set(key, val)
 	m.SetTTL(key, ttl)
+	m.notify(notifyString, "set", key)
+	m.notify(notifyGeneric, "expire", key)
 	return RESP.MakeStringData("OK")
 }
-func setNxString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func setNxString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "setnx" {
 		logger.Error("setNxString Function: commands is invalid")
 		return RESP.MakeErrorData("Server")
@@ -316,14 +349,17 @@ func setNxString(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("error: commands is in valid")
 	}
 	key := string(cmd[1])
-	val := cmd[2]
+	val := bytes.Clone(cmd[2])
 	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.UnLock(key)
 	res := m.db.SetIfNotExist(key, val)
+	if res == 1 {
+		m.notify(notifyString, "set", key)
+	}
 	return RESP.MakeIntData(int64(res))
 }
-func strLenString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func strLenString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "strlen" {
 		logger.Error("strLenString Function: cmdName is not strlen")
 		return RESP.MakeErrorData("Server error")
@@ -332,6 +368,7 @@ func strLenString(m *MemDb, cmd [][]byte) RESP.RedisData {
 		return RESP.MakeErrorData("error: commands is invalid")
 	}
 	key := string(cmd[1])
+	m.trackRead(c, key)
 	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnLock(key)
@@ -345,7 +382,7 @@ func strLenString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	return RESP.MakeIntData(int64(len(byteVal)))
 }
-func incrString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func incrString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "incr" {
 		logger.Error("incrString Function: cmdName is not incr")
 		return RESP.MakeErrorData("Server error")
@@ -360,6 +397,7 @@ func incrString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte("1"))
+		m.notify(notifyString, "incrby", key)
 		return RESP.MakeIntData(1)
 	}
 	typeVal, ok := val.([]byte)
@@ -372,9 +410,10 @@ func incrString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	intVal++
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "incrby", key)
 	return RESP.MakeIntData(intVal)
 }
-func incrByString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func incrByString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "incrby" {
 		logger.Error("incrByString Funcction: cmdName is not incrby")
 		return RESP.MakeErrorData("Server error")
@@ -394,6 +433,7 @@ func incrByString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte(strconv.FormatInt(inc, 10)))
+		m.notify(notifyString, "incrby", key)
 		return RESP.MakeIntData(inc)
 	}
 	typeVal, ok := val.([]byte)
@@ -406,9 +446,10 @@ func incrByString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	intVal += inc
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "incrby", key)
 	return RESP.MakeIntData(intVal)
 }
-func decrString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func decrString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "decr" {
 		logger.Error("decrString Function: cmdName is not decr")
 		return RESP.MakeErrorData("Server error")
@@ -424,6 +465,7 @@ func decrString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte("-1"))
+		m.notify(notifyString, "decrby", key)
 		return RESP.MakeIntData(-1)
 	}
 	typeVal, ok := val.([]byte)
@@ -436,9 +478,10 @@ func decrString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	intVal--
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "decrby", key)
 	return RESP.MakeIntData(intVal)
 }
-func decrByString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func decrByString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "decrby" {
 		logger.Error("decrByString Function: cmdName is not decrby")
 		return RESP.MakeErrorData("Server error")
@@ -458,6 +501,7 @@ func decrByString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte(strconv.FormatInt(-dec, 10)))
+		m.notify(notifyString, "decrby", key)
 		return RESP.MakeIntData(-dec)
 	}
 	typeVal, ok := val.([]byte)
@@ -470,10 +514,11 @@ func decrByString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	intVal -= dec
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "decrby", key)
 	return RESP.MakeIntData(intVal)
 
 }
-func incrByFloatString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func incrByFloatString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "incrbyfloat" {
 		logger.Error("incrByFloatString Function: cmdName is not incrbyfloat")
 		return RESP.MakeErrorData("Server error")
@@ -496,6 +541,7 @@ func incrByFloatString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte(strconv.FormatFloat(inc, 'f', -1, 64)))
+		m.notify(notifyString, "incrbyfloat", key)
 		return RESP.MakeBulkData([]byte(strconv.FormatFloat(inc, 'f', -1, 64)))
 	}
 	typeVal, ok := val.([]byte)
@@ -508,9 +554,10 @@ func incrByFloatString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	floatVal += inc
 	m.db.Set(key, []byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
+	m.notify(notifyString, "incrbyfloat", key)
 	return RESP.MakeBulkData([]byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
 }
-func appendString(m *MemDb, cmd [][]byte) RESP.RedisData {
+func appendString(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "append" {
 		logger.Error("appendString Function: cmdName is not append")
 		return RESP.MakeErrorData("Server error")
@@ -526,7 +573,8 @@ func appendString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	defer m.locks.UnLock(key)
 	oldVal, ok := m.db.Get(key)
 	if !ok {
-		m.db.Set(key, val)
+		m.db.Set(key, bytes.Clone(val))
+		m.notify(notifyString, "append", key)
 		return RESP.MakeIntData(int64(len(val)))
 	}
 	typeVal, ok := oldVal.([]byte)
@@ -535,5 +583,6 @@ func appendString(m *MemDb, cmd [][]byte) RESP.RedisData {
 	}
 	newVal := append(typeVal, val...)
 	m.db.Set(key, newVal)
+	m.notify(notifyString, "append", key)
 	return RESP.MakeIntData(int64(len(newVal)))
 }
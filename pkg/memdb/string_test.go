@@ -17,7 +17,7 @@ func TestSetString(t *testing.T) {
 	mem := NewMemDb()
 
 	// test set
-	res := setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("a")})
+	res := setString(mem, nil, [][]byte{[]byte("set"), []byte("a"), []byte("a")})
 	if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
 		t.Error("set reply error")
 	}
@@ -27,7 +27,7 @@ func TestSetString(t *testing.T) {
 	}
 
 	// test opt xx and ex
-	res = setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("b"), []byte("xx"), []byte("ex"), []byte("100")})
+	res = setString(mem, nil, [][]byte{[]byte("set"), []byte("a"), []byte("b"), []byte("xx"), []byte("ex"), []byte("100")})
 	if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
 		t.Error("set reply error")
 	}
@@ -41,7 +41,7 @@ func TestSetString(t *testing.T) {
 	}
 
 	// test opt keepttl
-	res = setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("c"), []byte("get"), []byte("keepttl")})
+	res = setString(mem, nil, [][]byte{[]byte("set"), []byte("a"), []byte("c"), []byte("get"), []byte("keepttl")})
 	if !bytes.Equal(res.ToBytes(), []byte("$1\r\nb\r\n")) {
 		t.Error("set reply error")
 	}
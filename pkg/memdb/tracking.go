@@ -0,0 +1,333 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// trackingChannel is the channel of invalidation messages sent to RESP2 clients
+const trackingChannel = "__redis__:invalidate"
+
+// Tracking is the server side of client-side caching.
+// In the default mode, keys records the clients which have read a key, a key is forgotten once it's invalidated.
+// In BCAST mode, prefixes records the clients interested in the keys starting with a prefix,
+// they are notified of every modified key matching the prefix, no matter whether they have read it.
+type Tracking struct {
+	mu       sync.Mutex
+	clients  int64 // number of clients with tracking on, checked without mu to skip writes quickly
+	keys     map[string]map[int64]struct{}
+	prefixes map[string]map[int64]struct{}
+}
+
+func NewTracking() *Tracking {
+	return &Tracking{
+		keys:     make(map[string]map[int64]struct{}),
+		prefixes: make(map[string]map[int64]struct{}),
+	}
+}
+
+func (t *Tracking) enabled() bool {
+	return atomic.LoadInt64(&t.clients) > 0
+}
+
+func (t *Tracking) track(id int64, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids, ok := t.keys[key]
+	if !ok {
+		ids = make(map[int64]struct{})
+		t.keys[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// invalidated returns the clients to be notified of a modification of key, and forgets the readers of key
+func (t *Tracking) invalidated(key string) []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]int64, 0)
+	for id := range t.keys[key] {
+		res = append(res, id)
+	}
+	delete(t.keys, key)
+	for prefix, ids := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for id := range ids {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+func (t *Tracking) addPrefixes(id int64, prefixes []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, prefix := range prefixes {
+		ids, ok := t.prefixes[prefix]
+		if !ok {
+			ids = make(map[int64]struct{})
+			t.prefixes[prefix] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+func (t *Tracking) removePrefixes(id int64, prefixes []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, prefix := range prefixes {
+		if ids, ok := t.prefixes[prefix]; ok {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(t.prefixes, prefix)
+			}
+		}
+	}
+}
+
+// trackRead records that the client has read key, so it will be notified when key is modified.
+// It's called by the read executors.
+func (m *MemDb) trackRead(c *Client, key string) {
+	if c == nil || !m.tracking.enabled() {
+		return
+	}
+	c.mu.Lock()
+	track := c.tracking && !c.trackingBcast
+	if c.trackingOptIn {
+		track = track && c.cachingCmd == c.commands
+	} else if c.trackingOptOut {
+		track = track && c.cachingCmd != c.commands
+	}
+	c.mu.Unlock()
+	if track {
+		m.tracking.track(c.id, key)
+	}
+}
+
+// invalidateKey sends an invalidation message of key to the clients tracking it.
+// writer is the client modifying key, nil if the key is modified by the server, such as expiry.
+func (m *MemDb) invalidateKey(key string, writer *Client) {
+	if !m.tracking.enabled() {
+		return
+	}
+	sent := make(map[int64]bool)
+	for _, id := range m.tracking.invalidated(key) {
+		if sent[id] {
+			continue
+		}
+		sent[id] = true
+		if c := m.clients.Get(id); c != nil {
+			m.sendInvalidation(c, writer, key)
+		}
+	}
+}
+
+// sendInvalidation sends the invalidation message to the client, or the client it redirects to.
+// A RESP3 connection receives it as an invalidate push,
+// a RESP2 connection can only receive it as a message of __redis__:invalidate in subscribed mode.
+func (m *MemDb) sendInvalidation(c, writer *Client, key string) {
+	c.mu.Lock()
+	tracking, noLoop, redirect := c.tracking, c.trackingNoLoop, c.trackingRedirect
+	c.mu.Unlock()
+	if !tracking || (noLoop && c == writer) {
+		return
+	}
+	target := c
+	if redirect != 0 {
+		if target = m.clients.Get(redirect); target == nil {
+			return
+		}
+	}
+	keys := RESP.MakeArrayData([]RESP.RedisData{RESP.MakeBulkData([]byte(key))})
+	if target.Protocol() == RESP.RESP3 {
+		target.WriteData(RESP.MakePushData([]RESP.RedisData{RESP.MakeBulkData([]byte("invalidate")), keys}))
+	} else if target.Subscribed() {
+		target.WriteData(RESP.MakePushData([]RESP.RedisData{
+			RESP.MakeBulkData([]byte("message")),
+			RESP.MakeBulkData([]byte(trackingChannel)),
+			keys,
+		}))
+	}
+}
+
+// signalModifiedKey is called after key is modified, it fails the transactions watching key
+// and invalidates the caches of key.
+func (m *MemDb) signalModifiedKey(c *Client, key string) {
+	m.touchWatched(key)
+	m.invalidateKey(key, c)
+}
+
+// trackingOff disables tracking of the client
+func (m *MemDb) trackingOff(c *Client) {
+	c.mu.Lock()
+	if !c.tracking {
+		c.mu.Unlock()
+		return
+	}
+	prefixes := c.trackingPrefixes
+	c.tracking, c.trackingBcast, c.trackingOptIn, c.trackingOptOut, c.trackingNoLoop = false, false, false, false, false
+	c.trackingRedirect, c.trackingPrefixes = 0, nil
+	c.mu.Unlock()
+	m.tracking.removePrefixes(c.id, prefixes)
+	atomic.AddInt64(&m.tracking.clients, -1)
+}
+
+// clientTracking
+// CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTracking(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) < 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|tracking' command")
+	}
+	var on bool
+	switch strings.ToLower(string(cmd[2])) {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	default:
+		return RESP.MakeErrorData("ERR syntax error")
+	}
+	var redirect int64
+	var prefixes []string
+	var bcast, optIn, optOut, noLoop bool
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "redirect":
+			if i+1 >= len(cmd) {
+				return RESP.MakeErrorData("ERR syntax error")
+			}
+			i++
+			id, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil {
+				return RESP.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			if m.clients.Get(id) == nil {
+				return RESP.MakeErrorData("ERR The client ID you want redirect to does not exist")
+			}
+			redirect = id
+		case "prefix":
+			if i+1 >= len(cmd) {
+				return RESP.MakeErrorData("ERR syntax error")
+			}
+			i++
+			prefixes = append(prefixes, string(cmd[i]))
+		case "bcast":
+			bcast = true
+		case "optin":
+			optIn = true
+		case "optout":
+			optOut = true
+		case "noloop":
+			noLoop = true
+		default:
+			return RESP.MakeErrorData("ERR syntax error")
+		}
+	}
+	if !on {
+		m.trackingOff(c)
+		return RESP.MakeStringData("OK")
+	}
+	if len(prefixes) > 0 && !bcast {
+		return RESP.MakeErrorData("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if optIn && optOut {
+		return RESP.MakeErrorData("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if bcast && (optIn || optOut) {
+		return RESP.MakeErrorData("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if bcast && len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	// switching the options of an enabled client starts over
+	m.trackingOff(c)
+	c.mu.Lock()
+	c.tracking, c.trackingBcast, c.trackingOptIn, c.trackingOptOut, c.trackingNoLoop = true, bcast, optIn, optOut, noLoop
+	c.trackingRedirect, c.trackingPrefixes = redirect, prefixes
+	c.mu.Unlock()
+	m.tracking.addPrefixes(c.id, prefixes)
+	atomic.AddInt64(&m.tracking.clients, 1)
+	return RESP.MakeStringData("OK")
+}
+
+// clientCaching
+// CLIENT CACHING YES|NO
+// In OPTIN mode, YES makes the keys read by the next command tracked.
+// In OPTOUT mode, NO makes the keys read by the next command not tracked.
+func clientCaching(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|caching' command")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch opt := strings.ToLower(string(cmd[2])); {
+	case opt == "yes" && c.tracking && c.trackingOptIn:
+	case opt == "no" && c.tracking && c.trackingOptOut:
+	case opt == "yes" || opt == "no":
+		return RESP.MakeErrorData(fmt.Sprintf("ERR CLIENT CACHING %s is only valid when tracking is enabled in %s mode.",
+			strings.ToUpper(opt), map[string]string{"yes": "OPTIN", "no": "OPTOUT"}[opt]))
+	default:
+		return RESP.MakeErrorData("ERR syntax error")
+	}
+	c.cachingCmd = c.commands + 1
+	return RESP.MakeStringData("OK")
+}
+
+// clientGetRedirect
+// CLIENT GETREDIRECT returns the redirect client id, 0 if tracking is not redirected, -1 if tracking is off
+func clientGetRedirect(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|getredirect' command")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.tracking {
+		return RESP.MakeIntData(-1)
+	}
+	return RESP.MakeIntData(c.trackingRedirect)
+}
+
+// clientTrackingInfo
+// CLIENT TRACKINGINFO returns the tracking flags, redirect and prefixes of the client
+func clientTrackingInfo(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if len(cmd) != 2 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'client|trackinginfo' command")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	flags := make([]RESP.RedisData, 0)
+	redirect := int64(-1)
+	if !c.tracking {
+		flags = append(flags, RESP.MakeBulkData([]byte("off")))
+	} else {
+		redirect = c.trackingRedirect
+		flags = append(flags, RESP.MakeBulkData([]byte("on")))
+		for _, flag := range []struct {
+			on   bool
+			name string
+		}{{c.trackingBcast, "bcast"}, {c.trackingOptIn, "optin"}, {c.trackingOptOut, "optout"}, {c.trackingNoLoop, "noloop"}} {
+			if flag.on {
+				flags = append(flags, RESP.MakeBulkData([]byte(flag.name)))
+			}
+		}
+	}
+	prefixes := append([]string(nil), c.trackingPrefixes...)
+	sort.Strings(prefixes)
+	prefixData := make([]RESP.RedisData, len(prefixes))
+	for i, prefix := range prefixes {
+		prefixData[i] = RESP.MakeBulkData([]byte(prefix))
+	}
+	return RESP.MakeArrayData([]RESP.RedisData{
+		RESP.MakeBulkData([]byte("flags")), RESP.MakeArrayData(flags),
+		RESP.MakeBulkData([]byte("redirect")), RESP.MakeIntData(redirect),
+		RESP.MakeBulkData([]byte("prefixes")), RESP.MakeArrayData(prefixData),
+	})
+}
@@ -0,0 +1,112 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// expectInvalidate reads an invalidation message of key pushed to a RESP2 client
+func expectInvalidate(t *testing.T, r *bufio.Reader, key string) {
+	t.Helper()
+	expect := "*3\r\n$7\r\nmessage\r\n" + bulkString(trackingChannel) + "*1\r\n" + bulkString(key)
+	if got := readPushed(t, r, len(expect)); string(got) != expect {
+		t.Errorf("expect invalidation %q, get %q", expect, got)
+	}
+}
+
+func TestClientTracking(t *testing.T) {
+	m := newNotifyTestDb()
+	server, peer := net.Pipe()
+	sub := NewClient(server)
+	m.AddClient(sub)
+	r := bufio.NewReader(peer)
+	execArgs(m, sub, "subscribe", trackingChannel)
+
+	c := NewFakeClient()
+	m.AddClient(c)
+	writer := NewFakeClient()
+	m.AddClient(writer)
+	redirect := strconv.FormatInt(sub.ID(), 10)
+	// the sentinel published after writes which should not be notified
+	sentinel := func() {
+		execArgs(m, writer, "publish", trackingChannel, "sentinel")
+		expectMessages(t, r, [2]string{trackingChannel, "sentinel"})
+	}
+
+	for _, args := range [][]string{
+		{"client", "tracking", "on", "redirect", "100000"},
+		{"client", "tracking", "on", "prefix", "a"},
+		{"client", "tracking", "on", "optin", "optout"},
+		{"client", "tracking", "on", "bcast", "optin"},
+		{"client", "caching", "yes"},
+	} {
+		if res := execArgs(m, c, args...); res[0] != '-' {
+			t.Errorf("%v should be rejected: %q", args, res)
+		}
+	}
+
+	// default mode, a key is invalidated once after it's read
+	if res := execArgs(m, c, "client", "tracking", "on", "redirect", redirect); !bytes.Equal(res, []byte("+OK\r\n")) {
+		t.Fatalf("client tracking reply error: %q", res)
+	}
+	if res := execArgs(m, c, "client", "getredirect"); string(res) != ":"+redirect+"\r\n" {
+		t.Errorf("client getredirect reply error: %q", res)
+	}
+	execArgs(m, writer, "set", "a", "1")
+	execArgs(m, c, "get", "a")
+	execArgs(m, c, "hget", "h", "f")
+	execArgs(m, writer, "set", "a", "2")
+	expectInvalidate(t, r, "a")
+	execArgs(m, writer, "set", "a", "3")
+	execArgs(m, writer, "set", "b", "3")
+	sentinel()
+	execArgs(m, writer, "hset", "h", "f", "v")
+	expectInvalidate(t, r, "h")
+
+	// expired keys are invalidated
+	execArgs(m, c, "get", "a")
+	m.ttlKeys.Set("a", time.Now().Unix()-1)
+	m.activeExpireCycle()
+	expectInvalidate(t, r, "a")
+
+	// NOLOOP skips the keys modified by the client itself
+	execArgs(m, c, "client", "tracking", "on", "redirect", redirect, "noloop")
+	execArgs(m, c, "get", "a")
+	execArgs(m, c, "set", "a", "1")
+	sentinel()
+
+	// OPTIN only tracks the keys read right after CLIENT CACHING YES
+	execArgs(m, c, "client", "tracking", "on", "redirect", redirect, "optin")
+	execArgs(m, c, "get", "a")
+	execArgs(m, writer, "set", "a", "2")
+	sentinel()
+	if res := execArgs(m, c, "client", "caching", "yes"); !bytes.Equal(res, []byte("+OK\r\n")) {
+		t.Errorf("client caching reply error: %q", res)
+	}
+	execArgs(m, c, "get", "a")
+	execArgs(m, c, "get", "b")
+	execArgs(m, writer, "set", "b", "1")
+	execArgs(m, writer, "set", "a", "1")
+	expectInvalidate(t, r, "a")
+
+	// BCAST notifies every modified key matching the prefixes
+	execArgs(m, c, "client", "tracking", "on", "redirect", redirect, "bcast", "prefix", "user:")
+	execArgs(m, writer, "set", "user:1", "x")
+	expectInvalidate(t, r, "user:1")
+	execArgs(m, writer, "set", "item:1", "x")
+	sentinel()
+	if info := execArgs(m, c, "client", "info"); !bytes.Contains(info, []byte("flags=tB ")) {
+		t.Errorf("client info should contain the tracking flags: %q", info)
+	}
+
+	execArgs(m, c, "client", "tracking", "off")
+	execArgs(m, writer, "set", "user:2", "x")
+	sentinel()
+	if res := execArgs(m, c, "client", "getredirect"); !bytes.Equal(res, []byte(":-1\r\n")) {
+		t.Errorf("client getredirect reply error: %q", res)
+	}
+}
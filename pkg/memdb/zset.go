@@ -8,11 +8,14 @@ import (
 )
 
 func RegisterZSetCommands() {
-	RegisterCommand("zadd", zAddZset)
+	RegisterCommand("zadd", zAddZset, -4, flagWrite|flagDenyOOM, catSortedSet, 1, 1, 1,
+		commandDoc{"Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", "1.2.0", "sorted-set"})
+	RegisterCommand("zscore", zScoreZset, 3, flagReadonly, catSortedSet, 1, 1, 1,
+		commandDoc{"Returns the score of a member in a sorted set.", "1.2.0", "sorted-set"})
 
 }
 
-func zAddZset(m *MemDb, cmd [][]byte) RESP.RedisData {
+func zAddZset(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
 	if strings.ToLower(string(cmd[0])) != "zadd" {
 		logger.Error("zAddZset Function: cmdName is not zadd")
 		return nil
@@ -43,5 +46,37 @@ func zAddZset(m *MemDb, cmd [][]byte) RESP.RedisData {
 		zset.Add(member, score)
 		res++
 	}
+	m.notify(notifyZSet, "zadd", key)
 	return RESP.MakeIntData(int64(res))
 }
+
+// zScoreZset returns the score of member as a double, null if the key or the member doesn't exist
+func zScoreZset(m *MemDb, c *Client, cmd [][]byte) RESP.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zscore" {
+		logger.Error("zScoreZset Function: cmdName is not zscore")
+		return RESP.MakeErrorData("Server error")
+	}
+	if len(cmd) != 3 {
+		return RESP.MakeErrorData("ERR wrong number of arguments for 'zscore' command")
+	}
+	key := string(cmd[1])
+	m.trackRead(c, key)
+	if !m.CheckTTL(key) {
+		return RESP.MakeBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return RESP.MakeBulkData(nil)
+	}
+	zset, ok := temp.(*ZSet)
+	if !ok {
+		return RESP.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	score, ok := zset.Score(string(cmd[2]))
+	if !ok {
+		return RESP.MakeBulkData(nil)
+	}
+	return RESP.MakeDoubleData(score)
+}
@@ -16,6 +16,7 @@ type ZSet struct {
 	header *zSetNode
 	level  int
 	length int
+	dict   map[string]float64 // member 到 score 的映射，用于按 member 查找
 }
 
 func NewZSetNode(level int, member string, score float64) *zSetNode {
@@ -26,11 +27,17 @@ func NewZSetNode(level int, member string, score float64) *zSetNode {
 	}
 }
 
+// less reports whether the node is ordered before member with score, nodes are ordered by score, then by member
+func (n *zSetNode) less(member string, score float64) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
 func NewZSet() *ZSet {
 	return &ZSet{
 		header: NewZSetNode(MaxLevel, "", 0),
 		level:  1,
 		length: 0, // 初始化长度为 0
+		dict:   make(map[string]float64),
 	}
 }
 
@@ -42,11 +49,20 @@ func randomLevel() int {
 	}
 	return level
 }
+
+// Add inserts member with score, the score of an existing member is updated.
 func (z *ZSet) Add(member string, score float64) {
+	if old, ok := z.dict[member]; ok {
+		if old == score {
+			return
+		}
+		z.Remove(member, old)
+	}
+	z.dict[member] = score
 	update := make([]*zSetNode, MaxLevel)
 	current := z.header
 	for i := z.level - 1; i >= 0; i-- {
-		for current.forward[i] != nil && current.forward[i].score < score {
+		for current.forward[i] != nil && current.forward[i].less(member, score) {
 			current = current.forward[i]
 		}
 		update[i] = current
@@ -66,24 +82,23 @@ func (z *ZSet) Add(member string, score float64) {
 	z.length++
 }
 func (z *ZSet) Get(member string) float64 {
-	current := z.header
-	for i := z.level - 1; i >= 0; i-- {
-		for current.forward[i] != nil && current.forward[i].member < member {
-			current = current.forward[i]
-		}
-	}
-	current = current.forward[0]
-	if current != nil && current.member == member {
-		return current.score
+	if score, ok := z.dict[member]; ok {
+		return score
 	}
 	return -1
 }
+
+// Score returns the score of member, and whether member exists
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
 func (z *ZSet) Remove(member string, score float64) bool {
 	update := make([]*zSetNode, MaxLevel)
 	current := z.header
 
 	for i := z.level - 1; i >= 0; i-- {
-		for current.forward[i] != nil && current.forward[i].score < score {
+		for current.forward[i] != nil && current.forward[i].less(member, score) {
 			current = current.forward[i]
 		}
 		update[i] = current
@@ -104,6 +119,7 @@ func (z *ZSet) Remove(member string, score float64) bool {
 		}
 
 		z.length--
+		delete(z.dict, member)
 		return true
 	}
 
@@ -1,41 +1,114 @@
 package server
 
 import (
+	"errors"
 	"github.com/hsn/tiny-redis/pkg/RESP"
 	"github.com/hsn/tiny-redis/pkg/logger"
+	"github.com/hsn/tiny-redis/pkg/memdb"
 	"io"
 	"os"
-	"strings"
+	"time"
 )
 
-const aofPath = "aof"
 const aofFileSize = 10 << 10
 
-func (h *Handler) aofLogger(aofPath string) {
+// openAOF opens the AOF file for appending. It's opened before the AOF logger starts,
+// so the file doesn't depend on the working directory at the time the logger runs.
+func openAOF(aofPath string) *os.File {
 	f, err := os.OpenFile(aofPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		logger.Panic("Failed to open AOF file: ", err)
 	}
+	return f
+}
+
+// aofLogger writes the queued commands to the AOF file f until the handler is stopped, then closes f
+func (h *Handler) aofLogger(f *os.File) {
 	defer f.Close()
 
+	stats := h.memDb.Stats()
+	if info, err := f.Stat(); err == nil {
+		stats.SetAofEnabled(info.Size())
+	}
+	latency := h.memDb.LatencyMonitor()
 	for {
 		select {
 		case cmdBytes := <-h.aofChan:
-			_, err := f.Write(cmdBytes)
+			start := time.Now()
+			n, err := f.Write(cmdBytes)
+			latency.AddSample(memdb.LatencyAofWrite, time.Since(start))
+			stats.AofWritten(len(cmdBytes), n, err)
 			if err != nil {
 				logger.Error("Failed to write to AOF file: ", err)
 			}
+		case done := <-h.aofFlush:
+			done <- flushAOF(f, h.aofChan, latency, stats)
 		case <-h.stopCh:
 			logger.Info("AOF logger shutting down")
+			// the commands queued before the stop are kept, appendAOF doesn't queue any more
+			if err := flushAOF(f, h.aofChan, latency, stats); err != nil {
+				logger.Error("Failed to flush the AOF file: ", err)
+			}
 			return
 		}
 	}
 }
+
+// flushAOF writes the queued commands to f and fsyncs it, the fsync is sampled by the latency monitor and its histogram
+func flushAOF(f *os.File, queue chan []byte, latency *memdb.LatencyMonitor, stats *memdb.Stats) error {
+	for {
+		select {
+		case cmdBytes := <-queue:
+			n, err := f.Write(cmdBytes)
+			stats.AofWritten(len(cmdBytes), n, err)
+			if err != nil {
+				return err
+			}
+		default:
+			start := time.Now()
+			err := f.Sync()
+			duration := time.Since(start)
+			latency.AddSample(memdb.LatencyAofFsync, duration)
+			stats.AofFsynced(duration)
+			return err
+		}
+	}
+}
+
+// FlushAOF makes the AOF logger write the queued commands and fsync the AOF file
+func (h *Handler) FlushAOF() error {
+	done := make(chan error, 1)
+	select {
+	case h.aofFlush <- done:
+	case <-h.stopCh:
+		return errors.New("AOF logger is stopped")
+	}
+	return <-done
+}
+
+// appendAOF queues a command executed by memDb to be written to the AOF file.
+// The command is dropped once the AOF logger is stopped, the caller holds the locks of its keys and must not block.
+func (h *Handler) appendAOF(cmd [][]byte) {
+	args := make([]RESP.RedisData, len(cmd))
+	for i, arg := range cmd {
+		args[i] = RESP.MakeBulkData(arg)
+	}
+	data := RESP.MakeArrayData(args).ToBytes()
+	stats := h.memDb.Stats()
+	stats.AofQueued(len(data))
+	select {
+	case h.aofChan <- data:
+	case <-h.stopCh:
+		stats.AofQueued(-len(data))
+	}
+}
 func (h *Handler) StartAOF(aofPath string) {
-	go h.aofLogger(aofPath)
+	go h.aofLogger(openAOF(aofPath))
 }
 func (h *Handler) Stop() {
-	close(h.stopCh)
+	h.stopOnce.Do(func() {
+		close(h.stopCh)
+	})
 }
 
 func (h *Handler) loadAOF(aofPath string) {
@@ -50,72 +123,29 @@ func (h *Handler) loadAOF(aofPath string) {
 	defer f.Close()
 
 	logger.Info("Starting data recovery from AOF file")
+	stats := h.memDb.Stats()
+	stats.SetLoading(true)
+	defer stats.SetLoading(false)
 
-	ch := RESP.ParseStream(f)
+	reader := RESP.NewReader(f)
+	defer reader.Release()
+	client := memdb.NewFakeClient()
 
-	for parsedRes := range ch {
-		if parsedRes.Err != nil {
-			if parsedRes.Err == io.EOF {
+	for {
+		cmd, err := reader.ReadCommand()
+		if err != nil {
+			if err == io.EOF {
 				logger.Info("Finished reading the AOF file")
-				break
+			} else if err == io.ErrUnexpectedEOF {
+				logger.Warning("The AOF file is truncated, the last incomplete command is discarded")
 			} else {
-				logger.Error("Error parsing the AOF file: ", parsedRes.Err)
-				continue
+				// the rest of the file can't be parsed once it's malformed
+				logger.Error("Error parsing the AOF file: ", err)
 			}
+			break
 		}
-
-		if parsedRes.Data == nil {
-			logger.Error("Empty command in AOF file")
-			continue
-		}
-
-		arrayData, ok := parsedRes.Data.(*RESP.ArrayData)
-		if !ok {
-			logger.Error("Invalid command format in AOF file")
-			continue
-		}
-
-		cmd := arrayData.ToCommand()
-		h.memDb.ExecCommand(cmd)
+		h.memDb.ExecCommand(client, cmd)
 	}
 
 	logger.Info("AOF data recovery complete")
 }
-func IsWriteCommand(cmd [][]byte) bool {
-	if len(cmd) == 0 {
-		return false
-	}
-
-	switch strings.ToUpper(string(cmd[0])) {
-	// String commands
-	case "SET", "SETNX", "SETEX", "PSETEX", "DEL", "INCR", "DECR", "APPEND", "MSET", "MSETNX":
-		return true
-
-	// Hash commands
-	case "HSET", "HSETNX", "HDEL", "HINCRBY", "HINCRBYFLOAT", "HMSET":
-		return true
-
-	// List commands
-	case "LPUSH", "RPUSH", "LPOP", "RPOP", "LREM", "LSET", "LTRIM":
-		return true
-
-	// Set commands
-	case "SADD", "SREM", "SPOP", "SMOVE":
-		return true
-
-	// Sorted Set commands
-	case "ZADD", "ZREM", "ZINCRBY", "ZPOPMAX", "ZPOPMIN":
-		return true
-
-	// Generic commands
-	case "EXPIRE", "EXPIREAT", "PERSIST", "RENAME", "RENAMENX", "FLUSHDB", "FLUSHALL":
-		return true
-
-	// Transactional commands (since they modify state in the context of a transaction)
-	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
-		return true
-
-	default:
-		return false
-	}
-}
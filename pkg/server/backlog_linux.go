@@ -0,0 +1,45 @@
+package server
+
+import (
+	"errors"
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// somaxconnPath caps the backlog of every listening socket
+const somaxconnPath = "/proc/sys/net/core/somaxconn"
+
+// setBacklog changes the length of the queue of the pending connections of listener.
+// Go listens with the backlog of somaxconn, listening again on a listening socket only updates it on Linux.
+func setBacklog(listener net.Listener, backlog int) error {
+	sc, ok := listener.(syscall.Conn)
+	if !ok {
+		return errors.New("listener without file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err = raw.Control(func(fd uintptr) { listenErr = syscall.Listen(int(fd), backlog) }); err != nil {
+		return err
+	}
+	return listenErr
+}
+
+// checkBacklog warns like Redis if backlog can't be enforced because somaxconn is lower
+func checkBacklog(backlog int) {
+	data, err := os.ReadFile(somaxconnPath)
+	if err != nil {
+		return
+	}
+	somaxconn, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && somaxconn < backlog {
+		logger.Warning("WARNING: The TCP backlog setting of", backlog, "cannot be enforced because", somaxconnPath,
+			"is set to the lower value of", somaxconn)
+	}
+}
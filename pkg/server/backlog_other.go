@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+// setBacklog is a no-op, the listeners keep the backlog chosen by Go
+func setBacklog(listener net.Listener, backlog int) error {
+	return nil
+}
+
+func checkBacklog(backlog int) {}
@@ -1,74 +1,262 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/config"
 	"github.com/hsn/tiny-redis/pkg/logger"
 	"github.com/hsn/tiny-redis/pkg/memdb"
 	"io"
 	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// clientsCronInterval is the interval of checking the idle clients
+	clientsCronInterval = time.Second
+	// rejectWriteTimeout bounds the time spent replying to a connection rejected by maxclients
+	rejectWriteTimeout = time.Second
 )
 
 type Handler struct {
-	memDb   *memdb.MemDb
-	aofChan chan []byte   // Channel for AOF logging
-	stopCh  chan struct{} // Channel to signal shutdown
+	memDb      *memdb.MemDb
+	cfg        *config.Config  // configuration the handler was created with
+	aofChan    chan []byte     // Channel for AOF logging
+	aofFlush   chan chan error // Requests to flush and fsync the AOF file
+	stopCh     chan struct{}   // Channel to signal shutdown
+	stopOnce   sync.Once
+	replicator *Replicator // Active-active replication, nil if no peers are configured
+
+	mu        sync.Mutex
+	cond      *sync.Cond // signaled when a command finishes or the state of shutdown changes
+	inflight  int        // number of commands being executed
+	paused    bool       // commands wait until the shutdown is done or canceled
+	shutting  bool       // a shutdown is in progress
+	closed    bool       // the server has been shut down
+	abortCh   chan struct{}
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	done      chan struct{} // closed when the server has been shut down
+	exitErr   error         // error ignored by SHUTDOWN FORCE, it makes the process exit with a failure status
 }
 
 func NewHandler() *Handler {
 	handler := &Handler{
-		memDb:   memdb.NewMemDb(),
-		aofChan: make(chan []byte, 100), // Buffer AOF commands
-		stopCh:  make(chan struct{}),
+		memDb:    memdb.NewMemDb(),
+		cfg:      config.Configures,
+		aofChan:  make(chan []byte, 100), // Buffer AOF commands
+		aofFlush: make(chan chan error),
+		stopCh:   make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+	handler.cond = sync.NewCond(&handler.mu)
+	// the AOF file replays the ops of the replication
+	if len(config.Configures.Peers) > 0 {
+		handler.replicator = NewReplicator(config.Configures, handler.memDb)
+	}
+	handler.loadAOF(handler.cfg.AofFile)
+	handler.memDb.SetPropagator(handler.appendAOF)
+	handler.memDb.SetShutdown(handler.Shutdown)
+	go handler.aofLogger(openAOF(handler.cfg.AofFile))
+	go handler.memDb.ActiveExpire(handler.stopCh)
+	go handler.clientsCron()
+	if handler.replicator != nil {
+		handler.replicator.Start(handler.stopCh)
 	}
-	handler.loadAOF(aofPath)
-	go handler.aofLogger(aofPath)
 	return handler
 }
 
 func (h *Handler) Handle(conn net.Conn) {
+	if h.accept(conn) != nil {
+		_ = conn.Close()
+		return
+	}
 	defer func() {
+		h.removeConn(conn)
 		err := conn.Close()
-		if err != nil {
+		if err != nil && !h.isClosed() {
 			logger.Error(err)
 		}
 	}()
-	ch := RESP.ParseStream(conn)
-	for parsedRes := range ch {
-		if parsedRes.Err != nil {
-			if parsedRes.Err == io.EOF {
+	var certUser string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		cn, err := tlsHandshake(tlsConn)
+		if err != nil {
+			logger.Error("TLS handshake", conn.RemoteAddr().String(), "error: ", err.Error())
+			return
+		}
+		if config.Configures.TlsAuthClientsUser == "cn" {
+			certUser = cn
+		}
+	}
+	client := memdb.NewClient(conn)
+	h.memDb.AddClient(client)
+	defer h.memDb.CloseClient(client)
+	// a client whose certificate CN names no enabled user stays the default user
+	if certUser != "" && !h.memDb.AuthenticateUser(client, certUser) {
+		logger.Info("No enabled ACL user for the certificate CN", certUser, "of", conn.RemoteAddr().String())
+	}
+	// the replies are buffered and written once the input read so far has been executed
+	client.Cork()
+	reader := RESP.NewReader(flushingReader{Conn: conn, client: client, stats: h.memDb.Stats()})
+	defer reader.Release()
+	for {
+		reader.SetLimits(h.queryLimits())
+		cmd, err := reader.ReadCommand()
+		if err != nil {
+			if h.isClosed() {
+				return
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				logger.Info("Close connection", conn.RemoteAddr().String())
+			} else if client.Killed() {
+				logger.Info("Client killed", conn.RemoteAddr().String())
+			} else if err == RESP.ErrQueryBufferLimit {
+				logger.Warning("Closing client that reached max query buffer length", conn.RemoteAddr().String())
+			} else if errors.Is(err, RESP.ErrProtocol) {
+				// the rest of the input can't be parsed, the error is replied before closing the connection like Redis
+				logger.Error("Handle connection", conn.RemoteAddr().String(), "error: ", err.Error())
+				client.WriteData(RESP.MakeErrorData("ERR " + err.Error()))
 			} else {
-				logger.Panic("Handle connection", conn.RemoteAddr().String(), "panic: ", parsedRes.Err.Error())
+				// such as a connection reset by the client or an i/o timeout, the client is closed on return
+				logger.Warning("Close connection", conn.RemoteAddr().String(), "error: ", err.Error())
 			}
 			return
 		}
-		if parsedRes.Data == nil {
-			logger.Error("empty parsedRes.Data from ", conn.RemoteAddr().String())
+		if !h.waitPause(client, cmd) {
+			return
 		}
-		arrayData, ok := parsedRes.Data.(*RESP.ArrayData)
+		// the arguments point into the buffer of the reader, the executors copy the values they keep
+		res, ok := h.execute(client, cmd)
 		if !ok {
-			logger.Error("parsedRes.Data is not ArrayData from ", conn.RemoteAddr().String())
-			continue
+			return
 		}
-		cmd := arrayData.ToCommand()
-		res := h.memDb.ExecCommand(cmd)
-		if res != nil {
-			_, err := conn.Write(res.ToBytes())
-			if err != nil {
-				logger.Error("writer response to ", conn.RemoteAddr().String(), " error: ", err.Error())
-			}
-		} else {
-			errData := RESP.MakeErrorData("unknown error")
-			_, err := conn.Write(errData.ToBytes())
-			if err != nil {
-				logger.Error("writer response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+		if client.ReplyAllowed() {
+			client.WriteData(res)
+		}
+		if client.CloseAfterReply() {
+			logger.Info("Close connection", conn.RemoteAddr().String())
+			return
+		}
+	}
+}
+
+// accept registers a new connection and applies tcp-keepalive to it.
+// A connection beyond maxclients is rejected with an error reply and counted by rejected_connections.
+func (h *Handler) accept(conn net.Conn) error {
+	maxClients, _, keepalive := h.cfg.ClientLimits()
+	if err := h.addConn(conn, maxClients); err != nil {
+		if err == errMaxClients {
+			h.memDb.RejectConnection()
+			logger.Info("Reject connection", conn.RemoteAddr().String(), "max number of clients reached")
+			_ = conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+			_, _ = conn.Write([]byte("-" + err.Error() + "\r\n"))
+		}
+		return err
+	}
+	h.memDb.Stats().ConnectionReceived()
+	setKeepAlive(conn, keepalive)
+	return nil
+}
+
+// setKeepAlive enables TCP keepalive on conn, the peer is probed after period seconds of silence and 0 disables it.
+// Like Redis the connection is dropped after 3 unanswered probes sent every period/3 seconds.
+func setKeepAlive(conn net.Conn, period int) {
+	if wrapper, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapper.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period <= 0 {
+		_ = tcpConn.SetKeepAlive(false)
+		return
+	}
+	idle := time.Duration(period) * time.Second
+	_ = tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true, Idle: idle, Interval: max(idle/3, time.Second), Count: 3})
+}
+
+// clientsCron closes the clients idle for longer than timeout and samples the instantaneous metrics of INFO
+// every second until the handler is stopped
+func (h *Handler) clientsCron() {
+	ticker := time.NewTicker(clientsCronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+			h.memDb.Stats().Sample()
+			if _, timeout, _ := h.cfg.ClientLimits(); timeout > 0 {
+				if closed := h.memDb.CloseIdleClients(time.Duration(timeout) * time.Second); closed > 0 {
+					logger.Info("Closed", closed, "idle clients")
+				}
 			}
 		}
-		// Log write commands to AOF
-		if IsWriteCommand(cmd) {
-			go func() {
-				h.aofChan <- arrayData.ToBytes()
-			}()
+	}
+}
+
+// queryLimits returns the limits of the commands read from the clients
+func (h *Handler) queryLimits() RESP.Limits {
+	limits := RESP.DefaultLimits
+	if maxBulkLen, maxQueryBuffer := h.cfg.QueryLimits(); maxBulkLen > 0 {
+		limits.MaxBulkLen, limits.MaxQueryBuffer = maxBulkLen, maxQueryBuffer
+	}
+	return limits
+}
+
+// waitPause holds cmd of client while it's paused by CLIENT PAUSE, it returns false if the server is stopped
+func (h *Handler) waitPause(client *memdb.Client, cmd [][]byte) bool {
+	for {
+		changed := h.memDb.PauseWait(client, cmd)
+		if changed == nil {
+			return true
+		}
+		// the replies of the commands executed before are not held
+		client.Uncork()
+		select {
+		case <-changed:
+			client.Cork()
+		case <-h.stopCh:
+			return false
 		}
 	}
 }
+
+// execute runs cmd for client and returns its reply.
+// It returns false if the server is shut down, a successful SHUTDOWN has no reply.
+func (h *Handler) execute(client *memdb.Client, cmd [][]byte) (RESP.RedisData, bool) {
+	if !h.beginCommand() {
+		return nil, false
+	}
+	res := h.memDb.ExecCommand(client, cmd)
+	h.endCommand()
+	if h.isClosed() {
+		return nil, false
+	}
+	if res == nil {
+		res = RESP.MakeErrorData("unknown error")
+	}
+	return res, true
+}
+
+// flushingReader writes the buffered replies of a client before waiting for more input,
+// so that the replies to pipelined commands are written with a single syscall.
+// The input read is counted by INFO stats.
+type flushingReader struct {
+	net.Conn
+	client *memdb.Client
+	stats  *memdb.Stats
+}
+
+func (r flushingReader) Read(p []byte) (int, error) {
+	r.client.Uncork()
+	n, err := r.Conn.Read(p)
+	r.client.Cork()
+	r.stats.AddNetInput(n)
+	return n, err
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestServer serves a new handler on a random local port, by the event loops if epoll is set.
+// It returns the address of the server.
+func startTestServer(t testing.TB, epoll bool) string {
+	t.Helper()
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	return serveTestHandler(t, newTestHandler(t, cfg), epoll)
+}
+
+// serveTestHandler serves h on a random local port, by the event loops if epoll is set
+func serveTestHandler(t testing.TB, h *Handler, epoll bool) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AddListener(listener)
+	t.Cleanup(func() { _ = listener.Close() })
+	var sg sync.WaitGroup
+	if !epoll {
+		go serve(listener, h, &sg)
+		return listener.Addr().String()
+	}
+	r, err := newReactor(h, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	r.run(&sg)
+	go r.serve(listener)
+	return listener.Addr().String()
+}
+
+func dialTCP(t testing.TB, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// bulk sends a command replied by a bulk string and returns the string
+func (c *testConn) bulk(t testing.TB, args ...string) string {
+	t.Helper()
+	res, err := c.do(t, args...)
+	if err != nil || !strings.HasPrefix(res, "$") {
+		t.Fatalf("%v reply error: %q %v", args, res, err)
+	}
+	size, _ := strconv.Atoi(strings.TrimSpace(res[1:]))
+	data := make([]byte, size+2)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		t.Fatal(err)
+	}
+	return string(data[:size])
+}
+
+func TestInlineAndProtocolErrors(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	for _, model := range []string{"goroutine", "epoll"} {
+		t.Run(model, func(t *testing.T) {
+			addr := serveTestHandler(t, h, model == "epoll")
+			c := dialTCP(t, addr)
+			if _, err := c.Write([]byte("PING\r\nset k \"a b\"\n\r\nget k\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			for _, expect := range []string{"+PONG\r\n", "+OK\r\n", "$3\r\n", "a b\r\n"} {
+				if line, err := c.r.ReadString('\n'); err != nil || line != expect {
+					t.Errorf("inline reply error: %q %v | expect: %q", line, err, expect)
+				}
+			}
+			if _, err := c.Write([]byte("set k \"v\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			if line, err := c.r.ReadString('\n'); err != nil || line != "-ERR Protocol error: unbalanced quotes in request\r\n" {
+				t.Errorf("protocol error reply error: %q %v", line, err)
+			}
+			if _, err := c.r.ReadString('\n'); err != io.EOF {
+				t.Errorf("the connection should be closed after a protocol error: %v", err)
+			}
+
+			// proto-max-bulk-len rejects larger arguments before they are read
+			c = dialTCP(t, addr)
+			if res, err := c.do(t, "config", "set", "proto-max-bulk-len", "1mb"); err != nil || res != "+OK\r\n" {
+				t.Errorf("config set reply error: %q %v", res, err)
+			}
+			if _, err := c.Write([]byte("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$2000000\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			if line, err := c.r.ReadString('\n'); err != nil || line != "-ERR Protocol error: invalid bulk length\r\n" {
+				t.Errorf("protocol error reply error: %q %v", line, err)
+			}
+
+			// a client sending more than client-query-buffer-limit without completing a command is closed
+			c = dialTCP(t, addr)
+			if res, err := c.do(t, "config", "set", "proto-max-bulk-len", "512mb"); err != nil || res != "+OK\r\n" {
+				t.Errorf("config set reply error: %q %v", res, err)
+			}
+			if res, err := c.do(t, "config", "set", "client-query-buffer-limit", "1mb"); err != nil || res != "+OK\r\n" {
+				t.Errorf("config set reply error: %q %v", res, err)
+			}
+			go func() {
+				_, _ = c.Write([]byte("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$2000000\r\n" + strings.Repeat("x", 3<<19)))
+			}()
+			if line, err := c.r.ReadString('\n'); err == nil {
+				t.Errorf("the connection should be closed without reply: %q", line)
+			}
+			if err := config.Configures.Set("client-query-buffer-limit", "1gb"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPipelinedValuesAreKept(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	for _, model := range []string{"goroutine", "epoll"} {
+		t.Run(model, func(t *testing.T) {
+			c := dialTCP(t, serveTestHandler(t, h, model == "epoll"))
+			// the arguments point into the input buffer, which is refilled many times by the pipeline
+			var req strings.Builder
+			const n = 2000
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(&req, "set %s-k%d v%d\r\nhset %s-h f%d x%d\r\n", model, i, i, model, i, i)
+			}
+			fmt.Fprintf(&req, "multi\r\nset %s-m queued\r\nappend %s-a xyz\r\nexec\r\n", model, model)
+			if _, err := c.Write([]byte(req.String())); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2*n+6; i++ {
+				if _, err := c.r.ReadString('\n'); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, i := range []int{0, n / 2, n - 1} {
+				if v := c.bulk(t, "get", fmt.Sprintf("%s-k%d", model, i)); v != "v"+strconv.Itoa(i) {
+					t.Errorf("get %s-k%d: %q", model, i, v)
+				}
+				if v := c.bulk(t, "hget", model+"-h", "f"+strconv.Itoa(i)); v != "x"+strconv.Itoa(i) {
+					t.Errorf("hget %s-h f%d: %q", model, i, v)
+				}
+			}
+			if v := c.bulk(t, "get", model+"-m"); v != "queued" {
+				t.Errorf("get %s-m: %q", model, v)
+			}
+			if v := c.bulk(t, "get", model+"-a"); v != "xyz" {
+				t.Errorf("get %s-a: %q", model, v)
+			}
+		})
+	}
+}
+
+// connCount returns the number of the connections registered by h
+func connCount(h *Handler) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+func TestClientLimits(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	rejected := 0
+	for _, model := range []string{"goroutine", "epoll"} {
+		t.Run(model, func(t *testing.T) {
+			// the connections of the previous model are closed in the background
+			for i := 0; i < 100 && connCount(h) > 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			addr := serveTestHandler(t, h, model == "epoll")
+			c := dialTCP(t, addr)
+			if res, err := c.do(t, "config", "set", "maxclients", "2"); err != nil || res != "+OK\r\n" {
+				t.Fatalf("config set reply error: %q %v", res, err)
+			}
+			defer func() { _ = config.Configures.Set("maxclients", strconv.Itoa(config.DefaultMaxClients)) }()
+			if res, err := dialTCP(t, addr).do(t, "ping"); err != nil || res != "+PONG\r\n" {
+				t.Errorf("the second client should be accepted: %q %v", res, err)
+			}
+			// the rejected connection is closed after the error reply
+			line, err := dialTCP(t, addr).r.ReadString('\n')
+			if err != nil || line != "-ERR max number of clients reached\r\n" {
+				t.Errorf("the third client should be rejected: %q %v", line, err)
+			}
+			rejected++
+
+			info := c.bulk(t, "info")
+			for _, field := range []string{"\r\nconnected_clients:2\r\n", "\r\nmaxclients:2\r\n", fmt.Sprintf("\r\nrejected_connections:%d\r\n", rejected)} {
+				if !strings.Contains(info, field) {
+					t.Errorf("info should contain %q", field)
+				}
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		addr := serveTestHandler(t, h, false)
+		c := dialTCP(t, addr)
+		if res, err := c.do(t, "config", "set", "timeout", "1"); err != nil || res != "+OK\r\n" {
+			t.Fatalf("config set reply error: %q %v", res, err)
+		}
+		defer func() { _ = config.Configures.Set("timeout", "0") }()
+		start := time.Now()
+		if line, err := c.r.ReadString('\n'); err != io.EOF {
+			t.Errorf("the idle client should be closed: %q %v", line, err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("the client is closed after %v, before timeout", elapsed)
+		}
+	})
+}
+
+func TestClientOutputBufferLimit(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	for _, model := range []string{"goroutine", "epoll"} {
+		t.Run(model, func(t *testing.T) {
+			addr := serveTestHandler(t, h, model == "epoll")
+			c := dialTCP(t, addr)
+			if res, err := c.do(t, "config", "set", "client-output-buffer-limit", "pubsub 1mb 0 0"); err != nil || res != "+OK\r\n" {
+				t.Fatalf("config set reply error: %q %v", res, err)
+			}
+			defer func() {
+				_, _ = c.do(t, "config", "set", "client-output-buffer-limit", "pubsub 32mb 8mb 60")
+			}()
+			// the subscriber never reads the messages
+			subscriber := dialTCP(t, addr)
+			if _, err := subscriber.Write([]byte("subscribe news\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100 && c.bulk(t, "client", "list", "type", "pubsub") == ""; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// the messages are far larger than the socket buffers, so the output is buffered by the server
+			msg := strings.Repeat("x", 32<<10)
+			omem := false
+			closed := false
+			for i := 0; i < 1024 && !closed; i++ {
+				res, err := c.do(t, "publish", "news", msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				closed = res == ":0\r\n"
+				if !closed && !omem {
+					omem = !strings.Contains(c.bulk(t, "client", "list", "type", "pubsub"), " omem=0 ")
+				}
+			}
+			if !omem {
+				t.Error("the buffered messages should be reported by omem")
+			}
+			if !closed {
+				t.Fatal("the subscriber exceeding the hard limit should be closed")
+			}
+		})
+	}
+}
+
+func TestMonitorOutputBufferLimit(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	for _, model := range []string{"goroutine", "epoll"} {
+		t.Run(model, func(t *testing.T) {
+			addr := serveTestHandler(t, h, model == "epoll")
+			c := dialTCP(t, addr)
+			if res, err := c.do(t, "config", "set", "client-output-buffer-limit", "pubsub 1mb 0 0"); err != nil || res != "+OK\r\n" {
+				t.Fatalf("config set reply error: %q %v", res, err)
+			}
+			defer func() {
+				_, _ = c.do(t, "config", "set", "client-output-buffer-limit", "pubsub 32mb 8mb 60")
+			}()
+			// the monitor never reads the commands it is fed
+			monitor := dialTCP(t, addr)
+			if _, err := monitor.Write([]byte("monitor\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100 && !strings.Contains(c.bulk(t, "client", "list"), " flags=O "); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// the values are far larger than the socket buffers, so the output is buffered by the server
+			value := strings.Repeat("x", 32<<10)
+			closed := false
+			for i := 0; i < 1024 && !closed; i++ {
+				if res, err := c.do(t, "set", "monitored-"+model, value); err != nil || res != "+OK\r\n" {
+					t.Fatalf("set reply error: %q %v", res, err)
+				}
+				closed = !strings.Contains(c.bulk(t, "client", "list"), " flags=O ")
+			}
+			if !closed {
+				t.Fatal("the monitor exceeding the hard limit of pubsub should be closed")
+			}
+		})
+	}
+}
+
+func TestClientPauseAndReply(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	for _, model := range []string{"goroutine", "epoll"} {
+		t.Run(model, func(t *testing.T) {
+			addr := serveTestHandler(t, h, model == "epoll")
+			admin, c := dialTCP(t, addr), dialTCP(t, addr)
+			if res, err := admin.do(t, "client", "pause", "60000", "write"); err != nil || res != "+OK\r\n" {
+				t.Fatalf("client pause reply error: %q %v", res, err)
+			}
+			// the write is held, the read pipelined after it waits for it
+			key := "paused-" + model
+			if _, err := c.Write([]byte("get " + key + "\r\nset " + key + " v\r\nget " + key + "\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			if line, err := c.r.ReadString('\n'); err != nil || line != "$-1\r\n" {
+				t.Errorf("the read before the write should be replied: %q %v", line, err)
+			}
+			_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			if line, err := c.r.ReadString('\n'); err == nil {
+				t.Errorf("the write should be held by the pause: %q", line)
+			}
+			_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+			if res, err := admin.do(t, "client", "unpause"); err != nil || res != "+OK\r\n" {
+				t.Errorf("client unpause reply error: %q %v", res, err)
+			}
+			for _, expect := range []string{"+OK\r\n", "$1\r\n", "v\r\n"} {
+				if line, err := c.r.ReadString('\n'); err != nil || line != expect {
+					t.Errorf("reply after unpause error: %q %v | expect: %q", line, err, expect)
+				}
+			}
+
+			// a pause of ALL holds every command until it expires
+			if res, err := admin.do(t, "client", "pause", "200"); err != nil || res != "+OK\r\n" {
+				t.Fatalf("client pause reply error: %q %v", res, err)
+			}
+			start := time.Now()
+			if res, err := c.do(t, "ping"); err != nil || res != "+PONG\r\n" {
+				t.Errorf("ping reply error: %q %v", res, err)
+			}
+			if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+				t.Errorf("ping should be held until the pause expires, replied after %v", elapsed)
+			}
+
+			// OFF and SKIP suppress their own replies, ON is replied
+			if _, err := c.Write([]byte("client reply off\r\nping\r\nclient reply on\r\nclient reply skip\r\nping\r\nget missing\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			for _, expect := range []string{"+OK\r\n", "$-1\r\n"} {
+				if line, err := c.r.ReadString('\n'); err != nil || line != expect {
+					t.Errorf("client reply error: %q %v | expect: %q", line, err, expect)
+				}
+			}
+		})
+	}
+}
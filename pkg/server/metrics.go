@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metricsReadTimeout bounds the time spent reading a scrape request
+const metricsReadTimeout = 5 * time.Second
+
+// metricsHandler serves the metrics of the server of h on /metrics in the text exposition format of Prometheus
+func metricsHandler(h *Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := h.memDb.WriteMetrics(w); err != nil {
+			logger.Warning("metrics scrape from", r.RemoteAddr, "error:", err.Error())
+		}
+	})
+	return mux
+}
+
+// serveMetrics serves the metrics on listener until it's closed by the shutdown of h
+func serveMetrics(listener net.Listener, h *Handler) {
+	srv := &http.Server{Handler: metricsHandler(h), ReadHeaderTimeout: metricsReadTimeout}
+	if err := srv.Serve(listener); err != nil && !h.isClosed() {
+		logger.Error("metrics:", err.Error())
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/hsn/tiny-redis/pkg/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	c := dialTCP(t, serveTestHandler(t, h, false))
+	for _, args := range [][]string{{"set", "k", "v"}, {"get", "k"}, {"get", "missing"}, {"expire", "k", "100"},
+		{"hset", "h", "f", "v"}, {"incr", "k"}, {"sadd", "s", "m"}, {"set", "s2", "v"}, {"del", "s2"}} {
+		if _, err := c.do(t, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.FlushAOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(metricsHandler(h))
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("scrape status %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	metrics := string(body)
+	for _, pattern := range []string{
+		`(?m)^# TYPE redis_connected_clients gauge\nredis_connected_clients 1$`,
+		`(?m)^redis_commands_total\{cmd="get"\} 2$`,
+		`(?m)^redis_commands_failed_calls_total\{cmd="incr"\} 1$`,
+		`(?m)^redis_errors_total\{err="ERR"\} 1$`,
+		`(?m)^redis_command_duration_seconds_bucket\{cmd="set",le="\+Inf"\} 2$`,
+		`(?m)^redis_command_duration_seconds_count\{cmd="set"\} 2$`,
+		`(?m)^redis_keys\{type="string"\} 1$`,
+		`(?m)^redis_keys\{type="hash"\} 1$`,
+		`(?m)^redis_keys\{type="set"\} 1$`,
+		`(?m)^redis_keys\{type="list"\} 0$`,
+		`(?m)^redis_expiring_keys 1$`,
+		`(?m)^redis_keyspace_hits_total 1$`,
+		`(?m)^redis_keyspace_misses_total 1$`,
+		`(?m)^redis_aof_fsync_duration_seconds_count 1$`,
+		`(?m)^redis_aof_current_size_bytes [1-9]\d*$`,
+		`(?m)^go_goroutines [1-9]\d*$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(metrics) {
+			t.Errorf("metrics should match %q:\n%s", pattern, metrics)
+		}
+	}
+	// there is no eviction to count
+	if strings.Contains(metrics, "redis_evicted_keys_total") {
+		t.Error("redis_evicted_keys_total should not be exported")
+	}
+	// every sample belongs to the family declared before it
+	family := ""
+	for _, line := range strings.Split(strings.TrimSuffix(metrics, "\n"), "\n") {
+		if name, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			family, _, _ = strings.Cut(name, " ")
+			continue
+		}
+		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, family) {
+			t.Errorf("sample %q out of the family %s", line, family)
+		}
+	}
+
+	if res, err = http.Post(srv.URL+"/metrics", "text/plain", nil); err != nil || res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST should not be allowed: %v", err)
+	}
+}
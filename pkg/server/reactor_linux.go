@@ -0,0 +1,487 @@
+package server
+
+import (
+	"errors"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"github.com/hsn/tiny-redis/pkg/memdb"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// reactorReadSize is the size of the read buffer shared by the connections of an event loop
+	reactorReadSize = 64 << 10
+	// maxReadsPerEvent bounds the reads of a connection per event, so that a busy client can't starve the others
+	maxReadsPerEvent = 16
+	maxEpollEvents   = 256
+)
+
+// reactor serves connections with a few epoll event loops instead of a goroutine per connection.
+// A connection is assigned to a loop when it's accepted, the loop reads and executes its commands,
+// and the replies are written by the client writer which only runs while there is output.
+// An idle connection costs no goroutine, only its client and the bytes of an incomplete command.
+type reactor struct {
+	handler *Handler
+	loops   []*eventLoop
+	next    atomic.Uint32
+}
+
+// eventLoop waits for readable connections with epoll and serves them one by one
+type eventLoop struct {
+	handler *Handler
+	epfd    int
+	wakeR   int // the read end of the pipe waking up epoll_wait
+	wakeW   int
+	buf     []byte // read buffer shared by the connections of the loop
+
+	mu      sync.Mutex
+	conns   map[int]*reactorConn
+	closing []*reactorConn // connections closed by other goroutines, they are released by the loop
+	resumed []*reactorConn // paused connections whose pause has changed, they are processed again by the loop
+	stopped bool
+}
+
+// reactorConn is a connection served by an event loop.
+// Closing it asks the loop to deregister the fd before the fd is closed, so that the fd can't be reused meanwhile.
+type reactorConn struct {
+	net.Conn
+	fd      int
+	loop    *eventLoop
+	client  *memdb.Client
+	pending []byte // bytes of an incomplete command, or of the commands held by CLIENT PAUSE
+	paused  bool   // a command is held by CLIENT PAUSE, the input is kept in pending until the loop resumes it
+	closing bool   // guarded by loop.mu
+}
+
+func newReactor(handler *Handler, loops int) (*reactor, error) {
+	if loops <= 0 {
+		loops = runtime.GOMAXPROCS(0)
+	}
+	r := &reactor{handler: handler}
+	for i := 0; i < loops; i++ {
+		l, err := newEventLoop(handler)
+		if err != nil {
+			for _, l := range r.loops {
+				l.release()
+			}
+			return nil, err
+		}
+		r.loops = append(r.loops, l)
+	}
+	return r, nil
+}
+
+func newEventLoop(handler *Handler) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var pipe [2]int
+	if err = syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, err
+	}
+	l := &eventLoop{
+		handler: handler,
+		epfd:    epfd,
+		wakeR:   pipe[0],
+		wakeW:   pipe[1],
+		buf:     make([]byte, reactorReadSize),
+		conns:   make(map[int]*reactorConn),
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(l.wakeR)}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, l.wakeR, &event); err != nil {
+		l.release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// run starts the event loops, they exit once the handler is stopped
+func (r *reactor) run(sg *sync.WaitGroup) {
+	for _, l := range r.loops {
+		sg.Add(1)
+		go func(l *eventLoop) {
+			defer sg.Done()
+			l.run()
+		}(l)
+	}
+	go func() {
+		<-r.handler.stopCh
+		for _, l := range r.loops {
+			l.wake()
+		}
+	}()
+}
+
+// serve accepts the connections of listener until it's closed, each connection is served by an event loop
+func (r *reactor) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !r.handler.isClosed() {
+				logger.Error(err.Error())
+			}
+			return
+		}
+		logger.Info(conn.RemoteAddr().String(), " connected")
+		l := r.loops[r.next.Add(1)%uint32(len(r.loops))]
+		if err = l.register(conn); err != nil {
+			// a connection rejected by maxclients has been logged by accept
+			if err != errMaxClients {
+				logger.Error("Register connection", conn.RemoteAddr().String(), "error: ", err.Error())
+			}
+			_ = conn.Close()
+		}
+	}
+}
+
+// register adds conn to the loop
+func (l *eventLoop) register(conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New("connection without file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	if err = raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return err
+	}
+	rc := &reactorConn{Conn: conn, fd: fd, loop: l}
+	if err = l.handler.accept(rc); err != nil {
+		return err
+	}
+	rc.client = memdb.NewClient(rc)
+	l.handler.memDb.AddClient(rc.client)
+
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		l.handler.removeConn(rc)
+		l.handler.memDb.CloseClient(rc.client)
+		return errors.New("event loop is stopped")
+	}
+	l.conns[fd] = rc
+	l.mu.Unlock()
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	if err = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		l.mu.Lock()
+		delete(l.conns, fd)
+		l.mu.Unlock()
+		l.handler.removeConn(rc)
+		l.handler.memDb.CloseClient(rc.client)
+		return err
+	}
+	return nil
+}
+
+func (l *eventLoop) run() {
+	events := make([]syscall.EpollEvent, maxEpollEvents)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err != nil && err != syscall.EINTR {
+			logger.Error("epoll_wait error: ", err.Error())
+			break
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wakeR {
+				l.drainWake()
+				continue
+			}
+			l.mu.Lock()
+			rc := l.conns[fd]
+			l.mu.Unlock()
+			if rc != nil {
+				l.serveConn(rc)
+			}
+		}
+		l.releaseClosing()
+		l.processResumed()
+		if l.isStopping() {
+			break
+		}
+	}
+	l.stop()
+}
+
+func (l *eventLoop) isStopping() bool {
+	select {
+	case <-l.handler.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// serveConn reads the available input of rc and executes its complete commands
+func (l *eventLoop) serveConn(rc *reactorConn) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Panic("Recovered from panic in event loop: ", r)
+			l.closeConn(rc)
+		}
+	}()
+	for i := 0; i < maxReadsPerEvent; i++ {
+		n, err := syscall.Read(rc.fd, l.buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return
+		}
+		if n <= 0 || err != nil {
+			if !rc.isClosing() {
+				logger.Info("Close connection", rc.RemoteAddr().String())
+			}
+			l.closeConn(rc)
+			return
+		}
+		l.handler.memDb.Stats().AddNetInput(n)
+		// the replies to the commands of a read are written together
+		rc.client.Cork()
+		ok := l.process(rc, l.buf[:n])
+		rc.client.Uncork()
+		if !ok {
+			l.closeConn(rc)
+			return
+		}
+		if n < len(l.buf) {
+			return
+		}
+	}
+}
+
+// process executes the complete commands of the input read from rc, the rest is kept until more input arrives.
+// It returns false if the connection should be closed.
+func (l *eventLoop) process(rc *reactorConn, input []byte) bool {
+	data := input
+	if len(rc.pending) > 0 {
+		rc.pending = append(rc.pending, input...)
+		data = rc.pending
+	}
+	limits := l.handler.queryLimits()
+	pos := 0
+	for pos < len(data) && !rc.paused {
+		if rc.isClosing() {
+			return false
+		}
+		cmd, n, err := RESP.ParseCommand(data[pos:], limits.MaxBulkLen)
+		if err != nil {
+			logger.Error("Handle connection", rc.RemoteAddr().String(), "error: ", err.Error())
+			rc.client.WriteData(RESP.MakeErrorData("ERR " + err.Error()))
+			return false
+		}
+		if n == 0 {
+			break
+		}
+		if len(cmd) == 0 {
+			pos += n
+			continue
+		}
+		// a held command would block the loop, it's kept with the rest of the input until the pause changes
+		if changed := l.handler.memDb.PauseWait(rc.client, cmd); changed != nil {
+			rc.paused = true
+			go l.resumeAfter(rc, changed)
+			break
+		}
+		pos += n
+		// the arguments point into the read buffer, the executors copy the values they keep
+		res, ok := l.handler.execute(rc.client, cmd)
+		if !ok {
+			return false
+		}
+		if rc.client.ReplyAllowed() {
+			rc.client.WriteData(res)
+		}
+		if rc.client.CloseAfterReply() {
+			logger.Info("Close connection", rc.RemoteAddr().String())
+			return false
+		}
+	}
+	rest := data[pos:]
+	if limits.MaxQueryBuffer > 0 && int64(len(rest)) >= limits.MaxQueryBuffer {
+		logger.Warning("Closing client that reached max query buffer length", rc.RemoteAddr().String())
+		return false
+	}
+	switch {
+	case len(rest) == 0:
+		rc.pending = nil
+	case len(rc.pending) > 0:
+		rc.pending = rc.pending[:copy(rc.pending, rest)]
+	default:
+		rc.pending = append([]byte(nil), rest...)
+	}
+	return true
+}
+
+// resumeAfter asks the loop to process the input of the paused rc again once changed is closed
+func (l *eventLoop) resumeAfter(rc *reactorConn, changed <-chan struct{}) {
+	select {
+	case <-changed:
+	case <-l.handler.stopCh:
+		return
+	}
+	l.mu.Lock()
+	l.resumed = append(l.resumed, rc)
+	l.mu.Unlock()
+	l.wake()
+}
+
+// processResumed processes the input held by the connections resumed after a pause
+func (l *eventLoop) processResumed() {
+	l.mu.Lock()
+	resumed := l.resumed
+	l.resumed = nil
+	l.mu.Unlock()
+	for _, rc := range resumed {
+		l.mu.Lock()
+		registered := l.conns[rc.fd] == rc
+		l.mu.Unlock()
+		if registered {
+			l.resume(rc)
+		}
+	}
+}
+
+// resume processes the input held by rc
+func (l *eventLoop) resume(rc *reactorConn) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Panic("Recovered from panic in event loop: ", r)
+			l.closeConn(rc)
+		}
+	}()
+	rc.paused = false
+	rc.client.Cork()
+	ok := l.process(rc, nil)
+	rc.client.Uncork()
+	if !ok {
+		l.closeConn(rc)
+	}
+}
+
+// Close asks the event loop to release the connection, it's used by CLIENT KILL and the shutdown
+func (rc *reactorConn) Close() error {
+	l := rc.loop
+	l.mu.Lock()
+	if rc.closing {
+		l.mu.Unlock()
+		return nil
+	}
+	rc.closing = true
+	if l.stopped {
+		l.mu.Unlock()
+		go l.releaseConn(rc)
+		return nil
+	}
+	l.closing = append(l.closing, rc)
+	l.mu.Unlock()
+	l.wake()
+	return nil
+}
+
+// NetConn returns the underlying connection, like tls.Conn
+func (rc *reactorConn) NetConn() net.Conn {
+	return rc.Conn
+}
+
+func (rc *reactorConn) isClosing() bool {
+	rc.loop.mu.Lock()
+	defer rc.loop.mu.Unlock()
+	return rc.closing
+}
+
+// closeConn releases a connection closed by the loop itself
+func (l *eventLoop) closeConn(rc *reactorConn) {
+	l.mu.Lock()
+	if l.conns[rc.fd] != rc {
+		l.mu.Unlock()
+		return
+	}
+	rc.closing = true
+	delete(l.conns, rc.fd)
+	l.mu.Unlock()
+	_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, rc.fd, nil)
+	go l.releaseConn(rc)
+}
+
+// releaseClosing releases the connections closed by other goroutines
+func (l *eventLoop) releaseClosing() {
+	l.mu.Lock()
+	var closing []*reactorConn
+	for _, rc := range l.closing {
+		// a connection may be closed by the loop too
+		if l.conns[rc.fd] == rc {
+			delete(l.conns, rc.fd)
+			closing = append(closing, rc)
+		}
+	}
+	l.closing = nil
+	l.mu.Unlock()
+	for _, rc := range closing {
+		_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, rc.fd, nil)
+		go l.releaseConn(rc)
+	}
+}
+
+// releaseConn waits for the pending output of the connection and closes it.
+// It runs in its own goroutine as a slow client would block the loop.
+func (l *eventLoop) releaseConn(rc *reactorConn) {
+	l.handler.removeConn(rc)
+	l.handler.memDb.CloseClient(rc.client)
+	if err := rc.Conn.Close(); err != nil && !l.handler.isClosed() {
+		logger.Error(err)
+	}
+}
+
+// stop releases all connections of the loop and its file descriptors
+func (l *eventLoop) stop() {
+	l.mu.Lock()
+	l.stopped = true
+	conns := make([]*reactorConn, 0, len(l.conns))
+	for _, rc := range l.conns {
+		rc.closing = true
+		conns = append(conns, rc)
+	}
+	l.conns = make(map[int]*reactorConn)
+	l.closing = nil
+	l.mu.Unlock()
+	for _, rc := range conns {
+		_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, rc.fd, nil)
+		go l.releaseConn(rc)
+	}
+	l.release()
+}
+
+func (l *eventLoop) release() {
+	_ = syscall.Close(l.wakeR)
+	_ = syscall.Close(l.wakeW)
+	_ = syscall.Close(l.epfd)
+}
+
+// wake interrupts epoll_wait, a full pipe already wakes it up
+func (l *eventLoop) wake() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the pipe is closed once the loop is stopped
+	if !l.stopped {
+		_, _ = syscall.Write(l.wakeW, []byte{0})
+	}
+}
+
+func (l *eventLoop) drainWake() {
+	var buf [64]byte
+	for {
+		if n, err := syscall.Read(l.wakeR, buf[:]); n <= 0 || err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReactor(t *testing.T) {
+	addr := startTestServer(t, true)
+	a, b := dialTCP(t, addr), dialTCP(t, addr)
+	if res, err := a.do(t, "set", "k", "v"); err != nil || res != "+OK\r\n" {
+		t.Errorf("set reply error: %q %v", res, err)
+	}
+	if res, err := b.do(t, "get", "k"); err != nil || res != "$1\r\n" {
+		t.Errorf("get reply error: %q %v", res, err)
+	}
+	_, _ = b.r.ReadString('\n')
+
+	// a command split across reads is executed once it's complete, pipelined commands are all executed
+	if _, err := a.Write([]byte("*2\r\n$3\r\nget\r\n$1")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := a.Write([]byte("\r\nk\r\n*1\r\n$4\r\nping\r\n*1\r\n$4\r\nping\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"$1\r\n", "v\r\n", "+PONG\r\n", "+PONG\r\n"} {
+		if line, err := a.r.ReadString('\n'); err != nil || line != expect {
+			t.Errorf("pipelined reply error: %q %v | expect: %q", line, err, expect)
+		}
+	}
+
+	// a value larger than the read buffer of the loop
+	value := strings.Repeat("x", 3*reactorReadSize+7)
+	if res, err := a.do(t, "set", "big", value); err != nil || res != "+OK\r\n" {
+		t.Errorf("set big reply error: %q %v", res, err)
+	}
+	if res, err := b.do(t, "strlen", "big"); err != nil || res != ":"+strconv.Itoa(len(value))+"\r\n" {
+		t.Errorf("strlen reply error: %q %v", res, err)
+	}
+
+	// CLIENT KILL closes a connection served by another goroutine
+	if res, err := a.do(t, "client", "kill", "addr", b.LocalAddr().String()); err != nil || res != ":1\r\n" {
+		t.Errorf("client kill reply error: %q %v", res, err)
+	}
+	if _, err := b.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("the killed connection should be closed: %v", err)
+	}
+
+	// a protocol error is replied before the connection is closed
+	c := dialTCP(t, addr)
+	if res, err := c.do(t, "ping"); err != nil || res != "+PONG\r\n" {
+		t.Errorf("ping reply error: %q %v", res, err)
+	}
+	if _, err := c.Write([]byte("*1\r\n:1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := c.r.ReadString('\n'); err != nil || line != "-ERR Protocol error: expected '$', got ':'\r\n" {
+		t.Errorf("protocol error reply error: %q %v", line, err)
+	}
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("the connection should be closed after a protocol error: %v", err)
+	}
+
+	if res, err := a.do(t, "quit"); err != nil || res != "+OK\r\n" {
+		t.Errorf("quit reply error: %q %v", res, err)
+	}
+	if _, err := a.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("the connection should be closed after QUIT: %v", err)
+	}
+
+	// the released clients are removed from CLIENT LIST
+	d := dialTCP(t, addr)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := d.do(t, "client", "list")
+		if err != nil || !strings.HasPrefix(res, "$") {
+			t.Fatalf("client list reply error: %q %v", res, err)
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(res[1:]))
+		list := make([]byte, size+2)
+		if _, err = io.ReadFull(d.r, list); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(list), "id=") == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the closed clients should be released: %q", list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// BenchmarkPing compares the round trips of concurrent clients served by a goroutine per connection and by event loops
+func BenchmarkPing(b *testing.B) {
+	for _, pipeline := range []int{1, 16} {
+		for _, model := range []string{"goroutine", "epoll"} {
+			b.Run(model+"/pipeline="+strconv.Itoa(pipeline), func(b *testing.B) {
+				addr := startTestServer(b, model == "epoll")
+				req := []byte(strings.Repeat("*1\r\n$4\r\nping\r\n", pipeline))
+				b.SetParallelism(8)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					defer conn.Close()
+					r := bufio.NewReader(conn)
+					for pb.Next() {
+						if _, err = conn.Write(req); err != nil {
+							b.Error(err)
+							return
+						}
+						for i := 0; i < pipeline; i++ {
+							if _, err = r.ReadString('\n'); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkIdleConns measures the round trips of a few clients while many connections are idle,
+// and reports the goroutines and the heap memory kept by each idle connection.
+func BenchmarkIdleConns(b *testing.B) {
+	const idle = 2000
+	for _, model := range []string{"goroutine", "epoll"} {
+		b.Run(model, func(b *testing.B) {
+			addr := startTestServer(b, model == "epoll")
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			goroutines := runtime.NumGoroutine()
+			conns := make([]*testConn, idle)
+			for i := range conns {
+				conns[i] = dialTCP(b, addr)
+				// the connection is served once it replies
+				if res, err := conns[i].do(b, "ping"); err != nil || res != "+PONG\r\n" {
+					b.Fatalf("ping reply error: %q %v", res, err)
+				}
+			}
+			// the writers of the replies exit shortly after writing
+			time.Sleep(100 * time.Millisecond)
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			goroutinesPerConn := float64(runtime.NumGoroutine()-goroutines) / idle
+			heapPerConn := float64(int64(after.HeapInuse)-int64(before.HeapInuse)) / idle
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if res, err := conns[i%idle].do(b, "ping"); err != nil || res != "+PONG\r\n" {
+					b.Fatalf("ping reply error: %q %v", res, err)
+				}
+			}
+			// ResetTimer deletes the metrics reported before it
+			b.ReportMetric(goroutinesPerConn, "goroutines/conn")
+			b.ReportMetric(heapPerConn, "heap-B/conn")
+		})
+	}
+}
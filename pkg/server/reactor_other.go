@@ -0,0 +1,20 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// reactor is only implemented with epoll on Linux
+type reactor struct{}
+
+func newReactor(handler *Handler, loops int) (*reactor, error) {
+	return nil, errors.New("the epoll reactor is only supported on Linux")
+}
+
+func (r *reactor) run(sg *sync.WaitGroup) {}
+
+func (r *reactor) serve(listener net.Listener) {}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hsn/tiny-redis/pkg/RESP"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"github.com/hsn/tiny-redis/pkg/crdt"
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"github.com/hsn/tiny-redis/pkg/memdb"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const peerRetryInterval = time.Second
+
+// Replicator runs the active-active mode.
+// Write commands are turned into CRDT ops by the store and the affected keys are rebuilt in memDb,
+// ops are pushed to every peer over its RESP port with crdt.apply.
+// The writes reach the replicator through memDb once they have passed the authentication, the ACL and MULTI,
+// and the links authenticate on the peers with masteruser and masterauth, crdt.* being admin commands.
+// Every op, local or applied from a peer, is propagated to the AOF file as crdt.apply, so a restarted node
+// replays the replicated state from its AOF file under the origins that issued the ops, while it gets a new origin.
+// The ops of every origin are forwarded to the peers, which skip the ones they have applied with crdt.sync,
+// so the ops of a node reach all peers even if it stops before pushing them to some, and the ops received by
+// every peer are compacted from the op log.
+type Replicator struct {
+	mu    sync.Mutex // serializes state changes with the rebuild of their keys
+	cfg   *config.Config
+	store *crdt.Store
+	memDb *memdb.MemDb
+	peers []*peer
+}
+
+type peer struct {
+	addr   string
+	notify chan struct{}
+	acked  int64 // position of the latest op of the log received or skipped by the peer, accessed atomically
+	online int32 // the link has been synced and is pushing ops, accessed atomically
+}
+
+// NewReplicator creates the replication of memDb, it has to be created before loading the AOF file,
+// which replays the ops with crdt.apply.
+func NewReplicator(cfg *config.Config, memDb *memdb.MemDb) *Replicator {
+	r := &Replicator{
+		cfg:   cfg,
+		store: crdt.NewStore(cfg.NodeID),
+		memDb: memDb,
+	}
+	for _, addr := range cfg.Peers {
+		r.peers = append(r.peers, &peer{addr: addr, notify: make(chan struct{}, 1)})
+	}
+	memDb.SetReplication(r)
+	return r
+}
+
+// Info returns the replication section of INFO, every peer is given with its link state and
+// the number of logged ops it hasn't received yet
+func (r *Replicator) Info() string {
+	last := int64(r.store.LogEnd())
+	var sb strings.Builder
+	sb.WriteString("role:master\r\nconnected_slaves:0\r\n")
+	sb.WriteString(fmt.Sprintf("active_active_origin:%s\r\nactive_active_last_seq:%d\r\nactive_active_peers:%d\r\n",
+		r.store.Origin(), r.store.LastSeq(), len(r.peers)))
+	for i, p := range r.peers {
+		state := "connecting"
+		if atomic.LoadInt32(&p.online) == 1 {
+			state = "online"
+		}
+		acked := atomic.LoadInt64(&p.acked)
+		sb.WriteString(fmt.Sprintf("peer%d:addr=%s,state=%s,acked=%d,lag=%d\r\n", i, p.addr, state, acked, last-acked))
+	}
+	return sb.String()
+}
+
+// Start adopts the keys loaded from the AOF file, then connects to all peers and keeps shipping the ops to them
+// until stopCh is closed.
+func (r *Replicator) Start(stopCh <-chan struct{}) {
+	logger.Info("Active-active replication started, origin ", r.store.Origin())
+	logger.Warning("Active-active mode only accepts the writes ", strings.Join(crdt.Commands, " "),
+		", the other writes and the ttls are rejected")
+	r.adopt()
+	for _, p := range r.peers {
+		go r.link(p, stopCh)
+	}
+}
+
+// adopt writes the keys of memDb the store doesn't know as local writes, such as the keys loaded from an AOF file
+// written without the replication, so that they are replicated rather than deleted by the first rebuild of the key.
+// memDb already holds their values, so only their ops are propagated.
+func (r *Replicator) adopt() {
+	known := make(map[string]bool)
+	for _, key := range r.store.Keys() {
+		known[key] = true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cmd := range r.memDb.KeyCommands() {
+		if known[string(cmd[1])] {
+			continue
+		}
+		_, ops, err := r.store.Local(cmd)
+		if err != nil {
+			logger.Warning("Failed to replicate key ", string(cmd[1]), ": ", err.Error())
+			continue
+		}
+		r.propagate(ops)
+	}
+}
+
+// Apply merges an op shipped by a peer, or loaded from the AOF file, with crdt.apply.
+func (r *Replicator) Apply(cmd [][]byte) RESP.RedisData {
+	op, err := crdt.DecodeOp(cmd)
+	if err != nil {
+		return RESP.MakeErrorData("ERR " + err.Error())
+	}
+	op.Value = bytes.Clone(op.Value)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store.Apply(op) {
+		r.propagate([]crdt.Op{op})
+		r.materialize(op.Key)
+		r.notify()
+	}
+	return RESP.MakeStringData("OK")
+}
+
+// Sync returns the last sequence number applied from the origin given by crdt.sync.
+func (r *Replicator) Sync(cmd [][]byte) RESP.RedisData {
+	return RESP.MakeIntData(int64(r.store.Applied(string(cmd[1]))))
+}
+
+// Write executes a write command of a client through the store and ships its ops to the peers.
+func (r *Replicator) Write(cmd [][]byte) RESP.RedisData {
+	r.mu.Lock()
+	// the ops and the state keep the values of cmd, whose arguments may point into the input buffer
+	res, ops, err := r.store.Local(RESP.CloneCommand(cmd))
+	if err != nil {
+		r.mu.Unlock()
+		return RESP.MakeErrorData(err.Error())
+	}
+	r.propagate(ops)
+	done := make(map[string]struct{})
+	for _, op := range ops {
+		if _, ok := done[op.Key]; !ok {
+			r.materialize(op.Key)
+			done[op.Key] = struct{}{}
+		}
+	}
+	r.mu.Unlock()
+
+	if len(ops) > 0 {
+		r.notify()
+	}
+	switch strings.ToLower(string(cmd[0])) {
+	case "set":
+		if res == 0 {
+			return RESP.MakeBulkData(nil)
+		}
+		return RESP.MakeStringData("OK")
+	case "mset":
+		return RESP.MakeStringData("OK")
+	}
+	return RESP.MakeIntData(res)
+}
+
+// Check rejects the write commands the store doesn't support, before they are queued by MULTI.
+func (r *Replicator) Check(cmd [][]byte) RESP.RedisData {
+	if err := crdt.Check(cmd); err != nil {
+		return RESP.MakeErrorData(err.Error())
+	}
+	return nil
+}
+
+// Synced reports whether every peer has received all logged ops
+func (r *Replicator) Synced() bool {
+	last := int64(r.store.LogEnd())
+	for _, p := range r.peers {
+		if atomic.LoadInt64(&p.acked) < last {
+			return false
+		}
+	}
+	return true
+}
+
+// materialize rebuilds key in memDb from the replicated state, the caller holds txLocks of key.
+func (r *Replicator) materialize(key string) {
+	if res, ok := r.memDb.Rebuild(r.store.Materialize(key)).(*RESP.ErrorData); ok {
+		logger.Error("materialize key ", key, " error: ", res.Error())
+	}
+}
+
+// propagate sends ops to the AOF file, the caller holds txLocks of their keys.
+func (r *Replicator) propagate(ops []crdt.Op) {
+	for _, op := range ops {
+		r.memDb.Propagate(op.Encode())
+	}
+}
+
+// notify wakes up the links to push the new ops of the log
+func (r *Replicator) notify() {
+	for _, p := range r.peers {
+		select {
+		case p.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// compact drops the ops received by every peer from the op log
+func (r *Replicator) compact() {
+	pos := r.store.LogEnd()
+	for _, p := range r.peers {
+		pos = min(pos, uint64(atomic.LoadInt64(&p.acked)))
+	}
+	r.store.Compact(pos)
+}
+
+// link keeps a connection to p and pushes local ops through it, reconnecting after errors.
+func (r *Replicator) link(p *peer, stopCh <-chan struct{}) {
+	for {
+		conn, err := net.DialTimeout("tcp", p.addr, peerRetryInterval)
+		if err == nil {
+			logger.Info("Connected to peer ", p.addr)
+			err = r.push(p, conn, stopCh)
+			_ = conn.Close()
+		}
+		if err != nil {
+			logger.Warning("Peer ", p.addr, " link error: ", err.Error())
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(peerRetryInterval):
+		}
+	}
+}
+
+func (r *Replicator) push(p *peer, conn net.Conn, stopCh <-chan struct{}) error {
+	reader := bufio.NewReader(conn)
+	if user, password := r.cfg.MasterCredentials(); password != "" {
+		auth := [][]byte{[]byte("auth"), []byte(password)}
+		if user != "" {
+			auth = [][]byte{[]byte("auth"), []byte(user), []byte(password)}
+		}
+		if _, err := r.request(conn, reader, auth); err != nil {
+			return err
+		}
+	}
+	// the peer gives the last op it has applied from every origin, the ops it already has are skipped
+	applied := make(map[string]uint64)
+	syncOrigin := func(origin string) error {
+		seq, err := r.request(conn, reader, [][]byte{[]byte(crdt.SyncCommand), []byte(origin)})
+		applied[origin] = uint64(seq)
+		return err
+	}
+	if err := syncOrigin(r.store.Origin()); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&p.online, 1)
+	defer atomic.StoreInt32(&p.online, 0)
+	ticker := time.NewTicker(peerRetryInterval)
+	defer ticker.Stop()
+	// the ops before acked have been received by the peer, they may have been compacted
+	acked := atomic.LoadInt64(&p.acked)
+	for {
+		ops := r.store.OpsSince(uint64(acked))
+		if len(ops) == 0 {
+			select {
+			case <-stopCh:
+				return nil
+			case <-p.notify:
+			case <-ticker.C:
+			}
+			continue
+		}
+		for _, op := range ops {
+			if _, ok := applied[op.Origin]; !ok {
+				if err := syncOrigin(op.Origin); err != nil {
+					return err
+				}
+			}
+			if op.Seq > applied[op.Origin] {
+				if _, err := r.request(conn, reader, op.Encode()); err != nil {
+					return err
+				}
+				applied[op.Origin] = op.Seq
+			}
+			acked++
+			atomic.StoreInt64(&p.acked, acked)
+		}
+		r.compact()
+	}
+}
+
+// request sends cmd to a peer and reads its single line reply.
+func (r *Replicator) request(conn net.Conn, reader *bufio.Reader, cmd [][]byte) (int64, error) {
+	args := make([]RESP.RedisData, len(cmd))
+	for i, arg := range cmd {
+		args[i] = RESP.MakeBulkData(arg)
+	}
+	if _, err := conn.Write(RESP.MakeArrayData(args).ToBytes()); err != nil {
+		return 0, err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return 0, errors.New("empty reply from peer")
+	}
+	switch line[0] {
+	case '-':
+		return 0, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	}
+	return 0, nil
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"github.com/hsn/tiny-redis/pkg/config"
+	"github.com/hsn/tiny-redis/pkg/memdb"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startReplicatedPair serves two handlers replicating to each other, and returns a connection to each of them
+func startReplicatedPair(t *testing.T, cfg *config.Config) (*testConn, *testConn) {
+	t.Helper()
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		listeners = append(listeners, listener)
+		cfg.Peers = append(cfg.Peers, listener.Addr().String())
+	}
+	var sg sync.WaitGroup
+	for _, listener := range listeners {
+		h := newTestHandler(t, cfg)
+		h.AddListener(listener)
+		go serve(listener, h, &sg)
+	}
+	return dialTCP(t, cfg.Peers[0]), dialTCP(t, cfg.Peers[1])
+}
+
+// waitReplicated waits until key exists on the peer c
+func waitReplicated(t *testing.T, c *testConn, key string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if res, err := c.do(t, "exists", key); err != nil || res == ":1\r\n" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has not been replicated", key)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitReply waits until the peer c replies expect to args
+func waitReply(t *testing.T, c *testConn, expect string, args ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := c.do(t, args...)
+		if err == nil && res == expect {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v: %q %v, expect %q", args, res, err, expect)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReplicationRestart(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+		cfg.Peers = append(cfg.Peers, listener.Addr().String())
+	}
+	// the AOF file of the first node is written without peers
+	aofFile := filepath.Join(t.TempDir(), "aof")
+	if err := os.WriteFile(aofFile, []byte("*3\r\n$4\r\nsadd\r\n$1\r\ns\r\n$1\r\na\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var sg sync.WaitGroup
+	start := func(listener net.Listener, aofFile string) *Handler {
+		setupTestEnv(t, cfg)
+		if aofFile != "" {
+			cfg.AofFile = aofFile
+		}
+		h := NewHandler()
+		t.Cleanup(func() { _ = h.Shutdown(nil, memdb.ShutdownOptions{Now: true, Force: true}) })
+		h.AddListener(listener)
+		go serve(listener, h, &sg)
+		return h
+	}
+	h := start(listeners[0], aofFile)
+	start(listeners[1], "")
+	a, b := dialTCP(t, cfg.Peers[0]), dialTCP(t, cfg.Peers[1])
+
+	// the keys of the AOF file are adopted, rather than deleted by the first write
+	if res, err := a.do(t, "sadd", "s", "b"); err != nil || res != ":1\r\n" {
+		t.Fatalf("sadd: %q %v", res, err)
+	}
+	waitReply(t, b, ":2\r\n", "scard", "s")
+
+	// the restarted node replays the ops of its AOF file, and gets the ops written meanwhile from its peer
+	if err := h.Shutdown(nil, memdb.ShutdownOptions{Now: true}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := b.do(t, "sadd", "s", "c"); err != nil || res != ":1\r\n" {
+		t.Fatalf("sadd: %q %v", res, err)
+	}
+	listener, err := net.Listen("tcp", cfg.Peers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(listener, aofFile)
+	a = dialTCP(t, cfg.Peers[0])
+	waitReply(t, a, ":3\r\n", "scard", "s")
+	if res, err := a.do(t, "sadd", "s", "d"); err != nil || res != ":1\r\n" {
+		t.Fatalf("sadd after restart: %q %v", res, err)
+	}
+	waitReply(t, b, ":4\r\n", "scard", "s")
+	waitReply(t, a, ":4\r\n", "scard", "s")
+}
+
+func TestReplicationRequiresAuth(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	cfg.RequirePass = "secret"
+	cfg.MasterAuth = "secret"
+	a, b := startReplicatedPair(t, cfg)
+
+	for _, args := range [][]string{
+		{"set", "k", "v"},
+		{"del", "k"},
+		{"crdt.sync", "intruder"},
+		{"crdt.apply", "intruder", "1", "1", "0", "n", "set", "k", "", "v", "0", ""},
+	} {
+		if res, err := a.do(t, args...); err != nil || !strings.HasPrefix(res, "-NOAUTH") {
+			t.Errorf("%v without auth: %q %v", args, res, err)
+		}
+	}
+	for _, c := range []*testConn{a, b} {
+		if res, err := c.do(t, "auth", "secret"); err != nil || res != "+OK\r\n" {
+			t.Fatalf("auth: %q %v", res, err)
+		}
+	}
+	if res, err := a.do(t, "acl", "setuser", "app", "on", ">pass", "~*", "+@all", "-@admin"); err != nil || res != "+OK\r\n" {
+		t.Fatalf("acl setuser: %q %v", res, err)
+	}
+	app := dialTCP(t, cfg.Peers[0])
+	if res, err := app.do(t, "auth", "app", "pass"); err != nil || res != "+OK\r\n" {
+		t.Fatalf("auth app: %q %v", res, err)
+	}
+	if res, err := app.do(t, "crdt.sync", "intruder"); err != nil || !strings.HasPrefix(res, "-NOPERM") {
+		t.Errorf("crdt.sync of a user without @admin: %q %v", res, err)
+	}
+
+	// the writes of a transaction are replicated, the links authenticate with masterauth
+	for _, args := range [][]string{{"multi"}, {"set", "k", "v"}, {"hset", "h", "f", "v"}} {
+		if _, err := a.do(t, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res, err := a.do(t, "exec"); err != nil || res != "*2\r\n" {
+		t.Fatalf("exec: %q %v", res, err)
+	}
+	_, _ = a.r.ReadString('\n')
+	_, _ = a.r.ReadString('\n')
+	waitReplicated(t, b, "h")
+	if v := b.bulk(t, "hget", "h", "f"); v != "v" {
+		t.Errorf("hget on the peer: %q", v)
+	}
+	if v := b.bulk(t, "get", "k"); v != "v" {
+		t.Errorf("get on the peer: %q", v)
+	}
+}
+
+func TestReplicationCommands(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	a, b := startReplicatedPair(t, cfg)
+	for _, step := range []struct {
+		args  []string
+		reply string
+	}{
+		{[]string{"sadd", "s", "m1", "m2"}, ":2\r\n"},
+		{[]string{"set", "c", "10"}, "+OK\r\n"},
+		{[]string{"incr", "c"}, ":11\r\n"},
+		{[]string{"set", "c", "0", "nx"}, "$-1\r\n"},
+		{[]string{"set", "k", "v", "ex", "10"}, "-ERR command is not supported in active-active mode: set ex\r\n"},
+		{[]string{"append", "c", "1"}, "-ERR command is not supported in active-active mode: append\r\n"},
+		{[]string{"multi"}, "+OK\r\n"},
+		{[]string{"expire", "c", "10"}, "-ERR command is not supported in active-active mode: expire\r\n"},
+		{[]string{"exec"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{[]string{"mset", "m", "1", "done", "1"}, "+OK\r\n"},
+	} {
+		if res, err := a.do(t, step.args...); err != nil || res != step.reply {
+			t.Errorf("%v: %q %v, expect %q", step.args, res, err, step.reply)
+		}
+	}
+	waitReplicated(t, b, "done")
+	if res, err := b.do(t, "scard", "s"); err != nil || res != ":2\r\n" {
+		t.Errorf("scard on the peer: %q %v", res, err)
+	}
+	if v := b.bulk(t, "get", "c"); v != "11" {
+		t.Errorf("get on the peer: %q", v)
+	}
+}
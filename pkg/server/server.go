@@ -1,35 +1,223 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"github.com/hsn/tiny-redis/pkg/config"
 	"github.com/hsn/tiny-redis/pkg/logger"
+	"github.com/hsn/tiny-redis/pkg/memdb"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 )
 
-// Start starts a simple redis server
+// Start starts a simple redis server.
+// It listens on port for plain TCP, on tls-port for TLS and on unixsocket, a port of 0 disables its listener.
+// The Prometheus metrics are served over HTTP on metrics-port.
 func Start(cfg *config.Config) error {
-	listener, err := net.Listen("tcp", cfg.Host+":"+strconv.Itoa(cfg.Port))
-	if err != nil {
+	var listeners []net.Listener
+	var metricsListener net.Listener
+	// the listeners are closed by the shutdown of the handler once it's serving
+	serving := false
+	defer func() {
+		if serving {
+			return
+		}
+		if metricsListener != nil {
+			listeners = append(listeners, metricsListener)
+		}
+		for _, listener := range listeners {
+			if err := listener.Close(); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+	if cfg.TcpBacklog > 0 {
+		checkBacklog(cfg.TcpBacklog)
+	}
+	if cfg.Port != 0 {
+		listener, err := listenTCP(cfg.Host+":"+strconv.Itoa(cfg.Port), cfg.TcpBacklog)
+		if err != nil {
+			logger.Panic(err)
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info("Server Listen at", cfg.Host+":"+strconv.Itoa(cfg.Port))
+	}
+	// TLS connections are always served by goroutines, the handshake and the decryption need the connection to block
+	var tlsListener net.Listener
+	if cfg.TlsPort != 0 {
+		tlsCfg, err := NewTLSConfig(cfg)
+		if err != nil {
+			logger.Panic(err)
+			return err
+		}
+		listener, err := listenTCP(cfg.Host+":"+strconv.Itoa(cfg.TlsPort), cfg.TcpBacklog)
+		if err != nil {
+			logger.Panic(err)
+			return err
+		}
+		listener = tls.NewListener(listener, tlsCfg)
+		listeners = append(listeners, listener)
+		tlsListener = listener
+		logger.Info("Server Listen TLS at", cfg.Host+":"+strconv.Itoa(cfg.TlsPort))
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			logger.Panic(err)
+			return err
+		}
+		if cfg.TcpBacklog > 0 {
+			if err = setBacklog(listener, cfg.TcpBacklog); err != nil {
+				logger.Warning("tcp-backlog:", err.Error())
+			}
+		}
+		listeners = append(listeners, listener)
+		logger.Info("Server Listen at unix socket", cfg.UnixSocket)
+	}
+	if len(listeners) == 0 {
+		err := errors.New("no listener: port and tls-port are 0 and unixsocket is not set")
 		logger.Panic(err)
 		return err
 	}
-	defer func() {
-		err := listener.Close()
-		if err != nil {
-			logger.Error(err)
+	if cfg.MetricsPort != 0 {
+		var err error
+		if metricsListener, err = listenTCP(cfg.Host+":"+strconv.Itoa(cfg.MetricsPort), 0); err != nil {
+			logger.Panic(err)
+			return err
 		}
-	}()
-	logger.Info("Server Listen at", cfg.Host+":"+strconv.Itoa(cfg.Port))
+		logger.Info("Serving metrics at", cfg.Host+":"+strconv.Itoa(cfg.MetricsPort))
+	}
 
 	var sg sync.WaitGroup
 	handler := NewHandler()
+	var r *reactor
+	if cfg.Reactor == "epoll" {
+		var err error
+		if r, err = newReactor(handler, cfg.ReactorLoops); err != nil {
+			handler.Stop()
+			logger.Panic(err)
+			return err
+		}
+		r.run(&sg)
+		logger.Info("Serving connections with epoll event loops")
+	}
+	serving = true
+	for _, listener := range listeners {
+		handler.AddListener(listener)
+		sg.Add(1)
+		go func(listener net.Listener) {
+			defer sg.Done()
+			if r != nil && listener != tlsListener {
+				r.serve(listener)
+				return
+			}
+			serve(listener, handler, &sg)
+		}(listener)
+	}
+	if metricsListener != nil {
+		handler.AddListener(metricsListener)
+		sg.Add(1)
+		go func() {
+			defer sg.Done()
+			serveMetrics(metricsListener, handler)
+		}()
+	}
+	stopSignals := handleSignals(handler)
+	defer stopSignals()
+	sg.Wait()
+	return handler.ExitErr()
+}
+
+// handleSignals shuts down the server on SIGTERM and SIGINT, a second SIGINT exits at once.
+// It returns the function to stop handling the signals.
+func handleSignals(handler *Handler) func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	stopCh := make(chan struct{})
+	failedCh := make(chan struct{})
+	go func() {
+		shutting := false
+		for {
+			select {
+			case sig := <-sigCh:
+				if shutting {
+					if sig == syscall.SIGINT {
+						logger.Warning("You insist... exiting now.")
+						os.Exit(1)
+					}
+					continue
+				}
+				shutting = true
+				logger.Info("Received", sig.String(), "scheduling shutdown...")
+				go func() {
+					if err := handler.Shutdown(nil, memdb.ShutdownOptions{}); err != nil {
+						logger.Warning(sig.String(), "received but errors trying to shut down the server, check the logs for more information")
+						failedCh <- struct{}{}
+					}
+				}()
+			case <-failedCh:
+				shutting = false
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigCh)
+		close(stopCh)
+	}
+}
+
+// listenTCP listens on the TCP address addr with the backlog of tcp-backlog, 0 keeps the one chosen by Go
+func listenTCP(addr string, backlog int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if backlog > 0 {
+		if err = setBacklog(listener, backlog); err != nil {
+			logger.Warning("tcp-backlog:", err.Error())
+		}
+	}
+	return listener, nil
+}
+
+// listenUnix listens on the Unix domain socket path, a stale socket file left by a crash is replaced.
+// The socket file is removed when the listener is closed.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(true)
+	if perm != 0 {
+		if err = os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// serve accepts the connections of listener until it's closed, each connection is handled in its own goroutine
+func serve(listener net.Listener, handler *Handler, sg *sync.WaitGroup) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			logger.Error(err.Error())
-			break
+			if !handler.isClosed() {
+				logger.Error(err.Error())
+			}
+			return
 		}
 		logger.Info(conn.RemoteAddr().String(), " connected")
 		sg.Add(1)
@@ -42,8 +230,5 @@ func Start(cfg *config.Config) error {
 			}()
 			handler.Handle(conn)
 		}()
-
 	}
-	sg.Wait()
-	return nil
 }
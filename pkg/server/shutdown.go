@@ -0,0 +1,197 @@
+package server
+
+import (
+	"errors"
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"github.com/hsn/tiny-redis/pkg/memdb"
+	"net"
+	"time"
+)
+
+// replicaPollInterval is the interval of checking whether the peers have received all writes during shutdown
+const replicaPollInterval = 10 * time.Millisecond
+
+var (
+	errShutdown     = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+	errServerClosed = errors.New("server is shut down")
+	// errMaxClients is replied to the connections rejected by maxclients
+	errMaxClients = errors.New("ERR max number of clients reached")
+)
+
+// AddListener registers a listener to be closed by shutdown, it returns false if the server is already shut down
+func (h *Handler) AddListener(listener net.Listener) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.listeners = append(h.listeners, listener)
+	return true
+}
+
+// addConn registers conn, it fails if the server is shut down or maxClients connections are registered
+func (h *Handler) addConn(conn net.Conn, maxClients int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return errServerClosed
+	}
+	if maxClients > 0 && len(h.conns) >= maxClients {
+		return errMaxClients
+	}
+	h.conns[conn] = struct{}{}
+	return nil
+}
+
+func (h *Handler) removeConn(conn net.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, conn)
+}
+
+func (h *Handler) isClosed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+// beginCommand waits while the shutdown is draining the commands, it returns false once the server is shut down
+func (h *Handler) beginCommand() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for h.paused && !h.closed {
+		h.cond.Wait()
+	}
+	if h.closed {
+		return false
+	}
+	h.inflight++
+	return true
+}
+
+func (h *Handler) endCommand() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inflight--
+	h.cond.Broadcast()
+}
+
+// Done returns a channel closed when the server has been shut down
+func (h *Handler) Done() <-chan struct{} {
+	return h.done
+}
+
+// ExitErr returns the error ignored by SHUTDOWN FORCE
+func (h *Handler) ExitErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.exitErr
+}
+
+// Shutdown stops the server, c is the client running SHUTDOWN, nil if it's triggered by a signal.
+// It waits up to shutdown-timeout for the peers to receive all writes unless NOW is given,
+// then lets the commands being executed finish, flushes and fsyncs the AOF file,
+// closes the listeners and the connections, and stops the background jobs.
+// If the AOF file can't be persisted, the shutdown is canceled unless FORCE is given.
+func (h *Handler) Shutdown(c *memdb.Client, opts memdb.ShutdownOptions) error {
+	if opts.Abort {
+		return h.abortShutdown()
+	}
+	h.mu.Lock()
+	if h.shutting || h.closed {
+		h.mu.Unlock()
+		return errors.New("ERR shutdown already in progress")
+	}
+	h.shutting = true
+	abortCh := make(chan struct{})
+	h.abortCh = abortCh
+	h.mu.Unlock()
+	logger.Info("User requested shutdown...")
+
+	if h.replicator != nil && !opts.Now && !h.waitReplicas(abortCh) {
+		logger.Warning("Shutdown canceled by SHUTDOWN ABORT")
+		return errShutdown
+	}
+
+	// the command running SHUTDOWN is in flight itself
+	self := 0
+	if c != nil {
+		self = 1
+	}
+	h.mu.Lock()
+	if h.abortCh != abortCh {
+		h.mu.Unlock()
+		logger.Warning("Shutdown canceled by SHUTDOWN ABORT")
+		return errShutdown
+	}
+	h.abortCh = nil
+	h.paused = true
+	for h.inflight > self {
+		h.cond.Wait()
+	}
+	h.mu.Unlock()
+
+	// there is no snapshot, the AOF file is the only persistence whether or not SAVE or NOSAVE is given
+	err := h.FlushAOF()
+	if err != nil {
+		logger.Error("Error flushing the AOF file on shutdown: ", err.Error())
+		if !opts.Force {
+			h.mu.Lock()
+			h.shutting, h.paused = false, false
+			h.cond.Broadcast()
+			h.mu.Unlock()
+			logger.Warning("Errors trying to shut down the server, check the logs for more information")
+			return errShutdown
+		}
+	}
+
+	h.mu.Lock()
+	h.closed = true
+	h.exitErr = err
+	for _, listener := range h.listeners {
+		if closeErr := listener.Close(); closeErr != nil {
+			logger.Error(closeErr)
+		}
+	}
+	for conn := range h.conns {
+		_ = conn.Close()
+	}
+	h.cond.Broadcast()
+	h.mu.Unlock()
+	h.Stop()
+	close(h.done)
+	logger.Info("Redis is now ready to exit, bye bye...")
+	return nil
+}
+
+// abortShutdown cancels a shutdown waiting for the peers
+func (h *Handler) abortShutdown() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.abortCh == nil {
+		return errors.New("ERR No shutdown in progress.")
+	}
+	close(h.abortCh)
+	h.abortCh = nil
+	h.shutting = false
+	return nil
+}
+
+// waitReplicas waits up to shutdown-timeout for the peers to receive all writes,
+// it returns false if the shutdown is aborted.
+func (h *Handler) waitReplicas(abortCh <-chan struct{}) bool {
+	timeout := time.After(time.Duration(h.cfg.ShutdownTimeoutSeconds()) * time.Second)
+	ticker := time.NewTicker(replicaPollInterval)
+	defer ticker.Stop()
+	for !h.replicator.Synced() {
+		select {
+		case <-abortCh:
+			return false
+		case <-timeout:
+			logger.Warning("Lagging peers did not catch up within shutdown-timeout, shutting down anyway")
+			return true
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func dialTestConn(t *testing.T, path string) *testConn {
+	t.Helper()
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns the first line of the reply
+func (c *testConn) do(t testing.TB, args ...string) (string, error) {
+	t.Helper()
+	req := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		req += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	if _, err := c.Write([]byte(req)); err != nil {
+		return "", err
+	}
+	return c.r.ReadString('\n')
+}
+
+func TestShutdown(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	cfg.Port = 0
+	// an unreachable peer never receives the writes, so SHUTDOWN waits for it
+	cfg.Peers = []string{"127.0.0.1:1"}
+	cfg.ShutdownTimeout = 60
+	dir := setupTestEnv(t, cfg)
+	cfg.UnixSocket = filepath.Join(dir, "redis.sock")
+	exited := make(chan error, 1)
+	go func() { exited <- Start(cfg) }()
+
+	a, b := dialTestConn(t, cfg.UnixSocket), dialTestConn(t, cfg.UnixSocket)
+	for _, args := range [][]string{{"shutdown", "save", "nosave"}, {"shutdown", "abort", "now"}, {"shutdown", "later"}} {
+		if res, err := a.do(t, args...); err != nil || res != "-ERR syntax error\r\n" {
+			t.Errorf("%v should be rejected: %q %v", args, res, err)
+		}
+	}
+	if res, err := a.do(t, "set", "k", "v"); err != nil || res != "+OK\r\n" {
+		t.Fatalf("set reply error: %q %v", res, err)
+	}
+	if res, err := a.do(t, "shutdown", "abort"); err != nil || res != "-ERR No shutdown in progress.\r\n" {
+		t.Errorf("shutdown abort reply error: %q %v", res, err)
+	}
+
+	// SHUTDOWN waits for the lagging peer until it's aborted
+	replied := make(chan string, 1)
+	go func() {
+		res, _ := a.do(t, "shutdown")
+		replied <- res
+	}()
+	// shutdown-timeout is read by the waiting shutdown while CONFIG SET may change it
+	if res, err := b.do(t, "config", "set", "shutdown-timeout", "60"); err != nil || res != "+OK\r\n" {
+		t.Errorf("config set reply error: %q %v", res, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case res := <-replied:
+		t.Fatalf("shutdown should wait for the lagging peer: %q", res)
+	default:
+	}
+	if res, err := b.do(t, "shutdown", "abort"); err != nil || res != "+OK\r\n" {
+		t.Errorf("shutdown abort reply error: %q %v", res, err)
+	}
+	if res := <-replied; res != "-ERR Errors trying to SHUTDOWN. Check logs.\r\n" {
+		t.Errorf("aborted shutdown reply error: %q", res)
+	}
+	if res, err := b.do(t, "ping"); err != nil || res != "+PONG\r\n" {
+		t.Errorf("the server should keep running after shutdown abort: %q %v", res, err)
+	}
+
+	// after shutdown-timeout the server is shut down without the peer
+	if res, err := b.do(t, "config", "set", "shutdown-timeout", "0"); err != nil || res != "+OK\r\n" {
+		t.Errorf("config set reply error: %q %v", res, err)
+	}
+	if res, err := b.do(t, "shutdown"); err != io.EOF {
+		t.Errorf("shutdown should close the connection without reply: %q %v", res, err)
+	}
+	if _, err := a.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("the connections should be closed by shutdown: %v", err)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("Start should return nil after shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start should return after shutdown")
+	}
+	// the replicated writes are kept as their ops
+	if aof, err := os.ReadFile(cfg.AofFile); err != nil || !strings.Contains(string(aof), "$10\r\ncrdt.apply\r\n") ||
+		!strings.Contains(string(aof), "$3\r\nset\r\n$1\r\nk\r\n$0\r\n\r\n$1\r\nv\r\n") {
+		t.Errorf("the writes should be in the AOF file: %q %v", aof, err)
+	}
+	if _, err := os.Stat(cfg.UnixSocket); !os.IsNotExist(err) {
+		t.Errorf("the socket file should be removed by shutdown: %v", err)
+	}
+}
+
+func TestAppendAOFAfterStop(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	h.Stop()
+	done := make(chan struct{})
+	go func() {
+		// more writes than the AOF queue holds, nobody takes them once the AOF logger is stopped
+		for i := 0; i < 2*cap(h.aofChan); i++ {
+			h.appendAOF([][]byte{[]byte("set"), []byte("k"), []byte(strconv.Itoa(i))})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("appendAOF blocks after the handler is stopped")
+	}
+}
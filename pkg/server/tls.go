@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"os"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the handshake of a TLS connection, so a silent client can't hold the goroutine
+const tlsHandshakeTimeout = 10 * time.Second
+
+// NewTLSConfig builds the TLS config of the TLS listener from the tls-* parameters
+func NewTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TlsCertFile == "" || cfg.TlsKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required by tls-port")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TlsCertFile, cfg.TlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.NoClientCert,
+	}
+	switch cfg.TlsAuthClients {
+	case "no":
+		return tlsCfg, nil
+	case "optional":
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.TlsCaCertFile == "" {
+		return nil, errors.New("tls-ca-cert-file is required to authenticate the clients, or set tls-auth-clients no")
+	}
+	pem, err := os.ReadFile(cfg.TlsCaCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in tls-ca-cert-file " + cfg.TlsCaCertFile)
+	}
+	tlsCfg.ClientCAs = pool
+	return tlsCfg, nil
+}
+
+// tlsHandshake completes the handshake of conn, and returns the CN of the verified client certificate,
+// empty if the client has given no certificate.
+func tlsHandshake(conn *tls.Conn) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout)); err != nil {
+		return "", err
+	}
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return "", err
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName, nil
+}
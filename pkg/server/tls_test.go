@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"github.com/hsn/tiny-redis/pkg/logger"
+	"github.com/hsn/tiny-redis/pkg/memdb"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCert is a throwaway certificate signed by parent, or self-signed if parent is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and the key to dir, and returns their paths
+func (c *testCert) writePEM(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// loggerOnce sets up the logger once, the goroutines of the previous tests may still be logging
+var loggerOnce sync.Once
+
+// registerOnce fills the command table once, it's read by the handlers without locking
+var registerOnce sync.Once
+
+// setupTestEnv makes the server write its AOF file to a temporary directory, and returns the directory
+func setupTestEnv(t testing.TB, cfg *config.Config) string {
+	t.Helper()
+	dir := t.TempDir()
+	loggerOnce.Do(func() {
+		if err := logger.SetUp(&config.Config{LogDir: os.TempDir(), LogLevel: "panic"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// every handler appends to its own AOF file
+	cfg.AofFile = filepath.Join(dir, "aof")
+	config.Configures = cfg
+	registerOnce.Do(func() {
+		memdb.RegisterKeyCommand()
+		memdb.RegisterStringCommands()
+		memdb.RegisterSetCommands()
+		memdb.RegisterHashCommands()
+		memdb.RegisterInfoCommands()
+		memdb.RegisterMultiCommands()
+		memdb.RegisterACLCommands()
+		memdb.RegisterPubSubCommands()
+	})
+	return dir
+}
+
+// newTestHandler creates a handler in the environment of setupTestEnv.
+// It's shut down at the end of the test and its connections are waited for, so that they don't outlive the test.
+func newTestHandler(t testing.TB, cfg *config.Config) *Handler {
+	t.Helper()
+	setupTestEnv(t, cfg)
+	h := NewHandler()
+	t.Cleanup(func() {
+		_ = h.Shutdown(nil, memdb.ShutdownOptions{Now: true, Force: true})
+		h.Stop()
+		for deadline := time.Now().Add(5 * time.Second); connCount(h) > 0 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	return h
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "server", ca).writePEM(t, dir, "server")
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	cfg.TlsCertFile, cfg.TlsKeyFile, cfg.TlsCaCertFile = certFile, keyFile, caFile
+	cfg.TlsAuthClientsUser = "cn"
+	h := newTestHandler(t, cfg)
+	h.memDb.ExecCommand(memdb.NewFakeClient(), [][]byte{[]byte("acl"), []byte("setuser"), []byte("alice"), []byte("on"), []byte("+@all"), []byte("~*")})
+
+	tlsCfg, err := NewTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sg sync.WaitGroup
+	go serve(listener, h, &sg)
+	defer listener.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	whoami := func(certs ...tls.Certificate) (string, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, Certificates: certs})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = conn.Write([]byte("*2\r\n$3\r\nacl\r\n$6\r\nwhoami\r\n")); err != nil {
+			return "", err
+		}
+		r := bufio.NewReader(conn)
+		if _, err = r.ReadString('\n'); err != nil {
+			return "", err
+		}
+		line, err := r.ReadString('\n')
+		return line, err
+	}
+
+	if user, err := whoami(newTestCert(t, "alice", ca).tlsCertificate()); err != nil || user != "alice\r\n" {
+		t.Errorf("the client certificate CN should authenticate the user: %q %v", user, err)
+	}
+	if user, err := whoami(newTestCert(t, "nobody", ca).tlsCertificate()); err != nil || user != "default\r\n" {
+		t.Errorf("an unknown CN should be the default user: %q %v", user, err)
+	}
+	if _, err := whoami(); err == nil {
+		t.Errorf("a client without certificate should be rejected")
+	}
+	if _, err := whoami(newTestCert(t, "alice", nil).tlsCertificate()); err == nil {
+		t.Errorf("a certificate not signed by the CA should be rejected")
+	}
+
+	cfg.TlsCaCertFile = ""
+	if _, err = NewTLSConfig(cfg); err == nil {
+		t.Errorf("tls-auth-clients yes requires tls-ca-cert-file")
+	}
+	cfg.TlsAuthClients = "no"
+	if _, err = NewTLSConfig(cfg); err != nil {
+		t.Errorf("tls-auth-clients no doesn't need tls-ca-cert-file: %v", err)
+	}
+}
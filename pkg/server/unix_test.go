@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bufio"
+	"github.com/hsn/tiny-redis/pkg/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ShardNum = 16
+	h := newTestHandler(t, cfg)
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	// a socket file left by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err := listenUnix(path, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("unixsocketperm should be applied to the socket file: %v %v", info, err)
+	}
+	var sg sync.WaitGroup
+	go serve(listener, h, &sg)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	if _, err = conn.Write([]byte("*1\r\n$4\r\nping\r\n*2\r\n$6\r\nclient\r\n$4\r\ninfo\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Errorf("ping reply error: %q %v", line, err)
+	}
+	_, _ = r.ReadString('\n')
+	info, err := r.ReadString('\n')
+	if err != nil || !strings.Contains(info, "addr="+path+":0 ") || !strings.Contains(info, "flags=U ") {
+		t.Errorf("client info of a unix socket connection error: %q %v", info, err)
+	}
+	_ = conn.Close()
+
+	_ = listener.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the socket file should be removed when the listener is closed: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package util
 
-import "github.com/spaolacci/murmur3"
+import (
+	"github.com/spaolacci/murmur3"
+	"strings"
+)
 
 // HashKey hashes a string to an int value using MurmurHash3 algorithm
 func HashKey(key string) int {
@@ -47,14 +50,10 @@ func PatternMatch(pattern, src string) bool {
 			if patPos == patLen {
 				return true
 			}
-			for srcPos < srcLen {
-				for srcPos < srcLen && src[srcPos] != pattern[patPos] {
-					srcPos++
-				}
-				if PatternMatch(pattern[patPos+1:], src[srcPos+1:]) {
+			// the star matches any number of characters, the rest of pattern may start with any special character
+			for ; srcPos <= srcLen; srcPos++ {
+				if PatternMatch(pattern[patPos:], src[srcPos:]) {
 					return true
-				} else {
-					srcPos++
 				}
 			}
 			return false
@@ -119,6 +118,7 @@ func PatternMatch(pattern, src string) bool {
 				return false
 			}
 			//	fall into default
+			fallthrough
 		default:
 			if pattern[patPos] != src[srcPos] {
 				return false
@@ -137,3 +137,34 @@ func PatternMatch(pattern, src string) bool {
 	}
 	return patPos == patLen && srcPos == srcLen
 }
+
+// SlotNum is the number of hash slots of the key space, the same as Redis Cluster
+const SlotNum = 16384
+
+// KeySlot returns the hash slot of a key, computed as CRC16(key) mod 16384.
+// If the key contains a non-empty hash tag like {user1000}.following, only the tag is hashed,
+// so keys with the same tag are in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotNum)
+}
+
+// crc16 implements CRC-16/XMODEM used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}